@@ -14,6 +14,7 @@ import (
 	"bytes"
 	"compress/gzip"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"reflect"
@@ -98,6 +99,184 @@ func BenchmarkCodeEncoder(b *testing.B) {
 	b.SetBytes(int64(len(codeJSON)))
 }
 
+func benchmarkEncodeMap(b *testing.B, sorted bool) {
+	b.ReportAllocs()
+	m := make(map[string]int, 10000)
+	for i := 0; i < 10000; i++ {
+		m[fmt.Sprintf("key%d", i)] = i
+	}
+	enc := NewEncoder(ioutil.Discard)
+	enc.SetSortMapKeys(sorted)
+	for i := 0; i < b.N; i++ {
+		if err := enc.Encode(m); err != nil {
+			b.Fatal("Encode:", err)
+		}
+	}
+}
+
+func BenchmarkEncodeMapSorted(b *testing.B)   { benchmarkEncodeMap(b, true) }
+func BenchmarkEncodeMapUnsorted(b *testing.B) { benchmarkEncodeMap(b, false) }
+
+// blobMarshaler marshals itself as a large, precomputed JSON value.
+type blobMarshaler struct{ json []byte }
+
+func (m *blobMarshaler) MarshalJSON() ([]byte, error) { return m.json, nil }
+
+func benchmarkEncodeMarshalers(b *testing.B, trust bool) {
+	b.ReportAllocs()
+	blob, err := Marshal(map[string]interface{}{
+		"id":    12345,
+		"name":  strings.Repeat("name ", 20),
+		"tags":  []string{"alpha", "beta", "gamma", "delta"},
+		"score": 98.6,
+	})
+	if err != nil {
+		b.Fatal("Marshal:", err)
+	}
+	v := make([]*blobMarshaler, 1000)
+	for i := range v {
+		v[i] = &blobMarshaler{blob}
+	}
+	enc := NewEncoder(ioutil.Discard)
+	enc.SetTrustMarshalJSON(trust)
+	b.SetBytes(int64(len(v) * (len(blob) + 1)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := enc.Encode(v); err != nil {
+			b.Fatal("Encode:", err)
+		}
+	}
+}
+
+func BenchmarkEncodeMarshalers(b *testing.B)       { benchmarkEncodeMarshalers(b, false) }
+func BenchmarkEncodeTrustMarshalJSON(b *testing.B) { benchmarkEncodeMarshalers(b, true) }
+
+type benchRecord struct {
+	ID     int      `json:"id"`
+	Name   string   `json:"name"`
+	Email  string   `json:"email"`
+	Active bool     `json:"active"`
+	Score  float64  `json:"score"`
+	Tags   []string `json:"tags"`
+}
+
+func benchmarkEncodeRecords(b *testing.B, reuse bool) {
+	b.ReportAllocs()
+	records := make([]benchRecord, 1000)
+	for i := range records {
+		records[i] = benchRecord{i, fmt.Sprint("user", i), fmt.Sprint("user", i, "@example.com"), i%2 == 0, float64(i%100) + 0.5, []string{"a", "b"}}
+	}
+	var buf []byte
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf = buf[:0]
+		for j := range records {
+			if reuse {
+				var err error
+				if buf, err = Append(buf, &records[j]); err != nil {
+					b.Fatal("Append:", err)
+				}
+			} else {
+				m, err := Marshal(&records[j])
+				if err != nil {
+					b.Fatal("Marshal:", err)
+				}
+				buf = append(buf, m...)
+			}
+			buf = append(buf, '\n')
+		}
+	}
+	b.SetBytes(int64(len(buf)))
+}
+
+func BenchmarkEncodeRecordsMarshal(b *testing.B) { benchmarkEncodeRecords(b, false) }
+func BenchmarkEncodeRecordsAppend(b *testing.B)  { benchmarkEncodeRecords(b, true) }
+
+func benchmarkEncodeSortKeys(b *testing.B, sortKeys bool) {
+	b.ReportAllocs()
+	records := make([]benchRecord, 1000)
+	for i := range records {
+		records[i] = benchRecord{i, fmt.Sprint("user", i), fmt.Sprint("user", i, "@example.com"), i%2 == 0, float64(i%100) + 0.5, []string{"a", "b"}}
+	}
+	enc := NewEncoder(ioutil.Discard)
+	enc.SetSortKeys(sortKeys)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := enc.Encode(records); err != nil {
+			b.Fatal("Encode:", err)
+		}
+	}
+}
+
+func BenchmarkEncodeDeclaredOrder(b *testing.B) { benchmarkEncodeSortKeys(b, false) }
+func BenchmarkEncodeSortKeys(b *testing.B)      { benchmarkEncodeSortKeys(b, true) }
+
+// namedStringMap and namedIntMap are encoded by the generic map encoder,
+// for comparison with the fast paths for the unnamed map types.
+type (
+	namedStringMap map[string]string
+	namedIntMap    map[string]int
+)
+
+func benchmarkEncodeMapValue(b *testing.B, v interface{}, sortKeys bool) {
+	b.ReportAllocs()
+	enc := NewEncoder(ioutil.Discard)
+	enc.SetSortMapKeys(sortKeys)
+	for i := 0; i < b.N; i++ {
+		if err := enc.Encode(v); err != nil {
+			b.Fatal("Encode:", err)
+		}
+	}
+}
+
+func BenchmarkEncodeMapStringString(b *testing.B) {
+	m := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		m[fmt.Sprint("key", i)] = fmt.Sprint("value", i)
+	}
+	for _, sorted := range []bool{true, false} {
+		b.Run(fmt.Sprint("Fast/Sorted=", sorted), func(b *testing.B) { benchmarkEncodeMapValue(b, m, sorted) })
+		b.Run(fmt.Sprint("Generic/Sorted=", sorted), func(b *testing.B) { benchmarkEncodeMapValue(b, namedStringMap(m), sorted) })
+	}
+}
+
+func BenchmarkEncodeMapStringInt(b *testing.B) {
+	m := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		m[fmt.Sprint("key", i)] = i * 1000
+	}
+	for _, sorted := range []bool{true, false} {
+		b.Run(fmt.Sprint("Fast/Sorted=", sorted), func(b *testing.B) { benchmarkEncodeMapValue(b, m, sorted) })
+		b.Run(fmt.Sprint("Generic/Sorted=", sorted), func(b *testing.B) { benchmarkEncodeMapValue(b, namedIntMap(m), sorted) })
+	}
+}
+
+func BenchmarkEncodeMapStringInterface(b *testing.B) {
+	m := make(map[string]interface{})
+	for i := 0; i < 100; i++ {
+		if i%2 == 0 {
+			m[fmt.Sprint("key", i)] = fmt.Sprint("value", i)
+		} else {
+			m[fmt.Sprint("key", i)] = float64(i)
+		}
+	}
+	for _, sorted := range []bool{true, false} {
+		b.Run(fmt.Sprint("Fast/Sorted=", sorted), func(b *testing.B) { benchmarkEncodeMapValue(b, m, sorted) })
+		// A registered encoder for an unrelated type disables the fast path.
+		b.Run(fmt.Sprint("Generic/Sorted=", sorted), func(b *testing.B) {
+			b.ReportAllocs()
+			enc := NewEncoder(ioutil.Discard)
+			enc.SetSortMapKeys(sorted)
+			enc.RegisterEncoder(reflect.TypeOf(struct{}{}), func(interface{}) ([]byte, error) { return []byte("{}"), nil })
+			for i := 0; i < b.N; i++ {
+				if err := enc.Encode(m); err != nil {
+					b.Fatal("Encode:", err)
+				}
+			}
+		})
+	}
+}
+
 func BenchmarkCodeMarshal(b *testing.B) {
 	b.ReportAllocs()
 	if codeJSON == nil {
@@ -115,6 +294,36 @@ func BenchmarkCodeMarshal(b *testing.B) {
 	b.SetBytes(int64(len(codeJSON)))
 }
 
+func BenchmarkCodeMarshalIndent(b *testing.B) {
+	b.ReportAllocs()
+	if codeJSON == nil {
+		b.StopTimer()
+		codeInit()
+		b.StartTimer()
+	}
+	for i := 0; i < b.N; i++ {
+		if _, err := MarshalIndent(&codeStruct, "", "\t"); err != nil {
+			b.Fatal("MarshalIndent:", err)
+		}
+	}
+	b.SetBytes(int64(len(codeJSON)))
+}
+
+func BenchmarkCodeMarshalIndentTo(b *testing.B) {
+	b.ReportAllocs()
+	if codeJSON == nil {
+		b.StopTimer()
+		codeInit()
+		b.StartTimer()
+	}
+	for i := 0; i < b.N; i++ {
+		if err := MarshalIndentTo(ioutil.Discard, &codeStruct, "", "\t"); err != nil {
+			b.Fatal("MarshalIndentTo:", err)
+		}
+	}
+	b.SetBytes(int64(len(codeJSON)))
+}
+
 func benchMarshalBytes(n int) func(*testing.B) {
 	sample := []byte("hello world")
 	// Use a struct pointer, to avoid an allocation when passing it as an
@@ -208,6 +417,68 @@ func BenchmarkDecoderStream(b *testing.B) {
 	}
 }
 
+func benchmarkDecodeRecords(b *testing.B, intern bool) {
+	b.ReportAllocs()
+	const records = 100000
+	var data bytes.Buffer
+	for i := 0; i < records; i++ {
+		fmt.Fprintf(&data, `{"id":%d,"name":"user%d","email":"user%d@example.com","active":true,"score":%d.5}`+"\n", i, i, i, i%100)
+	}
+	b.SetBytes(int64(data.Len()))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dec := NewDecoder(bytes.NewReader(data.Bytes()))
+		dec.SetInternKeys(intern)
+		for j := 0; j < records; j++ {
+			var m map[string]interface{}
+			if err := dec.Decode(&m); err != nil {
+				b.Fatal("Decode:", err)
+			}
+		}
+	}
+}
+
+func BenchmarkDecodeRecords(b *testing.B)           { benchmarkDecodeRecords(b, false) }
+func BenchmarkDecodeRecordsInternKeys(b *testing.B) { benchmarkDecodeRecords(b, true) }
+
+func benchmarkTokens(b *testing.B, typed bool) {
+	b.ReportAllocs()
+	var data bytes.Buffer
+	data.WriteByte('[')
+	for i := 0; i < 1000; i++ {
+		if i > 0 {
+			data.WriteByte(',')
+		}
+		fmt.Fprintf(&data, `{"id":%d,"name":"user%d","active":true,"score":%d.5,"tags":["a","b"],"ref":null}`, i, i, i%100)
+	}
+	data.WriteByte(']')
+	b.SetBytes(int64(data.Len()))
+	r := bytes.NewReader(nil)
+	dec := NewDecoder(r)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Reset(data.Bytes())
+		dec.Reset(r)
+		for {
+			var err error
+			if typed {
+				_, _, err = dec.TokenTyped()
+			} else {
+				_, err = dec.Token()
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkDecoderToken(b *testing.B)      { benchmarkTokens(b, false) }
+func BenchmarkDecoderTokenTyped(b *testing.B) { benchmarkTokens(b, true) }
+
 func BenchmarkCodeUnmarshal(b *testing.B) {
 	b.ReportAllocs()
 	if codeJSON == nil {
@@ -325,6 +596,60 @@ func BenchmarkUnmapped(b *testing.B) {
 	})
 }
 
+// record20 is a struct with 20 fields of basic types, as in a typical
+// server request.
+type record20 struct {
+	ID       int64   `json:"id"`
+	Name     string  `json:"name"`
+	Email    string  `json:"email"`
+	Age      int     `json:"age"`
+	Active   bool    `json:"active"`
+	Score    float64 `json:"score"`
+	Country  string  `json:"country"`
+	City     string  `json:"city"`
+	Zip      string  `json:"zip"`
+	Street   string  `json:"street"`
+	Phone    string  `json:"phone"`
+	Visits   uint32  `json:"visits"`
+	Balance  float64 `json:"balance"`
+	Verified bool    `json:"verified"`
+	Plan     string  `json:"plan"`
+	Seats    int     `json:"seats"`
+	Referrer string  `json:"referrer"`
+	Locale   string  `json:"locale"`
+	Created  int64   `json:"created"`
+	Updated  int64   `json:"updated"`
+}
+
+var record20JSON = []byte(`{"id":12345,"name":"Gopher","email":"gopher@example.com","age":13,` +
+	`"active":true,"score":98.5,"country":"US","city":"Mountain View","zip":"94043",` +
+	`"street":"1600 Amphitheatre Pkwy","phone":"+1 650 555 0100","visits":42,` +
+	`"balance":-12.75,"verified":false,"plan":"pro","seats":5,"referrer":"",` +
+	`"locale":"en-US","created":1257894000,"updated":1600000000}`)
+
+func BenchmarkUnmarshalStruct20(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(record20JSON)))
+	for i := 0; i < b.N; i++ {
+		var r record20
+		if err := Unmarshal(record20JSON, &r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCompiledDecoderStruct20(b *testing.B) {
+	c := CompileDecoder(reflect.TypeOf(record20{}))
+	b.ReportAllocs()
+	b.SetBytes(int64(len(record20JSON)))
+	for i := 0; i < b.N; i++ {
+		var r record20
+		if err := c.Decode(record20JSON, &r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func BenchmarkTypeFieldsCache(b *testing.B) {
 	b.ReportAllocs()
 	var maxTypes int = 1e6
@@ -0,0 +1,175 @@
+package json
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// An ObjectBuilder writes a JSON object to an io.Writer one member at a time,
+// inserting the braces, commas and colons, as a more convenient alternative to
+// Encoder.WriteToken for code that builds objects programmatically:
+//
+//	b := json.NewObjectBuilder(w)
+//	b.Field("id", 5)
+//	child := b.Object("owner")
+//	child.Field("name", "gopher")
+//	child.End()
+//	b.RawField("extra", raw)
+//	b.End()
+//	err := b.Close()
+//
+// Each member is written to the writer as soon as it is complete. A member
+// that cannot be encoded is not written at all, and the error is returned
+// without affecting the rest of the object. An error from the writer, by
+// contrast, is returned by every later call, since the output is then
+// incomplete.
+//
+// While a nested object begun by Object is open, members may only be added
+// to it, not to its parent. Close reports an error if the outermost object or
+// any nested one has not been ended, so that unbalanced use is detected.
+type ObjectBuilder struct {
+	state   *builderState
+	child   *ObjectBuilder // the most recent nested object, if any
+	started bool           // whether the opening brace has been written
+	ended   bool           // whether End has been called
+}
+
+// builderState is shared by an ObjectBuilder and all its nested objects.
+type builderState struct {
+	w    io.Writer
+	open int   // number of objects not yet ended
+	err  error // error from w, if any
+}
+
+var (
+	errBuilderEnded = errors.New("json: ObjectBuilder used after End")
+	errBuilderChild = errors.New("json: ObjectBuilder used while a nested object is open")
+)
+
+// NewObjectBuilder returns an ObjectBuilder that writes an object to w.
+func NewObjectBuilder(w io.Writer) *ObjectBuilder {
+	return &ObjectBuilder{state: &builderState{w: w, open: 1}}
+}
+
+// Field adds a member with the given key and the JSON encoding of v, as
+// produced by Marshal.
+func (b *ObjectBuilder) Field(key string, v interface{}) error {
+	e, err := b.member(key)
+	if err != nil {
+		return err
+	}
+	if err := e.marshal(v, encOpts{escapeHTML: true, sortMapKeys: true}); err != nil {
+		return err
+	}
+	return b.write(e)
+}
+
+// RawField adds a member with the given key and raw as its value, which must
+// be valid JSON. It is written compacted, with HTML characters escaped as by
+// Marshal; if raw is not valid JSON, RawField returns a *SyntaxError and
+// writes nothing.
+func (b *ObjectBuilder) RawField(key string, raw []byte) error {
+	e, err := b.member(key)
+	if err != nil {
+		return err
+	}
+	if err := compact(e, raw, true); err != nil {
+		e.writer.(*bytes.Buffer).Reset()
+		encodeStatePool.Put(e)
+		return err
+	}
+	return b.write(e)
+}
+
+// Object adds a member with the given key whose value is a nested object, and
+// returns an ObjectBuilder for that object, whose End must be called before
+// any more members are added to b. If b cannot be added to, the error is
+// returned by every method of the nested ObjectBuilder.
+func (b *ObjectBuilder) Object(key string) *ObjectBuilder {
+	child := &ObjectBuilder{state: b.state}
+	e, err := b.member(key)
+	if err == nil {
+		err = b.write(e)
+	}
+	if err != nil {
+		child.state = &builderState{err: err}
+		return child
+	}
+	b.state.open++
+	b.child = child
+	return child
+}
+
+// End writes the closing brace of the object, after which no more members
+// may be added to it.
+func (b *ObjectBuilder) End() error {
+	if err := b.check(); err != nil {
+		return err
+	}
+	e := newEncodeState()
+	if !b.started {
+		e.WriteByte('{')
+	}
+	e.WriteByte('}')
+	b.ended = true
+	b.state.open--
+	return b.write(e)
+}
+
+// Close returns the first error from the underlying writer, if there was one,
+// or an error if the outermost object or any nested object has not been
+// ended. It does not write anything.
+func (b *ObjectBuilder) Close() error {
+	if b.state.err != nil {
+		return b.state.err
+	}
+	if b.state.open > 0 {
+		return errors.New("json: ObjectBuilder closed with an object not ended")
+	}
+	return nil
+}
+
+// check returns an error if no member may be added to b.
+func (b *ObjectBuilder) check() error {
+	switch {
+	case b.state.err != nil:
+		return b.state.err
+	case b.ended:
+		return errBuilderEnded
+	case b.child != nil && !b.child.ended:
+		return errBuilderChild
+	}
+	return nil
+}
+
+// member returns an encodeState holding the separator and key of a new
+// member of b, ready for its value.
+func (b *ObjectBuilder) member(key string) (*encodeState, error) {
+	if err := b.check(); err != nil {
+		return nil, err
+	}
+	e := newEncodeState()
+	if b.started {
+		e.WriteByte(',')
+	} else {
+		e.WriteByte('{')
+	}
+	e.string(key, encOpts{escapeHTML: true})
+	e.WriteByte(':')
+	return e, nil
+}
+
+// write writes the contents of e to the underlying writer, and returns e to
+// the pool.
+func (b *ObjectBuilder) write(e *encodeState) error {
+	buf := e.writer.(*bytes.Buffer)
+	_, err := b.state.w.Write(buf.Bytes())
+	buf.Reset()
+	encodeStatePool.Put(e)
+	b.started = true
+	if err != nil {
+		b.state.err = err
+	}
+	return err
+}
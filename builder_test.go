@@ -0,0 +1,90 @@
+package json
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestObjectBuilder(t *testing.T) {
+	var buf bytes.Buffer
+	b := NewObjectBuilder(&buf)
+	if err := b.Field("id", 5); err != nil {
+		t.Fatalf("Field: %v", err)
+	}
+	child := b.Object("owner")
+	if err := child.Field("name", "<gopher>"); err != nil {
+		t.Fatalf("Field: %v", err)
+	}
+	if err := b.Field("late", 1); err != errBuilderChild {
+		t.Errorf("Field on parent with open child error = %v, want %v", err, errBuilderChild)
+	}
+	if err := child.Object("empty").End(); err != nil {
+		t.Fatalf("End: %v", err)
+	}
+	if err := child.End(); err != nil {
+		t.Fatalf("End: %v", err)
+	}
+	if err := b.RawField("extra", []byte(` [1, {"a": "&"}] `)); err != nil {
+		t.Fatalf("RawField: %v", err)
+	}
+
+	// Invalid members are not written, and do not spoil the object.
+	if _, ok := b.RawField("bad", []byte(`[1,`)).(*SyntaxError); !ok {
+		t.Error("RawField of invalid JSON: expected *SyntaxError")
+	}
+	if _, ok := b.Field("nan", math.NaN()).(*UnsupportedValueError); !ok {
+		t.Error("Field of NaN: expected *UnsupportedValueError")
+	}
+
+	if err := b.Close(); err == nil {
+		t.Error("Close before End: expected error")
+	}
+	if err := b.End(); err != nil {
+		t.Fatalf("End: %v", err)
+	}
+	if err := b.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	const want = `{"id":5,"owner":{"name":"\u003cgopher\u003e","empty":{}},"extra":[1,{"a":"\u0026"}]}`
+	if got := buf.String(); got != want {
+		t.Errorf("output:\n got %s\nwant %s", got, want)
+	}
+	if err := b.Field("after", 1); err != errBuilderEnded {
+		t.Errorf("Field after End error = %v, want %v", err, errBuilderEnded)
+	}
+
+	buf.Reset()
+	b = NewObjectBuilder(&buf)
+	if err := b.End(); err != nil || buf.String() != "{}" {
+		t.Errorf("empty object = %s, %v", buf.String(), err)
+	}
+
+	// A nested object left open is reported by Close.
+	b = NewObjectBuilder(&buf)
+	b.Object("child")
+	b.End()
+	if err := b.Close(); err == nil {
+		t.Error("Close with nested object not ended: expected error")
+	}
+
+	// Errors from the writer are sticky.
+	wantErr := errors.New("full")
+	b = NewObjectBuilder(&errWriter{n: 8, err: wantErr})
+	if err := b.Field("a", 1); err != nil {
+		t.Fatalf("Field: %v", err)
+	}
+	if err := b.Field("b", 2); err != wantErr {
+		t.Errorf("Field error = %v, want %v", err, wantErr)
+	}
+	if err := b.Object("c").End(); err != wantErr {
+		t.Errorf("End of nested object error = %v, want %v", err, wantErr)
+	}
+	if err := b.End(); err != wantErr {
+		t.Errorf("End error = %v, want %v", err, wantErr)
+	}
+	if err := b.Close(); err != wantErr {
+		t.Errorf("Close error = %v, want %v", err, wantErr)
+	}
+}
@@ -23,3 +23,18 @@ func BenchmarkBulkStream(b *testing.B) {
 		_ = enc.Encode(largeSlice)
 	}
 }
+
+func BenchmarkBulkMarshal(b *testing.B) {
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		buf, _ := Marshal(largeSlice)
+		_, _ = ioutil.Discard.Write(buf)
+	}
+}
+
+func BenchmarkBulkMarshalStream(b *testing.B) {
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		_ = MarshalStream(ioutil.Discard, largeSlice)
+	}
+}
@@ -0,0 +1,143 @@
+package json
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"strconv"
+	"unicode/utf16"
+)
+
+// MarshalCanonical returns the canonical JSON encoding of v, as defined by
+// the JSON Canonicalization Scheme (JCS) of RFC 8785. The canonical form is
+// deterministic, making it suitable for hashing and signing.
+//
+// v is first encoded as by Marshal, and the result is then canonicalized:
+// insignificant space is removed; the members of every object, whether it
+// came from a map, a struct or a Marshaler, are sorted by the UTF-16 code
+// units of their keys; strings are escaped only where JSON requires it; and
+// numbers are written as by the ECMAScript Number.prototype.toString method.
+//
+// As RFC 8785 requires, every number is treated as an IEEE 754 double, so
+// integers of magnitude beyond 2^53 may lose precision. A number which
+// overflows a double is an error, as is an object with duplicate keys.
+func MarshalCanonical(v interface{}) ([]byte, error) {
+	e := newEncodeState()
+	if err := e.marshal(v, encOpts{keepLineSeparators: true, sortMapKeys: true}); err != nil {
+		return nil, err
+	}
+	var d decodeState
+	d.init(e.writer.(*bytes.Buffer).Bytes())
+	d.numberMode = NumberModeNumber
+	d.disallowDuplicateKeys = true
+	var tree interface{}
+	err := d.unmarshal(&tree)
+	e.writer.(*bytes.Buffer).Reset()
+	encodeStatePool.Put(e)
+	if err != nil {
+		return nil, err
+	}
+	return appendCanonical(nil, tree)
+}
+
+// appendCanonical appends the canonical encoding of v, a value decoded with
+// NumberModeNumber, to b.
+func appendCanonical(b []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, "null"...), nil
+	case bool:
+		return strconv.AppendBool(b, v), nil
+	case Number:
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil {
+			return nil, &UnsupportedValueError{reflect.ValueOf(v), string(v)}
+		}
+		if f == 0 {
+			// Negative zero is written as 0.
+			return append(b, '0'), nil
+		}
+		return appendFloat(b, f, 64, FloatFormatDefault), nil
+	case string:
+		return appendCanonicalString(b, v), nil
+	case []interface{}:
+		b = append(b, '[')
+		for i, elem := range v {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			var err error
+			if b, err = appendCanonical(b, elem); err != nil {
+				return nil, err
+			}
+		}
+		return append(b, ']'), nil
+	case map[string]interface{}:
+		keys := make([]canonicalKey, 0, len(v))
+		for k := range v {
+			keys = append(keys, canonicalKey{k, utf16.Encode([]rune(k))})
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i].less(keys[j]) })
+		b = append(b, '{')
+		for i, k := range keys {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendCanonicalString(b, k.s)
+			b = append(b, ':')
+			var err error
+			if b, err = appendCanonical(b, v[k.s]); err != nil {
+				return nil, err
+			}
+		}
+		return append(b, '}'), nil
+	}
+	panic(phasePanicMsg)
+}
+
+// A canonicalKey is an object key together with its UTF-16 encoding, by
+// which RFC 8785 orders keys.
+type canonicalKey struct {
+	s     string
+	units []uint16
+}
+
+func (k canonicalKey) less(l canonicalKey) bool {
+	for i := 0; i < len(k.units) && i < len(l.units); i++ {
+		if k.units[i] != l.units[i] {
+			return k.units[i] < l.units[i]
+		}
+	}
+	return len(k.units) < len(l.units)
+}
+
+// appendCanonicalString appends s to b as a JSON string, escaping only the
+// quotation mark, the reverse solidus and the control characters, with the
+// short forms of RFC 8785, section 3.2.2.2, where they exist.
+func appendCanonicalString(b []byte, s string) []byte {
+	b = append(b, '"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '"', '\\':
+			b = append(b, '\\', c)
+		case '\b':
+			b = append(b, '\\', 'b')
+		case '\f':
+			b = append(b, '\\', 'f')
+		case '\n':
+			b = append(b, '\\', 'n')
+		case '\r':
+			b = append(b, '\\', 'r')
+		case '\t':
+			b = append(b, '\\', 't')
+		default:
+			if c < 0x20 {
+				b = append(b, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xF])
+			} else {
+				b = append(b, c)
+			}
+		}
+	}
+	return append(b, '"')
+}
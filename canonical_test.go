@@ -0,0 +1,148 @@
+package json
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+// canonicalNumberTests are the IEEE 754 test vectors of RFC 8785, appendix B.
+var canonicalNumberTests = []struct {
+	bits uint64
+	want string
+}{
+	{0x0000000000000000, "0"},
+	{0x8000000000000000, "0"},
+	{0x0000000000000001, "5e-324"},
+	{0x8000000000000001, "-5e-324"},
+	{0x7fefffffffffffff, "1.7976931348623157e+308"},
+	{0xffefffffffffffff, "-1.7976931348623157e+308"},
+	{0x4340000000000000, "9007199254740992"},
+	{0xc340000000000000, "-9007199254740992"},
+	{0x4430000000000000, "295147905179352830000"},
+	{0x44b52d02c7e14af5, "9.999999999999997e+22"},
+	{0x44b52d02c7e14af6, "1e+23"},
+	{0x44b52d02c7e14af7, "1.0000000000000001e+23"},
+	{0x444b1ae4d6e2ef4e, "999999999999999700000"},
+	{0x444b1ae4d6e2ef4f, "999999999999999900000"},
+	{0x444b1ae4d6e2ef50, "1e+21"},
+	{0x3eb0c6f7a0b5ed8c, "9.999999999999997e-7"},
+	{0x3eb0c6f7a0b5ed8d, "0.000001"},
+	{0x41b3de4355555553, "333333333.3333332"},
+	{0x41b3de4355555554, "333333333.33333325"},
+	{0x41b3de4355555555, "333333333.3333333"},
+	{0x41b3de4355555556, "333333333.3333334"},
+	{0x41b3de4355555557, "333333333.33333343"},
+	{0xbecbf647612f3696, "-0.0000033333333333333333"},
+	{0x43143ff3c1cb0959, "1424953923781206.2"},
+}
+
+func TestMarshalCanonicalNumbers(t *testing.T) {
+	for _, tt := range canonicalNumberTests {
+		f := math.Float64frombits(tt.bits)
+		got, err := MarshalCanonical(f)
+		if err != nil {
+			t.Errorf("MarshalCanonical(%#016x): %v", tt.bits, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("MarshalCanonical(%#016x) = %s, want %s", tt.bits, got, tt.want)
+		}
+		// The same must hold for numbers that arrive in other notations.
+		raw := RawMessage(strconv.FormatFloat(f, 'e', -1, 64))
+		if got, err := MarshalCanonical(raw); err != nil || string(got) != tt.want {
+			t.Errorf("MarshalCanonical(%s) = %s, %v, want %s", raw, got, err, tt.want)
+		}
+	}
+	for _, bits := range []uint64{0x7fffffffffffffff, 0x7ff0000000000000} {
+		if _, err := MarshalCanonical(math.Float64frombits(bits)); err == nil {
+			t.Errorf("MarshalCanonical(%#016x): no error", bits)
+		}
+	}
+	if _, err := MarshalCanonical(RawMessage("1e400")); err == nil {
+		t.Error("MarshalCanonical(1e400): no error")
+	}
+}
+
+var canonicalTests = []struct {
+	name string
+	in   string
+	want string
+}{{
+	// RFC 8785, section 3.2.2.
+	name: "sample",
+	in: `{
+  "numbers": [333333333.33333329, 1E30, 4.50, 2e-3, 0.000000000000000000000000001],
+  "string": "\u20ac$\u000F\u000aA'\u0042\u0022\u005c\\\"\/",
+  "literals": [null, true, false]
+}`,
+	want: "{\"literals\":[null,true,false],\"numbers\":[333333333.3333333,1e+30,4.5,0.002,1e-27],\"string\":\"\u20ac$\\u000f\\nA'B\\\"\\\\\\\\\\\"/\"}",
+}, {
+	// RFC 8785, section 3.2.3.
+	name: "sorting",
+	in: `{
+  "\u20ac": "Euro Sign",
+  "\r": "Carriage Return",
+  "\ufb33": "Hebrew Letter Dalet With Dagesh",
+  "1": "One",
+  "\ud83d\ude00": "Emoji: Grinning Face",
+  "\u0080": "Control",
+  "\u00f6": "Latin Small Letter O With Diaeresis"
+}`,
+	want: "{\"\\r\":\"Carriage Return\",\"1\":\"One\",\"\u0080\":\"Control\",\"\u00f6\":\"Latin Small Letter O With Diaeresis\",\"\u20ac\":\"Euro Sign\",\"\U0001f600\":\"Emoji: Grinning Face\",\"\ufb33\":\"Hebrew Letter Dalet With Dagesh\"}",
+}, {
+	name: "nested",
+	in:   `{"b":[{"z":1,"a":{"y":2,"x":3}}],"a":"<&>"}`,
+	want: `{"a":"<&>","b":[{"a":{"x":3,"y":2},"z":1}]}`,
+}, {
+	name: "line separators",
+	in:   `"\u2028\u2029"`,
+	want: "\"\u2028\u2029\"",
+}}
+
+func TestMarshalCanonical(t *testing.T) {
+	for _, tt := range canonicalTests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MarshalCanonical(RawMessage(tt.in))
+			if err != nil {
+				t.Fatalf("MarshalCanonical: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("MarshalCanonical:\n\tgot:  %s\n\twant: %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarshalCanonicalStruct(t *testing.T) {
+	type inner struct {
+		Zeta  int     `json:"zeta"`
+		Alpha float64 `json:"alpha"`
+	}
+	v := struct {
+		Name  string           `json:"name"`
+		Inner inner            `json:"inner"`
+		Map   map[string]int   `json:"map"`
+		Raw   RawMessage       `json:"raw"`
+		Any   interface{}      `json:"any"`
+		Big   map[string]inner `json:"big,omitempty"`
+	}{
+		Name:  "x",
+		Inner: inner{Zeta: 1, Alpha: 2.50},
+		Map:   map[string]int{"b": 2, "a": 1},
+		Raw:   RawMessage(`{ "q" : 1.0 , "p" : [ ] }`),
+		Any:   []interface{}{1e21, -0.0},
+	}
+	got, err := MarshalCanonical(v)
+	if err != nil {
+		t.Fatalf("MarshalCanonical: %v", err)
+	}
+	want := `{"any":[1e+21,0],"inner":{"alpha":2.5,"zeta":1},"map":{"a":1,"b":2},"name":"x","raw":{"p":[],"q":1}}`
+	if string(got) != want {
+		t.Errorf("MarshalCanonical:\n\tgot:  %s\n\twant: %s", got, want)
+	}
+
+	if _, err := MarshalCanonical(RawMessage(`{"a":1,"a":2}`)); err == nil {
+		t.Error("MarshalCanonical of duplicate keys: no error")
+	}
+}
@@ -0,0 +1,233 @@
+package json
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// A CompiledDecoder decodes JSON into values of a single struct type, using
+// a decoding plan worked out in advance by CompileDecoder. It is intended for
+// programs, such as servers with stable schemas, that decode many values of
+// the same type: the plan saves looking up the type's fields for each object,
+// and stores fields of basic types without the general-purpose dispatch the
+// decoder otherwise goes through for every value.
+//
+// Decoding with a CompiledDecoder has exactly the same result as Unmarshal,
+// including any error. A CompiledDecoder is safe for use by multiple
+// goroutines simultaneously.
+type CompiledDecoder struct {
+	typ    reflect.Type
+	fields structFields
+	plan   []compiledField // parallel to fields.list; nil if Unmarshal must be used
+}
+
+// A compiledField describes how a field of a compiled struct is decoded.
+type compiledField struct {
+	name  string
+	index []int
+	kind  reflect.Kind // kind stored directly from a literal, or Invalid
+}
+
+// CompileDecoder returns a CompiledDecoder for values of type t. It should be
+// called once, typically at startup, and the result reused.
+//
+// Only the fields of t itself are compiled; nested values are decoded as by
+// Unmarshal. If t is not a struct type, or decoding it involves features the
+// plan does not cover, such as an UnmarshalJSON method, embedded struct
+// pointers, or tag options other than omitempty and the field name, the
+// CompiledDecoder simply calls Unmarshal.
+func CompileDecoder(t reflect.Type) *CompiledDecoder {
+	c := &CompiledDecoder{typ: t}
+	if t.Kind() != reflect.Struct || reflect.PtrTo(t).NumMethod() > 0 {
+		return c
+	}
+	fields := cachedTypeFields(t)
+	if fields.err != nil || fields.hasDefaults || fields.raw != nil || fields.unknown != nil {
+		return c
+	}
+	plan := make([]compiledField, len(fields.list))
+	for i := range fields.list {
+		f := &fields.list[i]
+		if f.quoted || f.layout != "" || f.unit != "" || f.boolNumeric || f.nullEmpty || f.textNumber {
+			return c
+		}
+		ft := t
+		for j, x := range f.index {
+			if j > 0 && ft.Kind() == reflect.Ptr {
+				return c
+			}
+			ft = ft.Field(x).Type
+		}
+		cf := compiledField{name: f.name, index: f.index}
+		if reflect.PtrTo(ft).NumMethod() == 0 {
+			switch k := ft.Kind(); k {
+			case reflect.Bool, reflect.String,
+				reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+				reflect.Float32, reflect.Float64:
+				cf.kind = k
+			}
+		}
+		plan[i] = cf
+	}
+	c.fields = fields
+	c.plan = plan
+	return c
+}
+
+// Decode parses the JSON-encoded data and stores the result in the value
+// pointed to by v, which must be a pointer to the type given to
+// CompileDecoder.
+func (c *CompiledDecoder) Decode(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return &InvalidUnmarshalError{reflect.TypeOf(v)}
+	}
+	if rv.Type().Elem() != c.typ {
+		return fmt.Errorf("json: CompiledDecoder for %v cannot decode into %v", c.typ, rv.Type())
+	}
+	if c.plan == nil {
+		return Unmarshal(data, v)
+	}
+
+	var d decodeState
+	if err := checkValid(data, &d.scan); err != nil {
+		return err
+	}
+	d.init(data)
+	d.scan.reset()
+	d.scanWhile(scanSkipSpace)
+	var err error
+	if d.opcode == scanBeginObject {
+		if err = c.object(&d, rv.Elem()); err == nil {
+			d.scanNext()
+		}
+	} else {
+		err = d.value(rv)
+	}
+	if err != nil {
+		return d.addErrorContext(err)
+	}
+	return d.savedError
+}
+
+// object consumes an object from d.data[d.off-1:], decoding into v, as
+// decodeState.object does. The first byte of the object ('{') has been read
+// already.
+func (c *CompiledDecoder) object(d *decodeState, v reflect.Value) error {
+	for {
+		// Read opening " of string key or closing }.
+		d.scanWhile(scanSkipSpace)
+		if d.opcode == scanEndObject {
+			// closing } - can only happen on first iteration.
+			break
+		}
+		if d.opcode != scanBeginLiteral {
+			panic(phasePanicMsg)
+		}
+
+		// Read key.
+		start := d.readIndex()
+		d.rescanLiteral()
+		key, ok := unquoteBytes(d.data[start:d.readIndex()])
+		if !ok {
+			panic(phasePanicMsg)
+		}
+		var cf *compiledField
+		if i := c.fields.match(key, false); i >= 0 {
+			cf = &c.plan[i]
+		}
+
+		// Read : before value.
+		if d.opcode == scanSkipSpace {
+			d.scanWhile(scanSkipSpace)
+		}
+		if d.opcode != scanObjectKey {
+			panic(phasePanicMsg)
+		}
+		d.scanWhile(scanSkipSpace)
+
+		if cf == nil {
+			if err := d.value(reflect.Value{}); err != nil {
+				return err
+			}
+		} else {
+			d.errorContext.FieldStack = append(d.errorContext.FieldStack[:0], cf.name)
+			d.errorContext.Struct = c.typ
+			subv := v
+			for _, i := range cf.index {
+				subv = subv.Field(i)
+			}
+			if err := c.field(d, cf, subv); err != nil {
+				return err
+			}
+			d.errorContext.FieldStack = d.errorContext.FieldStack[:0]
+			d.errorContext.Struct = nil
+		}
+
+		// Next token must be , or }.
+		if d.opcode == scanSkipSpace {
+			d.scanWhile(scanSkipSpace)
+		}
+		if d.opcode == scanEndObject {
+			break
+		}
+		if d.opcode != scanObjectValue {
+			panic(phasePanicMsg)
+		}
+	}
+	return nil
+}
+
+// field decodes the value beginning at d.data[d.off-1] into v, the field
+// described by cf. A literal of the kind the field holds is stored directly;
+// anything else is left to the general decoder.
+func (c *CompiledDecoder) field(d *decodeState, cf *compiledField, v reflect.Value) error {
+	if cf.kind == reflect.Invalid || d.opcode != scanBeginLiteral {
+		return d.value(v)
+	}
+	start := d.readIndex()
+	d.rescanLiteral()
+	item := d.data[start:d.readIndex()]
+	switch b := item[0]; cf.kind {
+	case reflect.Bool:
+		if b == 't' || b == 'f' {
+			v.SetBool(b == 't')
+			return nil
+		}
+	case reflect.String:
+		if b == '"' {
+			s, ok := unquoteBytes(item)
+			if !ok {
+				panic(phasePanicMsg)
+			}
+			v.SetString(string(s))
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if b == '-' || '0' <= b && b <= '9' {
+			if n, err := strconv.ParseInt(string(item), 10, 64); err == nil && !v.OverflowInt(n) {
+				v.SetInt(n)
+				return nil
+			}
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if '0' <= b && b <= '9' {
+			if n, err := strconv.ParseUint(string(item), 10, 64); err == nil && !v.OverflowUint(n) {
+				v.SetUint(n)
+				return nil
+			}
+		}
+	case reflect.Float32, reflect.Float64:
+		if b == '-' || '0' <= b && b <= '9' {
+			if n, err := strconv.ParseFloat(string(item), v.Type().Bits()); err == nil && !v.OverflowFloat(n) {
+				v.SetFloat(n)
+				return nil
+			}
+		}
+	}
+	// Let literalStore deal with null, mismatched types and out of range
+	// numbers, reporting errors just as Unmarshal would.
+	return d.literalStore(item, start, v, false)
+}
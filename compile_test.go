@@ -0,0 +1,99 @@
+package json
+
+import (
+	"reflect"
+	"testing"
+)
+
+type compiledInner struct {
+	X int
+}
+
+type CompiledEmbed struct {
+	E string
+}
+
+type compiledStruct struct {
+	CompiledEmbed
+	B     bool
+	S     string `json:"s"`
+	I     int8
+	U     uint16
+	F     float32
+	F64   float64
+	N     Number
+	P     *int
+	Inner compiledInner
+	List  []string
+	Any   interface{}
+	Skip  string `json:"-"`
+}
+
+var compiledDecoderTests = []string{
+	`{}`,
+	`null`,
+	`{"B":true,"s":"aé\n","I":-5,"U":7,"F":1.5,"F64":-2e10,"N":12.50,"P":3,"Inner":{"X":4},"List":["x"],"Any":{"k":[1]},"E":"emb"}`,
+	`{"b":false,"S":"folded","i":1,"inner":{"x":2},"unknown":[1,{"a":2}]}`,
+	`{"B":null,"s":null,"I":null,"P":null,"List":null}`,
+	`{"I":300,"B":1,"U":-1}`,
+	`{"s":1,"F":"x","Inner":{"X":"y"}}`,
+	`{"F":1e100,"I":1.5}`,
+	`{"Skip":"no","-":"no"}`,
+	`[1,2]`,
+	`"str"`,
+	`{"B":true,}`,
+	`{"s":"a"} x`,
+}
+
+func TestCompiledDecoder(t *testing.T) {
+	c := CompileDecoder(reflect.TypeOf(compiledStruct{}))
+	if c.plan == nil {
+		t.Fatal("CompileDecoder did not compile a plan")
+	}
+	for _, in := range compiledDecoderTests {
+		var want, got compiledStruct
+		want.S, got.S = "prev", "prev"
+		wantErr := Unmarshal([]byte(in), &want)
+		err := c.Decode([]byte(in), &got)
+		if !reflect.DeepEqual(err, wantErr) {
+			t.Errorf("%s: Decode error = %v, want %v", in, err, wantErr)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: Decode = %+v, want %+v", in, got, want)
+		}
+	}
+
+	var wrong struct{}
+	if err := c.Decode([]byte(`{}`), &wrong); err == nil {
+		t.Error("Decode into another type: no error")
+	}
+	if err := c.Decode([]byte(`{}`), compiledStruct{}); err == nil {
+		t.Error("Decode into a non-pointer: no error")
+	}
+}
+
+func TestCompiledDecoderFallback(t *testing.T) {
+	type quoted struct {
+		A int `json:",string"`
+	}
+	for _, tc := range []struct {
+		v  interface{}
+		in string
+	}{
+		{new(quoted), `{"A":"5"}`},
+		{new(map[string]int), `{"a":1}`},
+		{new(unmarshalerText), `"a:b"`},
+	} {
+		typ := reflect.TypeOf(tc.v).Elem()
+		c := CompileDecoder(typ)
+		if c.plan != nil {
+			t.Errorf("%v: compiled a plan, want fallback to Unmarshal", typ)
+		}
+		got, want := reflect.New(typ), reflect.New(typ)
+		err := c.Decode([]byte(tc.in), got.Interface())
+		wantErr := Unmarshal([]byte(tc.in), want.Interface())
+		if !reflect.DeepEqual(err, wantErr) || !reflect.DeepEqual(got.Interface(), want.Interface()) {
+			t.Errorf("%v: Decode = %v, %v, want %v, %v", typ, got.Elem(), err, want.Elem(), wantErr)
+		}
+	}
+}
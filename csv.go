@@ -0,0 +1,98 @@
+package json
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"strconv"
+)
+
+// A RecordError describes an error in one element of the array read by
+// ToCSV.
+type RecordError struct {
+	Index int   // index of the element within the array
+	Err   error // the error in the element
+}
+
+func (e *RecordError) Error() string {
+	return "json: record " + strconv.Itoa(e.Index) + ": " + e.Err.Error()
+}
+
+func (e *RecordError) Unwrap() error { return e.Err }
+
+// ToCSV reads a JSON array of objects from r and writes it to w as CSV, with
+// a header row holding columns followed by a row for each object, holding
+// the values of its members with those keys. The array is read one object at
+// a time, so that it need not fit in memory.
+//
+// A string is written unquoted, and a number, true or false as its JSON text.
+// A nested array or object is written as its compacted JSON encoding. A
+// missing member or a null leaves the cell empty. If an object has duplicate
+// keys, the last is used.
+//
+// An error in an element of the array, such as an element which is not an
+// object, is returned as a *RecordError giving its index. Rows before it have
+// been written to w.
+func ToCSV(w io.Writer, r io.Reader, columns []string) error {
+	dec := NewDecoder(r)
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != Delim('[') {
+		return errors.New("json: ToCSV input is not an array")
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(columns); err != nil {
+		return err
+	}
+	row := make([]string, len(columns))
+	for i := 0; dec.More(); i++ {
+		var obj map[string]RawMessage
+		if err := dec.Decode(&obj); err != nil {
+			cw.Flush()
+			return &RecordError{i, err}
+		}
+		if obj == nil {
+			cw.Flush()
+			return &RecordError{i, errors.New("null is not an object")}
+		}
+		for j, col := range columns {
+			row[j] = csvCell(obj[col])
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		cw.Flush()
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvCell returns the text of the CSV cell holding raw.
+func csvCell(raw RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	switch raw[0] {
+	case 'n':
+		return ""
+	case '"':
+		s, ok := unquote(raw)
+		if !ok {
+			return string(raw)
+		}
+		return s
+	case '{', '[':
+		var buf bytes.Buffer
+		if err := compact(&buf, raw, false); err != nil {
+			return string(raw)
+		}
+		return buf.String()
+	}
+	return string(raw)
+}
@@ -0,0 +1,54 @@
+package json
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestToCSV(t *testing.T) {
+	const in = `[
+		{"id": 1, "name": "a, \"b\"", "ok": true, "tags": [1, 2]},
+		{"name": "c", "id": 2.5e3, "extra": "ignored", "tags": {"x": null}},
+		{"id": null},
+		{}
+	]`
+	var buf bytes.Buffer
+	if err := ToCSV(&buf, strings.NewReader(in), []string{"id", "name", "ok", "tags"}); err != nil {
+		t.Fatalf("ToCSV: %v", err)
+	}
+	const want = "id,name,ok,tags\n" +
+		"1,\"a, \"\"b\"\"\",true,\"[1,2]\"\n" +
+		"2.5e3,c,,\"{\"\"x\"\":null}\"\n" +
+		",,,\n" +
+		",,,\n"
+	if got := buf.String(); got != want {
+		t.Errorf("ToCSV =\n%s\nwant\n%s", got, want)
+	}
+
+	tests := []struct {
+		in    string
+		index int
+		rows  string
+	}{
+		{`[{"id":1}, 5]`, 1, "id\n1\n"},
+		{`[null]`, 0, "id\n"},
+		{`[{"id":1}, {"id" 2}]`, 1, "id\n1\n"},
+	}
+	for _, tt := range tests {
+		buf.Reset()
+		err := ToCSV(&buf, strings.NewReader(tt.in), []string{"id"})
+		var rerr *RecordError
+		if !errors.As(err, &rerr) || rerr.Index != tt.index {
+			t.Errorf("ToCSV(%s) error = %v, want a RecordError for index %d", tt.in, err, tt.index)
+		}
+		if buf.String() != tt.rows {
+			t.Errorf("ToCSV(%s) wrote %q, want %q", tt.in, buf.String(), tt.rows)
+		}
+	}
+
+	if err := ToCSV(&buf, strings.NewReader(`{"id":1}`), []string{"id"}); err == nil {
+		t.Error("ToCSV of an object: expected error")
+	}
+}
@@ -11,9 +11,13 @@ import (
 	"encoding"
 	"encoding/base64"
 	"fmt"
+	"math"
+	"math/big"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 	"unicode"
 	"unicode/utf16"
 	"unicode/utf8"
@@ -35,29 +39,55 @@ import (
 //
 // To unmarshal JSON into a value implementing the Unmarshaler interface,
 // Unmarshal calls that value's UnmarshalJSON method, including
-// when the input is a JSON null.
+// when the input is a JSON null. If the value implements UnmarshalerAt,
+// Unmarshal calls its UnmarshalJSONAt method instead.
 // Otherwise, if the value implements encoding.TextUnmarshaler
 // and the input is a JSON quoted string, Unmarshal calls that value's
 // UnmarshalText method with the unquoted form of the string.
+// Failing both, if the value implements encoding.BinaryUnmarshaler
+// and the input is a JSON quoted string, Unmarshal base64-decodes the
+// unquoted string and calls that value's UnmarshalBinary method with
+// the result.
+//
+// A JSON number unmarshaled into a big.Float is parsed directly at a
+// precision large enough to hold all of its digits, unless the
+// big.Float already has a precision set.
+//
+// The nullable types of package database/sql, such as sql.NullString and
+// sql.Null[T], are decoded natively: unmarshaling the JSON null value sets
+// Valid to false and the value held to its zero value, and unmarshaling any
+// other JSON value stores it in the value held and sets Valid to true.
+//
+// To unmarshal a JSON object into a sync.Map, Unmarshal decodes each member
+// as into a map[string]interface{} and adds it to the sync.Map with Store,
+// keyed by its string key, keeping any entries already present. Since only
+// Store is used, the sync.Map may be accessed concurrently while it is being
+// filled. Unmarshaling null into a sync.Map is a no-op.
 //
 // To unmarshal JSON into a struct, Unmarshal matches incoming object
 // keys to the keys used by Marshal (either the struct field name or its tag),
 // preferring an exact match but also accepting a case-insensitive match. By
 // default, object keys which don't have a corresponding struct field are
-// ignored (see Decoder.DisallowUnknownFields for an alternative).
+// ignored (see Decoder.DisallowUnknownFields and the "unknown" tag option,
+// described in the documentation for Marshal, for alternatives). Fields with
+// the "default" tag option, described in the documentation for Marshal, are
+// set to their default when their key is absent.
 //
 // To unmarshal JSON into an interface value,
 // Unmarshal stores one of these in the interface value:
 //
 //	bool, for JSON booleans
-//	float64, for JSON numbers
+//	float64, for JSON numbers (but see Decoder.SetNumberMode)
 //	string, for JSON strings
 //	[]interface{}, for JSON arrays
 //	map[string]interface{}, for JSON objects
 //	nil for JSON null
 //
 // To unmarshal a JSON array into a slice, Unmarshal resets the slice length
-// to zero and then appends each element to the slice.
+// to zero and then appends each element to the slice. If the slice has enough
+// capacity, its backing array is reused rather than reallocated; each element
+// is set to its zero value before being decoded into, so nothing from
+// the slice's previous contents survives.
 // As a special case, to unmarshal an empty JSON array into a slice,
 // Unmarshal replaces the slice with a new empty slice.
 //
@@ -73,7 +103,7 @@ import (
 // reuses the existing map, keeping existing entries. Unmarshal then stores
 // key-value pairs from the JSON object into the map. The map's key type must
 // either be any string type, an integer, implement json.Unmarshaler, or
-// implement encoding.TextUnmarshaler.
+// implement encoding.TextUnmarshaler or encoding.BinaryUnmarshaler.
 //
 // If a JSON value is not appropriate for a given target type,
 // or if a JSON number overflows the target type, Unmarshal
@@ -119,6 +149,35 @@ type Unmarshaler interface {
 	UnmarshalJSON([]byte) error
 }
 
+// UnmarshalerAt is an optional alternative to Unmarshaler for types that
+// want to know where their JSON description appears in the input, for
+// example to report errors anchored to the source. If a value implements
+// UnmarshalerAt, Unmarshal calls its UnmarshalJSONAt method instead of
+// UnmarshalJSON, with the same data and the offset of its first byte within
+// the overall input: the argument to Unmarshal, or the whole stream read by
+// a Decoder. The same conventions apply as for UnmarshalJSON.
+type UnmarshalerAt interface {
+	UnmarshalJSONAt(data []byte, offset int64) error
+}
+
+// unmarshalerAt adapts an UnmarshalerAt that does not also implement
+// Unmarshaler so that it can be returned by indirect.
+type unmarshalerAt struct {
+	UnmarshalerAt
+}
+
+func (u unmarshalerAt) UnmarshalJSON(data []byte) error {
+	return u.UnmarshalJSONAt(data, -1)
+}
+
+// callUnmarshaler calls u with data, which begins at d.data[start].
+func (d *decodeState) callUnmarshaler(u Unmarshaler, data []byte, start int) error {
+	if u, ok := u.(UnmarshalerAt); ok {
+		return u.UnmarshalJSONAt(data, d.baseOffset+int64(start))
+	}
+	return u.UnmarshalJSON(data)
+}
+
 // An UnmarshalTypeError describes a JSON value that was
 // not appropriate for a value of a specific Go type.
 type UnmarshalTypeError struct {
@@ -150,6 +209,17 @@ func (e *UnmarshalFieldError) Error() string {
 	return "json: cannot unmarshal object key " + strconv.Quote(e.Key) + " into unexported field " + e.Field.Name + " of type " + e.Type.String()
 }
 
+// A DuplicateKeyError is returned by a Decoder configured with
+// DisallowDuplicateKeys when a JSON object contains the same key more than once.
+type DuplicateKeyError struct {
+	Key    string // the repeated key, after unescaping
+	Offset int64  // offset of the repeated key's opening quote
+}
+
+func (e *DuplicateKeyError) Error() string {
+	return "json: duplicate object key " + strconv.Quote(e.Key) + " at offset " + strconv.FormatInt(e.Offset, 10)
+}
+
 // An InvalidUnmarshalError describes an invalid argument passed to Unmarshal.
 // (The argument to Unmarshal must be a non-nil pointer.)
 type InvalidUnmarshalError struct {
@@ -173,6 +243,15 @@ func (d *decodeState) unmarshal(v interface{}) error {
 		return &InvalidUnmarshalError{reflect.TypeOf(v)}
 	}
 
+	if d.disallowDuplicateKeys {
+		d.scan.reset()
+		d.scanWhile(scanSkipSpace)
+		if err := d.checkDuplicateKeys(); err != nil {
+			return err
+		}
+		d.off = 0
+	}
+
 	d.scan.reset()
 	d.scanWhile(scanSkipSpace)
 	// We decode rv not rv.Elem because the Unmarshaler interface
@@ -200,6 +279,25 @@ func (n Number) Int64() (int64, error) {
 	return strconv.ParseInt(string(n), 10, 64)
 }
 
+// A NumberMode selects the Go type used for JSON numbers when decoding into
+// an interface{} value, including values nested at any depth within
+// []interface{} and map[string]interface{}. It does not affect numbers
+// decoded into values of any other type.
+type NumberMode int
+
+const (
+	// NumberModeFloat64 decodes every number as a float64. This is the default.
+	NumberModeFloat64 NumberMode = iota
+
+	// NumberModeNumber decodes every number as a Number, as UseNumber does.
+	NumberModeNumber
+
+	// NumberModeSmartInt decodes an integer, that is, a number with no
+	// fraction or exponent, as an int64 if it is within the range of int64.
+	// Any other number is decoded as a Number, so no precision is lost.
+	NumberModeSmartInt
+)
+
 // decodeState represents the state while decoding a JSON value.
 type decodeState struct {
 	data         []byte
@@ -211,8 +309,19 @@ type decodeState struct {
 		FieldStack []string
 	}
 	savedError            error
-	useNumber             bool
+	numberMode            NumberMode
 	disallowUnknownFields bool
+	disallowDuplicateKeys bool
+	strictTypes           bool
+	emptyStringAsNull     bool
+	caseSensitive         bool
+	disallowLossyNumbers  bool
+	orderedObjects        bool              // whether valueInterface decodes objects as *OrderedMap
+	internKeys            map[string]string // if non-nil, object keys decoded so far
+	baseOffset            int64             // offset of data within the overall input
+
+	decoders map[reflect.Type]func(data []byte, v interface{}) error // registered by Decoder.RegisterDecoder
+	nullSink func(path string)                                       // set by Decoder.SetTrackNulls
 }
 
 // readIndex returns the position of the last byte read.
@@ -328,6 +437,12 @@ Switch:
 			switch data[i] {
 			case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9',
 				'.', 'e', 'E', '+', '-':
+			case 'x', 'X', 'o', 'O', 'a', 'A', 'b', 'B', 'c', 'C', 'd', 'D', 'f', 'F':
+				// The data has been validated, so these can only be
+				// part of an extended number.
+				if !d.scan.allowExtendedNumbers {
+					break Switch
+				}
 			default:
 				break Switch
 			}
@@ -351,6 +466,28 @@ Switch:
 // reads the following byte ahead. If v is invalid, the value is discarded.
 // The first byte of the value has been read already.
 func (d *decodeState) value(v reflect.Value) error {
+	if d.decoders != nil && v.IsValid() {
+		if fn, pv := d.customDecoder(v); fn != nil {
+			return d.decodeCustom(fn, pv)
+		}
+	}
+	if k := v.Kind(); k == reflect.Struct || k == reflect.Ptr {
+		switch cachedNativeType(v.Type()) {
+		case nativeSQLNull:
+			if done, err := d.sqlNullValue(v); done {
+				return err
+			}
+		case nativeSyncMap:
+			if done, err := d.syncMapValue(v); done {
+				return err
+			}
+		case nativeOrderedMap:
+			if done, err := d.orderedMapValue(v); done {
+				return err
+			}
+		}
+	}
+
 	switch d.opcode {
 	default:
 		panic(phasePanicMsg)
@@ -381,7 +518,7 @@ func (d *decodeState) value(v reflect.Value) error {
 		d.rescanLiteral()
 
 		if v.IsValid() {
-			if err := d.literalStore(d.data[start:d.readIndex()], v, false); err != nil {
+			if err := d.literalStore(d.data[start:d.readIndex()], start, v, false); err != nil {
 				return err
 			}
 		}
@@ -389,6 +526,113 @@ func (d *decodeState) value(v reflect.Value) error {
 	return nil
 }
 
+// A nativeType classifies the types, and pointers to them, that value decodes
+// itself rather than according to their kind.
+type nativeType uint8
+
+const (
+	nativeNone       nativeType = iota
+	nativeSQLNull               // a nullable type of package database/sql
+	nativeSyncMap               // sync.Map
+	nativeOrderedMap            // OrderedMap
+)
+
+var nativeTypeCache sync.Map // map[reflect.Type]nativeType
+
+// cachedNativeType is like nativeTypeOf but uses a cache to avoid repeated
+// work.
+func cachedNativeType(t reflect.Type) nativeType {
+	if n, ok := nativeTypeCache.Load(t); ok {
+		return n.(nativeType)
+	}
+	n, _ := nativeTypeCache.LoadOrStore(t, nativeTypeOf(t))
+	return n.(nativeType)
+}
+
+// nativeTypeOf returns the nativeType of t, a struct or pointer type.
+func nativeTypeOf(t reflect.Type) nativeType {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case isSQLNull(t):
+		return nativeSQLNull
+	case t == syncMapType:
+		return nativeSyncMap
+	case t == orderedMapType:
+		return nativeOrderedMap
+	}
+	return nativeNone
+}
+
+// sqlNullValue decodes the value beginning at d.data[d.off-1] into v, which
+// is, or points to, one of the nullable types of package database/sql, and
+// reports true. Null decoded into a pointer is left to the general decoder,
+// which sets it to nil; sqlNullValue then reports false, having consumed
+// nothing.
+func (d *decodeState) sqlNullValue(v reflect.Value) (bool, error) {
+	t := v.Type()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	isNull := d.opcode == scanBeginLiteral && d.data[d.readIndex()] == 'n'
+	if isNull && v.Kind() == reflect.Ptr && v.CanSet() {
+		return false, nil
+	}
+	_, _, pv := indirect(v, false)
+	if isNull {
+		d.rescanLiteral()
+		pv.Set(reflect.Zero(t))
+		return true, nil
+	}
+	saved := d.savedError
+	if err := d.value(pv.Field(0)); err != nil {
+		return true, err
+	}
+	// Mark the value valid only if it was stored without error.
+	if d.savedError == saved {
+		pv.Field(1).SetBool(true)
+	}
+	return true, nil
+}
+
+// customDecoder returns the function registered in d.decoders for the type of
+// v, or of the value v points to, along with a pointer to the value to decode
+// into. A nil pointer v is set to point to a new value.
+func (d *decodeState) customDecoder(v reflect.Value) (func([]byte, interface{}) error, reflect.Value) {
+	if fn, ok := d.decoders[v.Type()]; ok && v.CanAddr() && v.CanInterface() {
+		return fn, v.Addr()
+	}
+	if v.Kind() == reflect.Ptr && v.CanInterface() {
+		if fn, ok := d.decoders[v.Type().Elem()]; ok {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return nil, reflect.Value{}
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			return fn, v
+		}
+	}
+	return nil, reflect.Value{}
+}
+
+// decodeCustom consumes a JSON value from d.data[d.off-1:], like value, and
+// passes it to fn to be decoded into pv.
+func (d *decodeState) decodeCustom(fn func([]byte, interface{}) error, pv reflect.Value) error {
+	start := d.readIndex()
+	var data []byte
+	if d.opcode == scanBeginLiteral {
+		d.rescanLiteral()
+		data = d.data[start:d.readIndex()]
+	} else {
+		d.skip()
+		data = d.data[start:d.off]
+		d.scanNext()
+	}
+	return fn(data, pv.Interface())
+}
+
 type unquotedValue struct{}
 
 // valueQuoted is like value but decodes a
@@ -475,10 +719,16 @@ func indirect(v reflect.Value, decodingNull bool) (Unmarshaler, encoding.TextUnm
 			if u, ok := v.Interface().(Unmarshaler); ok {
 				return u, nil, reflect.Value{}
 			}
+			if u, ok := v.Interface().(UnmarshalerAt); ok {
+				return unmarshalerAt{u}, nil, reflect.Value{}
+			}
 			if !decodingNull {
 				if u, ok := v.Interface().(encoding.TextUnmarshaler); ok {
 					return nil, u, reflect.Value{}
 				}
+				if u, ok := v.Interface().(encoding.BinaryUnmarshaler); ok {
+					return nil, binaryTextUnmarshaler{u}, reflect.Value{}
+				}
 			}
 		}
 
@@ -492,6 +742,21 @@ func indirect(v reflect.Value, decodingNull bool) (Unmarshaler, encoding.TextUnm
 	return nil, nil, v
 }
 
+// binaryTextUnmarshaler adapts an encoding.BinaryUnmarshaler to
+// encoding.TextUnmarshaler, base64-decoding the text it is given.
+type binaryTextUnmarshaler struct {
+	u encoding.BinaryUnmarshaler
+}
+
+func (b binaryTextUnmarshaler) UnmarshalText(text []byte) error {
+	data := make([]byte, base64.StdEncoding.DecodedLen(len(text)))
+	n, err := base64.StdEncoding.Decode(data, text)
+	if err != nil {
+		return err
+	}
+	return b.u.UnmarshalBinary(data[:n])
+}
+
 // array consumes an array from d.data[d.off-1:], decoding into v.
 // The first byte of the array ('[') has been read already.
 func (d *decodeState) array(v reflect.Value) error {
@@ -500,7 +765,7 @@ func (d *decodeState) array(v reflect.Value) error {
 	if u != nil {
 		start := d.readIndex()
 		d.skip()
-		return u.UnmarshalJSON(d.data[start:d.off])
+		return d.callUnmarshaler(u, d.data[start:d.off], start)
 	}
 	if ut != nil {
 		d.saveError(&UnmarshalTypeError{Value: "array", Type: v.Type(), Offset: int64(d.off)})
@@ -528,6 +793,7 @@ func (d *decodeState) array(v reflect.Value) error {
 		break
 	}
 
+	z := reflect.Zero(v.Type().Elem())
 	i := 0
 	for {
 		// Look ahead for ] - can only happen on first iteration.
@@ -551,6 +817,9 @@ func (d *decodeState) array(v reflect.Value) error {
 			if i >= v.Len() {
 				v.SetLen(i + 1)
 			}
+			// The element may hold data from a previous decode into the
+			// same backing array, which must not leak into this one.
+			v.Index(i).Set(z)
 		}
 
 		if i < v.Len() {
@@ -581,11 +850,15 @@ func (d *decodeState) array(v reflect.Value) error {
 	if i < v.Len() {
 		if v.Kind() == reflect.Array {
 			// Array. Zero the rest.
-			z := reflect.Zero(v.Type().Elem())
 			for ; i < v.Len(); i++ {
 				v.Index(i).Set(z)
 			}
 		} else {
+			// Zero the elements dropped from the slice, so that the backing
+			// array does not keep anything they refer to alive.
+			for j := i; j < v.Len(); j++ {
+				v.Index(j).Set(z)
+			}
 			v.SetLen(i)
 		}
 	}
@@ -597,6 +870,19 @@ func (d *decodeState) array(v reflect.Value) error {
 
 var nullLiteral = []byte("null")
 var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+var binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+var unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+
+// isUnmarshalerPtr reports whether t, a pointer type, or the type it points
+// to, implements Unmarshaler or encoding.TextUnmarshaler.
+func isUnmarshalerPtr(t reflect.Type) bool {
+	for _, pt := range []reflect.Type{t, t.Elem()} {
+		if pt.Implements(unmarshalerType) || pt.Implements(textUnmarshalerType) {
+			return true
+		}
+	}
+	return false
+}
 
 // object consumes an object from d.data[d.off-1:], decoding into v.
 // The first byte ('{') of the object has been read already.
@@ -606,7 +892,7 @@ func (d *decodeState) object(v reflect.Value) error {
 	if u != nil {
 		start := d.readIndex()
 		d.skip()
-		return u.UnmarshalJSON(d.data[start:d.off])
+		return d.callUnmarshaler(u, d.data[start:d.off], start)
 	}
 	if ut != nil {
 		d.saveError(&UnmarshalTypeError{Value: "object", Type: v.Type(), Offset: int64(d.off)})
@@ -628,17 +914,17 @@ func (d *decodeState) object(v reflect.Value) error {
 	// Check type of target:
 	//   struct or
 	//   map[T1]T2 where T1 is string, an integer type,
-	//             or an encoding.TextUnmarshaler
+	//             or an encoding.TextUnmarshaler or encoding.BinaryUnmarshaler
 	switch v.Kind() {
 	case reflect.Map:
 		// Map key must either have string kind, have an integer kind,
-		// or be an encoding.TextUnmarshaler.
+		// or be an encoding.TextUnmarshaler or encoding.BinaryUnmarshaler.
 		switch t.Key().Kind() {
 		case reflect.String,
 			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
 			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
 		default:
-			if !reflect.PtrTo(t.Key()).Implements(textUnmarshalerType) {
+			if pt := reflect.PtrTo(t.Key()); !pt.Implements(textUnmarshalerType) && !pt.Implements(binaryUnmarshalerType) {
 				d.saveError(&UnmarshalTypeError{Value: "object", Type: t, Offset: int64(d.off)})
 				d.skip()
 				return nil
@@ -649,7 +935,11 @@ func (d *decodeState) object(v reflect.Value) error {
 		}
 	case reflect.Struct:
 		fields = cachedTypeFields(t)
-		// ok
+		if fields.err != nil {
+			d.saveError(fields.err)
+			d.skip()
+			return nil
+		}
 	default:
 		d.saveError(&UnmarshalTypeError{Value: "object", Type: t, Offset: int64(d.off)})
 		d.skip()
@@ -658,6 +948,13 @@ func (d *decodeState) object(v reflect.Value) error {
 
 	var mapElem reflect.Value
 	origErrorContext := d.errorContext
+	objStart := d.readIndex()
+
+	// seen records which fields matched a key, if any have defaults.
+	var seen []bool
+	if v.Kind() == reflect.Struct && fields.hasDefaults {
+		seen = make([]bool, len(fields.list))
+	}
 
 	for {
 		// Read opening " of string key or closing }.
@@ -682,6 +979,12 @@ func (d *decodeState) object(v reflect.Value) error {
 		// Figure out field corresponding to key.
 		var subv reflect.Value
 		destring := false // whether the value is wrapped in a string to be decoded first
+		var layout string // time layout with which to parse the value, if any
+		var unit string   // duration unit with which to parse the value, if any
+		unknown := false  // whether the value is collected by the unknown field
+		numeric := false  // whether a bool may be the number 0 or 1
+		nullStr := false  // whether null may be an empty string
+		textNum := false  // whether a number is passed to UnmarshalText
 
 		if v.Kind() == reflect.Map {
 			elemType := t.Elem()
@@ -693,23 +996,21 @@ func (d *decodeState) object(v reflect.Value) error {
 			subv = mapElem
 		} else {
 			var f *field
-			if i, ok := fields.nameIndex[string(key)]; ok {
-				// Found an exact name match.
-				f = &fields.list[i]
-			} else {
-				// Fall back to the expensive case-insensitive
-				// linear search.
-				for i := range fields.list {
-					ff := &fields.list[i]
-					if ff.equalFold(ff.nameBytes, key) {
-						f = ff
-						break
-					}
+			fi := fields.match(key, d.caseSensitive)
+			if fi >= 0 {
+				f = &fields.list[fi]
+				if seen != nil {
+					seen[fi] = true
 				}
 			}
 			if f != nil {
 				subv = v
-				destring = f.quoted
+				destring = f.quoted && !d.strictTypes
+				layout = f.layout
+				unit = f.unit
+				numeric = f.boolNumeric
+				nullStr = f.nullEmpty
+				textNum = f.textNumber
 				for _, i := range f.index {
 					if subv.Kind() == reflect.Ptr {
 						if subv.IsNil() {
@@ -724,6 +1025,8 @@ func (d *decodeState) object(v reflect.Value) error {
 								// the JSON value without assigning it to subv.
 								subv = reflect.Value{}
 								destring = false
+								layout = ""
+								unit = ""
 								break
 							}
 							subv.Set(reflect.New(subv.Type().Elem()))
@@ -734,6 +1037,9 @@ func (d *decodeState) object(v reflect.Value) error {
 				}
 				d.errorContext.FieldStack = append(d.errorContext.FieldStack, f.name)
 				d.errorContext.Struct = t
+			} else if fields.unknown != nil {
+				subv = reflect.New(rawMessageType).Elem()
+				unknown = true
 			} else if d.disallowUnknownFields {
 				d.saveError(fmt.Errorf("json: unknown field %q", key))
 			}
@@ -748,36 +1054,73 @@ func (d *decodeState) object(v reflect.Value) error {
 		}
 		d.scanWhile(scanSkipSpace)
 
-		if destring {
+		stored := false // whether the value has been stored already
+		if (numeric || nullStr) && d.opcode == scanBeginLiteral && subv.IsValid() {
+			var err error
+			if stored, err = d.literalOption(subv, numeric, nullStr); err != nil {
+				return err
+			}
+		}
+		if textNum && !stored && d.opcode == scanBeginLiteral && subv.IsValid() {
+			var err error
+			if stored, err = d.textNumberValue(subv); err != nil {
+				return err
+			}
+		}
+
+		if stored {
+			// Nothing more to do.
+		} else if destring {
+			start := d.readIndex()
 			switch qv := d.valueQuoted().(type) {
 			case nil:
-				if err := d.literalStore(nullLiteral, subv, false); err != nil {
+				if err := d.literalStore(nullLiteral, start, subv, false); err != nil {
 					return err
 				}
 			case string:
-				if err := d.literalStore([]byte(qv), subv, true); err != nil {
+				if err := d.literalStore([]byte(qv), start, subv, true); err != nil {
 					return err
 				}
 			default:
 				d.saveError(fmt.Errorf("json: invalid use of ,string struct tag, trying to unmarshal unquoted value into %v", subv.Type()))
 			}
+		} else if layout != "" {
+			if err := d.timeLayoutValue(subv, layout); err != nil {
+				return err
+			}
+		} else if unit != "" && subv.IsValid() {
+			if err := d.durationUnitValue(subv, unit); err != nil {
+				return err
+			}
 		} else {
 			if err := d.value(subv); err != nil {
 				return err
 			}
 		}
 
+		if unknown {
+			if mv := allocField(v, fields.unknown); mv.IsValid() {
+				if mv.IsNil() {
+					mv.Set(reflect.MakeMap(mv.Type()))
+				}
+				mv.SetMapIndex(reflect.ValueOf(string(key)).Convert(mv.Type().Key()), subv)
+			}
+		}
+
 		// Write value back to map;
 		// if using struct, subv points into struct already.
 		if v.Kind() == reflect.Map {
 			kt := t.Key()
 			var kv reflect.Value
 			switch {
+			case kt.Kind() == reflect.String && d.internKeys != nil:
+				kv = reflect.ValueOf(d.internKey(key)).Convert(kt)
 			case kt.Kind() == reflect.String:
 				kv = reflect.ValueOf(key).Convert(kt)
-			case reflect.PtrTo(kt).Implements(textUnmarshalerType):
+			case reflect.PtrTo(kt).Implements(textUnmarshalerType),
+				reflect.PtrTo(kt).Implements(binaryUnmarshalerType):
 				kv = reflect.New(kt)
-				if err := d.literalStore(item, kv, true); err != nil {
+				if err := d.literalStore(item, start, kv, true); err != nil {
 					return err
 				}
 				kv = kv.Elem()
@@ -824,42 +1167,448 @@ func (d *decodeState) object(v reflect.Value) error {
 			panic(phasePanicMsg)
 		}
 	}
+	if seen != nil {
+		d.storeDefaults(v, fields, seen)
+	}
+	if fields.raw != nil {
+		if rv := allocField(v, fields.raw); rv.IsValid() {
+			rv.SetBytes(append(rv.Bytes()[:0], d.data[objStart:d.off]...))
+		}
+	}
+	return nil
+}
+
+// match returns the index in fields.list of the field that an object key
+// decodes into, or -1 if there is none.
+func (fields *structFields) match(key []byte, caseSensitive bool) int {
+	if i, ok := fields.nameIndex[string(key)]; ok {
+		// Found an exact name match.
+		return i
+	}
+	if caseSensitive {
+		return -1
+	}
+	// Fall back to the expensive case-insensitive
+	// linear search.
+	for i := range fields.list {
+		f := &fields.list[i]
+		if f.decodeNames != nil {
+			if f.matchDecodeName(key) {
+				return i
+			}
+			continue
+		}
+		if f.equalFold(f.nameBytes, key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// literalOption stores the literal beginning at d.data[d.off-1] in v, and
+// reports true, if it is one of the forms accepted by the bool=numeric and
+// null=emptystring tag options, as given by boolNumeric and nullEmpty: the
+// number 0 or 1 for a bool, or an empty string for null. Otherwise it reports
+// false, having consumed nothing. Numbers other than 0 and 1 are rejected.
+func (d *decodeState) literalOption(v reflect.Value, boolNumeric, nullEmpty bool) (bool, error) {
+	start := d.readIndex()
+	c := d.data[start]
+	var lit []byte
+	switch {
+	case nullEmpty && c == '"' && start+1 < len(d.data) && d.data[start+1] == '"':
+		lit = nullLiteral
+	case boolNumeric && (c == '-' || '0' <= c && c <= '9'):
+	default:
+		return false, nil
+	}
+	d.rescanLiteral()
+	if lit == nil {
+		switch item := d.data[start:d.readIndex()]; string(item) {
+		case "0":
+			lit = []byte("false")
+		case "1":
+			lit = []byte("true")
+		default:
+			d.saveError(&UnmarshalTypeError{Value: "number " + string(item), Type: v.Type(), Offset: int64(start)})
+			return true, nil
+		}
+	}
+	return true, d.literalStore(lit, start, v, false)
+}
+
+// textNumberValue stores the literal beginning at d.data[d.off-1] in v, and
+// reports true, if it is a number and v is an encoding.TextUnmarshaler, as
+// for the number tag option: the text of the number is passed to
+// UnmarshalText. Otherwise it reports false, having consumed nothing.
+func (d *decodeState) textNumberValue(v reflect.Value) (bool, error) {
+	start := d.readIndex()
+	if c := d.data[start]; c != '-' && (c < '0' || '9' < c) {
+		return false, nil
+	}
+	_, ut, _ := indirect(v, false)
+	if ut == nil {
+		return false, nil
+	}
+	d.rescanLiteral()
+	return true, ut.UnmarshalText(d.data[start:d.readIndex()])
+}
+
+// allocField returns the field of the struct v with the given index sequence,
+// allocating any nil embedded struct pointers on the way, or the zero Value if
+// one of those cannot be set.
+func allocField(v reflect.Value, index []int) reflect.Value {
+	for _, i := range index {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v
+}
+
+// storeDefaults sets each field of the struct v that has a default, whose key
+// was not seen in the object just decoded and which is still zero, to its
+// default. Errors are saved, as for the values of the object.
+func (d *decodeState) storeDefaults(v reflect.Value, fields structFields, seen []bool) {
+	for i := range fields.list {
+		f := &fields.list[i]
+		if f.defaultValue == nil || seen[i] {
+			continue
+		}
+		subv := allocField(v, f.index)
+		if !subv.IsValid() || !subv.IsZero() {
+			continue
+		}
+
+		sub := decodeState{
+			numberMode:           d.numberMode,
+			strictTypes:          d.strictTypes,
+			emptyStringAsNull:    d.emptyStringAsNull,
+			caseSensitive:        d.caseSensitive,
+			disallowLossyNumbers: d.disallowLossyNumbers,
+			decoders:             d.decoders,
+		}
+		sub.init(f.defaultValue)
+		sub.scan.reset()
+		sub.scanWhile(scanSkipSpace)
+		var err error
+		switch {
+		case f.layout != "":
+			err = sub.timeLayoutValue(subv, f.layout)
+		case f.unit != "":
+			err = sub.durationUnitValue(subv, f.unit)
+		default:
+			err = sub.value(subv)
+		}
+		if err == nil {
+			err = sub.savedError
+		}
+		if err != nil {
+			stack, st := d.errorContext.FieldStack, d.errorContext.Struct
+			d.errorContext.FieldStack = append(stack, f.name)
+			d.errorContext.Struct = v.Type()
+			d.saveError(err)
+			d.errorContext.FieldStack, d.errorContext.Struct = stack, st
+		}
+	}
+}
+
+// checkDuplicateKeys consumes a JSON value from d.data[d.off-1:], without
+// decoding it, and returns a DuplicateKeyError for the first object found to
+// contain a repeated key.
+func (d *decodeState) checkDuplicateKeys() error {
+	switch d.opcode {
+	default:
+		panic(phasePanicMsg)
+
+	case scanBeginArray:
+		for {
+			// Look ahead for ] - can only happen on first iteration.
+			d.scanWhile(scanSkipSpace)
+			if d.opcode == scanEndArray {
+				break
+			}
+
+			if err := d.checkDuplicateKeys(); err != nil {
+				return err
+			}
+
+			// Next token must be , or ].
+			if d.opcode == scanSkipSpace {
+				d.scanWhile(scanSkipSpace)
+			}
+			if d.opcode == scanEndArray {
+				break
+			}
+			if d.opcode != scanArrayValue {
+				panic(phasePanicMsg)
+			}
+		}
+		d.scanNext()
+
+	case scanBeginObject:
+		keys := make(map[string]struct{})
+		for {
+			// Read opening " of string key or closing }.
+			d.scanWhile(scanSkipSpace)
+			if d.opcode == scanEndObject {
+				// closing } - can only happen on first iteration.
+				break
+			}
+			if d.opcode != scanBeginLiteral {
+				panic(phasePanicMsg)
+			}
+
+			// Read string key.
+			start := d.readIndex()
+			d.rescanLiteral()
+			key, ok := unquote(d.data[start:d.readIndex()])
+			if !ok {
+				panic(phasePanicMsg)
+			}
+			if _, dup := keys[key]; dup {
+				return &DuplicateKeyError{Key: key, Offset: d.baseOffset + int64(start)}
+			}
+			keys[key] = struct{}{}
+
+			// Read : before value.
+			if d.opcode == scanSkipSpace {
+				d.scanWhile(scanSkipSpace)
+			}
+			if d.opcode != scanObjectKey {
+				panic(phasePanicMsg)
+			}
+			d.scanWhile(scanSkipSpace)
+
+			if err := d.checkDuplicateKeys(); err != nil {
+				return err
+			}
+
+			// Next token must be , or }.
+			if d.opcode == scanSkipSpace {
+				d.scanWhile(scanSkipSpace)
+			}
+			if d.opcode == scanEndObject {
+				break
+			}
+			if d.opcode != scanObjectValue {
+				panic(phasePanicMsg)
+			}
+		}
+		d.scanNext()
+
+	case scanBeginLiteral:
+		d.rescanLiteral()
+	}
 	return nil
 }
 
-// convertNumber converts the number literal s to a float64 or a Number
-// depending on the setting of d.useNumber.
-func (d *decodeState) convertNumber(s string) (interface{}, error) {
-	if d.useNumber {
+// timeLayoutValue consumes a JSON value from d.data[d.off-1:], parsing it
+// into v, which is a time.Time or *time.Time, with the given layout.
+func (d *decodeState) timeLayoutValue(v reflect.Value, layout string) error {
+	if d.opcode != scanBeginLiteral {
+		val := "array"
+		if d.opcode == scanBeginObject {
+			val = "object"
+		}
+		d.saveError(&UnmarshalTypeError{Value: val, Type: v.Type(), Offset: int64(d.off)})
+		d.skip()
+		d.scanNext()
+		return nil
+	}
+
+	start := d.readIndex()
+	d.rescanLiteral()
+	item := d.data[start:d.readIndex()]
+	switch item[0] {
+	case 'n':
+		return d.literalStore(item, start, v, false)
+	case '"':
+	default:
+		val := "number"
+		if item[0] == 't' || item[0] == 'f' {
+			val = "bool"
+		}
+		d.saveError(&UnmarshalTypeError{Value: val, Type: v.Type(), Offset: int64(start)})
+		return nil
+	}
+
+	s, ok := unquote(item)
+	if !ok {
+		panic(phasePanicMsg)
+	}
+	tm, err := time.Parse(layout, s)
+	if err != nil {
+		d.saveError(fmt.Errorf("json: cannot unmarshal %s into Go struct field %s.%s with layout %q: %v",
+			item, d.errorContext.Struct.Name(), strings.Join(d.errorContext.FieldStack, "."), layout, err))
+		return nil
+	}
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	v.Set(reflect.ValueOf(tm))
+	return nil
+}
+
+// durationUnitValue consumes a JSON value from d.data[d.off-1:], decoding it
+// into v, which is a time.Duration or *time.Duration. A number is interpreted
+// in the given unit, or as nanoseconds if unit is "string".
+func (d *decodeState) durationUnitValue(v reflect.Value, unit string) error {
+	u := durationUnits[unit]
+	if d.opcode != scanBeginLiteral || u == 0 || u == time.Nanosecond {
+		return d.value(v)
+	}
+	start := d.readIndex()
+	d.rescanLiteral()
+	item := d.data[start:d.readIndex()]
+	if c := item[0]; c != '-' && (c < '0' || c > '9') {
+		return d.literalStore(item, start, v, false)
+	}
+
+	s := string(item)
+	var dur time.Duration
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		dur = time.Duration(n) * u
+		if dur/u != time.Duration(n) {
+			d.saveError(&UnmarshalTypeError{Value: "number " + s, Type: v.Type(), Offset: int64(start)})
+			return nil
+		}
+	} else {
+		f, err := strconv.ParseFloat(s, 64)
+		f *= float64(u)
+		if err != nil || f < math.MinInt64 || f >= math.MaxInt64 {
+			d.saveError(&UnmarshalTypeError{Value: "number " + s, Type: v.Type(), Offset: int64(start)})
+			return nil
+		}
+		dur = time.Duration(math.Round(f))
+	}
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	v.SetInt(int64(dur))
+	return nil
+}
+
+// convertNumber converts the number literal s, which begins at d.data[start],
+// to a float64, an int64 or a Number depending on the setting of d.numberMode.
+func (d *decodeState) convertNumber(s string, start int) (interface{}, error) {
+	switch d.numberMode {
+	case NumberModeNumber:
+		return Number(s), nil
+	case NumberModeSmartInt:
+		if strings.IndexAny(s, ".eE") < 0 {
+			if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return i, nil
+			}
+		}
 		return Number(s), nil
 	}
 	f, err := strconv.ParseFloat(s, 64)
 	if err != nil {
 		return nil, &UnmarshalTypeError{Value: "number " + s, Type: reflect.TypeOf(0.0), Offset: int64(d.off)}
 	}
+	if d.disallowLossyNumbers && lossyInteger(s, f, 64) {
+		return nil, &UnmarshalTypeError{Value: "number " + s, Type: reflect.TypeOf(0.0), Offset: d.baseOffset + int64(start)}
+	}
 	return f, nil
 }
 
+// lossyInteger reports whether s, a number literal, is an integer, written
+// without a fraction or exponent, whose value differs from f, its conversion
+// to a float of the given bit size.
+func lossyInteger(s string, f float64, bits int) bool {
+	// Integers of up to 15 digits, or 7 for a float32, are always exact.
+	digits := len(s)
+	if s[0] == '-' {
+		digits--
+	}
+	if digits <= 15 && bits == 64 || digits <= 7 || strings.IndexAny(s, ".eE") >= 0 {
+		return false
+	}
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return false
+	}
+	fn, _ := big.NewFloat(f).Int(nil)
+	return fn.Cmp(n) != 0
+}
+
 var numberType = reflect.TypeOf(Number(""))
 
+// storeBigFloat parses the JSON number item into f. If f has no
+// precision set, it is given enough to hold every digit of item, and
+// never less than that of a float64 conversion.
+func (d *decodeState) storeBigFloat(f *big.Float, item []byte) error {
+	if f.Prec() == 0 {
+		digits := 0
+		for _, c := range item {
+			if c == 'e' || c == 'E' {
+				break
+			}
+			if '0' <= c && c <= '9' {
+				digits++
+			}
+		}
+		// log2(10) < 3.322
+		prec := uint(digits*3322/1000 + 1)
+		if prec < 64 {
+			prec = 64
+		}
+		f.SetPrec(prec)
+	}
+	if _, _, err := f.Parse(string(item), 10); err != nil {
+		return err
+	}
+	return nil
+}
+
 // literalStore decodes a literal stored in item into v.
 //
+// start is the index in d.data of the literal, or of the string it was
+// unwrapped from, for use by UnmarshalerAt implementations.
+//
 // fromQuoted indicates whether this literal came from unwrapping a
 // string from the ",string" struct tag option. this is used only to
 // produce more helpful error messages.
-func (d *decodeState) literalStore(item []byte, v reflect.Value, fromQuoted bool) error {
+func (d *decodeState) literalStore(item []byte, start int, v reflect.Value, fromQuoted bool) error {
 	// Check for unmarshaler.
 	if len(item) == 0 {
 		//Empty string given
 		d.saveError(fmt.Errorf("json: invalid use of ,string struct tag, trying to unmarshal %q into %v", item, v.Type()))
 		return nil
 	}
+	if d.emptyStringAsNull && string(item) == `""` {
+		// A pointer passed to Decode is not itself settable; it is the
+		// value it points to that is being decoded into.
+		t := v
+		if t.Kind() == reflect.Ptr && !t.CanSet() {
+			t = t.Elem()
+		}
+		if t.Kind() == reflect.Ptr && isUnmarshalerPtr(t.Type()) {
+			item = nullLiteral
+		}
+	}
 	isNull := item[0] == 'n' // null
 	u, ut, pv := indirect(v, isNull)
 	if u != nil {
-		return u.UnmarshalJSON(item)
+		return d.callUnmarshaler(u, item, start)
 	}
 	if ut != nil {
+		if f, ok := ut.(*big.Float); ok && (item[0] == '-' || '0' <= item[0] && item[0] <= '9') {
+			return d.storeBigFloat(f, item)
+		}
 		if item[0] != '"' {
 			if fromQuoted {
 				d.saveError(fmt.Errorf("json: invalid use of ,string struct tag, trying to unmarshal %q into %v", item, v.Type()))
@@ -898,7 +1647,13 @@ func (d *decodeState) literalStore(item []byte, v reflect.Value, fromQuoted bool
 		switch v.Kind() {
 		case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice:
 			v.Set(reflect.Zero(v.Type()))
+		default:
 			// otherwise, ignore null for primitives/string
+			if d.strictTypes {
+				d.saveError(&UnmarshalTypeError{Value: "null", Type: v.Type(), Offset: int64(d.readIndex())})
+			} else if d.nullSink != nil {
+				d.nullSink(strings.Join(d.errorContext.FieldStack, "."))
+			}
 		}
 	case 't', 'f': // true, false
 		value := item[0] == 't'
@@ -948,7 +1703,22 @@ func (d *decodeState) literalStore(item []byte, v reflect.Value, fromQuoted bool
 				break
 			}
 			v.SetBytes(b[:n])
+		case reflect.Int64:
+			if v.Type() != durationType {
+				d.saveError(&UnmarshalTypeError{Value: "string", Type: v.Type(), Offset: int64(d.readIndex())})
+				break
+			}
+			dur, err := time.ParseDuration(string(s))
+			if err != nil {
+				d.saveError(&UnmarshalTypeError{Value: "string " + string(item), Type: v.Type(), Offset: int64(d.readIndex())})
+				break
+			}
+			v.SetInt(int64(dur))
 		case reflect.String:
+			if d.strictTypes && v.Type() == numberType {
+				d.saveError(&UnmarshalTypeError{Value: "string", Type: v.Type(), Offset: int64(d.readIndex())})
+				break
+			}
 			v.SetString(string(s))
 		case reflect.Interface:
 			if v.NumMethod() == 0 {
@@ -966,11 +1736,20 @@ func (d *decodeState) literalStore(item []byte, v reflect.Value, fromQuoted bool
 			panic(phasePanicMsg)
 		}
 		s := string(item)
+		base := 10
+		if d.scan.allowExtendedNumbers && !fromQuoted && isExtendedNumber(item) {
+			base = 0
+		} else if d.scan.allowLeadingZeros {
+			s = trimLeadingZeros(s)
+		}
 		switch v.Kind() {
 		default:
 			if v.Kind() == reflect.String && v.Type() == numberType {
 				// s must be a valid number, because it's
 				// already been tokenized.
+				if base == 0 {
+					s = extendedNumberDecimal(s)
+				}
 				v.SetString(s)
 				break
 			}
@@ -979,7 +1758,10 @@ func (d *decodeState) literalStore(item []byte, v reflect.Value, fromQuoted bool
 			}
 			d.saveError(&UnmarshalTypeError{Value: "number", Type: v.Type(), Offset: int64(d.readIndex())})
 		case reflect.Interface:
-			n, err := d.convertNumber(s)
+			if base == 0 {
+				s = extendedNumberDecimal(s)
+			}
+			n, err := d.convertNumber(s, start)
 			if err != nil {
 				d.saveError(err)
 				break
@@ -991,7 +1773,7 @@ func (d *decodeState) literalStore(item []byte, v reflect.Value, fromQuoted bool
 			v.Set(reflect.ValueOf(n))
 
 		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-			n, err := strconv.ParseInt(s, 10, 64)
+			n, err := strconv.ParseInt(s, base, 64)
 			if err != nil || v.OverflowInt(n) {
 				d.saveError(&UnmarshalTypeError{Value: "number " + s, Type: v.Type(), Offset: int64(d.readIndex())})
 				break
@@ -999,7 +1781,7 @@ func (d *decodeState) literalStore(item []byte, v reflect.Value, fromQuoted bool
 			v.SetInt(n)
 
 		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-			n, err := strconv.ParseUint(s, 10, 64)
+			n, err := strconv.ParseUint(s, base, 64)
 			if err != nil || v.OverflowUint(n) {
 				d.saveError(&UnmarshalTypeError{Value: "number " + s, Type: v.Type(), Offset: int64(d.readIndex())})
 				break
@@ -1007,7 +1789,16 @@ func (d *decodeState) literalStore(item []byte, v reflect.Value, fromQuoted bool
 			v.SetUint(n)
 
 		case reflect.Float32, reflect.Float64:
+			if base == 0 {
+				// Only integers may be written in another base.
+				d.saveError(&UnmarshalTypeError{Value: "number " + s, Type: v.Type(), Offset: int64(d.readIndex())})
+				break
+			}
 			n, err := strconv.ParseFloat(s, v.Type().Bits())
+			if err == nil && d.disallowLossyNumbers && lossyInteger(s, n, v.Type().Bits()) {
+				d.saveError(&UnmarshalTypeError{Value: "number " + s, Type: v.Type(), Offset: d.baseOffset + int64(start)})
+				break
+			}
 			if err != nil || v.OverflowFloat(n) {
 				d.saveError(&UnmarshalTypeError{Value: "number " + s, Type: v.Type(), Offset: int64(d.readIndex())})
 				break
@@ -1031,7 +1822,13 @@ func (d *decodeState) valueInterface() (val interface{}) {
 		val = d.arrayInterface()
 		d.scanNext()
 	case scanBeginObject:
-		val = d.objectInterface()
+		if d.orderedObjects {
+			m := &OrderedMap{}
+			d.orderedObject(m)
+			val = m
+		} else {
+			val = d.objectInterface()
+		}
 		d.scanNext()
 	case scanBeginLiteral:
 		val = d.literalInterface()
@@ -1083,10 +1880,11 @@ func (d *decodeState) objectInterface() map[string]interface{} {
 		start := d.readIndex()
 		d.rescanLiteral()
 		item := d.data[start:d.readIndex()]
-		key, ok := unquote(item)
+		kb, ok := unquoteBytes(item)
 		if !ok {
 			panic(phasePanicMsg)
 		}
+		key := d.internKey(kb)
 
 		// Read : before value.
 		if d.opcode == scanSkipSpace {
@@ -1114,6 +1912,26 @@ func (d *decodeState) objectInterface() map[string]interface{} {
 	return m
 }
 
+// maxInternedKeys is the number of distinct object keys beyond which
+// internKey stops adding to d.internKeys.
+const maxInternedKeys = 4096
+
+// internKey returns the object key b as a string. If key interning is enabled,
+// a key equal to one returned earlier shares its storage, saving a copy.
+func (d *decodeState) internKey(b []byte) string {
+	if d.internKeys == nil {
+		return string(b)
+	}
+	if s, ok := d.internKeys[string(b)]; ok {
+		return s
+	}
+	s := string(b)
+	if len(d.internKeys) < maxInternedKeys {
+		d.internKeys[s] = s
+	}
+	return s
+}
+
 // literalInterface consumes and returns a literal from d.data[d.off-1:] and
 // it reads the following byte ahead. The first byte of the literal has been
 // read already (that's how the caller knows it's a literal).
@@ -1142,7 +1960,13 @@ func (d *decodeState) literalInterface() interface{} {
 		if c != '-' && (c < '0' || c > '9') {
 			panic(phasePanicMsg)
 		}
-		n, err := d.convertNumber(string(item))
+		s := string(item)
+		if d.scan.allowExtendedNumbers && isExtendedNumber(item) {
+			s = extendedNumberDecimal(s)
+		} else if d.scan.allowLeadingZeros {
+			s = trimLeadingZeros(s)
+		}
+		n, err := d.convertNumber(s, start)
 		if err != nil {
 			d.saveError(err)
 		}
@@ -1150,6 +1974,50 @@ func (d *decodeState) literalInterface() interface{} {
 	}
 }
 
+// isExtendedNumber reports whether the number literal item is an integer
+// written with a 0x, 0o or 0b prefix, as allowed by
+// Decoder.AllowExtendedNumbers.
+func isExtendedNumber(item []byte) bool {
+	if len(item) > 0 && item[0] == '-' {
+		item = item[1:]
+	}
+	if len(item) < 2 || item[0] != '0' {
+		return false
+	}
+	switch item[1] {
+	case 'x', 'X', 'o', 'O', 'b', 'B':
+		return true
+	}
+	return false
+}
+
+// extendedNumberDecimal returns the decimal form of the extended number s,
+// which the scanner has already validated.
+func extendedNumberDecimal(s string) string {
+	n, ok := new(big.Int).SetString(s, 0)
+	if !ok {
+		panic(phasePanicMsg)
+	}
+	return n.String()
+}
+
+// trimLeadingZeros returns the number literal s without any leading zeros in
+// its integer part, as allowed by Decoder.AllowLeadingZeros.
+func trimLeadingZeros(s string) string {
+	i := 0
+	if s[0] == '-' {
+		i = 1
+	}
+	j := i
+	for j+1 < len(s) && s[j] == '0' && '0' <= s[j+1] && s[j+1] <= '9' {
+		j++
+	}
+	if j == i {
+		return s
+	}
+	return s[:i] + s[j:]
+}
+
 // getu4 decodes \uXXXX from the beginning of s, returning the hex value,
 // or it returns -1.
 func getu4(s []byte) rune {
@@ -6,6 +6,7 @@ package json
 
 import (
 	"bytes"
+	"database/sql"
 	"encoding"
 	"errors"
 	"fmt"
@@ -2118,6 +2119,174 @@ func TestPrefilled(t *testing.T) {
 	}
 }
 
+func TestUnmarshalReuseSlice(t *testing.T) {
+	type elem struct {
+		A int
+		B *string
+		C []int
+	}
+	b := "b"
+	s := make([]elem, 3, 8)
+	s[0] = elem{A: 1, B: &b, C: []int{1}}
+	s[1] = elem{A: 2, B: &b}
+	s[2] = elem{A: 3, C: []int{3}}
+	backing := s[:cap(s)]
+	backing[5] = elem{A: 6, B: &b}
+
+	if err := Unmarshal([]byte(`[{"C":[7]},{"A":8}]`), &s); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if want := []elem{{C: []int{7}}, {A: 8}}; !reflect.DeepEqual(s, want) {
+		t.Errorf("Unmarshal = %+v, want %+v", s, want)
+	}
+	if &s[0] != &backing[0] {
+		t.Error("Unmarshal reallocated a slice with sufficient capacity")
+	}
+	if !reflect.DeepEqual(backing[2], elem{}) {
+		t.Errorf("dropped element = %+v, want zero", backing[2])
+	}
+
+	if err := Unmarshal([]byte(`[{},{},{},{},{},{}]`), &s); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if want := make([]elem, 6); !reflect.DeepEqual(s, want) {
+		t.Errorf("Unmarshal = %+v, want %+v", s, want)
+	}
+	if &s[0] != &backing[0] {
+		t.Error("Unmarshal reallocated a slice with sufficient capacity")
+	}
+}
+
+func TestSQLNullRoundTrip(t *testing.T) {
+	when := time.Date(2009, 11, 10, 23, 0, 0, 0, time.UTC)
+	tests := []struct {
+		valid, invalid interface{}
+		json           string
+	}{
+		{sql.NullString{String: "x", Valid: true}, sql.NullString{}, `"x"`},
+		{sql.NullInt64{Int64: -1 << 40, Valid: true}, sql.NullInt64{}, `-1099511627776`},
+		{sql.NullInt32{Int32: 32, Valid: true}, sql.NullInt32{}, `32`},
+		{sql.NullInt16{Int16: -16, Valid: true}, sql.NullInt16{}, `-16`},
+		{sql.NullByte{Byte: 8, Valid: true}, sql.NullByte{}, `8`},
+		{sql.NullFloat64{Float64: 1.5, Valid: true}, sql.NullFloat64{}, `1.5`},
+		{sql.NullBool{Bool: true, Valid: true}, sql.NullBool{}, `true`},
+		{sql.NullTime{Time: when, Valid: true}, sql.NullTime{}, `"2009-11-10T23:00:00Z"`},
+		{sql.Null[[]int]{V: []int{1, 2}, Valid: true}, sql.Null[[]int]{}, `[1,2]`},
+	}
+	for _, tt := range tests {
+		typ := reflect.TypeOf(tt.valid)
+		for _, c := range []struct {
+			v    interface{}
+			json string
+		}{{tt.valid, tt.json}, {tt.invalid, "null"}} {
+			out, err := Marshal(c.v)
+			if err != nil || string(out) != c.json {
+				t.Errorf("Marshal(%#v) = %s, %v, want %s", c.v, out, err, c.json)
+			}
+			// Decode over the opposite value, to see both fields set.
+			pv := reflect.New(typ)
+			if c.json == "null" {
+				pv.Elem().Set(reflect.ValueOf(tt.valid))
+			}
+			if err := Unmarshal([]byte(c.json), pv.Interface()); err != nil {
+				t.Errorf("Unmarshal(%s) into %v: %v", c.json, typ, err)
+				continue
+			}
+			if got := pv.Elem().Interface(); !reflect.DeepEqual(got, c.v) {
+				t.Errorf("Unmarshal(%s) into %v = %#v, want %#v", c.json, typ, got, c.v)
+			}
+		}
+	}
+
+	// In structs, pointers to the null types are set to nil by null, and a
+	// value of the wrong type leaves Valid false.
+	var v struct {
+		S  sql.NullString
+		P  *sql.NullInt64
+		Q  *sql.NullInt64
+		B  sql.NullBool `json:",omitempty"`
+		Zs []sql.NullString
+	}
+	v.Q = &sql.NullInt64{Int64: 1, Valid: true}
+	err := Unmarshal([]byte(`{"S":5,"P":7,"Q":null,"Zs":["a",null]}`), &v)
+	if _, ok := err.(*UnmarshalTypeError); !ok {
+		t.Errorf("Unmarshal error = %v, want UnmarshalTypeError", err)
+	}
+	if v.S.Valid || v.P == nil || *v.P != (sql.NullInt64{Int64: 7, Valid: true}) || v.Q != nil {
+		t.Errorf("Unmarshal = %+v", v)
+	}
+	if want := []sql.NullString{{String: "a", Valid: true}, {}}; !reflect.DeepEqual(v.Zs, want) {
+		t.Errorf("Zs = %#v, want %#v", v.Zs, want)
+	}
+	out, err := Marshal(v)
+	if want := `{"S":null,"P":7,"Q":null,"B":null,"Zs":["a",null]}`; err != nil || string(out) != want {
+		t.Errorf("Marshal = %s, %v, want %s", out, err, want)
+	}
+}
+
+func TestBigNumberRoundTrip(t *testing.T) {
+	const digits = "1234567890123456789012345678901234567890123456789012345678901234567890123456789012345678901234567890"
+	type bigs struct {
+		I  big.Int
+		PI *big.Int
+		F  big.Float
+		PF *big.Float
+	}
+	for _, num := range []string{digits, "-" + digits, "0", digits + "." + digits[:40], "-3.25e-300"} {
+		in := `{"I":` + num + `,"PI":` + num + `,"F":` + num + `,"PF":` + num + `}`
+		if strings.ContainsAny(num, ".e") {
+			in = `{"I":0,"PI":null,"F":` + num + `,"PF":` + num + `}`
+		}
+		var v bigs
+		if err := Unmarshal([]byte(in), &v); err != nil {
+			t.Errorf("Unmarshal(%s): %v", in, err)
+			continue
+		}
+		if want, _, _ := big.ParseFloat(num, 10, v.F.Prec(), big.ToNearestEven); v.F.Cmp(want) != 0 || v.PF.Cmp(want) != 0 {
+			t.Errorf("Unmarshal(%s): F = %v, PF = %v, want %v", in, &v.F, v.PF, want)
+		}
+		out, err := Marshal(v)
+		if err != nil {
+			t.Errorf("Marshal(%s): %v", in, err)
+			continue
+		}
+		var w bigs
+		if err := Unmarshal(out, &w); err != nil {
+			t.Errorf("Unmarshal(%s): %v", out, err)
+			continue
+		}
+		if !strings.ContainsAny(num, ".e") {
+			if want := `{"I":` + num + `,"PI":` + num + `,`; !bytes.HasPrefix(out, []byte(want)) {
+				t.Errorf("Marshal:\n\tgot:  %s\n\twant: %s...", out, want)
+			}
+			if w.I.String() != num || w.PI.String() != num {
+				t.Errorf("round trip of %s: I = %v, PI = %v", num, &w.I, w.PI)
+			}
+			if i, _ := w.F.Int(nil); !w.F.IsInt() || i.String() != num {
+				t.Errorf("round trip of %s: F = %v", num, &w.F)
+			}
+		}
+		// Trailing zeros aside, the decimal digits survive a round trip.
+		if got, want := w.F.Text('g', -1), v.F.Text('g', -1); got != want || w.PF.Text('g', -1) != want {
+			t.Errorf("round trip of %s: F = %s, PF = %s, want %s", out, got, w.PF.Text('g', -1), want)
+		}
+	}
+
+	// A precision set before decoding is kept.
+	f := new(big.Float).SetPrec(24)
+	if err := Unmarshal([]byte(digits), f); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if f.Prec() != 24 {
+		t.Errorf("Prec = %d, want 24", f.Prec())
+	}
+
+	// Quoted strings still go through UnmarshalText.
+	if err := Unmarshal([]byte(`"`+digits+`"`), f); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+}
+
 var invalidUnmarshalTests = []struct {
 	v    interface{}
 	want string
@@ -2380,3 +2549,661 @@ func TestUnmarshalRecursivePointer(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestTimeLayoutTag(t *testing.T) {
+	type Inner struct {
+		When time.Time `json:"when,layout=15:04"`
+	}
+	type T struct {
+		Date    time.Time  `json:"date,layout=2006-01-02"`
+		PtrDate *time.Time `json:"ptr_date,layout=02/01/2006,omitempty"`
+		Default time.Time  `json:"default"`
+		NotTime string     `json:"not_time,layout=2006"`
+		Inner   Inner      `json:"inner"`
+	}
+	day := time.Date(2019, time.September, 13, 0, 0, 0, 0, time.UTC)
+	in := T{
+		Date:    day,
+		PtrDate: &day,
+		Default: day,
+		NotTime: "x",
+		Inner:   Inner{When: time.Date(0, time.January, 1, 12, 34, 0, 0, time.UTC)},
+	}
+	const want = `{"date":"2019-09-13","ptr_date":"13/09/2019","default":"2019-09-13T00:00:00Z","not_time":"x","inner":{"when":"12:34"}}`
+
+	b, err := Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if string(b) != want {
+		t.Fatalf("Marshal = %s, want %s", b, want)
+	}
+	var out T
+	if err := Unmarshal(b, &out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("Unmarshal = %#v, want %#v", out, in)
+	}
+
+	if err := Unmarshal([]byte(`{"ptr_date":null}`), &out); err != nil || out.PtrDate != nil {
+		t.Errorf("Unmarshal null = %v, %v, want nil pointer", out.PtrDate, err)
+	}
+
+	errTests := []struct {
+		in  string
+		err string
+	}{
+		{`{"inner":{"when":"noon"}}`, `json: cannot unmarshal "noon" into Go struct field Inner.inner.when with layout "15:04": parsing time "noon" as "15:04": cannot parse "noon" as "15"`},
+		{`{"date":20190913}`, "json: cannot unmarshal number into Go struct field T.date of type time.Time"},
+		{`{"date":["2019-09-13"]}`, "json: cannot unmarshal array into Go struct field T.date of type time.Time"},
+	}
+	for _, tt := range errTests {
+		err := Unmarshal([]byte(tt.in), &out)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("Unmarshal(%s) error = %v, want %s", tt.in, err, tt.err)
+		}
+	}
+}
+
+func TestDurationUnitTag(t *testing.T) {
+	type T struct {
+		Default time.Duration  `json:"default"`
+		Seconds time.Duration  `json:"seconds,unit=s"`
+		Millis  *time.Duration `json:"millis,unit=ms,omitempty"`
+		Hours   time.Duration  `json:"hours,unit=h"`
+		Nanos   time.Duration  `json:"nanos,unit=ns"`
+		String  time.Duration  `json:"string,unit=string"`
+		Bogus   time.Duration  `json:"bogus,unit=fortnight"`
+		NotDur  int64          `json:"not_dur,unit=s"`
+	}
+	ms := 1500 * time.Millisecond
+	in := T{
+		Default: time.Second,
+		Seconds: ms,
+		Millis:  &ms,
+		Hours:   2 * time.Hour,
+		Nanos:   7,
+		String:  90 * time.Minute,
+		Bogus:   time.Microsecond,
+		NotDur:  3,
+	}
+	const want = `{"default":1000000000,"seconds":1.5,"millis":1500,"hours":2,"nanos":7,"string":"1h30m0s","bogus":1000,"not_dur":3}`
+
+	b, err := Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if string(b) != want {
+		t.Fatalf("Marshal = %s, want %s", b, want)
+	}
+	var out T
+	if err := Unmarshal(b, &out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("Unmarshal = %#v, want %#v", out, in)
+	}
+
+	// Strings are parsed by time.ParseDuration whatever the unit, and
+	// numbers are nanoseconds unless a unit is given.
+	var got T
+	const durations = `{"default":"30s","seconds":"1m","millis":"2ms","hours":45,"nanos":"1us","string":12,"bogus":"1h"}`
+	if err := Unmarshal([]byte(durations), &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	two := 2 * time.Millisecond
+	wantGot := T{Default: 30 * time.Second, Seconds: time.Minute, Millis: &two, Hours: 45 * time.Hour, Nanos: time.Microsecond, String: 12, Bogus: time.Hour}
+	if !reflect.DeepEqual(got, wantGot) {
+		t.Errorf("Unmarshal = %#v, want %#v", got, wantGot)
+	}
+	var ds []time.Duration
+	if err := Unmarshal([]byte(`["1.5h", 5, null]`), &ds); err != nil || !reflect.DeepEqual(ds, []time.Duration{90 * time.Minute, 5, 0}) {
+		t.Errorf("Unmarshal of []time.Duration = %v, %v", ds, err)
+	}
+	if err := Unmarshal([]byte(`{"millis":null}`), &got); err != nil || got.Millis != nil {
+		t.Errorf("Unmarshal null = %v, %v, want nil pointer", got.Millis, err)
+	}
+	if err := Unmarshal([]byte(`{"seconds":0.0000000005}`), &got); err != nil || got.Seconds != 1 {
+		t.Errorf("Unmarshal of rounded seconds = %v, %v, want 1ns", got.Seconds, err)
+	}
+
+	errTests := []struct {
+		in  string
+		err string
+	}{
+		{`{"seconds":"soon"}`, `json: cannot unmarshal string "soon" into Go struct field T.seconds of type time.Duration`},
+		{`{"hours":9999999}`, `json: cannot unmarshal number 9999999 into Go struct field T.hours of type time.Duration`},
+		{`{"seconds":1e10}`, `json: cannot unmarshal number 1e10 into Go struct field T.seconds of type time.Duration`},
+		{`{"seconds":true}`, `json: cannot unmarshal bool into Go struct field T.seconds of type time.Duration`},
+		{`{"not_dur":"1s"}`, `json: cannot unmarshal string into Go struct field T.not_dur of type int64`},
+	}
+	for _, tt := range errTests {
+		err := Unmarshal([]byte(tt.in), &out)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("Unmarshal(%s) error = %v, want %s", tt.in, err, tt.err)
+		}
+	}
+}
+
+type inlineAudit struct {
+	By    string `json:"by"`
+	Notes string `json:"notes,omitempty"`
+}
+
+type inlineMeta struct {
+	Version int         `json:"version"`
+	ID      string      `json:"id"` // hidden by inlineDoc.ID
+	Audit   inlineAudit `json:"audit,inline"`
+}
+
+type inlineExtra struct {
+	Color string `json:"color"`
+	By    string `json:"by"` // hides inlineAudit.By, which is nested more deeply
+}
+
+type inlineDoc struct {
+	ID    string       `json:"id"`
+	Meta  inlineMeta   `json:"meta,inline"`
+	Extra *inlineExtra `json:",inline"`
+	Count int          `json:",inline"` // not a struct, so not inlined
+}
+
+func TestInlineTag(t *testing.T) {
+	v := inlineDoc{
+		ID:    "doc",
+		Meta:  inlineMeta{Version: 2, ID: "meta", Audit: inlineAudit{By: "ann", Notes: "n"}},
+		Count: 3,
+	}
+	b, err := Marshal(v)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	// Meta.Audit.By is hidden by Extra.By, even though Extra is nil.
+	const want = `{"id":"doc","version":2,"notes":"n","Count":3}`
+	if string(b) != want {
+		t.Errorf("Marshal = %s, want %s", b, want)
+	}
+
+	v.Extra = &inlineExtra{Color: "red", By: "bob"}
+	b, err = Marshal(v)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	const wantExtra = `{"id":"doc","version":2,"notes":"n","color":"red","by":"bob","Count":3}`
+	if string(b) != wantExtra {
+		t.Errorf("Marshal = %s, want %s", b, wantExtra)
+	}
+
+	var got inlineDoc
+	if err := Unmarshal([]byte(`{"id":"x","version":5,"notes":"y","by":"z","color":"blue","Count":1}`), &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	wantDoc := inlineDoc{
+		ID:    "x",
+		Meta:  inlineMeta{Version: 5, Audit: inlineAudit{Notes: "y"}},
+		Extra: &inlineExtra{Color: "blue", By: "z"},
+		Count: 1,
+	}
+	if !reflect.DeepEqual(got, wantDoc) {
+		t.Errorf("Unmarshal = %+v, want %+v", got, wantDoc)
+	}
+
+	// Keys inlined at the same depth from two fields collide, and are
+	// dropped.
+	twice := struct {
+		A inlineExtra `json:",inline"`
+		B inlineExtra `json:",inline"`
+		C int         `json:"c"`
+	}{inlineExtra{"a", "b"}, inlineExtra{"c", "d"}, 1}
+	if b, err := Marshal(twice); err != nil || string(b) != `{"c":1}` {
+		t.Errorf("Marshal = %s, %v, want {\"c\":1}", b, err)
+	}
+
+	// Two levels of inlining, with nothing hiding the innermost fields.
+	var meta inlineMeta
+	if err := Unmarshal([]byte(`{"version":1,"id":"m","by":"c","notes":"d"}`), &meta); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if want := (inlineMeta{1, "m", inlineAudit{"c", "d"}}); meta != want {
+		t.Errorf("Unmarshal = %+v, want %+v", meta, want)
+	}
+	if b, err := Marshal(meta); err != nil || string(b) != `{"version":1,"id":"m","by":"c","notes":"d"}` {
+		t.Errorf("Marshal = %s, %v", b, err)
+	}
+}
+
+type defaultConfig struct {
+	Host    string        `json:"host,default=localhost"`
+	Port    int           `json:"port,default=8080"`
+	Debug   bool          `json:"debug,default=true"`
+	Retries *int          `json:"retries,default=3"`
+	Tags    []string      `json:"tags,default=[\"a\"]"`
+	Start   time.Time     `json:"start,layout=2006-01-02,default=2020-01-02"`
+	Timeout time.Duration `json:"timeout,unit=s,default=30"`
+	Name    string        `json:"name"`
+}
+
+func TestDefaultTag(t *testing.T) {
+	three := 3
+	start := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	var got defaultConfig
+	if err := Unmarshal([]byte(`{"name":"x"}`), &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	want := defaultConfig{"localhost", 8080, true, &three, []string{"a"}, start, 30 * time.Second, "x"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("absent fields:\n got %+v\nwant %+v", got, want)
+	}
+
+	// Keys present with zero values, or null, keep those values.
+	got = defaultConfig{}
+	in := `{"host":"","port":0,"debug":false,"retries":null,"tags":null,"start":null,"timeout":0}`
+	if err := Unmarshal([]byte(in), &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if want := (defaultConfig{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("zero fields:\n got %+v\nwant %+v", got, want)
+	}
+
+	// A field already set before decoding is not replaced.
+	got = defaultConfig{Port: 9090}
+	if err := Unmarshal([]byte(`{}`), &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if got.Port != 9090 || got.Host != "localhost" {
+		t.Errorf("Unmarshal into set fields = %+v", got)
+	}
+
+	// Encoding ignores defaults.
+	b, err := Marshal(struct {
+		Port int `json:"port,default=8080"`
+	}{})
+	if err != nil || string(b) != `{"port":0}` {
+		t.Errorf("Marshal = %s, %v, want {\"port\":0}", b, err)
+	}
+
+	var bad struct {
+		Port int `json:"port,default=eighty"`
+	}
+	err = Unmarshal([]byte(`{}`), &bad)
+	const wantErr = `json: cannot unmarshal string into Go struct field .port of type int`
+	if err == nil || err.Error() != wantErr {
+		t.Errorf("Unmarshal with invalid default error = %v, want %s", err, wantErr)
+	}
+}
+
+type rawInner struct {
+	Raw []byte `json:",raw"`
+	N   int    `json:"n"`
+}
+
+type rawOuter struct {
+	Name   string     `json:"name"`
+	Source RawMessage `json:"source,raw"`
+	Inner  rawInner   `json:"inner"`
+	List   []rawInner `json:"list"`
+}
+
+func TestRawTag(t *testing.T) {
+	const in = ` { "name" : "x",
+	"inner": {"n":1,  "Raw":"ignored"},
+	"list": [ {"n": 2} ]
+} `
+	var got rawOuter
+	if err := Unmarshal([]byte(in), &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	want := rawOuter{
+		Name:   "x",
+		Source: RawMessage(strings.TrimSpace(in)),
+		Inner:  rawInner{Raw: []byte(`{"n":1,  "Raw":"ignored"}`), N: 1},
+		List:   []rawInner{{Raw: []byte(`{"n": 2}`), N: 2}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshal:\n got %+v\nwant %+v", got, want)
+	}
+
+	// The raw field holds a copy of the input.
+	data := []byte(`{"n":3}`)
+	var inner rawInner
+	if err := Unmarshal(data, &inner); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	data[5] = '4'
+	if string(inner.Raw) != `{"n":3}` {
+		t.Errorf("Raw = %s after changing the input", inner.Raw)
+	}
+
+	// Marshal ignores the raw field.
+	b, err := Marshal(inner)
+	if err != nil || string(b) != `{"n":3}` {
+		t.Errorf("Marshal = %s, %v, want {\"n\":3}", b, err)
+	}
+
+	var twice struct {
+		A RawMessage `json:",raw"`
+		B []byte     `json:",raw"`
+	}
+	err = Unmarshal([]byte(`{}`), &twice)
+	if err == nil || !strings.Contains(err.Error(), "more than one field") {
+		t.Errorf("Unmarshal with two raw fields error = %v", err)
+	}
+	var notBytes struct {
+		A string `json:",raw"`
+	}
+	err = Unmarshal([]byte(`{}`), &notBytes)
+	if err == nil || !strings.Contains(err.Error(), "not a byte slice") {
+		t.Errorf("Unmarshal with a raw string field error = %v", err)
+	}
+}
+
+type unknownDoc struct {
+	ID    int                   `json:"id"`
+	Name  string                `json:"name,omitempty"`
+	Extra map[string]RawMessage `json:",unknown"`
+}
+
+func TestUnknownTag(t *testing.T) {
+	const in = `{"id":1,"ext":{"a": [1, 2]},"name":"n","v2":true}`
+	var got unknownDoc
+	if err := Unmarshal([]byte(in), &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	want := unknownDoc{ID: 1, Name: "n", Extra: map[string]RawMessage{
+		"ext": RawMessage(`{"a": [1, 2]}`),
+		"v2":  RawMessage(`true`),
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshal = %+v, want %+v", got, want)
+	}
+
+	// The unknown keys are written after the named fields, in key order.
+	b, err := Marshal(got)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	const wantJSON = `{"id":1,"name":"n","ext":{"a":[1,2]},"v2":true}`
+	if string(b) != wantJSON {
+		t.Errorf("Marshal = %s, want %s", b, wantJSON)
+	}
+	var again unknownDoc
+	if err := Unmarshal(b, &again); err != nil || !reflect.DeepEqual(again.Extra["v2"], want.Extra["v2"]) || again.ID != 1 {
+		t.Errorf("round trip = %+v, %v", again, err)
+	}
+
+	// Unknown keys are collected even if DisallowUnknownFields is set.
+	dec := NewDecoder(strings.NewReader(`{"x":null}`))
+	dec.DisallowUnknownFields()
+	got = unknownDoc{}
+	if err := dec.Decode(&got); err != nil || string(got.Extra["x"]) != "null" {
+		t.Errorf("Decode with DisallowUnknownFields = %+v, %v", got, err)
+	}
+
+	// A key naming a declared field is an error, even if that field is
+	// omitted.
+	_, err = Marshal(unknownDoc{Extra: map[string]RawMessage{"name": RawMessage(`1`)}})
+	if _, ok := err.(*UnsupportedValueError); !ok {
+		t.Errorf("Marshal of colliding key error = %v, want UnsupportedValueError", err)
+	}
+
+	if b, err := Marshal(unknownDoc{ID: 2}); err != nil || string(b) != `{"id":2}` {
+		t.Errorf("Marshal without unknown keys = %s, %v", b, err)
+	}
+
+	var bad struct {
+		Extra map[string]interface{} `json:",unknown"`
+	}
+	err = Unmarshal([]byte(`{}`), &bad)
+	if err == nil || !strings.Contains(err.Error(), "not a map[string]RawMessage") {
+		t.Errorf("Unmarshal with a map[string]interface{} unknown field error = %v", err)
+	}
+}
+
+type literalOptions struct {
+	Active  bool              `json:"active,bool=numeric"`
+	Enabled *bool             `json:"enabled,bool=numeric"`
+	Note    *string           `json:"note,null=emptystring"`
+	Tags    []string          `json:"tags,null=emptystring"`
+	Attrs   map[string]string `json:"attrs,null=emptystring"`
+	Any     interface{}       `json:"any,null=emptystring"`
+	Plain   *string           `json:"plain"`
+}
+
+func TestLiteralTagOptions(t *testing.T) {
+	yes, note := true, "n"
+
+	b, err := Marshal(literalOptions{Active: true})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	const wantZero = `{"active":1,"enabled":null,"note":"","tags":"","attrs":"","any":"","plain":null}`
+	if string(b) != wantZero {
+		t.Errorf("Marshal =\n%s\nwant\n%s", b, wantZero)
+	}
+	full := literalOptions{
+		Enabled: &yes,
+		Note:    &note,
+		Tags:    []string{"a"},
+		Attrs:   map[string]string{"k": "v"},
+		Any:     false,
+		Plain:   &note,
+	}
+	b, err = Marshal(full)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	const wantFull = `{"active":0,"enabled":1,"note":"n","tags":["a"],"attrs":{"k":"v"},"any":false,"plain":"n"}`
+	if string(b) != wantFull {
+		t.Errorf("Marshal =\n%s\nwant\n%s", b, wantFull)
+	}
+
+	// Both the special and the usual forms are decoded.
+	for _, in := range []string{wantZero, `{"active":true,"enabled":null,"note":null,"tags":null,"attrs":null,"any":null}`} {
+		got := literalOptions{Note: &note, Tags: []string{"x"}, Attrs: map[string]string{}, Any: 1}
+		if err := Unmarshal([]byte(in), &got); err != nil {
+			t.Fatalf("Unmarshal(%s): %v", in, err)
+		}
+		if want := (literalOptions{Active: true}); !reflect.DeepEqual(got, want) {
+			t.Errorf("Unmarshal(%s) = %+v, want %+v", in, got, want)
+		}
+	}
+	var got literalOptions
+	if err := Unmarshal([]byte(wantFull), &got); err != nil || !reflect.DeepEqual(got, full) {
+		t.Errorf("Unmarshal(%s) = %+v, %v, want %+v", wantFull, got, err, full)
+	}
+	got = literalOptions{}
+	if err := Unmarshal([]byte(`{"enabled":0,"active":false,"note":"x"}`), &got); err != nil || got.Enabled == nil || *got.Enabled || got.Note == nil || *got.Note != "x" {
+		t.Errorf("Unmarshal = %+v, %v", got, err)
+	}
+
+	// Only 0 and 1 are accepted as numbers, and only for the option's fields.
+	errTests := []struct {
+		in  string
+		err string
+	}{
+		{`{"active":2}`, `json: cannot unmarshal number 2 into Go struct field literalOptions.active of type bool`},
+		{`{"plain":""}`, ``},
+		{`{"note":1}`, `json: cannot unmarshal number into Go struct field literalOptions.note of type string`},
+	}
+	for _, tt := range errTests {
+		got = literalOptions{}
+		err := Unmarshal([]byte(tt.in), &got)
+		if tt.err == "" {
+			if err != nil || got.Plain == nil || *got.Plain != "" {
+				t.Errorf("Unmarshal(%s) = %+v, %v", tt.in, got, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("Unmarshal(%s) error = %v, want %s", tt.in, err, tt.err)
+		}
+	}
+}
+
+// testDecimal is a stand-in for a decimal type, such as that of
+// github.com/shopspring/decimal, which marshals as text without exponents.
+type testDecimal struct {
+	unscaled int64
+	scale    int // digits after the decimal point
+}
+
+func (d testDecimal) MarshalText() ([]byte, error) {
+	s := strconv.FormatInt(d.unscaled, 10)
+	if d.scale == 0 {
+		return []byte(s), nil
+	}
+	s = fmt.Sprintf("%0*s", d.scale+1, s)
+	return []byte(s[:len(s)-d.scale] + "." + s[len(s)-d.scale:]), nil
+}
+
+func (d *testDecimal) UnmarshalText(b []byte) error {
+	s := string(b)
+	scale := 0
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		scale = len(s) - i - 1
+		s = s[:i] + s[i+1:]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid decimal %q", b)
+	}
+	*d = testDecimal{n, scale}
+	return nil
+}
+
+// badDecimal marshals as text that is not a number.
+type badDecimal struct{}
+
+func (badDecimal) MarshalText() ([]byte, error) { return []byte("1,5"), nil }
+
+func TestNumberTagOption(t *testing.T) {
+	type order struct {
+		Amount testDecimal  `json:"amount,number"`
+		Tax    *testDecimal `json:"tax,number,omitempty"`
+		Fee    *testDecimal `json:"fee,number"`
+		Quoted testDecimal  `json:"quoted,number,string"`
+		Plain  testDecimal  `json:"plain"`
+		Count  int          `json:"count,number"`
+	}
+	in := order{
+		Amount: testDecimal{1999, 2},
+		Tax:    &testDecimal{5, 3},
+		Quoted: testDecimal{-7, 0},
+		Plain:  testDecimal{150, 2},
+		Count:  2,
+	}
+	b, err := Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	const want = `{"amount":19.99,"tax":0.005,"fee":null,"quoted":-7,"plain":"1.50","count":2}`
+	if string(b) != want {
+		t.Errorf("Marshal =\n%s\nwant\n%s", b, want)
+	}
+	var out order
+	if err := Unmarshal(b, &out); err != nil || !reflect.DeepEqual(out, in) {
+		t.Errorf("Unmarshal(%s) = %+v, %v, want %+v", b, out, err, in)
+	}
+
+	// A string is decoded as usual.
+	out = order{}
+	if err := Unmarshal([]byte(`{"amount":"0.10","tax":3}`), &out); err != nil || out.Amount != (testDecimal{10, 2}) || out.Tax == nil || *out.Tax != (testDecimal{3, 0}) {
+		t.Errorf("Unmarshal = %+v, %v", out, err)
+	}
+	if err := Unmarshal([]byte(`{"amount":1e3}`), &out); err == nil || !strings.Contains(err.Error(), "invalid decimal") {
+		t.Errorf("Unmarshal with exponent error = %v, want invalid decimal", err)
+	}
+
+	_, err = Marshal(struct {
+		Bad badDecimal `json:"bad,number"`
+	}{})
+	var me *MarshalerError
+	if !errors.As(err, &me) || !strings.Contains(err.Error(), "not a valid number") {
+		t.Errorf("Marshal of invalid number error = %v, want MarshalerError", err)
+	}
+}
+
+// atRecorder records the data and offset passed to UnmarshalJSONAt.
+type atRecorder struct {
+	Data   string
+	Offset int64
+}
+
+func (r *atRecorder) UnmarshalJSONAt(data []byte, offset int64) error {
+	r.Data, r.Offset = string(data), offset
+	return nil
+}
+
+// atAndPlain implements both UnmarshalerAt and Unmarshaler.
+type atAndPlain struct {
+	atRecorder
+}
+
+func (p *atAndPlain) UnmarshalJSON([]byte) error {
+	return errors.New("UnmarshalJSON called")
+}
+
+// positiveAt fails with an error anchored to the input.
+type positiveAt int
+
+func (p *positiveAt) UnmarshalJSONAt(data []byte, offset int64) error {
+	n, err := strconv.Atoi(string(data))
+	if err != nil || n <= 0 {
+		return fmt.Errorf("not a positive integer at offset %d", offset)
+	}
+	*p = positiveAt(n)
+	return nil
+}
+
+func TestUnmarshalerAt(t *testing.T) {
+	var v struct {
+		A atRecorder
+		B []atRecorder
+		C map[string]atRecorder
+		E atAndPlain
+	}
+	const in = `{"A": {"x": 1}, "B": [true, "s"], "C": {"k": null}, "E": []}`
+	if err := Unmarshal([]byte(in), &v); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		got  atRecorder
+		want atRecorder
+	}{
+		{"A", v.A, atRecorder{`{"x": 1}`, 6}},
+		{"B[0]", v.B[0], atRecorder{`true`, 22}},
+		{"B[1]", v.B[1], atRecorder{`"s"`, 28}},
+		{"E", v.E.atRecorder, atRecorder{`[]`, 57}},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, tt.got, tt.want)
+		}
+	}
+	if got, want := v.C["k"], (atRecorder{"null", 45}); got != want {
+		t.Errorf("C: got %+v, want %+v", got, want)
+	}
+
+	// With the ",string" option, the offset is that of the string.
+	var q struct {
+		P positiveAt `json:",string"`
+	}
+	err := Unmarshal([]byte(`{"P": "-1"}`), &q)
+	if want := "not a positive integer at offset 6"; err == nil || err.Error() != want {
+		t.Errorf("Unmarshal error = %v, want %s", err, want)
+	}
+
+	// Offsets from a Decoder are relative to the whole stream.
+	dec := NewDecoder(strings.NewReader(`[1, 2] [3, 0]`))
+	var p []positiveAt
+	if err := dec.Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	err = dec.Decode(&p)
+	if want := "not a positive integer at offset 11"; err == nil || err.Error() != want {
+		t.Errorf("Decode error = %v, want %s", err, want)
+	}
+}
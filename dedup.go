@@ -0,0 +1,66 @@
+package json
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"errors"
+	"io"
+)
+
+// DedupArray reads a JSON array from src and writes it to dst, compacted,
+// with each element that equals an earlier one removed. The elements are read
+// one at a time, so that the array need not fit in memory; only a hash of
+// each distinct element is kept.
+//
+// Elements are compared by their canonical encoding, as produced by
+// MarshalCanonical, so that elements differing only in space, in the order
+// of object members, in string escapes or in the form of numbers are equal.
+// Each distinct element is written in its compacted form as first seen, in
+// the order first seen.
+//
+// An error in an element of the array, such as an object with duplicate
+// keys, is returned as a *RecordError giving its index. Output is buffered,
+// and the elements before it may already have been written to dst.
+func DedupArray(dst io.Writer, src io.Reader) error {
+	dec := NewDecoder(src)
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != Delim('[') {
+		return errors.New("json: DedupArray input is not an array")
+	}
+	w := bufio.NewWriter(dst)
+	w.WriteByte('[')
+	seen := make(map[[sha256.Size]byte]struct{})
+	var raw RawMessage
+	for i := 0; dec.More(); i++ {
+		raw = raw[:0]
+		if err := dec.Decode(&raw); err != nil {
+			w.Flush()
+			return &RecordError{i, err}
+		}
+		canon, err := MarshalCanonical(raw)
+		if err != nil {
+			w.Flush()
+			return &RecordError{i, err}
+		}
+		sum := sha256.Sum256(canon)
+		if _, ok := seen[sum]; ok {
+			continue
+		}
+		if len(seen) > 0 {
+			w.WriteByte(',')
+		}
+		seen[sum] = struct{}{}
+		if err := compact(w, raw, false); err != nil {
+			return err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		w.Flush()
+		return err
+	}
+	w.WriteByte(']')
+	return w.Flush()
+}
@@ -0,0 +1,53 @@
+package json
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDedupArray(t *testing.T) {
+	const in = `[
+		{"a": 1, "b": {"x": [1, 2], "y": "z"}},
+		{"b": {"y": "z", "x": [1, 2]}, "a": 1.0},
+		{"b": {"y": "z", "x": [2, 1]}, "a": 1},
+		"A", "A", null, 10, 1e1, [], [ ], null
+	]`
+	const want = `[{"a":1,"b":{"x":[1,2],"y":"z"}},{"b":{"y":"z","x":[2,1]},"a":1},"A",null,10,[]]`
+	var out bytes.Buffer
+	if err := DedupArray(&out, iotest.OneByteReader(strings.NewReader(in))); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want {
+		t.Errorf("DedupArray:\n got %s\nwant %s", out.String(), want)
+	}
+
+	out.Reset()
+	if err := DedupArray(&out, strings.NewReader(`[]`)); err != nil || out.String() != `[]` {
+		t.Errorf("DedupArray of [] = %s, %v", out.String(), err)
+	}
+}
+
+func TestDedupArrayErrors(t *testing.T) {
+	var out bytes.Buffer
+	if err := DedupArray(&out, strings.NewReader(`{"a":1}`)); err == nil {
+		t.Error("DedupArray of an object: no error")
+	}
+
+	out.Reset()
+	err := DedupArray(&out, strings.NewReader(`[1, 1, {"a":1,"a":2}]`))
+	var re *RecordError
+	if !errors.As(err, &re) || re.Index != 2 {
+		t.Errorf("DedupArray with duplicate keys: err = %v, want RecordError for element 2", err)
+	}
+	if out.String() != `[1` {
+		t.Errorf("output before error = %q, want %q", out.String(), `[1`)
+	}
+
+	out.Reset()
+	if err := DedupArray(&out, strings.NewReader(`[1, 2`)); err == nil {
+		t.Error("DedupArray of truncated input: no error")
+	}
+}
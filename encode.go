@@ -12,17 +12,23 @@ package json
 
 import (
 	"bytes"
+	"context"
 	"encoding"
 	"encoding/base64"
 	"fmt"
+	"io"
 	"math"
+	"math/big"
 	"reflect"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 	"unicode"
+	"unicode/utf16"
 	"unicode/utf8"
+	"unsafe"
 )
 
 // Marshal returns the JSON encoding of v.
@@ -33,6 +39,9 @@ import (
 // to produce JSON. If no MarshalJSON method is present but the
 // value implements encoding.TextMarshaler instead, Marshal calls
 // its MarshalText method and encodes the result as a JSON string.
+// Failing both, if the value implements encoding.BinaryMarshaler,
+// Marshal calls its MarshalBinary method and encodes the result
+// as a base64-encoded JSON string, as for []byte.
 // The nil pointer exception is not strictly necessary
 // but mimics a similar, necessary exception in the behavior of
 // UnmarshalJSON.
@@ -42,6 +51,22 @@ import (
 // Boolean values encode as JSON booleans.
 //
 // Floating point, integer, and Number values encode as JSON numbers.
+// So do big.Int and big.Float values, whose digits are written in
+// full rather than through their MarshalJSON or MarshalText methods.
+//
+// The nullable types of package database/sql, such as sql.NullString,
+// sql.NullInt64 and sql.Null[T], encode as the JSON null value if they are
+// not valid, and otherwise as the value they hold.
+//
+// A sync.Map value encodes as a JSON object, built by ranging over its
+// entries, whose keys are made strings as for an ordinary map according to
+// their dynamic types; a key of any other type is an error. The entries are
+// sorted by key unless map key sorting is turned off. Since the map is read
+// with Range, it may be modified concurrently, but the result then need not
+// reflect any single state of the map. A sync.Map must not be copied, so it
+// is an error to marshal one that is not reached through a pointer, such as
+// a field of a struct passed by value: pass a *sync.Map, or a pointer to the
+// struct, instead.
 //
 // String values encode as JSON strings coerced to valid UTF-8,
 // replacing invalid bytes with the Unicode replacement rune.
@@ -72,6 +97,18 @@ import (
 // false, 0, a nil pointer, a nil interface value, and any empty array,
 // slice, map, or string.
 //
+// The "omitzero" option specifies that the field should be omitted
+// from the encoding if the field has the zero value of its type. Unlike
+// "omitempty", this applies to structs and arrays: a struct is zero if all
+// of its fields, exported or not, are zero, and an array if all of its
+// elements are. If the field's type has an "IsZero() bool" method, that
+// method is used to decide instead, so that, for example, a time.Time field
+// is omitted if its IsZero method reports true. A pointer field is zero only
+// if it is nil, unless the pointer type itself has an IsZero method, as
+// *time.Time does: then a pointer to a zero time.Time is also omitted. If
+// both "omitempty" and "omitzero" are given, the field is omitted if it is
+// either empty or zero.
+//
 // As a special case, if the field tag is "-", the field is always omitted.
 // Note that a field with name "-" can still be generated using the tag "-,".
 //
@@ -90,6 +127,14 @@ import (
 //   // Note the leading comma.
 //   Field int `json:",omitempty"`
 //
+//   // Field appears in JSON as key "addr", but the field is
+//   // skipped if it is a zero-valued struct.
+//   Field Address `json:"addr,omitzero"`
+//
+//   // Field appears in JSON as key "user_id" when encoding, but is
+//   // decoded from key "userId" or "Field".
+//   Field int `json:"out=user_id,in=userId"`
+//
 //   // Field is ignored by this package.
 //   Field int `json:"-"`
 //
@@ -103,10 +148,81 @@ import (
 //
 //    Int64String int64 `json:",string"`
 //
+// The "layout" option applies only to fields of type time.Time or *time.Time,
+// and specifies a layout, as accepted by time.Time.Format, with which the time
+// is formatted as a JSON string, instead of using its MarshalJSON method.
+// Unmarshal parses such fields using the same layout. Since options are
+// separated by commas, the layout cannot contain a comma:
+//
+//    Date time.Time `json:"date,layout=2006-01-02"`
+//
+// The "unit" option applies only to fields of type time.Duration or
+// *time.Duration, which are otherwise encoded as a number of nanoseconds. It
+// specifies one of the units "ns", "us", "ms", "s", "m" and "h", in which the
+// duration is encoded as a number, fractional if need be, or "string", with
+// which it is encoded as a JSON string formatted by time.Duration.String.
+// Unmarshal interprets numbers in the same unit. Unmarshal always accepts a
+// JSON string for a time.Duration, and parses it with time.ParseDuration:
+//
+//    Timeout time.Duration `json:"timeout,unit=s"`
+//
+// The "raw" option applies only to fields of type []byte or RawMessage, and
+// is ignored by Marshal. When Unmarshal decodes a JSON object into the struct,
+// it stores a copy of the object's source, from its opening brace to its
+// closing brace and including any space within it, in the field, as well as
+// decoding the other fields. A struct may have only one such field, and
+// Unmarshal reports an error for a struct with several:
+//
+//    Source RawMessage `json:",raw"`
+//
+// The "unknown" option applies only to fields of type map[string]RawMessage.
+// Unmarshal stores each key of a JSON object that matches no other field of
+// the struct in the map, with its value, rather than ignoring the key or, under
+// Decoder.DisallowUnknownFields, reporting an error. Marshal writes the entries
+// of the map after the other fields, and reports an error if any key is the
+// name of another field. A struct may have only one such field:
+//
+//    Extra map[string]RawMessage `json:",unknown"`
+//
+// The "bool=numeric" option applies only to fields of bool type, or pointers
+// to one, and encodes true and false as the numbers 1 and 0. The
+// "null=emptystring" option applies only to fields of pointer, interface, map
+// and slice types, and encodes a nil value as an empty string rather than as
+// null. Unmarshal accepts the same forms for such fields, as well as the usual
+// ones:
+//
+//    Active bool    `json:"active,bool=numeric"`
+//    Note   *string `json:"note,null=emptystring"`
+//
+// The "number" option applies only to fields whose type, or a pointer to it,
+// implements encoding.TextMarshaler, such as decimal types, and replaces the
+// string option. Marshal writes the output of MarshalText as a bare JSON
+// number rather than as a string, and reports a *MarshalerError if it is not a
+// valid number. Unmarshal passes the text of a JSON number to UnmarshalText,
+// and accepts a JSON string as usual:
+//
+//    Amount Decimal `json:"amount,number"`
+//
+// The "default" option is ignored by Marshal. When Unmarshal decodes a JSON
+// object into a struct and the field's key is absent from the object, it
+// decodes the default into the field, if the field is still zero. The default
+// is taken as JSON text, except that it is taken as a JSON string for fields
+// of string kind or if it is not valid JSON. A key that is present, even with
+// a zero value or null, leaves the default unused. The default cannot contain
+// a comma:
+//
+//    Port int `json:"port,default=8080"`
+//
 // The key name will be used if it's a non-empty string consisting of
 // only Unicode letters, digits, and ASCII punctuation except quotation
 // marks, backslash, and comma.
 //
+// A tag whose name begins with "out=" or "in=" gives separate key names for
+// encoding and decoding, as out=name and in=name options in either order,
+// followed by any other options. Marshal uses the out name, or the field name
+// if there is none. Unmarshal matches the in name, if any, and the field name,
+// but not the out name.
+//
 // Anonymous struct fields are usually marshaled as if their inner exported fields
 // were fields in the outer struct, subject to the usual Go visibility rules amended
 // as described in the next paragraph.
@@ -128,18 +244,37 @@ import (
 //
 // 3) Otherwise there are multiple fields, and all are ignored; no error occurs.
 //
+// The "inline" option applies to a field of struct type, or pointer to struct
+// type, and flattens it into the outer struct: the inner struct's fields are
+// marshaled and unmarshaled as if they were fields of the outer struct,
+// exactly as for an anonymous struct field without a JSON tag, whether or not
+// the field itself is anonymous. Any name given in the tag is ignored. The
+// inlined fields are one level more deeply nested than the outer struct's own,
+// so, by the rules above, an outer field with the same key hides an inlined
+// one, and fields with the same key inlined at the same level are all ignored
+// unless exactly one is tagged. Inlining may be repeated at any depth. A nil
+// pointer field contributes nothing to the encoding, and is allocated by
+// Unmarshal when one of its keys is decoded. The option is ignored for fields
+// of other types:
+//
+//    Meta Metadata `json:",inline"`
+//
 // Handling of anonymous struct fields is new in Go 1.1.
 // Prior to Go 1.1, anonymous struct fields were ignored. To force ignoring of
 // an anonymous struct field in both current and earlier versions, give the field
 // a JSON tag of "-".
 //
 // Map values encode as JSON objects. The map's key type must either be a
-// string, an integer type, or implement encoding.TextMarshaler. The map keys
-// are sorted and used as JSON object keys by applying the following rules,
-// subject to the UTF-8 coercion described for string values above:
+// string, an integer type, or implement encoding.TextMarshaler or
+// encoding.BinaryMarshaler. The map keys are sorted and used as JSON object
+// keys by applying the following rules, subject to the UTF-8 coercion
+// described for string values above:
 //   - keys of any string type are used directly
 //   - encoding.TextMarshalers are marshaled
-//   - integer keys are converted to strings
+//   - encoding.BinaryMarshalers are marshaled and base64-encoded
+//   - integer keys are converted to strings, or, for an Encoder on which
+//     SetStringerMapKeys(true) has been called, integer keys implementing
+//     fmt.Stringer are converted by their String method
 //
 // Pointer values encode as the value pointed to.
 // A nil pointer encodes as the null JSON value.
@@ -159,7 +294,7 @@ func Marshal(v interface{}) ([]byte, error) {
 	// Create an encode state backed by a growable bytes.Buffer
 	e := newEncodeState()
 
-	err := e.marshal(v, encOpts{escapeHTML: true})
+	err := e.marshal(v, encOpts{escapeHTML: true, sortMapKeys: true})
 	if err != nil {
 		return nil, err
 	}
@@ -171,6 +306,79 @@ func Marshal(v interface{}) ([]byte, error) {
 	return buf, nil
 }
 
+// Append appends the JSON encoding of v to dst and returns the extended
+// buffer, in the manner of strconv.AppendInt, so that a single buffer can be
+// reused to build many values without Marshal's allocation of a new one for
+// each. The encoding is exactly that of Marshal, including its escaping of
+// HTML characters. The buffer grows as append would, so the result only
+// shares storage with dst if dst has enough spare capacity. If an error
+// occurs, Append returns dst and the error; the elements of dst are
+// unchanged, though its spare capacity may have been written to.
+func Append(dst []byte, v interface{}) ([]byte, error) {
+	e := newEncodeState()
+	w := e.writer
+	e.appendBuf.b = dst
+	e.writer = &e.appendBuf
+	err := e.marshal(v, encOpts{escapeHTML: true, sortMapKeys: true})
+	b := e.appendBuf.b
+	e.appendBuf.b = nil
+	e.writer = w
+
+	encodeStatePool.Put(e)
+
+	if err != nil {
+		return dst, err
+	}
+	return b, nil
+}
+
+// An EncodedMessage holds the JSON encoding of a value, as returned by
+// Message, in a buffer taken from a pool shared with Marshal. The buffer is
+// returned to the pool by WriteTo, after which the EncodedMessage is empty. A
+// message that is never written does not hold on to the pool; its buffer is
+// simply garbage collected. An EncodedMessage must not be copied once it has
+// been returned by Message, since each copy would return the same buffer to
+// the pool.
+type EncodedMessage struct {
+	e *encodeState
+}
+
+// Message returns the JSON encoding of v, encoded exactly as by Marshal, as an
+// EncodedMessage which can be written out with its WriteTo method without the
+// copy of the encoding that Marshal makes.
+func Message(v interface{}) (EncodedMessage, error) {
+	e := newEncodeState()
+	if err := e.marshal(v, encOpts{escapeHTML: true, sortMapKeys: true}); err != nil {
+		return EncodedMessage{}, err
+	}
+	return EncodedMessage{e}, nil
+}
+
+// Len returns the length of the encoding held by m, suitable for a
+// Content-Length header, or 0 once m has been written.
+func (m *EncodedMessage) Len() int {
+	if m.e == nil {
+		return 0
+	}
+	return m.e.writer.(*bytes.Buffer).Len()
+}
+
+// WriteTo writes the encoding held by m to w, and then returns the buffer that
+// held it to the pool, whether or not the write succeeded. Any later call to
+// WriteTo writes nothing. It implements io.WriterTo.
+func (m *EncodedMessage) WriteTo(w io.Writer) (int64, error) {
+	if m.e == nil {
+		return 0, nil
+	}
+	e := m.e
+	m.e = nil
+	buf := e.writer.(*bytes.Buffer)
+	n, err := w.Write(buf.Bytes())
+	buf.Reset()
+	encodeStatePool.Put(e)
+	return int64(n), err
+}
+
 // MarshalIndent is like Marshal but applies Indent to format the output.
 // Each JSON element in the output will begin on a new line beginning with prefix
 // followed by one or more copies of indent according to the indentation nesting.
@@ -187,6 +395,42 @@ func MarshalIndent(v interface{}, prefix, indent string) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// MarshalIndentTo is like MarshalIndent but writes the indented encoding of v
+// to w, as MarshalStream does, rather than returning it. The encoding is
+// indented as it is produced, so neither the compact nor the indented form is
+// accumulated in an intermediate buffer.
+//
+// Output is written to w in many small writes; callers writing to an
+// unbuffered destination may wish to wrap it in a bufio.Writer. If an error is
+// encountered, a partial and invalid encoding may already have been written
+// to w. The error returned is the same as MarshalIndent would return, or the
+// first error returned by w.
+func MarshalIndentTo(w io.Writer, v interface{}, prefix, indent string) error {
+	iw := newIndentWriter(w, prefix, indent)
+	e := newDirectEncodeState(iw)
+	if err := e.marshal(v, encOpts{escapeHTML: true, sortMapKeys: true}); err != nil {
+		return err
+	}
+	return iw.close()
+}
+
+// MarshalStream writes the JSON encoding of v to w.
+//
+// Unlike Marshal, MarshalStream does not accumulate the encoding in an
+// intermediate buffer before writing it, so the memory used does not grow with
+// the size of the output. The tradeoff is that output is written to w
+// incrementally, in many small writes; callers writing to an unbuffered
+// destination may wish to wrap it in a bufio.Writer. If an error is
+// encountered, a partial and invalid encoding may already have been written
+// to w. The error returned is the same as Marshal would return, or the first
+// error returned by w.
+//
+// As with Marshal, HTML characters in strings are escaped.
+func MarshalStream(w io.Writer, v interface{}) error {
+	e := newDirectEncodeState(w)
+	return e.marshal(v, encOpts{escapeHTML: true, sortMapKeys: true})
+}
+
 // HTMLEscape appends to dst the JSON-encoded src with <, >, &, U+2028 and U+2029
 // characters inside string literals changed to \u003c, \u003e, \u0026, \u2028, \u2029
 // so that the JSON will be safe to embed inside HTML <script> tags.
@@ -228,6 +472,15 @@ type Marshaler interface {
 	MarshalJSON() ([]byte, error)
 }
 
+// MarshalerContext is the interface implemented by types that can marshal
+// themselves into valid JSON using request-scoped values carried by a
+// context, such as a locale. It is used only when encoding with
+// Encoder.EncodeContext and a non-nil context; otherwise, such types are
+// encoded as if they did not implement MarshalerContext.
+type MarshalerContext interface {
+	MarshalJSONContext(ctx context.Context) ([]byte, error)
+}
+
 // An UnsupportedTypeError is returned by Marshal when attempting
 // to encode an unsupported value type.
 type UnsupportedTypeError struct {
@@ -250,15 +503,36 @@ func (e *UnsupportedValueError) Error() string {
 // Before Go 1.2, an InvalidUTF8Error was returned by Marshal when
 // attempting to encode a string value with invalid UTF-8 sequences.
 // As of Go 1.2, Marshal instead coerces the string to valid UTF-8 by
-// replacing invalid bytes with the Unicode replacement rune U+FFFD.
-//
-// Deprecated: No longer used; kept for compatibility.
+// replacing invalid bytes with the Unicode replacement rune U+FFFD. It is
+// now returned only by an Encoder in the InvalidUTF8Reject mode set by
+// Encoder.SetInvalidUTF8.
 type InvalidUTF8Error struct {
-	S string // the whole string value that caused the error
+	S    string // the whole string value that caused the error
+	Path string // dotted path of the enclosing struct field, or "" if none
 }
 
 func (e *InvalidUTF8Error) Error() string {
-	return "json: invalid UTF-8 in string: " + strconv.Quote(e.S)
+	s := "json: invalid UTF-8 in string: " + strconv.Quote(e.S)
+	if e.Path != "" {
+		s += " in field " + e.Path
+	}
+	return s
+}
+
+// A StringLengthError is returned by an Encoder that is asked to encode a
+// string longer than the maximum set by Encoder.SetMaxStringLength.
+type StringLengthError struct {
+	Path   string // dotted path of the enclosing struct field, or "" if none
+	Length int    // length of the string in bytes
+	Max    int    // maximum length in bytes
+}
+
+func (e *StringLengthError) Error() string {
+	s := "json: string of " + strconv.Itoa(e.Length) + " bytes exceeds maximum length of " + strconv.Itoa(e.Max)
+	if e.Path != "" {
+		s += " in field " + e.Path
+	}
+	return s
 }
 
 // A MarshalerError represents an error from calling a MarshalJSON or MarshalText method.
@@ -279,6 +553,31 @@ var hex = "0123456789abcdef"
 type encodeState struct {
 	writer  // accumulated output
 	scratch [64]byte
+	ctx     context.Context // passed to MarshalerContext implementations, if non-nil
+
+	fieldPath []byte // dotted path of the struct field being encoded, if opts.fieldFilter, opts.maxStringLength or InvalidUTF8Reject is set
+
+	appendBuf appendWriter // the writer used by Append
+}
+
+// appendWriter is a writer that appends to a byte slice.
+type appendWriter struct {
+	b []byte
+}
+
+func (w *appendWriter) Write(p []byte) (int, error) {
+	w.b = append(w.b, p...)
+	return len(p), nil
+}
+
+func (w *appendWriter) WriteString(s string) (int, error) {
+	w.b = append(w.b, s...)
+	return len(s), nil
+}
+
+func (w *appendWriter) WriteByte(c byte) error {
+	w.b = append(w.b, c)
+	return nil
 }
 
 // encodeStatePool is only used for encode states where buffer is a *bytes.Buffer
@@ -291,6 +590,15 @@ func newEncodeState() *encodeState {
 	return &encodeState{writer: new(bytes.Buffer)}
 }
 
+// newDirectEncodeState returns an encode state which writes directly to w.
+func newDirectEncodeState(w io.Writer) *encodeState {
+	// If underlying writer supports the required methods then use it
+	if t, ok := w.(writer); ok {
+		return &encodeState{writer: t}
+	}
+	return &encodeState{writer: &convertWriter{Writer: w}}
+}
+
 // jsonError is an error wrapper type for internal use only.
 // Panics with errors are wrapped in jsonError so that the top-level recover
 // can distinguish intentional panics from this package.
@@ -306,6 +614,7 @@ func (e *encodeState) marshal(v interface{}, opts encOpts) (err error) {
 			}
 		}
 	}()
+	e.fieldPath = e.fieldPath[:0]
 	e.reflectValue(reflect.ValueOf(v), opts)
 	return nil
 }
@@ -315,6 +624,39 @@ func (e *encodeState) error(err error) {
 	panic(jsonError{err})
 }
 
+type isZeroer interface {
+	IsZero() bool
+}
+
+var isZeroerType = reflect.TypeOf((*isZeroer)(nil)).Elem()
+
+// newIsZeroFunc returns a function reporting whether a value of type t is
+// zero, for the "omitzero" option.
+func newIsZeroFunc(t reflect.Type) func(reflect.Value) bool {
+	switch {
+	case t.Implements(isZeroerType):
+		if t.Kind() == reflect.Ptr || t.Kind() == reflect.Interface {
+			return func(v reflect.Value) bool {
+				return v.IsNil() || v.Interface().(isZeroer).IsZero()
+			}
+		}
+		return func(v reflect.Value) bool {
+			return v.Interface().(isZeroer).IsZero()
+		}
+	case t.Kind() != reflect.Ptr && reflect.PtrTo(t).Implements(isZeroerType):
+		return func(v reflect.Value) bool {
+			if !v.CanAddr() {
+				// Make a copy, so that the pointer method can be called.
+				c := reflect.New(t).Elem()
+				c.Set(v)
+				v = c
+			}
+			return v.Addr().Interface().(isZeroer).IsZero()
+		}
+	}
+	return reflect.Value.IsZero
+}
+
 func isEmptyValue(v reflect.Value) bool {
 	switch v.Kind() {
 	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
@@ -334,6 +676,12 @@ func isEmptyValue(v reflect.Value) bool {
 }
 
 func (e *encodeState) reflectValue(v reflect.Value, opts encOpts) {
+	if opts.encoders != nil && e.encodeCustom(v, opts) {
+		return
+	}
+	if opts.errorAsString && e.encodeError(v, opts) {
+		return
+	}
 	valueEncoder(v)(e, v, opts)
 }
 
@@ -342,7 +690,135 @@ type encOpts struct {
 	quoted bool
 	// escapeHTML causes '<', '>', and '&' to be escaped in JSON strings.
 	escapeHTML bool
-}
+	// keepLineSeparators causes U+2028 and U+2029 not to be escaped in JSON
+	// strings, unless escapeNonASCII.
+	keepLineSeparators bool
+	// escapeNonASCII causes all non-ASCII characters to be escaped in JSON
+	// strings.
+	escapeNonASCII bool
+	// escapeSlash causes '/' to be escaped as \/ in JSON strings.
+	escapeSlash bool
+	// sortMapKeys causes map keys to be sorted.
+	sortMapKeys bool
+	// invalidFloat determines how NaN and infinite floats are encoded.
+	invalidFloat InvalidFloatMode
+	// invalidUTF8 determines how invalid UTF-8 in strings is encoded.
+	invalidUTF8 InvalidUTF8Mode
+	// stringerMapKeys causes integer map keys implementing fmt.Stringer
+	// to be encoded using their String method.
+	stringerMapKeys bool
+	// floatFormat determines the notation of floating point numbers.
+	floatFormat FloatFormat
+	// fieldFilter, if non-nil, is called with the path of each struct
+	// field, which is omitted if it returns false.
+	fieldFilter func(path string) bool
+	// trustPreEncoded causes PreEncoded values to be written without
+	// being validated.
+	trustPreEncoded bool
+	// trustMarshalJSON causes the output of MarshalJSON methods to be
+	// written verbatim, rather than compacted and validated.
+	trustMarshalJSON bool
+	// encoders holds the functions registered with Encoder.RegisterEncoder,
+	// if any, which take precedence over all other encodings of their types.
+	encoders map[reflect.Type]func(v interface{}) ([]byte, error)
+	// fieldOrders holds the field orders set with Encoder.SetFieldOrder,
+	// if any, which take precedence over sortKeys for their types.
+	fieldOrders map[reflect.Type]*fieldOrder
+	// nilSliceEmpty causes nil slices and maps to be encoded as empty
+	// ones, rather than as null.
+	nilSliceEmpty bool
+	// includeUnexported causes unexported struct fields to be encoded too.
+	includeUnexported bool
+	// maxStringLength, if positive, is the maximum length in bytes of a
+	// string value.
+	maxStringLength int
+	// sortKeys causes struct fields to be encoded in order of their keys.
+	sortKeys bool
+	// errorAsString causes errors other than Marshalers to be encoded as
+	// their messages.
+	errorAsString bool
+}
+
+// A FloatFormat determines the notation in which an Encoder writes floating
+// point numbers. Whatever the format, the shortest decimal representation
+// that parses back to the same float is used, so precision is never lost.
+type FloatFormat int
+
+const (
+	// FloatFormatDefault writes numbers as ECMAScript would, using
+	// exponent notation only for magnitudes below 1e-6 or from 1e21 on.
+	// This is the behavior of Marshal.
+	FloatFormatDefault FloatFormat = iota
+	// FloatFormatFixed never uses exponent notation. The output for very
+	// large or very small magnitudes can be long: 1e300 is written as 301
+	// digits, and 5e-324 with 323 zeros after the decimal point.
+	FloatFormatFixed
+	// FloatFormatIntegral is like FloatFormatDefault, except that whole
+	// numbers are written without exponent notation, so that 1e21 is
+	// written as 1000000000000000000000. Whole numbers of large magnitude
+	// are written with as many digits as FloatFormatFixed would use.
+	FloatFormatIntegral
+)
+
+// An EscapeMode is a set of flags, each of which causes an Encoder to escape a
+// class of characters in JSON strings. See Encoder.SetEscapeMode.
+type EscapeMode uint
+
+const (
+	// EscapeHTML escapes <, > and & as \u003c, \u003e and \u0026, so that
+	// the JSON is safe to embed inside HTML <script> tags.
+	EscapeHTML EscapeMode = 1 << iota
+	// EscapeLineSeparators escapes U+2028 LINE SEPARATOR and U+2029
+	// PARAGRAPH SEPARATOR as \u2028 and \u2029, which JavaScript before
+	// ES2019 does not permit in string literals.
+	EscapeLineSeparators
+	// EscapeNonASCII escapes every non-ASCII character as \uXXXX, using a
+	// UTF-16 surrogate pair for characters outside the Basic Multilingual
+	// Plane, so that the output consists only of ASCII.
+	EscapeNonASCII
+	// EscapeSlash escapes the solidus / as \/, as some older consumers
+	// expect, and so that a string containing </script> cannot end an
+	// enclosing HTML <script> element.
+	EscapeSlash
+)
+
+// An InvalidFloatMode determines how an Encoder encodes the floating point
+// values NaN, +Inf and -Inf, which have no JSON representation.
+type InvalidFloatMode int
+
+const (
+	// InvalidFloatError causes encoding to fail with an
+	// UnsupportedValueError. This is the default, and is the behavior of
+	// Marshal.
+	InvalidFloatError InvalidFloatMode = iota
+	// InvalidFloatNull encodes invalid floats as the JSON null.
+	InvalidFloatNull
+	// InvalidFloatString encodes invalid floats as the JSON strings "NaN",
+	// "+Inf" and "-Inf". Unmarshal cannot decode these strings into a float;
+	// doing so requires a type implementing Unmarshaler.
+	InvalidFloatString
+)
+
+// An InvalidUTF8Mode determines how an Encoder encodes the bytes of a string
+// that are not valid UTF-8.
+type InvalidUTF8Mode int
+
+const (
+	// InvalidUTF8Replace replaces each invalid byte with the Unicode
+	// replacement character U+FFFD. This is the default, and is the
+	// behavior of Marshal.
+	InvalidUTF8Replace InvalidUTF8Mode = iota
+	// InvalidUTF8Reject causes encoding to fail with an InvalidUTF8Error
+	// giving the string and the path of the struct field holding it.
+	InvalidUTF8Reject
+	// InvalidUTF8Escape writes each invalid byte b as the escape \u00XX,
+	// the code point of the same value, which makes the bytes visible in the
+	// output. The escape does not round-trip: \u0080 decodes to the valid
+	// code point U+0080, encoded in UTF-8 as two bytes, not to the original
+	// byte 0x80, and nothing in the output marks it as having been invalid.
+	// To detect such bytes, use InvalidUTF8Reject.
+	InvalidUTF8Escape
+)
 
 type encoderFunc func(e *encodeState, v reflect.Value, opts encOpts)
 
@@ -385,13 +861,55 @@ func typeEncoder(t reflect.Type) encoderFunc {
 }
 
 var (
-	marshalerType     = reflect.TypeOf((*Marshaler)(nil)).Elem()
-	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	marshalerType        = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	marshalerContextType = reflect.TypeOf((*MarshalerContext)(nil)).Elem()
+	textMarshalerType    = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	binaryMarshalerType  = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
 )
 
 // newTypeEncoder constructs an encoderFunc for a type.
 // The returned encoder only checks CanAddr when allowAddr is true.
 func newTypeEncoder(t reflect.Type, allowAddr bool) encoderFunc {
+	if t.Implements(marshalerContextType) {
+		return marshalerContextEncoder{fallback: newNonContextTypeEncoder(t, allowAddr)}.encode
+	}
+	if t.Kind() != reflect.Ptr && allowAddr && reflect.PtrTo(t).Implements(marshalerContextType) {
+		return newCondAddrEncoder(
+			marshalerContextEncoder{addr: true, fallback: newNonContextTypeEncoder(t, true)}.encode,
+			newTypeEncoder(t, false))
+	}
+	return newNonContextTypeEncoder(t, allowAddr)
+}
+
+// newNonContextTypeEncoder is like newTypeEncoder, but ignores any
+// implementation of MarshalerContext.
+func newNonContextTypeEncoder(t reflect.Type, allowAddr bool) encoderFunc {
+	// big.Int and big.Float are encoded natively as JSON numbers, in
+	// preference to their Marshaler or TextMarshaler implementations.
+	switch t {
+	case bigIntType:
+		return bigIntEncoder
+	case bigFloatType:
+		return bigFloatEncoder
+	}
+	if t.Kind() == reflect.Ptr && (t.Elem() == bigIntType || t.Elem() == bigFloatType) {
+		return newPtrEncoder(t)
+	}
+	if t == preEncodedType {
+		return preEncodedEncoder
+	}
+	if t.Kind() == reflect.Ptr && t.Elem() == preEncodedType {
+		return newPtrEncoder(t)
+	}
+	// OrderedMap is encoded with the options in effect, rather than through
+	// its MarshalJSON method, which can only use those of Marshal.
+	if t == orderedMapType {
+		return orderedMapEncoder
+	}
+	if t.Kind() == reflect.Ptr && t.Elem() == orderedMapType {
+		return newPtrEncoder(t)
+	}
+
 	if t.Implements(marshalerType) {
 		return marshalerEncoder
 	}
@@ -405,6 +923,18 @@ func newTypeEncoder(t reflect.Type, allowAddr bool) encoderFunc {
 	if t.Kind() != reflect.Ptr && allowAddr && reflect.PtrTo(t).Implements(textMarshalerType) {
 		return newCondAddrEncoder(addrTextMarshalerEncoder, newTypeEncoder(t, false))
 	}
+	if t.Implements(binaryMarshalerType) {
+		return binaryMarshalerEncoder
+	}
+	if t.Kind() != reflect.Ptr && allowAddr && reflect.PtrTo(t).Implements(binaryMarshalerType) {
+		return newCondAddrEncoder(addrBinaryMarshalerEncoder, newTypeEncoder(t, false))
+	}
+	if isSQLNull(t) {
+		return newSQLNullEncoder(t)
+	}
+	if t == syncMapType {
+		return syncMapEncoder
+	}
 
 	switch t.Kind() {
 	case reflect.Bool:
@@ -442,6 +972,70 @@ func invalidValueEncoder(e *encodeState, v reflect.Value, _ encOpts) {
 	}
 }
 
+var preEncodedType = reflect.TypeOf(PreEncoded(nil))
+
+var rawMessageType = reflect.TypeOf(RawMessage(nil))
+
+// unknownFields writes the entries of the map held by the field of the struct
+// v with the unknown option, each preceded by next and then by a comma, and
+// returns the byte to precede any further entry. It is an error for a key of
+// the map to be the name of another field of v.
+func (e *encodeState) unknownFields(v reflect.Value, fields structFields, next byte, opts encOpts) byte {
+	mv := v
+	for _, i := range fields.unknown {
+		if mv.Kind() == reflect.Ptr {
+			if mv.IsNil() {
+				return next
+			}
+			mv = mv.Elem()
+		}
+		mv = mv.Field(i)
+	}
+	if mv.Len() == 0 {
+		return next
+	}
+	keys := make([]string, 0, mv.Len())
+	for iter := mv.MapRange(); iter.Next(); {
+		keys = append(keys, iter.Key().String())
+	}
+	if opts.sortMapKeys {
+		sort.Strings(keys)
+	}
+	for _, k := range keys {
+		for i := range fields.list {
+			if fields.list[i].name == k {
+				e.error(&UnsupportedValueError{mv, fmt.Sprintf("unknown key %q collides with a field of %v", k, v.Type())})
+			}
+		}
+		if err := e.WriteByte(next); err != nil {
+			e.error(err)
+		}
+		next = ','
+		e.string(k, opts)
+		if err := e.WriteByte(':'); err != nil {
+			e.error(err)
+		}
+		e.reflectValue(mv.MapIndex(reflect.ValueOf(k).Convert(mv.Type().Key())), opts)
+	}
+	return next
+}
+
+// preEncodedEncoder writes a PreEncoded value verbatim, after checking that it
+// is valid JSON unless opts.trustPreEncoded.
+func preEncodedEncoder(e *encodeState, v reflect.Value, opts encOpts) {
+	b := v.Bytes()
+	if b == nil {
+		b = []byte("null")
+	} else if !opts.trustPreEncoded {
+		if err := checkValid(b, &scanner{}); err != nil {
+			e.error(&MarshalerError{v.Type(), err})
+		}
+	}
+	if _, err := e.Write(b); err != nil {
+		e.error(err)
+	}
+}
+
 func marshalerEncoder(e *encodeState, v reflect.Value, opts encOpts) {
 	if v.Kind() == reflect.Ptr && v.IsNil() {
 		if _, err := e.WriteString("null"); err != nil {
@@ -458,12 +1052,108 @@ func marshalerEncoder(e *encodeState, v reflect.Value, opts encOpts) {
 	}
 	b, err := m.MarshalJSON()
 	if err == nil {
-		// copy JSON into buffer, checking validity.
-		if bb, ok := e.writer.(*bytes.Buffer); ok {
-			err = compactWithRevert(bb, b, opts.escapeHTML)
-		} else {
-			err = compact(e, b, opts.escapeHTML)
+		err = e.writeMarshaled(b, opts)
+	}
+	if err != nil {
+		e.error(&MarshalerError{v.Type(), err})
+	}
+}
+
+// writeMarshaled copies b, the output of a MarshalJSON or MarshalJSONContext
+// method, into e. Unless opts.trustMarshalJSON, b is compacted and escaped,
+// which checks its validity.
+func (e *encodeState) writeMarshaled(b []byte, opts encOpts) error {
+	if opts.trustMarshalJSON {
+		_, err := e.Write(b)
+		return err
+	}
+	if bb, ok := e.writer.(*bytes.Buffer); ok {
+		return compactWithRevert(bb, b, opts.escapeHTML)
+	}
+	return compact(e, b, opts.escapeHTML)
+}
+
+// encodeCustom encodes v with the function registered for its type in
+// opts.encoders, if there is one, and reports whether there was.
+func (e *encodeState) encodeCustom(v reflect.Value, opts encOpts) bool {
+	if !v.IsValid() || !v.CanInterface() {
+		return false
+	}
+	fn, ok := opts.encoders[v.Type()]
+	if !ok {
+		return false
+	}
+	b, err := fn(v.Interface())
+	if err == nil {
+		err = e.writeMarshaled(b, opts)
+	}
+	if err != nil {
+		e.error(&MarshalerError{v.Type(), err})
+	}
+	return true
+}
+
+// encodeError encodes v as the string returned by its Error method, if it is
+// a non-nil error, or the address of one, that implements neither Marshaler
+// nor MarshalerContext, and reports whether it did, for opts.errorAsString.
+func (e *encodeState) encodeError(v reflect.Value, opts encOpts) bool {
+	if !v.IsValid() || !v.CanInterface() {
+		return false
+	}
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if !v.IsValid() || v.Kind() == reflect.Ptr && v.IsNil() {
+		// Encoded as null, as usual.
+		return false
+	}
+	if v.Kind() != reflect.Ptr && v.CanAddr() {
+		v = v.Addr()
+	}
+	if v.Type().Implements(marshalerType) || v.Type().Implements(marshalerContextType) {
+		return false
+	}
+	err, ok := v.Interface().(error)
+	if !ok {
+		return false
+	}
+	e.string(err.Error(), opts)
+	return true
+}
+
+// marshalerContextEncoder encodes values implementing MarshalerContext,
+// or whose address does if addr is set. If the encode state has no context,
+// it delegates to fallback.
+type marshalerContextEncoder struct {
+	addr     bool
+	fallback encoderFunc
+}
+
+func (ce marshalerContextEncoder) encode(e *encodeState, v reflect.Value, opts encOpts) {
+	if e.ctx == nil {
+		ce.fallback(e, v, opts)
+		return
+	}
+	va := v
+	if ce.addr {
+		va = v.Addr()
+	}
+	if va.Kind() == reflect.Ptr && va.IsNil() {
+		if _, err := e.WriteString("null"); err != nil {
+			e.error(err)
+		}
+		return
+	}
+	m, ok := va.Interface().(MarshalerContext)
+	if !ok {
+		if _, err := e.WriteString("null"); err != nil {
+			e.error(err)
 		}
+		return
+	}
+	b, err := m.MarshalJSONContext(e.ctx)
+	if err == nil {
+		err = e.writeMarshaled(b, opts)
 	}
 	if err != nil {
 		e.error(&MarshalerError{v.Type(), err})
@@ -481,12 +1171,7 @@ func addrMarshalerEncoder(e *encodeState, v reflect.Value, opts encOpts) {
 	m := va.Interface().(Marshaler)
 	b, err := m.MarshalJSON()
 	if err == nil {
-		// copy JSON into buffer, checking validity.
-		if bb, ok := e.writer.(*bytes.Buffer); ok {
-			err = compactWithRevert(bb, b, opts.escapeHTML)
-		} else {
-			err = compact(e, b, opts.escapeHTML)
-		}
+		err = e.writeMarshaled(b, opts)
 	}
 	if err != nil {
 		e.error(&MarshalerError{v.Type(), err})
@@ -511,7 +1196,7 @@ func textMarshalerEncoder(e *encodeState, v reflect.Value, opts encOpts) {
 	if err != nil {
 		e.error(&MarshalerError{v.Type(), err})
 	}
-	e.stringBytes(b, opts.escapeHTML)
+	e.stringBytes(b, opts)
 }
 
 func addrTextMarshalerEncoder(e *encodeState, v reflect.Value, opts encOpts) {
@@ -527,7 +1212,44 @@ func addrTextMarshalerEncoder(e *encodeState, v reflect.Value, opts encOpts) {
 	if err != nil {
 		e.error(&MarshalerError{v.Type(), err})
 	}
-	e.stringBytes(b, opts.escapeHTML)
+	e.stringBytes(b, opts)
+}
+
+func binaryMarshalerEncoder(e *encodeState, v reflect.Value, _ encOpts) {
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		if _, err := e.WriteString("null"); err != nil {
+			e.error(err)
+		}
+		return
+	}
+	m, ok := v.Interface().(encoding.BinaryMarshaler)
+	if !ok {
+		if _, err := e.WriteString("null"); err != nil {
+			e.error(err)
+		}
+		return
+	}
+	b, err := m.MarshalBinary()
+	if err != nil {
+		e.error(&MarshalerError{v.Type(), err})
+	}
+	e.base64(b)
+}
+
+func addrBinaryMarshalerEncoder(e *encodeState, v reflect.Value, _ encOpts) {
+	va := v.Addr()
+	if va.IsNil() {
+		if _, err := e.WriteString("null"); err != nil {
+			e.error(err)
+		}
+		return
+	}
+	m := va.Interface().(encoding.BinaryMarshaler)
+	b, err := m.MarshalBinary()
+	if err != nil {
+		e.error(&MarshalerError{v.Type(), err})
+	}
+	e.base64(b)
 }
 
 func boolEncoder(e *encodeState, v reflect.Value, opts encOpts) {
@@ -591,24 +1313,47 @@ type floatEncoder int // number of bits
 func (bits floatEncoder) encode(e *encodeState, v reflect.Value, opts encOpts) {
 	f := v.Float()
 	if math.IsInf(f, 0) || math.IsNaN(f) {
-		e.error(&UnsupportedValueError{v, strconv.FormatFloat(f, 'g', -1, int(bits))})
+		e.invalidFloat(v, strconv.FormatFloat(f, 'g', -1, int(bits)), opts)
+		return
+	}
+
+	b := appendFloat(e.scratch[:0], f, int(bits), opts.floatFormat)
+
+	if opts.quoted {
+		if err := e.WriteByte('"'); err != nil {
+			e.error(err)
+		}
+	}
+	if _, err := e.Write(b); err != nil {
+		e.error(err)
+	}
+	if opts.quoted {
+		if err := e.WriteByte('"'); err != nil {
+			e.error(err)
+		}
 	}
+}
 
+// appendFloat appends the finite float f, of the given bit size, to b in the
+// notation selected by format.
+func appendFloat(b []byte, f float64, bits int, format FloatFormat) []byte {
 	// Convert as if by ES6 number to string conversion.
 	// This matches most other JSON generators.
 	// See golang.org/issue/6384 and golang.org/issue/14135.
 	// Like fmt %g, but the exponent cutoffs are different
 	// and exponents themselves are not padded to two digits.
-	b := e.scratch[:0]
 	abs := math.Abs(f)
 	fmt := byte('f')
 	// Note: Must use float32 comparisons for underlying float32 value to get precise cutoffs right.
-	if abs != 0 {
+	if abs != 0 && format != FloatFormatFixed {
 		if bits == 64 && (abs < 1e-6 || abs >= 1e21) || bits == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
 			fmt = 'e'
 		}
+		if format == FloatFormatIntegral && abs >= 1 && abs == math.Trunc(abs) {
+			fmt = 'f'
+		}
 	}
-	b = strconv.AppendFloat(b, f, fmt, -1, int(bits))
+	b = strconv.AppendFloat(b, f, fmt, -1, bits)
 	if fmt == 'e' {
 		// clean up e-09 to e-9
 		n := len(b)
@@ -617,19 +1362,31 @@ func (bits floatEncoder) encode(e *encodeState, v reflect.Value, opts encOpts) {
 			b = b[:n-1]
 		}
 	}
+	return b
+}
 
-	if opts.quoted {
+// invalidFloat writes the NaN or infinite value v, whose textual form
+// is s, according to opts.invalidFloat.
+func (e *encodeState) invalidFloat(v reflect.Value, s string, opts encOpts) {
+	switch opts.invalidFloat {
+	case InvalidFloatNull:
+		if _, err := e.WriteString("null"); err != nil {
+			e.error(err)
+		}
+	case InvalidFloatString:
+		// s is one of "NaN", "+Inf" and "-Inf", none of which need
+		// escaping; it is written as a string even if opts.quoted.
 		if err := e.WriteByte('"'); err != nil {
 			e.error(err)
 		}
-	}
-	if _, err := e.Write(b); err != nil {
-		e.error(err)
-	}
-	if opts.quoted {
+		if _, err := e.WriteString(s); err != nil {
+			e.error(err)
+		}
 		if err := e.WriteByte('"'); err != nil {
 			e.error(err)
 		}
+	default:
+		e.error(&UnsupportedValueError{v, s})
 	}
 }
 
@@ -654,14 +1411,17 @@ func stringEncoder(e *encodeState, v reflect.Value, opts encOpts) {
 		}
 		return
 	}
+	if opts.maxStringLength > 0 && v.Len() > opts.maxStringLength {
+		e.error(&StringLengthError{Path: string(e.fieldPath), Length: v.Len(), Max: opts.maxStringLength})
+	}
 	if opts.quoted {
 		b := make([]byte, 0, v.Len()+2)
 		b = append(b, '"')
 		b = append(b, []byte(v.String())...)
 		b = append(b, '"')
-		e.stringBytes(b, opts.escapeHTML)
+		e.stringBytes(b, opts)
 	} else {
-		e.string(v.String(), opts.escapeHTML)
+		e.string(v.String(), opts)
 	}
 }
 
@@ -741,18 +1501,44 @@ func unsupportedTypeEncoder(e *encodeState, v reflect.Value, _ encOpts) {
 
 type structEncoder struct {
 	fields structFields
+	typ    reflect.Type
 }
 
 type structFields struct {
 	list      []field
-	nameIndex map[string]int
+	nameIndex map[string]int // maps the keys matched when decoding to fields
+	sorted    []field        // list, sorted by name for opts.sortKeys
+
+	hasDefaults bool  // whether any field has a default, for decoding
+	raw         []int // index sequence of the field with the raw option, if any
+	unknown     []int // index sequence of the field with the unknown option, if any
+	err         error // reports an invalid use of the raw or unknown option, when decoding
 }
 
 func (se structEncoder) encode(e *encodeState, v reflect.Value, opts encOpts) {
+	fields := se.fields
+	if opts.includeUnexported {
+		fields = cachedUnexportedTypeFields(se.typ)
+		if !v.CanAddr() {
+			// Unexported fields can only be read through their address.
+			addr := reflect.New(v.Type()).Elem()
+			addr.Set(v)
+			v = addr
+		}
+	}
+	list := fields.list
+	if fo := opts.fieldOrders[se.typ]; fo != nil {
+		list = fo.list
+		if opts.includeUnexported {
+			list = fo.unexported
+		}
+	} else if opts.sortKeys {
+		list = fields.sorted
+	}
 	next := byte('{')
 FieldLoop:
-	for i := range se.fields.list {
-		f := &se.fields.list[i]
+	for i := range list {
+		f := &list[i]
 
 		// Find the nested struct field by following f.index.
 		fv := v
@@ -764,16 +1550,39 @@ FieldLoop:
 				fv = fv.Elem()
 			}
 			fv = fv.Field(i)
+			if opts.includeUnexported && !fv.CanInterface() {
+				fv = reflect.NewAt(fv.Type(), unsafe.Pointer(fv.UnsafeAddr())).Elem()
+			}
 		}
 
 		if f.omitEmpty && isEmptyValue(fv) {
 			continue
 		}
+		if f.omitZero && f.isZero(fv) {
+			continue
+		}
+		pathLen := len(e.fieldPath)
+		if opts.fieldFilter != nil || opts.maxStringLength > 0 || opts.invalidUTF8 == InvalidUTF8Reject {
+			if pathLen > 0 {
+				e.fieldPath = append(e.fieldPath, '.')
+			}
+			e.fieldPath = append(e.fieldPath, f.name...)
+			if opts.fieldFilter != nil && !opts.fieldFilter(string(e.fieldPath)) {
+				e.fieldPath = e.fieldPath[:pathLen]
+				continue
+			}
+		}
 		if err := e.WriteByte(next); err != nil {
 			e.error(err)
 		}
 		next = ','
-		if opts.escapeHTML {
+		if opts.escapeNonASCII || opts.keepLineSeparators || opts.escapeSlash {
+			// The precomputed names escape only per escapeHTML.
+			e.string(f.name, opts)
+			if err := e.WriteByte(':'); err != nil {
+				e.error(err)
+			}
+		} else if opts.escapeHTML {
 			if _, err := e.WriteString(f.nameEscHTML); err != nil {
 				e.error(err)
 			}
@@ -783,7 +1592,16 @@ FieldLoop:
 			}
 		}
 		opts.quoted = f.quoted
-		f.encoder(e, fv, opts)
+		switch {
+		case opts.encoders != nil && e.encodeCustom(fv, opts):
+		case opts.errorAsString && e.encodeError(fv, opts):
+		default:
+			f.encoder(e, fv, opts)
+		}
+		e.fieldPath = e.fieldPath[:pathLen]
+	}
+	if fields.unknown != nil {
+		next = e.unknownFields(v, fields, next, opts)
 	}
 	if next == '{' {
 		if _, err := e.WriteString("{}"); err != nil {
@@ -796,8 +1614,44 @@ FieldLoop:
 	}
 }
 
+// A fieldOrder is the order of a struct type's fields set by
+// Encoder.SetFieldOrder, for the exported fields and for all of them.
+type fieldOrder struct {
+	list       []field
+	unexported []field
+}
+
+// orderFields returns a copy of list with the fields having the given keys
+// first, in that order, followed by the rest in their original order.
+func orderFields(list []field, keys []string) ([]field, error) {
+	pos := make(map[string]int, len(keys))
+	for i, k := range keys {
+		if _, ok := pos[k]; ok {
+			return nil, fmt.Errorf("key %q given twice", k)
+		}
+		pos[k] = i
+	}
+	first := make([]*field, len(keys))
+	var rest []field
+	for i := range list {
+		if j, ok := pos[list[i].name]; ok {
+			first[j] = &list[i]
+		} else {
+			rest = append(rest, list[i])
+		}
+	}
+	ordered := make([]field, 0, len(list))
+	for j, f := range first {
+		if f == nil {
+			return nil, fmt.Errorf("no field with key %q", keys[j])
+		}
+		ordered = append(ordered, *f)
+	}
+	return append(ordered, rest...), nil
+}
+
 func newStructEncoder(t reflect.Type) encoderFunc {
-	se := structEncoder{fields: cachedTypeFields(t)}
+	se := structEncoder{fields: cachedTypeFields(t), typ: t}
 	return se.encode
 }
 
@@ -807,7 +1661,11 @@ type mapEncoder struct {
 
 func (me mapEncoder) encode(e *encodeState, v reflect.Value, opts encOpts) {
 	if v.IsNil() {
-		if _, err := e.WriteString("null"); err != nil {
+		null := "null"
+		if opts.nilSliceEmpty {
+			null = "{}"
+		}
+		if _, err := e.WriteString(null); err != nil {
 			e.error(err)
 		}
 		return
@@ -816,12 +1674,40 @@ func (me mapEncoder) encode(e *encodeState, v reflect.Value, opts encOpts) {
 		e.error(err)
 	}
 
+	if !opts.sortMapKeys {
+		// Encode the entries in iteration order, avoiding the
+		// allocation and sorting of the keys.
+		iter := v.MapRange()
+		for i := 0; iter.Next(); i++ {
+			if i > 0 {
+				if err := e.WriteByte(','); err != nil {
+					e.error(err)
+				}
+			}
+			kv := reflectWithString{v: iter.Key()}
+			if err := kv.resolve(opts.stringerMapKeys); err != nil {
+				e.error(&MarshalerError{kv.v.Type(), err})
+			}
+			e.string(kv.s, opts)
+			if err := e.WriteByte(':'); err != nil {
+				e.error(err)
+			}
+			if mv := iter.Value(); opts.encoders == nil || !e.encodeCustom(mv, opts) {
+				me.elemEnc(e, mv, opts)
+			}
+		}
+		if err := e.WriteByte('}'); err != nil {
+			e.error(err)
+		}
+		return
+	}
+
 	// Extract and sort the keys.
 	keys := v.MapKeys()
 	sv := make([]reflectWithString, len(keys))
 	for i, v := range keys {
 		sv[i].v = v
-		if err := sv[i].resolve(); err != nil {
+		if err := sv[i].resolve(opts.stringerMapKeys); err != nil {
 			e.error(&MarshalerError{v.Type(), err})
 		}
 	}
@@ -833,11 +1719,13 @@ func (me mapEncoder) encode(e *encodeState, v reflect.Value, opts encOpts) {
 				e.error(err)
 			}
 		}
-		e.string(kv.s, opts.escapeHTML)
+		e.string(kv.s, opts)
 		if err := e.WriteByte(':'); err != nil {
 			e.error(err)
 		}
-		me.elemEnc(e, v.MapIndex(kv.v), opts)
+		if mv := v.MapIndex(kv.v); opts.encoders == nil || !e.encodeCustom(mv, opts) {
+			me.elemEnc(e, mv, opts)
+		}
 	}
 	if err := e.WriteByte('}'); err != nil {
 		e.error(err)
@@ -850,22 +1738,123 @@ func newMapEncoder(t reflect.Type) encoderFunc {
 		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
 		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
 	default:
-		if !t.Key().Implements(textMarshalerType) {
+		if !t.Key().Implements(textMarshalerType) && !t.Key().Implements(binaryMarshalerType) {
 			return unsupportedTypeEncoder
 		}
 	}
 	me := mapEncoder{typeEncoder(t.Elem())}
+	switch t {
+	case mapStringStringType, mapStringIntType, mapStringInterfaceType:
+		return fastMapEncoder{me}.encode
+	}
 	return me.encode
 }
 
-func encodeByteSlice(e *encodeState, v reflect.Value, _ encOpts) {
+var (
+	mapStringStringType    = reflect.TypeOf(map[string]string(nil))
+	mapStringIntType       = reflect.TypeOf(map[string]int(nil))
+	mapStringInterfaceType = reflect.TypeOf(map[string]interface{}(nil))
+)
+
+// fastMapEncoder encodes the common map types map[string]string,
+// map[string]int and map[string]interface{} without the reflection used by
+// mapEncoder, producing the same output. It falls back to mapEncoder when an
+// option that mapEncoder implements per element is in effect.
+type fastMapEncoder struct {
+	mapEncoder
+}
+
+func (fe fastMapEncoder) encode(e *encodeState, v reflect.Value, opts encOpts) {
+	if v.IsNil() || !v.CanInterface() || opts.encoders != nil || opts.maxStringLength > 0 {
+		fe.mapEncoder.encode(e, v, opts)
+		return
+	}
+	switch m := v.Interface().(type) {
+	case map[string]string:
+		e.mapEntries(len(m), func(keys []string) []string {
+			for k := range m {
+				keys = append(keys, k)
+			}
+			return keys
+		}, func(k string) {
+			e.string(m[k], opts)
+		}, opts)
+	case map[string]int:
+		e.mapEntries(len(m), func(keys []string) []string {
+			for k := range m {
+				keys = append(keys, k)
+			}
+			return keys
+		}, func(k string) {
+			if _, err := e.Write(strconv.AppendInt(e.scratch[:0], int64(m[k]), 10)); err != nil {
+				e.error(err)
+			}
+		}, opts)
+	case map[string]interface{}:
+		e.mapEntries(len(m), func(keys []string) []string {
+			for k := range m {
+				keys = append(keys, k)
+			}
+			return keys
+		}, func(k string) {
+			switch x := m[k].(type) {
+			case string:
+				e.string(x, opts)
+			case nil:
+				if _, err := e.WriteString("null"); err != nil {
+					e.error(err)
+				}
+			default:
+				e.reflectValue(reflect.ValueOf(x), opts)
+			}
+		}, opts)
+	}
+}
+
+// mapEntries writes a JSON object with n members, whose keys are appended to
+// a slice by keys and whose values are written by value, in sorted order of
+// their keys if opts.sortMapKeys is set, and otherwise in the order given.
+func (e *encodeState) mapEntries(n int, keys func([]string) []string, value func(k string), opts encOpts) {
+	if err := e.WriteByte('{'); err != nil {
+		e.error(err)
+	}
+	ks := keys(make([]string, 0, n))
+	if opts.sortMapKeys {
+		sort.Strings(ks)
+	}
+	for i, k := range ks {
+		if i > 0 {
+			if err := e.WriteByte(','); err != nil {
+				e.error(err)
+			}
+		}
+		e.string(k, opts)
+		if err := e.WriteByte(':'); err != nil {
+			e.error(err)
+		}
+		value(k)
+	}
+	if err := e.WriteByte('}'); err != nil {
+		e.error(err)
+	}
+}
+
+func encodeByteSlice(e *encodeState, v reflect.Value, opts encOpts) {
 	if v.IsNil() {
-		if _, err := e.WriteString("null"); err != nil {
+		null := "null"
+		if opts.nilSliceEmpty {
+			null = `""`
+		}
+		if _, err := e.WriteString(null); err != nil {
 			e.error(err)
 		}
 		return
 	}
-	s := v.Bytes()
+	e.base64(v.Bytes())
+}
+
+// base64 writes s to e as a base64-encoded JSON string.
+func (e *encodeState) base64(s []byte) {
 	if err := e.WriteByte('"'); err != nil {
 		e.error(err)
 	}
@@ -907,7 +1896,11 @@ type sliceEncoder struct {
 
 func (se sliceEncoder) encode(e *encodeState, v reflect.Value, opts encOpts) {
 	if v.IsNil() {
-		if _, err := e.WriteString("null"); err != nil {
+		null := "null"
+		if opts.nilSliceEmpty {
+			null = "[]"
+		}
+		if _, err := e.WriteString(null); err != nil {
 			e.error(err)
 		}
 		return
@@ -942,7 +1935,9 @@ func (ae arrayEncoder) encode(e *encodeState, v reflect.Value, opts encOpts) {
 				e.error(err)
 			}
 		}
-		ae.elemEnc(e, v.Index(i), opts)
+		if ev := v.Index(i); opts.encoders == nil || !e.encodeCustom(ev, opts) {
+			ae.elemEnc(e, ev, opts)
+		}
 	}
 	if err := e.WriteByte(']'); err != nil {
 		e.error(err)
@@ -965,7 +1960,9 @@ func (pe ptrEncoder) encode(e *encodeState, v reflect.Value, opts encOpts) {
 		}
 		return
 	}
-	pe.elemEnc(e, v.Elem(), opts)
+	if ev := v.Elem(); opts.encoders == nil || !e.encodeCustom(ev, opts) {
+		pe.elemEnc(e, ev, opts)
+	}
 }
 
 func newPtrEncoder(t reflect.Type) encoderFunc {
@@ -973,6 +1970,216 @@ func newPtrEncoder(t reflect.Type) encoderFunc {
 	return enc.encode
 }
 
+var timeType = reflect.TypeOf(time.Time{})
+
+// isSQLNull reports whether t is one of the nullable types of package
+// database/sql, such as sql.NullString or sql.Null[T]: a struct holding a
+// value, followed by a Valid flag. They are recognized by their shape, so
+// as not to import the package.
+func isSQLNull(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && t.PkgPath() == "database/sql" &&
+		strings.HasPrefix(t.Name(), "Null") && t.NumField() == 2 &&
+		t.Field(1).Name == "Valid" && t.Field(1).Type.Kind() == reflect.Bool
+}
+
+// newSQLNullEncoder returns an encoder for t, a type for which isSQLNull is
+// true, that writes null if the value is not valid.
+func newSQLNullEncoder(t reflect.Type) encoderFunc {
+	valueEnc := typeEncoder(t.Field(0).Type)
+	return func(e *encodeState, v reflect.Value, opts encOpts) {
+		if !v.Field(1).Bool() {
+			if _, err := e.WriteString("null"); err != nil {
+				e.error(err)
+			}
+			return
+		}
+		valueEnc(e, v.Field(0), opts)
+	}
+}
+
+var (
+	bigIntType   = reflect.TypeOf(big.Int{})
+	bigFloatType = reflect.TypeOf(big.Float{})
+)
+
+func bigIntEncoder(e *encodeState, v reflect.Value, _ encOpts) {
+	var x *big.Int
+	if v.CanAddr() {
+		x = v.Addr().Interface().(*big.Int)
+	} else {
+		y := v.Interface().(big.Int)
+		x = &y
+	}
+	if _, err := e.Write(x.Append(e.scratch[:0], 10)); err != nil {
+		e.error(err)
+	}
+}
+
+// bigFloatEncoder writes the shortest decimal representation that
+// reproduces the value at its own precision, using the same exponent
+// cutoffs as floatEncoder.
+func bigFloatEncoder(e *encodeState, v reflect.Value, opts encOpts) {
+	var x *big.Float
+	if v.CanAddr() {
+		x = v.Addr().Interface().(*big.Float)
+	} else {
+		y := v.Interface().(big.Float)
+		x = &y
+	}
+	if x.IsInf() {
+		e.invalidFloat(v, x.String(), opts)
+		return
+	}
+
+	fmt := byte('f')
+	if x.Sign() != 0 && opts.floatFormat != FloatFormatFixed {
+		abs, _ := new(big.Float).Abs(x).Float64()
+		if abs < 1e-6 || abs >= 1e21 {
+			fmt = 'e'
+		}
+		if opts.floatFormat == FloatFormatIntegral && x.IsInt() {
+			fmt = 'f'
+		}
+	}
+	b := x.Append(e.scratch[:0], fmt, -1)
+	if fmt == 'e' {
+		// clean up e-09 to e-9
+		n := len(b)
+		if n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	if _, err := e.Write(b); err != nil {
+		e.error(err)
+	}
+}
+
+type timeLayoutEncoder string
+
+func (layout timeLayoutEncoder) encode(e *encodeState, v reflect.Value, opts encOpts) {
+	b := v.Interface().(time.Time).AppendFormat(e.scratch[:0], string(layout))
+	e.stringBytes(b, opts)
+}
+
+// newTimeLayoutEncoder returns an encoder for t, which is time.Time or
+// *time.Time, which formats the time with layout.
+func newTimeLayoutEncoder(t reflect.Type, layout string) encoderFunc {
+	enc := timeLayoutEncoder(layout).encode
+	if t.Kind() == reflect.Ptr {
+		return ptrEncoder{enc}.encode
+	}
+	return enc
+}
+
+// boolNumericEncoder encodes a bool as the number 0 or 1.
+func boolNumericEncoder(e *encodeState, v reflect.Value, opts encOpts) {
+	s := "0"
+	if v.Bool() {
+		s = "1"
+	}
+	if opts.quoted {
+		s = `"` + s + `"`
+	}
+	if _, err := e.WriteString(s); err != nil {
+		e.error(err)
+	}
+}
+
+// newBoolNumericEncoder returns an encoder for t, which is a bool type or a
+// pointer to one, which encodes the bool as the number 0 or 1.
+func newBoolNumericEncoder(t reflect.Type) encoderFunc {
+	if t.Kind() == reflect.Ptr {
+		return ptrEncoder{boolNumericEncoder}.encode
+	}
+	return boolNumericEncoder
+}
+
+// textNumberEncoder encodes a TextMarshaler, or a value whose address is
+// one, as a JSON number holding the output of MarshalText.
+func textNumberEncoder(e *encodeState, v reflect.Value, opts encOpts) {
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		if _, err := e.WriteString("null"); err != nil {
+			e.error(err)
+		}
+		return
+	}
+	if !v.Type().Implements(textMarshalerType) {
+		if !v.CanAddr() {
+			// As without the option, a value whose MarshalText method
+			// has a pointer receiver is encoded by its kind.
+			typeEncoder(v.Type())(e, v, opts)
+			return
+		}
+		v = v.Addr()
+	}
+	b, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+	if err == nil && !isValidNumber(string(b)) {
+		err = fmt.Errorf("MarshalText returned %q, which is not a valid number", b)
+	}
+	if err != nil {
+		e.error(&MarshalerError{v.Type(), err})
+	}
+	if _, err := e.Write(b); err != nil {
+		e.error(err)
+	}
+}
+
+// nullEmptyEncoder wraps the encoder of a pointer, interface, map or slice,
+// writing an empty string in place of null where the value is nil.
+type nullEmptyEncoder encoderFunc
+
+func (enc nullEmptyEncoder) encode(e *encodeState, v reflect.Value, opts encOpts) {
+	if v.IsNil() {
+		if _, err := e.WriteString(`""`); err != nil {
+			e.error(err)
+		}
+		return
+	}
+	enc(e, v, opts)
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// durationUnits maps the values of the unit option to the units they name.
+var durationUnits = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+}
+
+type durationUnitEncoder string
+
+func (unit durationUnitEncoder) encode(e *encodeState, v reflect.Value, opts encOpts) {
+	d := time.Duration(v.Int())
+	if unit == "string" {
+		e.string(d.String(), opts)
+		return
+	}
+	var b []byte
+	if u := durationUnits[string(unit)]; d%u == 0 {
+		b = strconv.AppendInt(e.scratch[:0], int64(d/u), 10)
+	} else {
+		b = appendFloat(e.scratch[:0], float64(d)/float64(u), 64, FloatFormatDefault)
+	}
+	if _, err := e.Write(b); err != nil {
+		e.error(err)
+	}
+}
+
+// newDurationUnitEncoder returns an encoder for t, which is time.Duration or
+// *time.Duration, which encodes the duration in unit.
+func newDurationUnitEncoder(t reflect.Type, unit string) encoderFunc {
+	enc := durationUnitEncoder(unit).encode
+	if t.Kind() == reflect.Ptr {
+		return ptrEncoder{enc}.encode
+	}
+	return enc
+}
+
 type condAddrEncoder struct {
 	canAddrEnc, elseEnc encoderFunc
 }
@@ -1024,7 +2231,7 @@ type reflectWithString struct {
 	s string
 }
 
-func (w *reflectWithString) resolve() error {
+func (w *reflectWithString) resolve(stringer bool) error {
 	if w.v.Kind() == reflect.String {
 		w.s = w.v.String()
 		return nil
@@ -1037,6 +2244,20 @@ func (w *reflectWithString) resolve() error {
 		w.s = string(buf)
 		return err
 	}
+	if bm, ok := w.v.Interface().(encoding.BinaryMarshaler); ok {
+		if w.v.Kind() == reflect.Ptr && w.v.IsNil() {
+			return nil
+		}
+		buf, err := bm.MarshalBinary()
+		w.s = base64.StdEncoding.EncodeToString(buf)
+		return err
+	}
+	if stringer {
+		if sv, ok := w.v.Interface().(fmt.Stringer); ok {
+			w.s = sv.String()
+			return nil
+		}
+	}
 	switch w.v.Kind() {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		w.s = strconv.FormatInt(w.v.Int(), 10)
@@ -1049,14 +2270,14 @@ func (w *reflectWithString) resolve() error {
 }
 
 // NOTE: keep in sync with stringBytes below.
-func (e *encodeState) string(s string, escapeHTML bool) {
+func (e *encodeState) string(s string, opts encOpts) {
 	if err := e.WriteByte('"'); err != nil {
 		e.error(err)
 	}
 	start := 0
 	for i := 0; i < len(s); {
 		if b := s[i]; b < utf8.RuneSelf {
-			if htmlSafeSet[b] || (!escapeHTML && safeSet[b]) {
+			if (htmlSafeSet[b] || (!opts.escapeHTML && safeSet[b])) && (b != '/' || !opts.escapeSlash) {
 				i++
 				continue
 			}
@@ -1069,7 +2290,7 @@ func (e *encodeState) string(s string, escapeHTML bool) {
 				e.error(err)
 			}
 			switch b {
-			case '\\', '"':
+			case '\\', '"', '/':
 				if err := e.WriteByte(b); err != nil {
 					e.error(err)
 				}
@@ -1112,9 +2333,10 @@ func (e *encodeState) string(s string, escapeHTML bool) {
 					e.error(err)
 				}
 			}
-			if _, err := e.WriteString(`\ufffd`); err != nil {
-				e.error(err)
+			if opts.invalidUTF8 == InvalidUTF8Reject {
+				e.error(&InvalidUTF8Error{S: s, Path: string(e.fieldPath)})
 			}
+			e.invalidByte(s[i], opts)
 			i += size
 			start = i
 			continue
@@ -1124,20 +2346,15 @@ func (e *encodeState) string(s string, escapeHTML bool) {
 		// They are both technically valid characters in JSON strings,
 		// but don't work in JSONP, which has to be evaluated as JavaScript,
 		// and can lead to security holes there. It is valid JSON to
-		// escape them, so we do so unconditionally.
+		// escape them, so we do so unless opts.keepLineSeparators.
 		// See http://timelessrepo.com/json-isnt-a-javascript-subset for discussion.
-		if c == '\u2028' || c == '\u2029' {
+		if opts.escapeNonASCII || !opts.keepLineSeparators && (c == '\u2028' || c == '\u2029') {
 			if start < i {
 				if _, err := e.WriteString(s[start:i]); err != nil {
 					e.error(err)
 				}
 			}
-			if _, err := e.WriteString(`\u202`); err != nil {
-				e.error(err)
-			}
-			if err := e.WriteByte(hex[c&0xF]); err != nil {
-				e.error(err)
-			}
+			e.escapeRune(c)
 			i += size
 			start = i
 			continue
@@ -1155,14 +2372,14 @@ func (e *encodeState) string(s string, escapeHTML bool) {
 }
 
 // NOTE: keep in sync with string above.
-func (e *encodeState) stringBytes(s []byte, escapeHTML bool) {
+func (e *encodeState) stringBytes(s []byte, opts encOpts) {
 	if err := e.WriteByte('"'); err != nil {
 		e.error(err)
 	}
 	start := 0
 	for i := 0; i < len(s); {
 		if b := s[i]; b < utf8.RuneSelf {
-			if htmlSafeSet[b] || (!escapeHTML && safeSet[b]) {
+			if (htmlSafeSet[b] || (!opts.escapeHTML && safeSet[b])) && (b != '/' || !opts.escapeSlash) {
 				i++
 				continue
 			}
@@ -1175,7 +2392,7 @@ func (e *encodeState) stringBytes(s []byte, escapeHTML bool) {
 				e.error(err)
 			}
 			switch b {
-			case '\\', '"':
+			case '\\', '"', '/':
 				if err := e.WriteByte(b); err != nil {
 					e.error(err)
 				}
@@ -1218,9 +2435,10 @@ func (e *encodeState) stringBytes(s []byte, escapeHTML bool) {
 					e.error(err)
 				}
 			}
-			if _, err := e.WriteString(`\ufffd`); err != nil {
-				e.error(err)
+			if opts.invalidUTF8 == InvalidUTF8Reject {
+				e.error(&InvalidUTF8Error{S: string(s), Path: string(e.fieldPath)})
 			}
+			e.invalidByte(s[i], opts)
 			i += size
 			start = i
 			continue
@@ -1230,20 +2448,15 @@ func (e *encodeState) stringBytes(s []byte, escapeHTML bool) {
 		// They are both technically valid characters in JSON strings,
 		// but don't work in JSONP, which has to be evaluated as JavaScript,
 		// and can lead to security holes there. It is valid JSON to
-		// escape them, so we do so unconditionally.
+		// escape them, so we do so unless opts.keepLineSeparators.
 		// See http://timelessrepo.com/json-isnt-a-javascript-subset for discussion.
-		if c == '\u2028' || c == '\u2029' {
+		if opts.escapeNonASCII || !opts.keepLineSeparators && (c == '\u2028' || c == '\u2029') {
 			if start < i {
 				if _, err := e.Write(s[start:i]); err != nil {
 					e.error(err)
 				}
 			}
-			if _, err := e.WriteString(`\u202`); err != nil {
-				e.error(err)
-			}
-			if err := e.WriteByte(hex[c&0xF]); err != nil {
-				e.error(err)
-			}
+			e.escapeRune(c)
 			i += size
 			start = i
 			continue
@@ -1260,6 +2473,45 @@ func (e *encodeState) stringBytes(s []byte, escapeHTML bool) {
 	}
 }
 
+// invalidByte writes the encoding of b, a byte of a string that is not valid
+// UTF-8, as determined by opts.invalidUTF8.
+func (e *encodeState) invalidByte(b byte, opts encOpts) {
+	if opts.invalidUTF8 != InvalidUTF8Escape {
+		if _, err := e.WriteString(`\ufffd`); err != nil {
+			e.error(err)
+		}
+		return
+	}
+	if _, err := e.WriteString(`\u00`); err != nil {
+		e.error(err)
+	}
+	if err := e.WriteByte(hex[b>>4]); err != nil {
+		e.error(err)
+	}
+	if err := e.WriteByte(hex[b&0xF]); err != nil {
+		e.error(err)
+	}
+}
+
+// escapeRune writes the escape sequence for c, as a UTF-16 surrogate pair if
+// c is outside the Basic Multilingual Plane.
+func (e *encodeState) escapeRune(c rune) {
+	if c > 0xFFFF {
+		r1, r2 := utf16.EncodeRune(c)
+		e.escapeRune(r1)
+		e.escapeRune(r2)
+		return
+	}
+	if _, err := e.WriteString(`\u`); err != nil {
+		e.error(err)
+	}
+	for shift := 12; shift >= 0; shift -= 4 {
+		if err := e.WriteByte(hex[c>>uint(shift)&0xF]); err != nil {
+			e.error(err)
+		}
+	}
+}
+
 // A field represents a single field found in a struct.
 type field struct {
 	name      string
@@ -1273,11 +2525,38 @@ type field struct {
 	index     []int
 	typ       reflect.Type
 	omitEmpty bool
+	omitZero  bool
+	isZero    func(reflect.Value) bool // reports whether the field is zero, if omitZero
 	quoted    bool
+	layout    string // time layout, for time.Time fields
+	unit      string // duration unit, for time.Duration fields
+
+	// defaultValue, if non-nil, is the JSON text decoded into the field when
+	// its key is absent from an object.
+	defaultValue []byte
+
+	boolNumeric bool // whether a bool is written as 0 or 1, per bool=numeric
+	nullEmpty   bool // whether null is written as "", per null=emptystring
+	textNumber  bool // whether MarshalText output is written as a number, per number
+
+	// decodeNames, if non-nil, lists the keys matched by this field when
+	// decoding, in place of name.
+	decodeNames []string
 
 	encoder encoderFunc
 }
 
+// matchDecodeName reports whether key matches one of f.decodeNames, ignoring
+// case as for other field names.
+func (f *field) matchDecodeName(key []byte) bool {
+	for _, name := range f.decodeNames {
+		if bytes.EqualFold([]byte(name), key) {
+			return true
+		}
+	}
+	return false
+}
+
 // byIndex sorts field by index sequence.
 type byIndex []field
 
@@ -1300,7 +2579,10 @@ func (x byIndex) Less(i, j int) bool {
 // typeFields returns a list of fields that JSON should recognize for the given type.
 // The algorithm is breadth-first search over the set of structs to include - the top struct
 // and then any reachable anonymous structs.
-func typeFields(t reflect.Type) structFields {
+//
+// If unexported is true, unexported fields are treated as if they were
+// exported, for Encoder.SetIncludeUnexported.
+func typeFields(t reflect.Type, unexported bool) structFields {
 	// Anonymous fields to explore at the current level and the next.
 	current := []field{}
 	next := []field{{typ: t}}
@@ -1317,6 +2599,11 @@ func typeFields(t reflect.Type) structFields {
 	// Buffer to run HTMLEscape on field names.
 	var nameEscBuf bytes.Buffer
 
+	// Index sequences of the fields with the raw and unknown options, and
+	// any error in the use of those options.
+	var raw, unknown []int
+	var optErr error
+
 	for len(next) > 0 {
 		current, next = next, current[:0]
 		count, nextCount = nextCount, map[reflect.Type]int{}
@@ -1330,7 +2617,7 @@ func typeFields(t reflect.Type) structFields {
 			// Scan f.typ for fields to include.
 			for i := 0; i < f.typ.NumField(); i++ {
 				sf := f.typ.Field(i)
-				isUnexported := sf.PkgPath != ""
+				isUnexported := sf.PkgPath != "" && !unexported
 				if sf.Anonymous {
 					t := sf.Type
 					if t.Kind() == reflect.Ptr {
@@ -1350,10 +2637,13 @@ func typeFields(t reflect.Type) structFields {
 				if tag == "-" {
 					continue
 				}
-				name, opts := parseTag(tag)
+				name, inName, opts, directional := parseTagNames(tag)
 				if !isValidTag(name) {
 					name = ""
 				}
+				if !isValidTag(inName) {
+					inName = ""
+				}
 				index := make([]int, len(f.index)+1)
 				copy(index, f.index)
 				index[len(f.index)] = i
@@ -1364,6 +2654,34 @@ func typeFields(t reflect.Type) structFields {
 					ft = ft.Elem()
 				}
 
+				// A field with the raw option holds the source of the
+				// object, rather than the value of any key.
+				if opts.Contains("raw") {
+					switch {
+					case sf.Type.Kind() != reflect.Slice || sf.Type.Elem().Kind() != reflect.Uint8:
+						optErr = fmt.Errorf("json: raw option on field %s of %v, which is not a byte slice", sf.Name, t)
+					case raw != nil:
+						optErr = fmt.Errorf("json: more than one field of %v has the raw option", t)
+					default:
+						raw = index
+					}
+					continue
+				}
+
+				// A field with the unknown option collects the keys that
+				// match no other field.
+				if opts.Contains("unknown") {
+					switch {
+					case sf.Type.Kind() != reflect.Map || sf.Type.Key().Kind() != reflect.String || sf.Type.Elem() != rawMessageType:
+						optErr = fmt.Errorf("json: unknown option on field %s of %v, which is not a map[string]RawMessage", sf.Name, t)
+					case unknown != nil:
+						optErr = fmt.Errorf("json: more than one field of %v has the unknown option", t)
+					default:
+						unknown = index
+					}
+					continue
+				}
+
 				// Only strings, floats, integers, and booleans can be quoted.
 				quoted := false
 				if opts.Contains("string") {
@@ -1377,19 +2695,89 @@ func typeFields(t reflect.Type) structFields {
 					}
 				}
 
+				// Only time.Time fields can have a layout.
+				var layout string
+				if ft == timeType {
+					layout, _ = opts.Get("layout")
+				}
+
+				// Only time.Duration fields can have a unit, which
+				// replaces the string option.
+				var unit string
+				if ft == durationType {
+					if u, ok := opts.Get("unit"); ok && (u == "string" || durationUnits[u] != 0) {
+						unit = u
+						quoted = false
+					}
+				}
+
+				// A default is used as JSON text, unless the field is a
+				// string or the default is not valid JSON, in which case
+				// it is decoded as a JSON string.
+				var defaultValue []byte
+				if def, ok := opts.Get("default"); ok {
+					defaultValue = []byte(def)
+					if ft.Kind() == reflect.String || !Valid(defaultValue) {
+						defaultValue, _ = Marshal(def)
+					}
+				}
+
+				// Only bool fields can be numeric, and only fields that can
+				// be null can have null written as an empty string.
+				boolNumeric := false
+				if b, ok := opts.Get("bool"); ok && b == "numeric" && ft.Kind() == reflect.Bool {
+					boolNumeric = true
+				}
+				// Only fields that can be marshaled as text can be
+				// numbers, which replaces the string option.
+				textNumber := false
+				if opts.Contains("number") && (ft.Implements(textMarshalerType) || reflect.PtrTo(ft).Implements(textMarshalerType)) {
+					textNumber = true
+					quoted = false
+				}
+				nullEmpty := false
+				if n, ok := opts.Get("null"); ok && n == "emptystring" {
+					switch sf.Type.Kind() {
+					case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+						nullEmpty = true
+					}
+				}
+
+				// A field with the inline option is flattened into the
+				// parent, as if it were an untagged embedded struct.
+				inline := opts.Contains("inline") && ft.Kind() == reflect.Struct
+
 				// Record found field and index sequence.
-				if name != "" || !sf.Anonymous || ft.Kind() != reflect.Struct {
-					tagged := name != ""
+				if !inline && (name != "" || directional || !sf.Anonymous || ft.Kind() != reflect.Struct) {
+					tagged := name != "" || directional
 					if name == "" {
 						name = sf.Name
 					}
+					// A directional tag replaces the names matched when
+					// decoding with its in name, if any, and the Go name.
+					var decodeNames []string
+					if directional {
+						if inName != "" && inName != sf.Name {
+							decodeNames = append(decodeNames, inName)
+						}
+						decodeNames = append(decodeNames, sf.Name)
+					}
 					field := field{
 						name:      name,
 						tag:       tagged,
 						index:     index,
 						typ:       ft,
 						omitEmpty: opts.Contains("omitempty"),
+						omitZero:  opts.Contains("omitzero"),
 						quoted:    quoted,
+						layout:    layout,
+						unit:      unit,
+
+						decodeNames:  decodeNames,
+						defaultValue: defaultValue,
+						boolNumeric:  boolNumeric,
+						nullEmpty:    nullEmpty,
+						textNumber:   textNumber,
 					}
 					field.nameBytes = []byte(field.name)
 					field.equalFold = foldFunc(field.nameBytes)
@@ -1472,13 +2860,52 @@ func typeFields(t reflect.Type) structFields {
 
 	for i := range fields {
 		f := &fields[i]
-		f.encoder = typeEncoder(typeByIndex(t, f.index))
+		if f.omitZero {
+			f.isZero = newIsZeroFunc(typeByIndex(t, f.index))
+		}
+		switch {
+		case f.layout != "":
+			f.encoder = newTimeLayoutEncoder(typeByIndex(t, f.index), f.layout)
+		case f.unit != "":
+			f.encoder = newDurationUnitEncoder(typeByIndex(t, f.index), f.unit)
+		case f.boolNumeric:
+			f.encoder = newBoolNumericEncoder(typeByIndex(t, f.index))
+		case f.textNumber:
+			f.encoder = textNumberEncoder
+		default:
+			f.encoder = typeEncoder(typeByIndex(t, f.index))
+		}
+		if f.nullEmpty {
+			f.encoder = nullEmptyEncoder(f.encoder).encode
+		}
 	}
 	nameIndex := make(map[string]int, len(fields))
 	for i, field := range fields {
-		nameIndex[field.name] = i
+		if field.decodeNames == nil {
+			nameIndex[field.name] = i
+		}
+	}
+	// Names given for decoding only never hide the name of another field.
+	for i, field := range fields {
+		for _, name := range field.decodeNames {
+			if _, ok := nameIndex[name]; !ok {
+				nameIndex[name] = i
+			}
+		}
+	}
+	sorted := append([]field(nil), fields...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].name < sorted[j].name })
+	hasDefaults := false
+	for i := range fields {
+		if fields[i].defaultValue != nil {
+			hasDefaults = true
+			break
+		}
+	}
+	if optErr != nil {
+		raw, unknown = nil, nil
 	}
-	return structFields{fields, nameIndex}
+	return structFields{fields, nameIndex, sorted, hasDefaults, raw, unknown, optErr}
 }
 
 // dominantField looks through the fields, all of which are known to
@@ -1504,6 +2931,18 @@ func cachedTypeFields(t reflect.Type) structFields {
 	if f, ok := fieldCache.Load(t); ok {
 		return f.(structFields)
 	}
-	f, _ := fieldCache.LoadOrStore(t, typeFields(t))
+	f, _ := fieldCache.LoadOrStore(t, typeFields(t, false))
+	return f.(structFields)
+}
+
+var unexportedFieldCache sync.Map // map[reflect.Type]structFields
+
+// cachedUnexportedTypeFields is like cachedTypeFields, but includes
+// unexported fields.
+func cachedUnexportedTypeFields(t reflect.Type) structFields {
+	if f, ok := unexportedFieldCache.Load(t); ok {
+		return f.(structFields)
+	}
+	f, _ := unexportedFieldCache.LoadOrStore(t, typeFields(t, true))
 	return f.(structFields)
 }
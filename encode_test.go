@@ -7,13 +7,16 @@ package json
 import (
 	"bytes"
 	"encoding"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"math"
 	"reflect"
 	"regexp"
 	"strconv"
 	"testing"
+	"time"
 	"unicode"
 )
 
@@ -71,6 +74,91 @@ func TestOmitEmpty(t *testing.T) {
 	}
 }
 
+type zeroAddress struct {
+	Street string
+	Unit   *int
+	Tags   [2]string
+	secret int
+}
+
+// nonZeroer is zero when its value is 42, according to its IsZero method.
+type nonZeroer int
+
+func (n nonZeroer) IsZero() bool { return n == 42 }
+
+// ptrZeroer has an IsZero method with a pointer receiver.
+type ptrZeroer struct{ N int }
+
+func (p *ptrZeroer) IsZero() bool { return p.N < 0 }
+
+type OmitZeros struct {
+	Addr     zeroAddress             `json:"addr,omitzero"`
+	AddrPtr  *zeroAddress            `json:",omitzero"`
+	Nested   struct{ A zeroAddress } `json:",omitzero"`
+	Array    [2]int                  `json:",omitzero"`
+	Slice    []int                   `json:",omitzero"`
+	Map      map[string]int          `json:",omitzero"`
+	Time     time.Time               `json:",omitzero"`
+	TimePtr  *time.Time              `json:",omitzero"`
+	Custom   nonZeroer               `json:",omitzero"`
+	PtrRecv  ptrZeroer               `json:",omitzero"`
+	Iface    interface{}             `json:",omitzero"`
+	Both     [1]int                  `json:",omitempty,omitzero"`
+	Untagged zeroAddress
+}
+
+func TestOmitZero(t *testing.T) {
+	var o OmitZeros
+	o.Custom = 42
+	o.Slice = []int{} // non-nil, hence not zero
+	o.Map = map[string]int{}
+	o.PtrRecv.N = -1
+	o.TimePtr = new(time.Time) // *time.Time has IsZero
+	got, err := Marshal(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `{"Slice":[],"Map":{},"Untagged":{"Street":"","Unit":null,"Tags":["",""]}}`
+	if string(got) != want {
+		t.Errorf("Marshal zero value:\n\tgot:  %s\n\twant: %s", got, want)
+	}
+
+	unit := 0
+	o = OmitZeros{
+		Addr:    zeroAddress{Unit: &unit},
+		AddrPtr: &zeroAddress{},
+		Array:   [2]int{0, 1},
+		Time:    time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC),
+		Custom:  0,
+		Iface:   0,
+		Both:    [1]int{1},
+	}
+	o.Nested.A.Tags[1] = "x"
+	got, err = Marshal(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want2 = `{"addr":{"Street":"","Unit":0,"Tags":["",""]},` +
+		`"AddrPtr":{"Street":"","Unit":null,"Tags":["",""]},` +
+		`"Nested":{"A":{"Street":"","Unit":null,"Tags":["","x"]}},` +
+		`"Array":[0,1],"Time":"2020-01-02T00:00:00Z","Custom":0,"PtrRecv":{"N":0},"Iface":0,"Both":[1],` +
+		`"Untagged":{"Street":"","Unit":null,"Tags":["",""]}}`
+	if string(got) != want2 {
+		t.Errorf("Marshal non-zero value:\n\tgot:  %s\n\twant: %s", got, want2)
+	}
+
+	// A struct with only a non-zero unexported field is not zero.
+	got, err = Marshal(struct {
+		A zeroAddress `json:",omitzero"`
+	}{zeroAddress{secret: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"A":{"Street":"","Unit":null,"Tags":["",""]}}`; string(got) != want {
+		t.Errorf("Marshal with unexported field set:\n\tgot:  %s\n\twant: %s", got, want)
+	}
+}
+
 type StringTag struct {
 	BoolStr    bool    `json:",string"`
 	IntStr     int64   `json:",string"`
@@ -604,12 +692,17 @@ func TestStringBytes(t *testing.T) {
 	}
 	s := string(r) + "\xff\xff\xffhello" // some invalid UTF-8 too
 
-	for _, escapeHTML := range []bool{true, false} {
+	for _, opts := range []encOpts{
+		{escapeHTML: true},
+		{escapeHTML: false},
+		{keepLineSeparators: true},
+		{escapeNonASCII: true},
+	} {
 		es := &encodeState{writer: new(bytes.Buffer)}
-		es.string(s, escapeHTML)
+		es.string(s, opts)
 
 		esBytes := &encodeState{writer: new(bytes.Buffer)}
-		esBytes.stringBytes([]byte(s), escapeHTML)
+		esBytes.stringBytes([]byte(s), opts)
 
 		enc := es.writer.(*bytes.Buffer).String()
 		encBytes := esBytes.writer.(*bytes.Buffer).String()
@@ -634,8 +727,8 @@ func TestStringBytes(t *testing.T) {
 				encBytes = encBytes[:20] + "..."
 			}
 
-			t.Errorf("with escapeHTML=%t, encodings differ at %#q vs %#q",
-				escapeHTML, enc, encBytes)
+			t.Errorf("with %+v, encodings differ at %#q vs %#q",
+				opts, enc, encBytes)
 		}
 	}
 }
@@ -825,6 +918,105 @@ func TestNilMarshalerTextMapKey(t *testing.T) {
 	}
 }
 
+// binaryPoint implements encoding.BinaryMarshaler and
+// encoding.BinaryUnmarshaler, but neither Marshaler nor TextMarshaler.
+type binaryPoint struct {
+	X, Y uint8
+}
+
+func (p binaryPoint) MarshalBinary() ([]byte, error) {
+	return []byte{p.X, p.Y}, nil
+}
+
+func (p *binaryPoint) UnmarshalBinary(b []byte) error {
+	if len(b) != 2 {
+		return errors.New("binaryPoint: invalid length")
+	}
+	p.X, p.Y = b[0], b[1]
+	return nil
+}
+
+// binaryText implements both encoding.BinaryMarshaler and
+// encoding.TextMarshaler; the text form takes precedence.
+type binaryText struct{ binaryPoint }
+
+func (p binaryText) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%d,%d", p.X, p.Y)), nil
+}
+
+// binaryJSON implements both encoding.BinaryMarshaler and Marshaler;
+// the JSON form takes precedence.
+type binaryJSON struct{ binaryPoint }
+
+func (p binaryJSON) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf("[%d,%d]", p.X, p.Y)), nil
+}
+
+func TestBinaryMarshaler(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		want string
+	}{
+		{binaryPoint{1, 2}, `"AQI="`},
+		{&binaryPoint{1, 2}, `"AQI="`},
+		{(*binaryPoint)(nil), `null`},
+		{[]binaryPoint{{1, 2}, {250, 251}}, `["AQI=","+vs="]`},
+		{struct{ P binaryPoint }{binaryPoint{1, 2}}, `{"P":"AQI="}`},
+		{binaryText{binaryPoint{1, 2}}, `"1,2"`},
+		{binaryJSON{binaryPoint{1, 2}}, `[1,2]`},
+	}
+	for _, tt := range tests {
+		b, err := Marshal(tt.v)
+		if err != nil {
+			t.Errorf("Marshal(%#v): %v", tt.v, err)
+			continue
+		}
+		if got := string(b); got != tt.want {
+			t.Errorf("Marshal(%#v) = %s, want %s", tt.v, got, tt.want)
+		}
+	}
+
+	var p binaryPoint
+	if err := Unmarshal([]byte(`"+vs="`), &p); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if want := (binaryPoint{250, 251}); p != want {
+		t.Errorf("Unmarshal = %v, want %v", p, want)
+	}
+	if err := Unmarshal([]byte(`"AQID"`), &p); err == nil || err.Error() != "binaryPoint: invalid length" {
+		t.Errorf("Unmarshal with invalid length: err = %v, want binaryPoint: invalid length", err)
+	}
+	if err := Unmarshal([]byte(`"!!"`), &p); err == nil {
+		t.Errorf("Unmarshal with invalid base64: expected error")
+	}
+	if err := Unmarshal([]byte(`1`), &p); err == nil {
+		t.Errorf("Unmarshal number into binaryPoint: expected error")
+	}
+}
+
+func TestBinaryMarshalerMapKeys(t *testing.T) {
+	in := map[binaryPoint]int{
+		{1, 2}:     1,
+		{250, 251}: 2,
+		{0, 0}:     3,
+	}
+	b, err := Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	const want = `{"+vs=":2,"AAA=":3,"AQI=":1}`
+	if string(b) != want {
+		t.Errorf("Marshal map with binary.Marshaler keys: got %#q, want %#q", b, want)
+	}
+	var out map[binaryPoint]int
+	if err := Unmarshal(b, &out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("round trip = %v, want %v", out, in)
+	}
+}
+
 var re = regexp.MustCompile
 
 // syntactic checks on form of marshaled floating point numbers.
@@ -1033,6 +1225,34 @@ func TestMarshalRawMessageValue(t *testing.T) {
 	}
 }
 
+// Marshal has always compacted a RawMessage, as it does the output of any
+// Marshaler; this test pins down that existing behavior.
+func TestMarshalCompactsRawMessage(t *testing.T) {
+	indented := RawMessage("{\n\t\"a\": [\n\t\t1,\n\t\t\"x y\"\n\t]\n}\n")
+	tests := []struct {
+		in   interface{}
+		want string
+	}{
+		{indented, `{"a":[1,"x y"]}`},
+		{[]RawMessage{indented, RawMessage(" true ")}, `[{"a":[1,"x y"]},true]`},
+		{struct{ M *RawMessage }{&indented}, `{"M":{"a":[1,"x y"]}}`},
+	}
+	for _, tt := range tests {
+		b, err := Marshal(tt.in)
+		if err != nil {
+			t.Fatalf("Marshal(%s): %v", tt.in, err)
+		}
+		if got := string(b); got != tt.want {
+			t.Errorf("Marshal(%s) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+
+	_, err := Marshal(RawMessage("{\n\t\"a\": "))
+	if _, ok := err.(*MarshalerError); !ok {
+		t.Errorf("Marshal(invalid RawMessage) error = %v, want *MarshalerError", err)
+	}
+}
+
 type marshalPanic struct{}
 
 func (marshalPanic) MarshalJSON() ([]byte, error) { panic(0xdead) }
@@ -1061,3 +1281,266 @@ func TestMarshalUncommonFieldNames(t *testing.T) {
 		t.Fatalf("Marshal: got %s want %s", got, want)
 	}
 }
+
+type errWriter struct {
+	n   int // number of bytes to accept before failing
+	err error
+}
+
+func (w *errWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n := w.n
+		w.n = 0
+		return n, w.err
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestMarshalStream(t *testing.T) {
+	values := []interface{}{
+		nil,
+		"<tag> &  ",
+		[]interface{}{1, "two", 3.5, true},
+		map[string]interface{}{"b": 2, "a": "<a>"},
+		Optionals{Sr: "x", Mr: map[string]interface{}{"k": []int{1}}},
+		strMarshaler(`"<raw>"`),
+	}
+	for _, v := range values {
+		want, err := Marshal(v)
+		if err != nil {
+			t.Fatalf("Marshal(%#v): %v", v, err)
+		}
+		var buf bytes.Buffer
+		if err := MarshalStream(&buf, v); err != nil {
+			t.Fatalf("MarshalStream(%#v): %v", v, err)
+		}
+		if got := buf.String(); got != string(want) {
+			t.Errorf("MarshalStream(%#v) = %q, want %q", v, got, want)
+		}
+	}
+
+	t.Run("marshal error", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := MarshalStream(&buf, []float64{1, math.NaN()})
+		if _, ok := err.(*UnsupportedValueError); !ok {
+			t.Errorf("MarshalStream error = %v (%T), want *UnsupportedValueError", err, err)
+		}
+		if got, want := buf.String(), "[1,"; got != want {
+			t.Errorf("MarshalStream wrote %q, want %q", got, want)
+		}
+	})
+
+	t.Run("write error", func(t *testing.T) {
+		wantErr := fmt.Errorf("write failed")
+		w := &errWriter{n: 3, err: wantErr}
+		if err := MarshalStream(w, []string{"abc", "def"}); err != wantErr {
+			t.Errorf("MarshalStream error = %v, want %v", err, wantErr)
+		}
+	})
+}
+
+func TestAppend(t *testing.T) {
+	values := []interface{}{
+		map[string]interface{}{"b": []int{1, 2}, "a": "<&>"},
+		struct {
+			X int     `json:"x"`
+			Y float64 `json:",omitempty"`
+		}{X: 1},
+		nil,
+		"\u2028",
+		strMarshaler(`{"m": [1, 2]}`),
+	}
+	buf := make([]byte, 0, 1024)
+	buf = append(buf, "prefix:"...)
+	var want []byte
+	want = append(want, "prefix:"...)
+	for _, v := range values {
+		var err error
+		if buf, err = Append(buf, v); err != nil {
+			t.Fatalf("Append(%#v): %v", v, err)
+		}
+		m, err := Marshal(v)
+		if err != nil {
+			t.Fatalf("Marshal(%#v): %v", v, err)
+		}
+		want = append(want, m...)
+	}
+	if !bytes.Equal(buf, want) {
+		t.Errorf("Append:\n\tgot:  %s\n\twant: %s", buf, want)
+	}
+	if cap(buf) != 1024 {
+		t.Errorf("Append reallocated a buffer with spare capacity")
+	}
+
+	// The buffer grows as needed.
+	if b, err := Append(nil, []int{1, 2, 3}); err != nil || string(b) != "[1,2,3]" {
+		t.Errorf("Append(nil) = %s, %v", b, err)
+	}
+
+	// On error, dst is returned unchanged.
+	dst := []byte("[1,")
+	b, err := Append(dst, math.Inf(1))
+	if _, ok := err.(*UnsupportedValueError); !ok || string(b) != "[1," {
+		t.Errorf("Append of Inf = %q, %v, want %q and *UnsupportedValueError", b, err, dst)
+	}
+
+	if n := testing.AllocsPerRun(10, func() {
+		if buf, err = Append(buf[:0], 12345); err != nil {
+			t.Fatal(err)
+		}
+	}); n != 0 {
+		t.Errorf("Append made %v allocations, want 0", n)
+	}
+}
+
+func TestMessage(t *testing.T) {
+	v := map[string]interface{}{"b": []int{1, 2}, "a": "<&>"}
+	want, err := Marshal(v)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	m, err := Message(v)
+	if err != nil {
+		t.Fatalf("Message: %v", err)
+	}
+	if m.Len() != len(want) {
+		t.Errorf("Len = %d, want %d", m.Len(), len(want))
+	}
+	var buf bytes.Buffer
+	var wt io.WriterTo = &m
+	n, err := wt.WriteTo(&buf)
+	if err != nil || n != int64(len(want)) || !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("WriteTo = %d, %v, wrote %s, want %s", n, err, buf.Bytes(), want)
+	}
+
+	// The message is empty once written.
+	if m.Len() != 0 {
+		t.Errorf("Len after WriteTo = %d, want 0", m.Len())
+	}
+	if n, err := m.WriteTo(&buf); n != 0 || err != nil || buf.Len() != len(want) {
+		t.Errorf("second WriteTo = %d, %v", n, err)
+	}
+
+	// Errors from the writer are returned.
+	m, _ = Message([]int{1, 2, 3})
+	wantErr := errors.New("full")
+	if n, err := m.WriteTo(&errWriter{n: 2, err: wantErr}); n != 2 || err != wantErr {
+		t.Errorf("WriteTo = %d, %v, want 2, %v", n, err, wantErr)
+	}
+
+	if _, err := Message(math.NaN()); err == nil {
+		t.Error("Message(NaN): expected error")
+	}
+}
+
+func TestFastMapEncoders(t *testing.T) {
+	// The named types are encoded by the generic map encoder.
+	type (
+		stringMap    map[string]string
+		intMap       map[string]int
+		interfaceMap map[string]interface{}
+	)
+	strs := map[string]string{"b": "<&>", "a": "x\u2028y", "\xff": "\xfe", "": "", "z": "é"}
+	ints := map[string]int{"b": -1, "a": 0, "<c>": 1 << 40}
+	ifaces := map[string]interface{}{"s": "<", "n": nil, "f": 1.5, "b": true, "i": 3, "m": map[string]interface{}{"x": []interface{}{1.0, "y"}}, "t": struct{ A int }{1}}
+	tests := []struct {
+		fast, generic interface{}
+	}{
+		{strs, stringMap(strs)},
+		{ints, intMap(ints)},
+		{ifaces, interfaceMap(ifaces)},
+		{map[string]string(nil), stringMap(nil)},
+		{map[string]int{}, intMap{}},
+		{struct{ M map[string]interface{} }{ifaces}, struct{ M interfaceMap }{ifaces}},
+	}
+	configs := []func(*Encoder){
+		func(*Encoder) {},
+		func(enc *Encoder) { enc.SetEscapeHTML(false) },
+		func(enc *Encoder) { enc.SetEscapeMode(EscapeNonASCII) },
+		func(enc *Encoder) { enc.SetNilSliceEmpty(true) },
+		func(enc *Encoder) { enc.SetEscapeMode(0) },
+		func(enc *Encoder) { enc.SetMaxStringLength(2) },
+	}
+	encode := func(v interface{}, config func(*Encoder)) (string, error) {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		config(enc)
+		err := enc.Encode(v)
+		return buf.String(), err
+	}
+	for _, tt := range tests {
+		for i, config := range configs {
+			fast, fastErr := encode(tt.fast, config)
+			generic, genericErr := encode(tt.generic, config)
+			if fast != generic || !reflect.DeepEqual(fastErr, genericErr) {
+				t.Errorf("config %d: Encode(%#v) = %s, %v, want %s, %v", i, tt.fast, fast, fastErr, generic, genericErr)
+			}
+		}
+	}
+
+	// Unsorted output holds the same entries.
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetSortMapKeys(false)
+		if err := enc.Encode(tt.fast); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		var got, want interface{}
+		if err := Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		generic, _ := encode(tt.generic, func(*Encoder) {})
+		if err := Unmarshal([]byte(generic), &want); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Encode(%#v) unsorted = %v, want %v", tt.fast, got, want)
+		}
+	}
+}
+
+func TestMarshalIndentTo(t *testing.T) {
+	values := []interface{}{
+		nil,
+		12.5,
+		"<tag> &  ",
+		[]interface{}{1, "two", []int{}, map[string]int{}, true},
+		map[string]interface{}{"b": 2, "a": "<a>"},
+		Optionals{Sr: "x", Mr: map[string]interface{}{"k": []int{1}}},
+		strMarshaler(`{"raw":[1,2]}`),
+	}
+	for _, v := range values {
+		want, err := MarshalIndent(v, ">", "  ")
+		if err != nil {
+			t.Fatalf("MarshalIndent(%#v): %v", v, err)
+		}
+		var buf bytes.Buffer
+		if err := MarshalIndentTo(&buf, v, ">", "  "); err != nil {
+			t.Fatalf("MarshalIndentTo(%#v): %v", v, err)
+		}
+		if got := buf.String(); got != string(want) {
+			t.Errorf("MarshalIndentTo(%#v) = %q, want %q", v, got, want)
+		}
+	}
+
+	t.Run("marshal error", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := MarshalIndentTo(&buf, []float64{1, math.NaN()}, "", "\t")
+		if _, ok := err.(*UnsupportedValueError); !ok {
+			t.Errorf("MarshalIndentTo error = %v (%T), want *UnsupportedValueError", err, err)
+		}
+		if got, want := buf.String(), "[\n\t1,\n\t"; got != want {
+			t.Errorf("MarshalIndentTo wrote %q, want %q", got, want)
+		}
+	})
+
+	t.Run("write error", func(t *testing.T) {
+		wantErr := fmt.Errorf("write failed")
+		w := &errWriter{n: 3, err: wantErr}
+		if err := MarshalIndentTo(w, []string{"abc", "def"}, "", "\t"); err != wantErr {
+			t.Errorf("MarshalIndentTo error = %v, want %v", err, wantErr)
+		}
+	})
+}
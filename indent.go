@@ -5,8 +5,10 @@
 package json
 
 import (
+	"bufio"
 	"bytes"
 	"io"
+	"unicode/utf8"
 )
 
 // Compact appends to dst the JSON-encoded src with
@@ -24,7 +26,58 @@ func compactWithRevert(dst *bytes.Buffer, src []byte, escape bool) error {
 	return nil
 }
 
+// CompactValidate is like Compact, but also checks that the contents of
+// every string in src are valid UTF-8, which Compact assumes, as a check on
+// external input before it is stored. If escape is true, <, > and & in
+// strings are escaped to \u003c, \u003e and \u0026, as by HTMLEscape. For an
+// invalid UTF-8 sequence, CompactValidate returns a *SyntaxError whose Offset
+// is just after the sequence's first byte, as for other syntax errors. On any
+// error, dst is left unchanged.
+func CompactValidate(dst *bytes.Buffer, src []byte, escape bool) error {
+	// Check the syntax first, as compact itself does not report the
+	// offsets of errors.
+	var scan scanner
+	if err := checkValid(src, &scan); err != nil {
+		return err
+	}
+	// Bytes outside the ASCII range can only appear in strings of valid
+	// JSON, so it is enough to check src as a whole.
+	for i := 0; i < len(src); {
+		if src[i] < utf8.RuneSelf {
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(src[i:])
+		if r == utf8.RuneError && size == 1 {
+			return &SyntaxError{"invalid UTF-8 in string", int64(i + 1)}
+		}
+		i += size
+	}
+	return compactWithRevert(dst, src, escape)
+}
+
+// CompactSpaced is like Compact, but writes a single space after each colon
+// that separates an object key from its value and after each comma that
+// separates elements, yielding a readable single-line form such as
+// {"a": 1, "b": [2, 3]}. Empty objects and arrays are written as {} and [].
+func CompactSpaced(dst *bytes.Buffer, src []byte) error {
+	origLen := dst.Len()
+	if err := compactFormat(dst, src, false, ": ", ", "); err != nil {
+		dst.Truncate(origLen)
+		return err
+	}
+	return nil
+}
+
 func compact(dst writer, src []byte, escape bool) error {
+	return compactFormat(dst, src, escape, "", "")
+}
+
+// compactFormat implements compact, and also CompactSpaced: if keySep is
+// non-empty, it replaces each colon that separates an object key from its
+// value, and if itemSep is non-empty, it replaces each comma that separates
+// elements.
+func compactFormat(dst writer, src []byte, escape bool, keySep, itemSep string) error {
 	var scan scanner
 	scan.reset()
 	start := 0
@@ -72,6 +125,23 @@ func compact(dst writer, src []byte, escape bool) error {
 				}
 			}
 			start = i + 1
+			continue
+		}
+		sep := ""
+		switch v {
+		case scanObjectKey:
+			sep = keySep
+		case scanObjectValue, scanArrayValue:
+			sep = itemSep
+		}
+		if sep != "" {
+			if _, err := dst.Write(src[start:i]); err != nil {
+				return err
+			}
+			if _, err := dst.WriteString(sep); err != nil {
+				return err
+			}
+			start = i + 1
 		}
 	}
 	if scan.eof() == scanError {
@@ -85,21 +155,152 @@ func compact(dst writer, src []byte, escape bool) error {
 	return nil
 }
 
-func newline(dst writer, prefix, indent string, depth int) error {
-	if err := dst.WriteByte('\n'); err != nil {
-		return err
+// CompactWriter wraps w, eliding insignificant space characters from the data
+// written to it. U+2028 and U+2029 are always escaped; if escape is true,
+// <, > and & are also escaped to \u003c, \u003e and \u0026. If any parsing
+// error occurs, it will be returned by the call to Write() which encounters
+// it, and by any subsequent call.
+func CompactWriter(w io.Writer, escape bool) io.Writer {
+	dst, ok := w.(writer)
+	if !ok {
+		dst = &convertWriter{Writer: w}
 	}
-	if _, err := dst.WriteString(prefix); err != nil {
-		return err
+	var scan scanner
+	scan.reset()
+	return &compactWriter{
+		dst:    dst,
+		escape: escape,
+		scan:   &scan,
 	}
-	for i := 0; i < depth; i++ {
-		if _, err := dst.WriteString(indent); err != nil {
+}
+
+type compactWriter struct {
+	dst    writer
+	escape bool
+	scan   *scanner
+	// pending holds the leading bytes of a possible U+2028 or U+2029
+	// sequence, which may be split across calls to Write.
+	pending []byte
+}
+
+func (w *compactWriter) Write(src []byte) (int, error) {
+	if w.scan.err != nil {
+		return 0, w.scan.err
+	}
+	var n int
+	for _, c := range src {
+		n++
+		w.scan.bytes++
+		v := w.scan.step(w.scan, c)
+		if v == scanError {
+			return n, w.scan.err
+		}
+		if v >= scanSkipSpace {
+			continue
+		}
+		if v != scanContinue {
+			if err := w.dst.WriteByte(c); err != nil {
+				return n, err
+			}
+			continue
+		}
+
+		// Convert U+2028 and U+2029 (E2 80 A8 and E2 80 A9).
+		switch {
+		case len(w.pending) == 1 && c == 0x80:
+			w.pending = append(w.pending, c)
+			continue
+		case len(w.pending) == 2 && c&^1 == 0xA8:
+			w.pending = w.pending[:0]
+			if _, err := w.dst.WriteString(`\u202`); err != nil {
+				return n, err
+			}
+			if err := w.dst.WriteByte(hex[c&0xF]); err != nil {
+				return n, err
+			}
+			continue
+		case len(w.pending) > 0:
+			if _, err := w.dst.Write(w.pending); err != nil {
+				return n, err
+			}
+			w.pending = w.pending[:0]
+		}
+		if c == 0xE2 {
+			w.pending = append(w.pending, c)
+			continue
+		}
+
+		if w.escape && (c == '<' || c == '>' || c == '&') {
+			if _, err := w.dst.WriteString(`\u00`); err != nil {
+				return n, err
+			}
+			if err := w.dst.WriteByte(hex[c>>4]); err != nil {
+				return n, err
+			}
+			if err := w.dst.WriteByte(hex[c&0xF]); err != nil {
+				return n, err
+			}
+			continue
+		}
+		if err := w.dst.WriteByte(c); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// close reports an error if the data written to w is not a complete JSON
+// value, and otherwise writes any bytes still pending.
+func (w *compactWriter) close() error {
+	if w.scan.eof() == scanError {
+		return w.scan.err
+	}
+	if len(w.pending) > 0 {
+		if _, err := w.dst.Write(w.pending); err != nil {
 			return err
 		}
+		w.pending = w.pending[:0]
 	}
 	return nil
 }
 
+// CompactCopy is like CompactWriter, but copies the JSON-encoded value read
+// from src to dst until EOF, and returns the number of bytes written to dst.
+// It is the streaming analog of Compact, so the value may be of any size and
+// split across reads at any point.
+//
+// If src does not hold exactly one valid value, CompactCopy returns a
+// *SyntaxError whose Offset counts the bytes read from src, with the output
+// up to that point already written to dst. Output is buffered and written to
+// dst in large chunks.
+func CompactCopy(dst io.Writer, src io.Reader, escape bool) (int64, error) {
+	cw := &countingWriter{w: dst}
+	bw := bufio.NewWriter(cw)
+	var scan scanner
+	scan.reset()
+	w := &compactWriter{dst: bw, escape: escape, scan: &scan}
+	_, err := io.Copy(w, src)
+	if err == nil {
+		err = w.close()
+	}
+	if ferr := bw.Flush(); err == nil {
+		err = ferr
+	}
+	return cw.n, err
+}
+
+// A countingWriter counts the bytes successfully written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
 // Indent appends to dst an indented form of the JSON-encoded src.
 // Each element in a JSON object or array begins on a new,
 // indented line beginning with prefix followed by one or more
@@ -112,6 +313,236 @@ func newline(dst writer, prefix, indent string, depth int) error {
 // For example, if src has no trailing spaces, neither will dst;
 // if src ends in a trailing newline, so will dst.
 func Indent(dst *bytes.Buffer, src []byte, prefix, indent string) error {
+	return indentWith(dst, src, &indentWriter{prefix: prefix, indent: indent})
+}
+
+// IndentLike is like Indent, but indents src in the style of sample, which is
+// typically the original contents of a file being rewritten: the first line
+// of sample that begins with a tab or a space, after any prefix, determines
+// the indentation, which is a single tab if that line begins with a tab, and
+// otherwise as many spaces as the line begins with. If sample has no such
+// line, IndentLike indents by two spaces. Sample need not be valid JSON.
+func IndentLike(dst *bytes.Buffer, src, sample []byte, prefix string) error {
+	return Indent(dst, src, prefix, detectIndent(sample, prefix))
+}
+
+// detectIndent returns the indentation used by the first indented line of
+// sample, as described for IndentLike.
+func detectIndent(sample []byte, prefix string) string {
+	for len(sample) > 0 {
+		line := sample
+		if i := bytes.IndexByte(sample, '\n'); i >= 0 {
+			line, sample = sample[:i], sample[i+1:]
+		} else {
+			sample = nil
+		}
+		line = bytes.TrimPrefix(line, []byte(prefix))
+		rest := bytes.TrimLeft(line, " \t\r")
+		if len(rest) == 0 || line[0] != ' ' && line[0] != '\t' {
+			continue
+		}
+		if line[0] == '\t' {
+			return "\t"
+		}
+		return string(line[:len(line)-len(bytes.TrimLeft(line, " "))])
+	}
+	return "  "
+}
+
+// indentWith implements Indent, formatting src as configured in w, whose dst
+// and scanner it sets.
+func indentWith(dst *bytes.Buffer, src []byte, w *indentWriter) error {
+	origLen := dst.Len()
+	var scan scanner
+	scan.reset()
+	w.dst = dst
+	w.scan = &scan
+	if _, err := w.Write(src); err != nil {
+		dst.Truncate(origLen)
+		return err
+	}
+	if err := w.close(); err != nil {
+		dst.Truncate(origLen)
+		return err
+	}
+	return nil
+}
+
+// IndentTo is like Indent, but writes the indented form of src to w rather
+// than appending it to a buffer. The same transformation is applied, so
+// trailing space characters in src are likewise preserved.
+//
+// Src is validated before anything is written, so a syntax error in src is
+// returned as a *SyntaxError with nothing written to w. Output is buffered
+// and written to w in large chunks; if w returns an error, IndentTo returns
+// it, and only part of the output may have been written.
+func IndentTo(w io.Writer, src []byte, prefix, indent string) error {
+	var scan scanner
+	if err := checkValid(src, &scan); err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	iw := newIndentWriter(bw, prefix, indent)
+	if _, err := iw.Write(src); err != nil {
+		return err
+	}
+	if err := iw.close(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// IndentSmart is like Indent, but writes each array or object that fits on
+// the current line within maxWidth runes on that line, in the form written by
+// CompactSpaced, such as [1, 2, 3]. Only those that do not fit are broken
+// over several lines, with each element on its own indented line as by
+// Indent, and the decision is then made afresh for each element, against the
+// width left on its line. Scalars are never broken, so a line holding a long
+// string may exceed maxWidth. If maxWidth is zero or negative, IndentSmart
+// breaks every non-empty array and object, as Indent does.
+//
+// The width of a line counts every rune on it: prefix, the indentation, any
+// object key with its following ": ", the value, and any comma after it.
+// Prefix is counted on the first line too, although, as with Indent, the data
+// appended to dst does not begin with it. Unlike Indent, IndentSmart writes
+// no leading or trailing space characters from src.
+func IndentSmart(dst *bytes.Buffer, src []byte, prefix, indent string, maxWidth int) error {
+	var compact bytes.Buffer
+	if err := CompactSpaced(&compact, src); err != nil {
+		return err
+	}
+	w := &smartIndenter{
+		dst:         dst,
+		src:         compact.Bytes(),
+		prefix:      prefix,
+		indent:      indent,
+		maxWidth:    maxWidth,
+		prefixWidth: utf8.RuneCountInString(prefix),
+		indentWidth: utf8.RuneCountInString(indent),
+	}
+	w.value(0, w.valueEnd(0), 0, w.prefixWidth, 0)
+	return nil
+}
+
+// smartIndenter implements IndentSmart over src, the output of CompactSpaced
+// for a valid JSON value.
+type smartIndenter struct {
+	dst                      *bytes.Buffer
+	src                      []byte
+	prefix, indent           string
+	maxWidth                 int
+	prefixWidth, indentWidth int
+}
+
+// value writes the value src[i:end] at nesting depth depth, where col runes
+// precede it on its line and tail runes will follow it. It returns end.
+func (w *smartIndenter) value(i, end, depth, col, tail int) int {
+	c := w.src[i]
+	if c != '[' && c != '{' || end-i == 2 || col+utf8.RuneCount(w.src[i:end])+tail <= w.maxWidth {
+		w.dst.Write(w.src[i:end])
+		return end
+	}
+	w.dst.WriteByte(c)
+	for i++; ; {
+		w.newline(depth + 1)
+		col := w.prefixWidth + (depth+1)*w.indentWidth
+		if c == '{' {
+			k := w.valueEnd(i)
+			w.dst.Write(w.src[i : k+2])
+			col += utf8.RuneCount(w.src[i : k+2])
+			i = k + 2 // after ": "
+		}
+		j := w.valueEnd(i)
+		if w.src[j] != ',' {
+			w.value(i, j, depth+1, col, 0)
+			i = j
+			break
+		}
+		w.value(i, j, depth+1, col, 1)
+		w.dst.WriteByte(',')
+		i = j + 2 // after ", "
+	}
+	w.newline(depth)
+	w.dst.WriteByte(w.src[i])
+	return end
+}
+
+// valueEnd returns the offset in src just after the value, or object key,
+// beginning at src[i].
+func (w *smartIndenter) valueEnd(i int) int {
+	depth := 0
+	for ; i < len(w.src); i++ {
+		switch w.src[i] {
+		case '"':
+			for i++; w.src[i] != '"'; i++ {
+				if w.src[i] == '\\' {
+					i++
+				}
+			}
+			if depth == 0 {
+				return i + 1
+			}
+		case '[', '{':
+			depth++
+		case ']', '}':
+			if depth == 0 {
+				return i
+			}
+			if depth--; depth == 0 {
+				return i + 1
+			}
+		case ',', ':':
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return i
+}
+
+// newline starts a new line, indented to the given depth.
+func (w *smartIndenter) newline(depth int) {
+	w.dst.WriteByte('\n')
+	w.dst.WriteString(w.prefix)
+	for i := 0; i < depth; i++ {
+		w.dst.WriteString(w.indent)
+	}
+}
+
+// A ColorScheme specifies the escape sequences, typically ANSI color codes,
+// written by IndentColor before each class of token. A class whose sequence
+// is empty is written without color. Each colored token is followed by the
+// ANSI reset sequence "\x1b[0m".
+type ColorScheme struct {
+	Key    string // object keys
+	String string // string values
+	Number string // numbers
+	Bool   string // true and false
+	Null   string // null
+}
+
+// DefaultColorScheme is a ColorScheme that colors keys blue, strings green,
+// numbers cyan, booleans yellow and null magenta.
+var DefaultColorScheme = ColorScheme{
+	Key:    "\x1b[34;1m",
+	String: "\x1b[32m",
+	Number: "\x1b[36m",
+	Bool:   "\x1b[33m",
+	Null:   "\x1b[35m",
+}
+
+// NoColor is a ColorScheme that writes no escape sequences, so that
+// IndentColor behaves exactly like Indent.
+var NoColor = ColorScheme{}
+
+const colorReset = "\x1b[0m"
+
+// IndentColor is like Indent, but writes each object key, string, number,
+// boolean and null literal in src preceded by the escape sequence given for
+// its class by scheme, for display on a terminal. Punctuation, including
+// punctuation inside strings, is not colored. Removing the escape sequences
+// from the output yields the output of Indent.
+func IndentColor(dst *bytes.Buffer, src []byte, prefix, indent string, scheme ColorScheme) error {
 	origLen := dst.Len()
 	var scan scanner
 	scan.reset()
@@ -120,21 +551,84 @@ func Indent(dst *bytes.Buffer, src []byte, prefix, indent string) error {
 		prefix: prefix,
 		indent: indent,
 		scan:   &scan,
+		colors: &scheme,
 	}
 	if _, err := w.Write(src); err != nil {
 		dst.Truncate(origLen)
 		return err
 	}
+	if err := w.close(); err != nil {
+		dst.Truncate(origLen)
+		return err
+	}
 	return nil
 }
 
+// color returns the escape sequence with which to begin the literal
+// beginning with c, which is an object key if key is true.
+func (cs *ColorScheme) color(c byte, key bool) string {
+	switch c {
+	case '"':
+		if key {
+			return cs.Key
+		}
+		return cs.String
+	case 't', 'f':
+		return cs.Bool
+	case 'n':
+		return cs.Null
+	}
+	return cs.Number
+}
+
+// An IndentWriterOption changes the behavior of IndentWriter.
+type IndentWriterOption int
+
+const (
+	// FlushOnTopLevel makes the writer accept a sequence of top-level values,
+	// such as a log of JSON objects, rather than a single value, and flush w
+	// after each complete value, so that a reader downstream sees each value
+	// as soon as it has been written. A value is complete once the scanner
+	// returns to depth zero: at its closing brace or bracket, or, for a
+	// scalar, at the first byte after it. w is flushed if it has a Flush
+	// method, with or without an error result, as do bufio.Writer and
+	// http.Flusher; writers without one are unaffected.
+	FlushOnTopLevel IndentWriterOption = 1 << iota
+)
+
 // IndentWriter wraps w, re-indenting the data written to it, according to
-// prefix and indent. If any parsing error occurs, it will be returned on the
-// next call to Write() on the returned io.Writer.
-func IndentWriter(w io.Writer, prefix, indent string) io.Writer {
+// prefix and indent. The data may be split across any number of calls to
+// Write. If any parsing error occurs, it will be returned by the call to
+// Write() which encounters it, and by any subsequent call.
+func IndentWriter(w io.Writer, prefix, indent string, opts ...IndentWriterOption) io.Writer {
+	iw := newIndentWriter(w, prefix, indent)
+	for _, opt := range opts {
+		if opt&FlushOnTopLevel != 0 {
+			iw.sequence = true
+			iw.flush = flushFunc(w)
+		}
+	}
+	return iw
+}
+
+// flushFunc returns the Flush method of w, or nil if it has none.
+func flushFunc(w io.Writer) func() error {
+	switch f := w.(type) {
+	case interface{ Flush() error }:
+		return f.Flush
+	case interface{ Flush() }:
+		return func() error {
+			f.Flush()
+			return nil
+		}
+	}
+	return nil
+}
+
+func newIndentWriter(w io.Writer, prefix, indent string) *indentWriter {
 	dst, ok := w.(writer)
 	if !ok {
-		dst = &convertWriter{w}
+		dst = &convertWriter{Writer: w}
 	}
 	var scan scanner
 	scan.reset()
@@ -153,84 +647,179 @@ type indentWriter struct {
 	depth      int
 	scan       *scanner
 	needIndent bool
+	line       []byte       // reused by newline
+	colors     *ColorScheme // if non-nil, literals are colored
+	colored    bool         // whether a colored literal is being written
+	sequence   bool         // whether a sequence of top-level values is accepted
+	flush      func() error // if non-nil, called after each top-level value
+	unflushed  bool         // whether bytes were written since the last flush
+
+	indentFunc func(depth int) string // if non-nil, replaces indent
+	keySep     string                 // if non-empty, replaces ": " after each key
+	itemSep    string                 // if non-empty, replaces each ","
+}
+
+// newline writes a newline, followed by the prefix and the indentation for the
+// current depth, to w.dst in a single call to Write.
+func (w *indentWriter) newline() error {
+	w.line = append(w.line[:0], '\n')
+	w.line = append(w.line, w.prefix...)
+	if w.indentFunc != nil {
+		w.line = append(w.line, w.indentFunc(w.depth)...)
+	} else {
+		for i := 0; i < w.depth; i++ {
+			w.line = append(w.line, w.indent...)
+		}
+	}
+	_, err := w.dst.Write(w.line)
+	return err
 }
 
 func (w *indentWriter) Write(src []byte) (int, error) {
-	var n int
-	for _, c := range src {
-		n++
-		w.scan.bytes++
-		v := w.scan.step(w.scan, c)
-		if v == scanSkipSpace {
-			continue
+	if w.scan.err != nil {
+		return 0, w.scan.err
+	}
+	for i, c := range src {
+		if err := w.WriteByte(c); err != nil {
+			return i + 1, err
 		}
-		if v == scanError {
-			break
+	}
+	return len(src), nil
+}
+
+func (w *indentWriter) WriteString(src string) (int, error) {
+	if w.scan.err != nil {
+		return 0, w.scan.err
+	}
+	for i := 0; i < len(src); i++ {
+		if err := w.WriteByte(src[i]); err != nil {
+			return i + 1, err
 		}
-		if w.needIndent && v != scanEndObject && v != scanEndArray {
-			w.needIndent = false
-			w.depth++
-			if err := newline(w.dst, w.prefix, w.indent, w.depth); err != nil {
-				return n, err
-			}
+	}
+	return len(src), nil
+}
+
+// WriteByte re-indents the single byte c. Together with Write and
+// WriteString, it allows an encodeState to write directly to an indentWriter.
+func (w *indentWriter) WriteByte(c byte) error {
+	w.scan.bytes++
+	v := w.scan.step(w.scan, c)
+	if v == scanEnd && w.sequence && !isSpace(c) {
+		// Begin the next top-level value.
+		w.scan.reset()
+		v = w.scan.step(w.scan, c)
+	}
+	if w.colored && v != scanContinue {
+		w.colored = false
+		if _, err := w.dst.WriteString(colorReset); err != nil {
+			return err
+		}
+	}
+	if v == scanSkipSpace {
+		return nil
+	}
+	if v == scanError {
+		return w.scan.err
+	}
+	if w.needIndent && v != scanEndObject && v != scanEndArray {
+		w.needIndent = false
+		w.depth++
+		if err := w.newline(); err != nil {
+			return err
 		}
+	}
 
-		// Emit semantically uninteresting bytes
-		// (in particular, punctuation in strings) unmodified.
-		if v == scanContinue {
-			if err := w.dst.WriteByte(c); err != nil {
-				return n, err
+	// Emit semantically uninteresting bytes
+	// (in particular, punctuation in strings) unmodified.
+	if v == scanContinue {
+		return w.dst.WriteByte(c)
+	}
+
+	if v == scanBeginLiteral && w.colors != nil {
+		key := len(w.scan.parseState) > 0 && w.scan.parseState[len(w.scan.parseState)-1] == parseObjectKey
+		if color := w.colors.color(c, key); color != "" {
+			if _, err := w.dst.WriteString(color); err != nil {
+				return err
 			}
-			continue
+			w.colored = true
 		}
+	}
 
-		// Add spacing around real punctuation.
-		switch c {
-		case '{', '[':
-			// delay indent so that empty object and array are formatted as {} and [].
-			w.needIndent = true
-			if err := w.dst.WriteByte(c); err != nil {
-				return n, err
-			}
+	// Add spacing around real punctuation.
+	switch c {
+	case '{', '[':
+		// delay indent so that empty object and array are formatted as {} and [].
+		w.needIndent = true
+		return w.dst.WriteByte(c)
 
-		case ',':
-			if err := w.dst.WriteByte(c); err != nil {
-				return n, err
-			}
-			if err := newline(w.dst, w.prefix, w.indent, w.depth); err != nil {
-				return n, err
+	case ',':
+		if w.itemSep != "" {
+			if _, err := w.dst.WriteString(w.itemSep); err != nil {
+				return err
 			}
+		} else if err := w.dst.WriteByte(c); err != nil {
+			return err
+		}
+		return w.newline()
 
-		case ':':
-			if err := w.dst.WriteByte(c); err != nil {
-				return n, err
-			}
-			if err := w.dst.WriteByte(' '); err != nil {
-				return n, err
-			}
+	case ':':
+		if w.keySep != "" {
+			_, err := w.dst.WriteString(w.keySep)
+			return err
+		}
+		if err := w.dst.WriteByte(c); err != nil {
+			return err
+		}
+		return w.dst.WriteByte(' ')
 
-		case '}', ']':
-			if w.needIndent {
-				// suppress indent in empty object/array
-				w.needIndent = false
-			} else {
-				w.depth--
-				if err := newline(w.dst, w.prefix, w.indent, w.depth); err != nil {
-					return n, err
-				}
-			}
-			if err := w.dst.WriteByte(c); err != nil {
-				return n, err
+	case '}', ']':
+		if w.needIndent {
+			// suppress indent in empty object/array
+			w.needIndent = false
+		} else {
+			w.depth--
+			if err := w.newline(); err != nil {
+				return err
 			}
+		}
+	}
+	if w.flush == nil {
+		return w.dst.WriteByte(c)
+	}
+	if err := w.dst.WriteByte(c); err != nil {
+		return err
+	}
+	return w.flushTopLevel(v)
+}
 
-		default:
-			if err := w.dst.WriteByte(c); err != nil {
-				return n, err
-			}
+// flushTopLevel flushes the underlying writer if the byte just written, for
+// which the scanner returned v, completed a top-level value.
+func (w *indentWriter) flushTopLevel(v int) error {
+	switch {
+	case v == scanEnd:
+		if !w.unflushed {
+			return nil
 		}
+	case (v == scanEndObject || v == scanEndArray) && len(w.scan.parseState) == 0:
+	default:
+		w.unflushed = true
+		return nil
 	}
+	w.unflushed = false
+	return w.flush()
+}
+
+// close reports an error if the data written to w is not a complete JSON
+// value, and ends any colored literal still being written.
+func (w *indentWriter) close() error {
 	if w.scan.eof() == scanError {
-		return n, w.scan.err
+		return w.scan.err
 	}
-	return n, nil
+	if w.colored {
+		w.colored = false
+		if _, err := w.dst.WriteString(colorReset); err != nil {
+			return err
+		}
+	}
+	return nil
 }
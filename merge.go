@@ -0,0 +1,132 @@
+package json
+
+// Merge applies patch to target as a JSON Merge Patch, as described in
+// RFC 7386, and returns the compacted result.
+//
+// If patch is a JSON object, each of its members is merged into target:
+// a member whose value is null removes the corresponding key from target,
+// if present; a member whose value is an object is merged recursively;
+// any other member replaces the corresponding value in target wholesale.
+// Arrays are never merged. If target is not an object, it is treated as
+// an empty object. If patch is not an object, it replaces target entirely.
+//
+// Both inputs must be valid JSON; otherwise Merge returns a *SyntaxError.
+// Object keys in the result are sorted, and numbers are preserved exactly
+// as they appear in the inputs.
+func Merge(target, patch []byte) ([]byte, error) {
+	t, err := unmarshalNumber(target)
+	if err != nil {
+		return nil, err
+	}
+	p, err := unmarshalNumber(patch)
+	if err != nil {
+		return nil, err
+	}
+	return Marshal(mergePatch(t, p))
+}
+
+// Diff returns a JSON Merge Patch, as described in RFC 7386, that Merge
+// applies to a to produce b. The patch is compact and minimal: it contains
+// the members of b whose values differ from those in a, null for each key of
+// a that b lacks, and nothing for unchanged members. Objects present in both
+// a and b are compared recursively; arrays, and values of different kinds,
+// are replaced wholesale. Numbers are compared by numeric value.
+//
+// A merge patch cannot set a member to null, since null means removal. A
+// member whose value is null in b is therefore treated as though it were
+// absent, unless it is also null in a. If b is not an object, or a is not,
+// the patch is b itself.
+//
+// Both inputs must be valid JSON; otherwise Diff returns a *SyntaxError.
+func Diff(a, b []byte) (RawMessage, error) {
+	av, err := unmarshalNumber(a)
+	if err != nil {
+		return nil, err
+	}
+	bv, err := unmarshalNumber(b)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := Marshal(mergeDiff(av, bv))
+	if err != nil {
+		return nil, err
+	}
+	return RawMessage(patch), nil
+}
+
+// unmarshalNumber decodes data into an interface{} value, as Unmarshal
+// would, except that numbers are decoded as Number.
+func unmarshalNumber(data []byte) (interface{}, error) {
+	var d decodeState
+	if err := checkValid(data, &d.scan); err != nil {
+		return nil, err
+	}
+	d.init(data)
+	d.numberMode = NumberModeNumber
+	var v interface{}
+	if err := d.unmarshal(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// mergePatch implements the MergePatch function of RFC 7386, section 2.
+// It may modify target in place.
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{}, len(p))
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
+
+// mergeDiff returns a merge patch which mergePatch applies to a to produce b.
+func mergeDiff(a, b interface{}) interface{} {
+	bm, ok := b.(map[string]interface{})
+	if !ok {
+		return b
+	}
+	am, ok := a.(map[string]interface{})
+	if !ok {
+		return b
+	}
+	patch := make(map[string]interface{})
+	for k := range am {
+		if _, ok := bm[k]; !ok {
+			patch[k] = nil
+		}
+	}
+	for k, bv := range bm {
+		av, ok := am[k]
+		switch {
+		case bv == nil:
+			if ok && av != nil {
+				patch[k] = nil
+			}
+		case !ok:
+			patch[k] = bv
+		case !equalValue(av, bv):
+			_, aObj := av.(map[string]interface{})
+			_, bObj := bv.(map[string]interface{})
+			if !aObj || !bObj {
+				patch[k] = bv
+				break
+			}
+			if sub := mergeDiff(av, bv).(map[string]interface{}); len(sub) > 0 {
+				patch[k] = sub
+			}
+		}
+	}
+	return patch
+}
@@ -0,0 +1,128 @@
+package json
+
+import (
+	"strings"
+	"testing"
+)
+
+var mergeTests = []struct {
+	target, patch, want string
+}{
+	// Examples from RFC 7386, Appendix A.
+	{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+	{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+	{`{"a":"b"}`, `{"a":null}`, `{}`},
+	{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+	{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+	{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+	{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+	{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+	{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+	{`{"a":"b"}`, `["c"]`, `["c"]`},
+	{`{"a":"foo"}`, `null`, `null`},
+	{`{"a":"foo"}`, `"bar"`, `"bar"`},
+	{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+	{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+	{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+
+	// Deleting a nonexistent key is a no-op.
+	{`{"a":1}`, `{"b":null}`, `{"a":1}`},
+	// Numbers are preserved exactly.
+	{`{"a":12345678901234567890}`, `{"b":1.50}`, `{"a":12345678901234567890,"b":1.50}`},
+	// Whitespace is not preserved.
+	{" { \"a\" : [ 1 , 2 ] } ", "\t{ \"b\" : { } }\n", `{"a":[1,2],"b":{}}`},
+}
+
+func TestMerge(t *testing.T) {
+	for _, tt := range mergeTests {
+		got, err := Merge([]byte(tt.target), []byte(tt.patch))
+		if err != nil {
+			t.Errorf("Merge(%s, %s): %v", tt.target, tt.patch, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("Merge(%s, %s) = %s, want %s", tt.target, tt.patch, got, tt.want)
+		}
+	}
+}
+
+func TestMergeSyntaxError(t *testing.T) {
+	tests := []struct {
+		target, patch string
+		err           *SyntaxError
+	}{
+		{`{"a":}`, `{}`, &SyntaxError{"invalid character '}' looking for beginning of value", 6}},
+		{`{}`, `{"a" 1}`, &SyntaxError{"invalid character '1' after object key", 6}},
+		{`{}`, ``, &SyntaxError{"unexpected end of JSON input", 0}},
+	}
+	for _, tt := range tests {
+		_, err := Merge([]byte(tt.target), []byte(tt.patch))
+		se, ok := err.(*SyntaxError)
+		if !ok || *se != *tt.err {
+			t.Errorf("Merge(%#q, %#q) error = %#v, want %#v", tt.target, tt.patch, err, tt.err)
+		}
+	}
+}
+
+var diffTests = []struct {
+	a, b, want string
+}{
+	{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+	{`{"a":"b"}`, `{"a":"b","b":"c"}`, `{"b":"c"}`},
+	{`{"a":"b","b":"c"}`, `{"b":"c"}`, `{"a":null}`},
+	{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+	{`{"a":[1,2]}`, `{"a":[1,3]}`, `{"a":[1,3]}`},
+	{`{"a":[1,2]}`, `{"a":[1,2]}`, `{}`},
+	{`{"a":{"b":"c","d":1}}`, `{"a":{"b":"d","d":1.0}}`, `{"a":{"b":"d"}}`},
+	{`{"a":{"b":{"c":1}},"x":1}`, `{"a":{"b":{"c":1}},"x":2}`, `{"x":2}`},
+	{`{"a":{"b":1}}`, `{"a":{}}`, `{"a":{"b":null}}`},
+	{`{"a":1}`, `{"a":{"b":1}}`, `{"a":{"b":1}}`},
+	{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+	{`["a"]`, `["a"]`, `["a"]`},
+	{`{"a":"b"}`, `["c"]`, `["c"]`},
+	{`[1]`, `{"a":1}`, `{"a":1}`},
+	{`{"a":"foo"}`, `null`, `null`},
+	{`{"id":9007199254740993}`, `{"id":9007199254740992}`, `{"id":9007199254740992}`},
+	{`{"a":[1.50,2e3]}`, `{"a":[1.5,2000]}`, `{}`},
+	{" { \"a\" : [ 1 , 2 ] } ", "\t{ \"a\" : [ 1 , 2 ] , \"b\" : 12345678901234567890 }\n", `{"b":12345678901234567890}`},
+
+	// Null members of b can only be represented as absent.
+	{`{"a":1}`, `{"a":null}`, `{"a":null}`},
+	{`{"a":null}`, `{"a":null}`, `{}`},
+	{`{}`, `{"a":null}`, `{}`},
+}
+
+func TestDiff(t *testing.T) {
+	for _, tt := range diffTests {
+		got, err := Diff([]byte(tt.a), []byte(tt.b))
+		if err != nil {
+			t.Errorf("Diff(%s, %s): %v", tt.a, tt.b, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("Diff(%s, %s) = %s, want %s", tt.a, tt.b, got, tt.want)
+			continue
+		}
+
+		// Applying the patch to a must produce b, up to the handling of
+		// null members and the formatting of numbers.
+		merged, err := Merge([]byte(tt.a), got)
+		if err != nil {
+			t.Errorf("Merge(%s, %s): %v", tt.a, got, err)
+			continue
+		}
+		m, _ := unmarshalNumber(merged)
+		b, _ := unmarshalNumber([]byte(tt.b))
+		if !equalValue(m, b) && !strings.Contains(tt.b, "null") {
+			t.Errorf("Merge(%s, Diff(%s, %s)) = %s, want %s", tt.a, tt.a, tt.b, merged, tt.b)
+		}
+	}
+}
+
+func TestDiffSyntaxError(t *testing.T) {
+	if _, err := Diff([]byte(`{}`), []byte(`{"a" 1}`)); err == nil {
+		t.Error("Diff of invalid JSON: expected error")
+	} else if _, ok := err.(*SyntaxError); !ok {
+		t.Errorf("Diff error = %#v, want *SyntaxError", err)
+	}
+}
@@ -0,0 +1,179 @@
+package json
+
+import "reflect"
+
+// An OrderedMap is a JSON object that remembers the order of its keys,
+// for use in place of map[string]interface{} where a decoded object must
+// be encoded again with its members in their original order.
+//
+// Unmarshaling into an OrderedMap records the keys in the order they
+// appear in the input, with the options of the Decoder or function doing
+// so. Values are decoded as by Unmarshal into an interface{}, except that numbers are decoded as Number, so that they are
+// preserved exactly, and nested objects, including those within arrays,
+// are decoded as *OrderedMap, so that their order is preserved too. If a
+// key appears more than once, the last value is kept at the position of
+// the first. Marshaling an OrderedMap writes its members in key order,
+// encoding them with the options of the Encoder or function doing so.
+//
+// The zero value is an empty map ready to use.
+type OrderedMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+var orderedMapType = reflect.TypeOf(OrderedMap{})
+
+// Get returns the value for key and whether m contains it.
+func (m *OrderedMap) Get(key string) (interface{}, bool) {
+	v, ok := m.values[key]
+	return v, ok
+}
+
+// Set sets the value for key. A new key is added after all existing keys;
+// an existing key keeps its position.
+func (m *OrderedMap) Set(key string, value interface{}) {
+	if m.values == nil {
+		m.values = make(map[string]interface{})
+	}
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+// Keys returns the keys of m in order. The caller may modify the returned
+// slice without affecting m.
+func (m *OrderedMap) Keys() []string {
+	return append([]string(nil), m.keys...)
+}
+
+// MarshalJSON implements the Marshaler interface. Marshal and Encoder do not
+// call it, but encode an OrderedMap directly, using their own options.
+func (m OrderedMap) MarshalJSON() ([]byte, error) {
+	return Marshal(&m)
+}
+
+// orderedMapEncoder encodes an OrderedMap as a JSON object, writing its
+// members in key order.
+func orderedMapEncoder(e *encodeState, v reflect.Value, opts encOpts) {
+	m := v.Interface().(OrderedMap)
+	if err := e.WriteByte('{'); err != nil {
+		e.error(err)
+	}
+	for i, k := range m.keys {
+		if i > 0 {
+			if err := e.WriteByte(','); err != nil {
+				e.error(err)
+			}
+		}
+		e.string(k, opts)
+		if err := e.WriteByte(':'); err != nil {
+			e.error(err)
+		}
+		mv := reflect.ValueOf(m.values[k])
+		if opts.encoders == nil || !mv.IsValid() || !e.encodeCustom(mv, opts) {
+			e.reflectValue(mv, opts)
+		}
+	}
+	if err := e.WriteByte('}'); err != nil {
+		e.error(err)
+	}
+}
+
+// UnmarshalJSON implements the Unmarshaler interface. Any members already
+// in m are discarded. As with other types, unmarshaling null is a no-op.
+// Unmarshal and Decoder do not call it, but decode an OrderedMap directly,
+// using their own options.
+func (m *OrderedMap) UnmarshalJSON(data []byte) error {
+	return Unmarshal(data, m)
+}
+
+// orderedMapValue decodes the value beginning at d.data[d.off-1] into v,
+// which is, or points to, an OrderedMap, and reports true. Null decoded into
+// an OrderedMap itself is a no-op; decoded into a pointer, it is left to the
+// general decoder, which sets it to nil, and orderedMapValue reports false,
+// having consumed nothing, as it does if v is a nil pointer that cannot be
+// set.
+func (d *decodeState) orderedMapValue(v reflect.Value) (bool, error) {
+	isNull := d.opcode == scanBeginLiteral && d.data[d.readIndex()] == 'n'
+	if isNull && v.Kind() == reflect.Ptr && v.CanSet() {
+		return false, nil
+	}
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			if !v.CanSet() {
+				return false, nil
+			}
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	if isNull {
+		d.rescanLiteral()
+		return true, nil
+	}
+	if d.opcode != scanBeginObject {
+		kind := "array"
+		if d.opcode == scanBeginLiteral {
+			kind = literalKind(d.data[d.readIndex()])
+		}
+		d.saveError(&UnmarshalTypeError{Value: kind, Type: orderedMapType, Offset: int64(d.readIndex())})
+		return true, d.value(reflect.Value{})
+	}
+
+	m := v.Addr().Interface().(*OrderedMap)
+	*m = OrderedMap{}
+	mode, ordered := d.numberMode, d.orderedObjects
+	d.numberMode, d.orderedObjects = NumberModeNumber, true
+	defer func() { d.numberMode, d.orderedObjects = mode, ordered }()
+	d.orderedObject(m)
+	d.scanNext()
+	return true, nil
+}
+
+// orderedObject is like objectInterface but adds the members to m, in order.
+func (d *decodeState) orderedObject(m *OrderedMap) {
+	for {
+		// Read opening " of string key or closing }.
+		d.scanWhile(scanSkipSpace)
+		if d.opcode == scanEndObject {
+			// closing } - can only happen on first iteration.
+			break
+		}
+		if d.opcode != scanBeginLiteral {
+			panic(phasePanicMsg)
+		}
+
+		// Read string key.
+		start := d.readIndex()
+		d.rescanLiteral()
+		kb, ok := unquoteBytes(d.data[start:d.readIndex()])
+		if !ok {
+			panic(phasePanicMsg)
+		}
+		key := d.internKey(kb)
+
+		// Read : before value.
+		if d.opcode == scanSkipSpace {
+			d.scanWhile(scanSkipSpace)
+		}
+		if d.opcode != scanObjectKey {
+			panic(phasePanicMsg)
+		}
+		d.scanWhile(scanSkipSpace)
+
+		// Read value.
+		m.Set(key, d.valueInterface())
+
+		// Next token must be , or }.
+		if d.opcode == scanSkipSpace {
+			d.scanWhile(scanSkipSpace)
+		}
+		if d.opcode == scanEndObject {
+			break
+		}
+		if d.opcode != scanObjectValue {
+			panic(phasePanicMsg)
+		}
+	}
+}
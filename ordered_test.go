@@ -0,0 +1,154 @@
+package json
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOrderedMapRoundTrip(t *testing.T) {
+	const in = `{"zeta":1,"alpha":{"y":true,"x":null,"w":[{"b":"2","a":1.50}]},"mid":[],"beta":{}}`
+	var m OrderedMap
+	if err := Unmarshal([]byte(in), &m); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m.Keys(), []string{"zeta", "alpha", "mid", "beta"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Keys() = %q, want %q", got, want)
+	}
+	if v, ok := m.Get("zeta"); !ok || v != Number("1") {
+		t.Errorf(`Get("zeta") = %v, %v, want 1, true`, v, ok)
+	}
+	v, _ := m.Get("alpha")
+	alpha, ok := v.(*OrderedMap)
+	if !ok {
+		t.Fatalf(`Get("alpha") = %T, want *OrderedMap`, v)
+	}
+	if got, want := alpha.Keys(), []string{"y", "x", "w"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("nested Keys() = %q, want %q", got, want)
+	}
+	out, err := Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != in {
+		t.Errorf("round trip:\n got %s\nwant %s", out, in)
+	}
+
+	// A field of type OrderedMap preserves order likewise.
+	var s struct {
+		Config OrderedMap
+		Ptr    *OrderedMap
+	}
+	const sin = `{"Config":{"b":1,"a":2},"Ptr":{"d":3,"c":4}}`
+	if err := Unmarshal([]byte(sin), &s); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := Marshal(s); err != nil || string(out) != sin {
+		t.Errorf("struct round trip = %s, %v, want %s", out, err, sin)
+	}
+}
+
+func TestOrderedMapSet(t *testing.T) {
+	var m OrderedMap
+	m.Set("b", 1)
+	m.Set("a", "<x>")
+	m.Set("b", 2)
+	keys := m.Keys()
+	keys[0] = "changed"
+	out, err := Marshal(&m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(out), `{"b":2,"a":"\u003cx\u003e"}`; got != want {
+		t.Errorf("Marshal = %s, want %s", got, want)
+	}
+
+	// Unmarshaling replaces the contents; duplicate keys keep the first
+	// position and the last value.
+	if err := Unmarshal([]byte(`{"c":1,"d":2,"c":3}`), &m); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := Marshal(m); string(out) != `{"c":3,"d":2}` {
+		t.Errorf("after Unmarshal = %s", out)
+	}
+	if err := Unmarshal([]byte(`null`), &m); err != nil || len(m.Keys()) != 2 {
+		t.Errorf("Unmarshal null = %v, keys %q", err, m.Keys())
+	}
+	var empty OrderedMap
+	if out, _ := Marshal(empty); string(out) != `{}` {
+		t.Errorf("Marshal zero OrderedMap = %s, want {}", out)
+	}
+
+	err = Unmarshal([]byte(`[1]`), &m)
+	if te, ok := err.(*UnmarshalTypeError); !ok || te.Value != "array" {
+		t.Errorf("Unmarshal array = %v, want UnmarshalTypeError for array", err)
+	}
+}
+
+func TestOrderedMapEncoderOptions(t *testing.T) {
+	var inner OrderedMap
+	inner.Set("z", "<x>")
+	inner.Set("a", []string{"&"})
+	var m OrderedMap
+	m.Set("inner", &inner)
+	v := struct{ M OrderedMap }{m}
+
+	// A nested OrderedMap is encoded with the options of the Encoder.
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", " ")
+	if err := enc.Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	const want = "{\n \"M\": {\n  \"inner\": {\n   \"z\": \"<x>\",\n   \"a\": [\n    \"&\"\n   ]\n  }\n }\n}\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Encode =\n%s\nwant\n%s", got, want)
+	}
+
+	// MarshalJSON uses the options of Marshal.
+	out, err := inner.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(out), `{"z":"\u003cx\u003e","a":["\u0026"]}`; got != want {
+		t.Errorf("MarshalJSON = %s, want %s", got, want)
+	}
+}
+
+func TestOrderedMapDecoderOptions(t *testing.T) {
+	// A lenient Decoder accepts in an OrderedMap what it accepts elsewhere.
+	const in = `{"M":{"b":0x10,"a":[007,{"y":1,"x":2,},],},}`
+	dec := NewDecoder(strings.NewReader(in))
+	dec.AllowTrailingCommas(true)
+	dec.AllowExtendedNumbers(true)
+	dec.AllowLeadingZeros(true)
+	var v struct{ M *OrderedMap }
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := Marshal(v); err != nil || string(out) != `{"M":{"b":16,"a":[7,{"y":1,"x":2}]}}` {
+		t.Errorf("Decode = %s, %v", out, err)
+	}
+
+	// The strict checks of a Decoder apply too.
+	dec = NewDecoder(strings.NewReader(`{"a":1,"a":2}`))
+	dec.DisallowDuplicateKeys(true)
+	var m OrderedMap
+	if err := dec.Decode(&m); err == nil {
+		t.Error("Decode with duplicate key: no error")
+	} else if _, ok := err.(*DuplicateKeyError); !ok {
+		t.Errorf("Decode with duplicate key = %T %v, want *DuplicateKeyError", err, err)
+	}
+	dec = NewDecoder(strings.NewReader(`{"a":{"b":{"c":1}}}`))
+	dec.SetMaxDepth(2)
+	if err := dec.Decode(&m); err == nil {
+		t.Error("Decode beyond maximum depth: no error")
+	}
+
+	// Strict input is still rejected by default.
+	if err := Unmarshal([]byte(`{"a":1,}`), &m); err == nil {
+		t.Error("Unmarshal with trailing comma: no error")
+	}
+}
@@ -0,0 +1,146 @@
+package json
+
+import (
+	"errors"
+	"io"
+)
+
+// A Handler receives the events generated by Parse as it reads a JSON value.
+// The byte slices passed to its methods are valid only until the method
+// returns; a Handler must copy them if it wishes to retain them.
+//
+// If a method returns a non-nil error, Parse stops and returns that error,
+// unless it is ErrStopParsing, in which case Parse returns nil.
+type Handler interface {
+	// OnObjectStart is called at the beginning of an object.
+	OnObjectStart() error
+	// OnObjectKey is called with the unquoted key of each object member,
+	// before the events for its value.
+	OnObjectKey(key []byte) error
+	// OnArrayStart is called at the beginning of an array.
+	OnArrayStart() error
+	// OnEnd is called at the end of each object and array.
+	OnEnd() error
+	// OnString is called with the unquoted value of each string.
+	OnString(s []byte) error
+	// OnNumber is called with each number, exactly as it appears in the
+	// input; it may be parsed with strconv, or converted to a Number.
+	OnNumber(raw []byte) error
+	// OnBool is called for each true and false.
+	OnBool(b bool) error
+	// OnNull is called for each null.
+	OnNull() error
+}
+
+// ErrStopParsing may be returned by a Handler method to make Parse stop
+// reading its input and return nil.
+var ErrStopParsing = errors.New("json: stop parsing")
+
+// Parse reads a single JSON value from r, which must contain nothing else
+// but space characters, and reports its structure to h as it is read.
+// Parse avoids the allocations that Decoder.Token makes for each token, and
+// numbers are not parsed at all, so it is suited to processing very large
+// inputs.
+//
+// Because events are reported as the input is read, h may receive events
+// for part of a value before Parse finds that the input is malformed, in
+// which case Parse returns a *SyntaxError.
+func Parse(r io.Reader, h Handler) error {
+	err := parse(r, h)
+	if err == ErrStopParsing {
+		return nil
+	}
+	return err
+}
+
+func parse(r io.Reader, h Handler) error {
+	var (
+		scan    scanner
+		buf     = make([]byte, 4096)
+		lit     []byte // beginning of a literal continued from a previous read
+		inLit   bool   // whether a literal is being read
+		litKey  bool   // whether the literal being read is an object key
+		litFrom int    // start of the literal in buf
+	)
+	scan.reset()
+	for {
+		n, rerr := r.Read(buf)
+		litFrom = 0
+		for i, c := range buf[:n] {
+			scan.bytes++
+			op := scan.step(&scan, c)
+			if inLit && op != scanContinue {
+				inLit = false
+				b := buf[litFrom:i]
+				if len(lit) > 0 {
+					b = append(lit, b...)
+					lit = lit[:0]
+				}
+				if err := emitLiteral(h, b, litKey); err != nil {
+					return err
+				}
+			}
+			var err error
+			switch op {
+			case scanError:
+				return scan.err
+			case scanBeginLiteral:
+				inLit = true
+				litFrom = i
+				depth := len(scan.parseState)
+				litKey = depth > 0 && scan.parseState[depth-1] == parseObjectKey
+			case scanBeginObject:
+				err = h.OnObjectStart()
+			case scanBeginArray:
+				err = h.OnArrayStart()
+			case scanEndObject, scanEndArray:
+				err = h.OnEnd()
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if inLit {
+			// Carry the beginning of the literal over to the next read.
+			if lit == nil {
+				lit = make([]byte, 0, 64)
+			}
+			lit = append(lit, buf[litFrom:n]...)
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return rerr
+		}
+	}
+	if scan.eof() == scanError {
+		return scan.err
+	}
+	if inLit {
+		return emitLiteral(h, lit, false)
+	}
+	return nil
+}
+
+// emitLiteral reports the complete literal b to h.
+func emitLiteral(h Handler, b []byte, key bool) error {
+	switch b[0] {
+	case '"':
+		s, ok := unquoteBytes(b)
+		if !ok {
+			panic(phasePanicMsg)
+		}
+		if key {
+			return h.OnObjectKey(s)
+		}
+		return h.OnString(s)
+	case 't':
+		return h.OnBool(true)
+	case 'f':
+		return h.OnBool(false)
+	case 'n':
+		return h.OnNull()
+	}
+	return h.OnNumber(b)
+}
@@ -0,0 +1,140 @@
+package json
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// eventRecorder is a Handler that records the events it receives, and
+// returns err from the event numbered stopAt.
+type eventRecorder struct {
+	events []string
+	stopAt int
+	err    error
+}
+
+func (r *eventRecorder) add(format string, args ...interface{}) error {
+	r.events = append(r.events, fmt.Sprintf(format, args...))
+	if len(r.events) == r.stopAt {
+		return r.err
+	}
+	return nil
+}
+
+func (r *eventRecorder) OnObjectStart() error         { return r.add("{") }
+func (r *eventRecorder) OnObjectKey(key []byte) error { return r.add("key %s", key) }
+func (r *eventRecorder) OnArrayStart() error          { return r.add("[") }
+func (r *eventRecorder) OnEnd() error                 { return r.add("end") }
+func (r *eventRecorder) OnString(s []byte) error      { return r.add("string %s", s) }
+func (r *eventRecorder) OnNumber(raw []byte) error    { return r.add("number %s", raw) }
+func (r *eventRecorder) OnBool(b bool) error          { return r.add("bool %v", b) }
+func (r *eventRecorder) OnNull() error                { return r.add("null") }
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{`1`, "number 1"},
+		{` -1.5e+3 `, "number -1.5e+3"},
+		{`"a\nb"`, "string a\nb"},
+		{`[]`, "[|end"},
+		{`{}`, "{|end"},
+		{
+			`{"a": [1, true, null], "bé": {"c": "x", "d": false}, "e": []}`,
+			"{|key a|[|number 1|bool true|null|end|key bé|{|key c|string x|key d|bool false|end|key e|[|end|end",
+		},
+		{`[[0],{"":-0}]`, "[|[|number 0|end|{|key |number -0|end|end"},
+		{`["` + strings.Repeat("x", 10000) + `", 12345]`, "[|string " + strings.Repeat("x", 10000) + "|number 12345|end"},
+	}
+	for _, tt := range tests {
+		for _, oneByte := range []bool{false, true} {
+			var r io.Reader = strings.NewReader(tt.in)
+			if oneByte {
+				r = iotest.OneByteReader(r)
+			}
+			var h eventRecorder
+			if err := Parse(r, &h); err != nil {
+				t.Errorf("Parse(%#q): %v", tt.in, err)
+				continue
+			}
+			if got := strings.Join(h.events, "|"); got != tt.want {
+				t.Errorf("Parse(%#q) (oneByte=%v) events:\n\t%s\nwant:\n\t%s", tt.in, oneByte, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestParseSyntaxError(t *testing.T) {
+	tests := []struct {
+		in  string
+		err *SyntaxError
+	}{
+		{``, &SyntaxError{"unexpected end of JSON input", 0}},
+		{`[1,`, &SyntaxError{"unexpected end of JSON input", 3}},
+		{`tru`, &SyntaxError{"invalid character ' ' in literal true (expecting 'e')", 3}},
+		{`{"a" 1}`, &SyntaxError{"invalid character '1' after object key", 6}},
+		{`1 2`, &SyntaxError{"invalid character '2' after top-level value", 3}},
+	}
+	for _, tt := range tests {
+		err := Parse(strings.NewReader(tt.in), &eventRecorder{})
+		if se, ok := err.(*SyntaxError); !ok || *se != *tt.err {
+			t.Errorf("Parse(%#q) error = %#v, want %#v", tt.in, err, tt.err)
+		}
+	}
+}
+
+func TestParseStop(t *testing.T) {
+	const in = `[{"a": 1}, {"a": 2}, {]`
+	h := eventRecorder{stopAt: 4, err: ErrStopParsing}
+	if err := Parse(strings.NewReader(in), &h); err != nil {
+		t.Errorf("Parse stopped with ErrStopParsing: err = %v, want nil", err)
+	}
+	if got, want := strings.Join(h.events, "|"), "[|{|key a|number 1"; got != want {
+		t.Errorf("Parse events = %s, want %s", got, want)
+	}
+
+	errFoo := errors.New("foo")
+	h = eventRecorder{stopAt: 2, err: errFoo}
+	if err := Parse(strings.NewReader(in), &h); err != errFoo {
+		t.Errorf("Parse stopped with handler error: err = %v, want %v", err, errFoo)
+	}
+
+	errRead := errors.New("read failed")
+	r := io.MultiReader(strings.NewReader(`[1`), iotest.ErrReader(errRead))
+	if err := Parse(r, &eventRecorder{}); err != errRead {
+		t.Errorf("Parse with failing reader: err = %v, want %v", err, errRead)
+	}
+}
+
+// discardHandler is a Handler that ignores all events.
+type discardHandler struct{}
+
+func (discardHandler) OnObjectStart() error     { return nil }
+func (discardHandler) OnObjectKey([]byte) error { return nil }
+func (discardHandler) OnArrayStart() error      { return nil }
+func (discardHandler) OnEnd() error             { return nil }
+func (discardHandler) OnString([]byte) error    { return nil }
+func (discardHandler) OnNumber([]byte) error    { return nil }
+func (discardHandler) OnBool(bool) error        { return nil }
+func (discardHandler) OnNull() error            { return nil }
+
+func BenchmarkParse(b *testing.B) {
+	if codeJSON == nil {
+		b.StopTimer()
+		codeInit()
+		b.StartTimer()
+	}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(codeJSON)))
+	for i := 0; i < b.N; i++ {
+		if err := Parse(bytes.NewReader(codeJSON), discardHandler{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package json
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// A PatchTestError is returned by ApplyPatch when a "test" operation
+// finds that the value at Path differs from the expected value.
+type PatchTestError struct {
+	Path string // the pointer given by the failed operation
+}
+
+func (e *PatchTestError) Error() string {
+	return "json: patch test failed at " + strconv.Quote(e.Path)
+}
+
+// patchOperation is a single operation of a JSON Patch document.
+type patchOperation struct {
+	Op    string     `json:"op"`
+	Path  *string    `json:"path"`
+	From  *string    `json:"from"`
+	Value RawMessage `json:"value"`
+}
+
+// ApplyPatch applies patch, a JSON Patch document as described in RFC 6902,
+// to doc and returns the compacted result.
+//
+// The patch must be a JSON array of operation objects, each of which is
+// one of "add", "remove", "replace", "move", "copy" or "test". Operations
+// are applied in order; if any fails, ApplyPatch returns an error and no
+// result. A failed "test" operation is reported as a *PatchTestError, and
+// a path that is malformed, missing, or refers to an out-of-range array
+// index is reported as a *PointerError.
+//
+// Object keys in the result are sorted, and numbers are preserved exactly
+// as they appear in the inputs.
+func ApplyPatch(doc, patch []byte) ([]byte, error) {
+	v, err := unmarshalNumber(doc)
+	if err != nil {
+		return nil, err
+	}
+	var ops []patchOperation
+	if err := Unmarshal(patch, &ops); err != nil {
+		return nil, err
+	}
+	for i, op := range ops {
+		if v, err = op.apply(v); err != nil {
+			if _, ok := err.(*invalidPatchError); ok {
+				return nil, errors.New("json: invalid patch operation " + strconv.Itoa(i) + ": " + err.Error())
+			}
+			return nil, err
+		}
+	}
+	return Marshal(v)
+}
+
+// invalidPatchError describes a malformed patch operation.
+type invalidPatchError struct {
+	msg string
+}
+
+func (e *invalidPatchError) Error() string { return e.msg }
+
+func (op *patchOperation) apply(doc interface{}) (interface{}, error) {
+	if op.Path == nil {
+		return nil, &invalidPatchError{`missing "path"`}
+	}
+	path := *op.Path
+	tokens, err := parsePointer(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, &invalidPatchError{`missing "value"`}
+		}
+		if value, err = unmarshalNumber(op.Value); err != nil {
+			return nil, err
+		}
+	case "move", "copy":
+		if op.From == nil {
+			return nil, &invalidPatchError{`missing "from"`}
+		}
+		from := *op.From
+		fromTokens, err := parsePointer(from)
+		if err != nil {
+			return nil, err
+		}
+		if value, err = pointerValue(doc, fromTokens, from); err != nil {
+			return nil, err
+		}
+		if op.Op == "copy" {
+			value = copyValue(value)
+			break
+		}
+		if from == path {
+			return doc, nil
+		}
+		if strings.HasPrefix(path, from+"/") {
+			return nil, pointerError(path, "cannot move %q into one of its children", from)
+		}
+		if doc, err = patchRemove(doc, fromTokens, from); err != nil {
+			return nil, err
+		}
+	case "remove":
+	default:
+		return nil, &invalidPatchError{"unknown op " + strconv.Quote(op.Op)}
+	}
+
+	switch op.Op {
+	case "add", "move", "copy":
+		return patchAdd(doc, tokens, path, value)
+	case "remove":
+		return patchRemove(doc, tokens, path)
+	case "replace":
+		return patchReplace(doc, tokens, path, value)
+	default: // "test"
+		v, err := pointerValue(doc, tokens, path)
+		if err != nil {
+			return nil, err
+		}
+		if !equalValue(v, value) {
+			return nil, &PatchTestError{Path: path}
+		}
+		return doc, nil
+	}
+}
+
+// pointerValue returns the value within doc referred to by tokens.
+func pointerValue(doc interface{}, tokens []string, pointer string) (interface{}, error) {
+	for _, tok := range tokens {
+		switch c := doc.(type) {
+		case map[string]interface{}:
+			v, ok := c[tok]
+			if !ok {
+				return nil, pointerError(pointer, "object has no key %q", tok)
+			}
+			doc = v
+		case []interface{}:
+			i, err := arrayIndex(pointer, tok, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = c[i]
+		default:
+			return nil, pointerError(pointer, "cannot resolve token %q in a non-container value", tok)
+		}
+	}
+	return doc, nil
+}
+
+// patchAt walks doc to the container holding the value referred to by
+// tokens, which must not be empty, and replaces that container with the
+// result of calling f with it and the final token. It returns the updated
+// document.
+func patchAt(doc interface{}, tokens []string, pointer string, f func(container interface{}, tok string) (interface{}, error)) (interface{}, error) {
+	if len(tokens) == 1 {
+		return f(doc, tokens[0])
+	}
+	tok := tokens[0]
+	switch c := doc.(type) {
+	case map[string]interface{}:
+		v, ok := c[tok]
+		if !ok {
+			return nil, pointerError(pointer, "object has no key %q", tok)
+		}
+		v, err := patchAt(v, tokens[1:], pointer, f)
+		if err != nil {
+			return nil, err
+		}
+		c[tok] = v
+		return c, nil
+	case []interface{}:
+		i, err := arrayIndex(pointer, tok, len(c), false)
+		if err != nil {
+			return nil, err
+		}
+		v, err := patchAt(c[i], tokens[1:], pointer, f)
+		if err != nil {
+			return nil, err
+		}
+		c[i] = v
+		return c, nil
+	}
+	return nil, pointerError(pointer, "cannot resolve token %q in a non-container value", tok)
+}
+
+func patchAdd(doc interface{}, tokens []string, pointer string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return patchAt(doc, tokens, pointer, func(container interface{}, tok string) (interface{}, error) {
+		switch c := container.(type) {
+		case map[string]interface{}:
+			c[tok] = value
+			return c, nil
+		case []interface{}:
+			i, err := arrayIndex(pointer, tok, len(c), true)
+			if err != nil {
+				return nil, err
+			}
+			c = append(c, nil)
+			copy(c[i+1:], c[i:])
+			c[i] = value
+			return c, nil
+		}
+		return nil, pointerError(pointer, "cannot add to a non-container value")
+	})
+}
+
+func patchRemove(doc interface{}, tokens []string, pointer string) (interface{}, error) {
+	if len(tokens) == 0 {
+		return nil, pointerError(pointer, "cannot remove the whole document")
+	}
+	return patchAt(doc, tokens, pointer, func(container interface{}, tok string) (interface{}, error) {
+		switch c := container.(type) {
+		case map[string]interface{}:
+			if _, ok := c[tok]; !ok {
+				return nil, pointerError(pointer, "object has no key %q", tok)
+			}
+			delete(c, tok)
+			return c, nil
+		case []interface{}:
+			i, err := arrayIndex(pointer, tok, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			return append(c[:i], c[i+1:]...), nil
+		}
+		return nil, pointerError(pointer, "cannot remove from a non-container value")
+	})
+}
+
+func patchReplace(doc interface{}, tokens []string, pointer string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return patchAt(doc, tokens, pointer, func(container interface{}, tok string) (interface{}, error) {
+		switch c := container.(type) {
+		case map[string]interface{}:
+			if _, ok := c[tok]; !ok {
+				return nil, pointerError(pointer, "object has no key %q", tok)
+			}
+			c[tok] = value
+			return c, nil
+		case []interface{}:
+			i, err := arrayIndex(pointer, tok, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			c[i] = value
+			return c, nil
+		}
+		return nil, pointerError(pointer, "cannot replace in a non-container value")
+	})
+}
+
+// copyValue returns a deep copy of a value decoded by unmarshalNumber.
+func copyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = copyValue(e)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(v))
+		for i, e := range v {
+			a[i] = copyValue(e)
+		}
+		return a
+	}
+	return v
+}
+
+// equalValue reports whether two values decoded by unmarshalNumber are
+// equal in the sense of RFC 6902, section 4.6: numbers are compared by
+// their exact numeric values, and other values structurally.
+func equalValue(a, b interface{}) bool {
+	switch a := a.(type) {
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, ae := range a {
+			be, ok := b[k]
+			if !ok || !equalValue(ae, be) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equalValue(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case Number:
+		b, ok := b.(Number)
+		if !ok {
+			return false
+		}
+		return a == b || equalNumber(string(a), string(b))
+	}
+	return a == b
+}
+
+// equalNumber reports whether the JSON numbers a and b have exactly the same
+// value, however they are written, so that 1, 1.0 and 10e-1 are equal but
+// 9007199254740993 and 9007199254740992 are not, as they are when compared as
+// float64. The numbers are compared as decimals, without converting them.
+func equalNumber(a, b string) bool {
+	an, ad, ae, aok := decimalParts(a)
+	bn, bd, be, bok := decimalParts(b)
+	return aok && bok && an == bn && ad == bd && ae == be
+}
+
+// decimalParts splits the JSON number s into its sign, its significant digits
+// without leading or trailing zeros, and the exponent of the last of them,
+// such that the value is digits × 10^exp, negated if neg. Zero has no digits,
+// and is never negative. It reports false if s is not a valid number, or its
+// exponent is out of range.
+func decimalParts(s string) (neg bool, digits string, exp int64, ok bool) {
+	if !isValidNumber(s) {
+		return false, "", 0, false
+	}
+	if s[0] == '-' {
+		neg, s = true, s[1:]
+	}
+	mant := s
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		mant = s[:i]
+		e, err := strconv.ParseInt(s[i+1:], 10, 64)
+		if err != nil {
+			return false, "", 0, false
+		}
+		exp = e
+	}
+	if i := strings.IndexByte(mant, '.'); i >= 0 {
+		frac := mant[i+1:]
+		mant = mant[:i] + frac
+		exp -= int64(len(frac))
+	}
+	mant = strings.TrimLeft(mant, "0")
+	trimmed := strings.TrimRight(mant, "0")
+	exp += int64(len(mant) - len(trimmed))
+	if trimmed == "" {
+		return false, "", 0, true
+	}
+	return neg, trimmed, exp, true
+}
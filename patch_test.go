@@ -0,0 +1,112 @@
+package json
+
+import "testing"
+
+var patchTests = []struct {
+	doc, patch, want string
+}{
+	// Examples from RFC 6902, Appendix A.
+	{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+	{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+	{`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+	{`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+	{`{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+	{
+		`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+		`[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+		`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`,
+	},
+	{`{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+	{`{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`, `{"baz":"qux","foo":["a",2,"c"]}`},
+	{`{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"child":{"grandchild":{}},"foo":"bar"}`},
+	{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`, `{"baz":"qux","foo":"bar"}`},
+	{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+	{`{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10}]`, `{"/":9,"~1":10}`},
+
+	// Other cases.
+	{`{"foo":1}`, `[]`, `{"foo":1}`},
+	{`{"foo":1}`, `[{"op":"replace","path":"","value":[1,2]}]`, `[1,2]`},
+	{`{"foo":1}`, `[{"op":"add","path":"","value":null}]`, `null`},
+	{`{"a":{"b":[1,2]}}`, `[{"op":"copy","from":"/a/b","path":"/c"},{"op":"add","path":"/c/0","value":0}]`, `{"a":{"b":[1,2]},"c":[0,1,2]}`},
+	{`{"a":1}`, `[{"op":"move","from":"/a","path":"/a"}]`, `{"a":1}`},
+	{`{"a":1.0}`, `[{"op":"test","path":"/a","value":1}]`, `{"a":1.0}`},
+	{`{"a":[0.5,-0,1200]}`, `[{"op":"test","path":"/a","value":[5e-1,0,12E2]}]`, `{"a":[0.5,-0,1200]}`},
+	{`{"id":9007199254740993}`, `[{"op":"test","path":"/id","value":9007199254740993.0}]`, `{"id":9007199254740993}`},
+	{`{"a":{"x":[1,{"y":null}]}}`, `[{"op":"test","path":"/a","value":{"x":[1,{"y":null}]}}]`, `{"a":{"x":[1,{"y":null}]}}`},
+}
+
+func TestApplyPatch(t *testing.T) {
+	for _, tt := range patchTests {
+		got, err := ApplyPatch([]byte(tt.doc), []byte(tt.patch))
+		if err != nil {
+			t.Errorf("ApplyPatch(%s, %s): %v", tt.doc, tt.patch, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("ApplyPatch(%s, %s) = %s, want %s", tt.doc, tt.patch, got, tt.want)
+		}
+	}
+}
+
+func TestApplyPatchErrors(t *testing.T) {
+	tests := []struct {
+		doc, patch, err string
+	}{
+		// Examples from RFC 6902, Appendix A.
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, `json: pointer "/baz/bat": object has no key "baz"`},
+		{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/3","value":"qux"}]`, `json: pointer "/foo/3": array index 3 out of range`},
+
+		{`{"foo":[1]}`, `[{"op":"remove","path":"/foo/1"}]`, `json: pointer "/foo/1": array index 1 out of range`},
+		{`{"foo":[1]}`, `[{"op":"replace","path":"/foo/-","value":2}]`, `json: pointer "/foo/-": array index "-" refers to a nonexistent element`},
+		{`{"foo":[1]}`, `[{"op":"remove","path":"/foo/01"}]`, `json: pointer "/foo/01": invalid array index "01"`},
+		{`{"foo":1}`, `[{"op":"remove","path":"/bar"}]`, `json: pointer "/bar": object has no key "bar"`},
+		{`{"foo":1}`, `[{"op":"replace","path":"/bar","value":2}]`, `json: pointer "/bar": object has no key "bar"`},
+		{`{"foo":1}`, `[{"op":"add","path":"/foo/bar","value":2}]`, `json: pointer "/foo/bar": cannot add to a non-container value`},
+		{`{"foo":{"a":1}}`, `[{"op":"test","path":"/foo/a/b","value":1}]`, `json: pointer "/foo/a/b": cannot resolve token "b" in a non-container value`},
+		{`{"foo":1}`, `[{"op":"remove","path":"foo"}]`, `json: pointer "foo": must be empty or begin with '/'`},
+		{`{"foo":1}`, `[{"op":"remove","path":"/~2"}]`, `json: pointer "/~2": invalid escape in token "~2"`},
+		{`{"foo":{"a":1}}`, `[{"op":"move","from":"/foo","path":"/foo/a/b"}]`, `json: pointer "/foo/a/b": cannot move "/foo" into one of its children`},
+		{`{"foo":1}`, `[{"op":"copy","from":"/bar","path":"/baz"}]`, `json: pointer "/bar": object has no key "bar"`},
+		{`{"foo":1}`, `[{"op":"remove","path":""}]`, `json: pointer "": cannot remove the whole document`},
+		{`{"foo":1}`, `[{"op":"test","path":"/foo"},{"op":"add"}]`, `json: invalid patch operation 0: missing "value"`},
+		{`{"foo":1}`, `[{"op":"remove","path":"/foo"},{"op":"add","value":1}]`, `json: invalid patch operation 1: missing "path"`},
+		{`{"foo":1}`, `[{"op":"copy","path":"/foo"}]`, `json: invalid patch operation 0: missing "from"`},
+		{`{"foo":1}`, `[{"op":"frob","path":"/foo"}]`, `json: invalid patch operation 0: unknown op "frob"`},
+		{`{"foo":1}`, `{"op":"remove","path":"/foo"}`, `json: cannot unmarshal object into Go value of type []json.patchOperation`},
+		{`{"foo":1`, `[]`, `unexpected end of JSON input`},
+	}
+	for _, tt := range tests {
+		_, err := ApplyPatch([]byte(tt.doc), []byte(tt.patch))
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("ApplyPatch(%s, %s) error = %v, want %s", tt.doc, tt.patch, err, tt.err)
+		}
+	}
+}
+
+func TestApplyPatchTestError(t *testing.T) {
+	tests := []struct {
+		doc, patch, path string
+	}{
+		// Example from RFC 6902, Appendix A.
+		{`{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, "/baz"},
+
+		{`{"a":[1,2]}`, `[{"op":"test","path":"/a","value":[2,1]}]`, "/a"},
+		{`{"a":{"b":1}}`, `[{"op":"test","path":"/a","value":{"b":1,"c":2}}]`, "/a"},
+		{`{"a":1}`, `[{"op":"test","path":"/a","value":"1"}]`, "/a"},
+		{`{"id":9007199254740993}`, `[{"op":"test","path":"/id","value":9007199254740992}]`, "/id"},
+		{`{"a":0.1}`, `[{"op":"test","path":"/a","value":0.10000000000000001}]`, "/a"},
+		{`{"a":null}`, `[{"op":"test","path":"/a","value":false}]`, "/a"},
+		{`{"a":1}`, `[{"op":"test","path":"","value":{"a":2}}]`, ""},
+	}
+	for _, tt := range tests {
+		_, err := ApplyPatch([]byte(tt.doc), []byte(tt.patch))
+		te, ok := err.(*PatchTestError)
+		if !ok {
+			t.Errorf("ApplyPatch(%s, %s) error = %v, want *PatchTestError", tt.doc, tt.patch, err)
+			continue
+		}
+		if te.Path != tt.path {
+			t.Errorf("ApplyPatch(%s, %s) error path = %q, want %q", tt.doc, tt.patch, te.Path, tt.path)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package json
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// GetPointer returns the JSON value within data referred to by pointer,
+// a JSON Pointer as described in RFC 6901. The empty pointer refers to
+// the whole of data.
+//
+// Within pointer, "~1" stands for '/' and "~0" for '~'. The token "-",
+// which refers to the nonexistent element past the end of an array,
+// never resolves to a value, so GetPointer can be used to check that
+// such an element is absent. If an object contains duplicate keys, the
+// first is used.
+//
+// GetPointer first checks, as Unmarshal does, that the whole of data is
+// valid JSON, returning a *SyntaxError if it is not. It then walks only as
+// much of data as is needed to find the value, without decoding the values
+// it passes over. The returned RawMessage aliases data. If pointer is
+// malformed or does not refer to an existing value, GetPointer returns a
+// *PointerError.
+func GetPointer(data []byte, pointer string) (RawMessage, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	var d decodeState
+	if err := checkValid(data, &d.scan); err != nil {
+		return nil, err
+	}
+	d.init(data)
+	d.scan.reset()
+	d.scanWhile(scanSkipSpace)
+	for _, tok := range tokens {
+		if err := d.pointerChild(pointer, tok); err != nil {
+			return nil, err
+		}
+	}
+	start := d.readIndex()
+	if d.opcode == scanBeginLiteral {
+		d.rescanLiteral()
+		return data[start:d.readIndex()], nil
+	}
+	d.skip()
+	return data[start:d.off], nil
+}
+
+// pointerChild advances d from the beginning of an object or array to the
+// beginning of its member or element named by the reference token tok.
+func (d *decodeState) pointerChild(pointer, tok string) error {
+	switch d.opcode {
+	case scanBeginObject:
+		for {
+			d.scanWhile(scanSkipSpace)
+			if d.opcode == scanEndObject {
+				break
+			}
+			if d.opcode != scanBeginLiteral {
+				panic(phasePanicMsg)
+			}
+			start := d.readIndex()
+			d.rescanLiteral()
+			key, ok := unquoteBytes(d.data[start:d.readIndex()])
+			if !ok {
+				panic(phasePanicMsg)
+			}
+			if d.opcode == scanSkipSpace {
+				d.scanWhile(scanSkipSpace)
+			}
+			if d.opcode != scanObjectKey {
+				panic(phasePanicMsg)
+			}
+			d.scanWhile(scanSkipSpace)
+			if string(key) == tok {
+				return nil
+			}
+			if err := d.value(reflect.Value{}); err != nil {
+				return err
+			}
+			if d.opcode == scanSkipSpace {
+				d.scanWhile(scanSkipSpace)
+			}
+			if d.opcode == scanEndObject {
+				break
+			}
+			if d.opcode != scanObjectValue {
+				panic(phasePanicMsg)
+			}
+		}
+		return pointerError(pointer, "object has no key %q", tok)
+
+	case scanBeginArray:
+		n, err := arrayIndex(pointer, tok, maxInt, false)
+		if err != nil {
+			return err
+		}
+		for i := 0; ; i++ {
+			d.scanWhile(scanSkipSpace)
+			if d.opcode == scanEndArray {
+				break
+			}
+			if i == n {
+				return nil
+			}
+			if err := d.value(reflect.Value{}); err != nil {
+				return err
+			}
+			if d.opcode == scanSkipSpace {
+				d.scanWhile(scanSkipSpace)
+			}
+			if d.opcode == scanEndArray {
+				break
+			}
+			if d.opcode != scanArrayValue {
+				panic(phasePanicMsg)
+			}
+		}
+		return pointerError(pointer, "array index %s out of range", tok)
+	}
+	return pointerError(pointer, "cannot resolve token %q in a non-container value", tok)
+}
+
+const maxInt = int(^uint(0) >> 1)
+
+// A PointerError describes a JSON Pointer, as defined by RFC 6901,
+// that is malformed or cannot be resolved against a document.
+type PointerError struct {
+	Pointer string // the pointer being resolved
+	msg     string // description of error
+}
+
+func (e *PointerError) Error() string {
+	return "json: pointer " + strconv.Quote(e.Pointer) + ": " + e.msg
+}
+
+func pointerError(pointer, format string, args ...interface{}) *PointerError {
+	return &PointerError{Pointer: pointer, msg: fmt.Sprintf(format, args...)}
+}
+
+// parsePointer splits a JSON Pointer into its unescaped reference tokens.
+// The empty pointer, which refers to the whole document, has no tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if pointer[0] != '/' {
+		return nil, pointerError(pointer, "must be empty or begin with '/'")
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, tok := range tokens {
+		if strings.IndexByte(tok, '~') < 0 {
+			continue
+		}
+		var b strings.Builder
+		for j := 0; j < len(tok); j++ {
+			c := tok[j]
+			if c == '~' {
+				if j+1 == len(tok) || (tok[j+1] != '0' && tok[j+1] != '1') {
+					return nil, pointerError(pointer, "invalid escape in token %q", tok)
+				}
+				j++
+				if tok[j] == '0' {
+					c = '~'
+				} else {
+					c = '/'
+				}
+			}
+			b.WriteByte(c)
+		}
+		tokens[i] = b.String()
+	}
+	return tokens, nil
+}
+
+// arrayIndex parses tok as an index into an array of length n. If end is
+// true, tok may also equal n or be "-", both of which refer to the position
+// just past the last element.
+func arrayIndex(pointer, tok string, n int, end bool) (int, error) {
+	if tok == "-" {
+		if end {
+			return n, nil
+		}
+		return 0, pointerError(pointer, "array index \"-\" refers to a nonexistent element")
+	}
+	if tok == "" || (len(tok) > 1 && tok[0] == '0') {
+		return 0, pointerError(pointer, "invalid array index %q", tok)
+	}
+	for i := 0; i < len(tok); i++ {
+		if tok[i] < '0' || tok[i] > '9' {
+			return 0, pointerError(pointer, "invalid array index %q", tok)
+		}
+	}
+	i, err := strconv.Atoi(tok)
+	if err != nil || i > n || (i == n && !end) {
+		return 0, pointerError(pointer, "array index %s out of range", tok)
+	}
+	return i, nil
+}
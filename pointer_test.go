@@ -0,0 +1,94 @@
+package json
+
+import "testing"
+
+// pointerDoc is the example document from RFC 6901, section 5.
+const pointerDoc = `{
+	"foo": ["bar", "baz"],
+	"": 0,
+	"a/b": 1,
+	"c%d": 2,
+	"e^f": 3,
+	"g|h": 4,
+	"i\\j": 5,
+	"k\"l": 6,
+	" ": 7,
+	"m~n": 8
+}`
+
+func TestGetPointer(t *testing.T) {
+	tests := []struct {
+		data, pointer, want string
+	}{
+		// Examples from RFC 6901, section 5.
+		{pointerDoc, "", pointerDoc},
+		{pointerDoc, "/foo", `["bar", "baz"]`},
+		{pointerDoc, "/foo/0", `"bar"`},
+		{pointerDoc, "/", `0`},
+		{pointerDoc, "/a~1b", `1`},
+		{pointerDoc, "/c%d", `2`},
+		{pointerDoc, "/e^f", `3`},
+		{pointerDoc, "/g|h", `4`},
+		{pointerDoc, "/i\\j", `5`},
+		{pointerDoc, "/k\"l", `6`},
+		{pointerDoc, "/ ", `7`},
+		{pointerDoc, "/m~0n", `8`},
+
+		// Other cases.
+		{` [1, {"a": [true, null]}] `, "", `[1, {"a": [true, null]}]`},
+		{` [1, {"a": [true, null]}] `, "/1/a/1", `null`},
+		{` [1, {"a": [true, null]}] `, "/1/a", `[true, null]`},
+		{`{"x":{"y":[]},"z":{"y":-1.5e3}}`, "/z/y", `-1.5e3`},
+		{`{"A":1}`, "/A", `1`},
+		{`{"a":1,"a":2}`, "/a", `1`},
+		{`{"0":"x"}`, "/0", `"x"`},
+	}
+	for _, tt := range tests {
+		got, err := GetPointer([]byte(tt.data), tt.pointer)
+		if err != nil {
+			t.Errorf("GetPointer(%#q, %q): %v", tt.data, tt.pointer, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("GetPointer(%#q, %q) = %#q, want %#q", tt.data, tt.pointer, got, tt.want)
+		}
+	}
+}
+
+func TestGetPointerError(t *testing.T) {
+	tests := []struct {
+		data, pointer, err string
+	}{
+		{pointerDoc, "/bar", `json: pointer "/bar": object has no key "bar"`},
+		{pointerDoc, "/foo/2", `json: pointer "/foo/2": array index 2 out of range`},
+		{pointerDoc, "/foo/-", `json: pointer "/foo/-": array index "-" refers to a nonexistent element`},
+		{pointerDoc, "/foo/01", `json: pointer "/foo/01": invalid array index "01"`},
+		{pointerDoc, "/foo/x", `json: pointer "/foo/x": invalid array index "x"`},
+		{pointerDoc, "/foo/0/x", `json: pointer "/foo/0/x": cannot resolve token "x" in a non-container value`},
+		{pointerDoc, "/m~2n", `json: pointer "/m~2n": invalid escape in token "m~2n"`},
+		{pointerDoc, "foo", `json: pointer "foo": must be empty or begin with '/'`},
+		{`[]`, "/0", `json: pointer "/0": array index 0 out of range`},
+		{`{}`, "/", `json: pointer "/": object has no key ""`},
+		{`{"a":1`, "/a", `unexpected end of JSON input`},
+	}
+	for _, tt := range tests {
+		_, err := GetPointer([]byte(tt.data), tt.pointer)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("GetPointer(%#q, %q) error = %v, want %s", tt.data, tt.pointer, err, tt.err)
+		}
+	}
+}
+
+func BenchmarkGetPointer(b *testing.B) {
+	if codeJSON == nil {
+		b.StopTimer()
+		codeInit()
+		b.StartTimer()
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := GetPointer(codeJSON, "/tree/kids/1/kids/0/name"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package json
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Redact reads a JSON value from src and writes it to dst, compacted, with
+// each value whose path matches one of paths replaced by replacement, as in
+// removing passwords or tokens from a document before logging it. Paths and
+// patterns are as for Decoder.OnPath: "user.password" matches the password
+// member of the user object, and "tokens.*" matches every element of the
+// tokens array, or every member of the tokens object. A nil replacement
+// stands for null.
+//
+// The value is read a little at a time, so that it need not fit in memory;
+// only those objects and arrays that contain a match are taken apart, and
+// the subtree of a matched value is skipped without being decoded. The
+// replacement must be valid JSON, and is written compacted; if it is not,
+// Redact returns a *SyntaxError and writes nothing. Output is buffered, and
+// on an error in src part of the value may already have been written to dst.
+func Redact(dst io.Writer, src io.Reader, paths []string, replacement RawMessage) error {
+	if replacement == nil {
+		replacement = RawMessage("null")
+	}
+	var repl bytes.Buffer
+	if err := compact(&repl, replacement, false); err != nil {
+		return err
+	}
+	r := redactor{
+		dec:  NewDecoder(src),
+		w:    bufio.NewWriter(dst),
+		repl: repl.Bytes(),
+	}
+	for _, p := range paths {
+		var elems []string
+		if p != "" {
+			elems = strings.Split(p, ".")
+		}
+		r.patterns = append(r.patterns, elems)
+	}
+	err := r.value(nil)
+	if ferr := r.w.Flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// A redactor holds the state of a call to Redact.
+type redactor struct {
+	dec      *Decoder
+	w        *bufio.Writer
+	repl     []byte     // compacted replacement
+	patterns [][]string // paths split into elements
+	raw      RawMessage // reused for values copied whole
+}
+
+// value copies the next value in the input, whose path is path, to the
+// output, redacting the values within it that match.
+func (r *redactor) value(path []string) error {
+	descend := false
+	for _, p := range r.patterns {
+		if len(p) < len(path) || !matchPath(p[:len(path)], path) {
+			continue
+		}
+		if len(p) == len(path) {
+			r.raw = r.raw[:0]
+			if err := r.dec.Decode(&r.raw); err != nil {
+				return err
+			}
+			r.w.Write(r.repl)
+			return nil
+		}
+		descend = true
+	}
+	// Consume any pending comma or colon, so that peek sees the value.
+	if err := r.dec.tokenPrepareForDecode(); err != nil {
+		return err
+	}
+	c, err := r.dec.peek()
+	if err != nil {
+		return err
+	}
+	if !descend || c != '{' && c != '[' {
+		r.raw = r.raw[:0]
+		if err := r.dec.Decode(&r.raw); err != nil {
+			return err
+		}
+		return compact(r.w, r.raw, false)
+	}
+
+	if _, err := r.dec.Token(); err != nil {
+		return err
+	}
+	r.w.WriteByte(c)
+	for i := 0; r.dec.More(); i++ {
+		if i > 0 {
+			r.w.WriteByte(',')
+		}
+		elem := strconv.Itoa(i)
+		if c == '{' {
+			tok, err := r.dec.Token()
+			if err != nil {
+				return err
+			}
+			key, _ := tok.(string)
+			b, err := Marshal(key)
+			if err != nil {
+				return err
+			}
+			r.w.Write(b)
+			r.w.WriteByte(':')
+			elem = key
+		}
+		if err := r.value(append(path, elem)); err != nil {
+			return err
+		}
+	}
+	if _, err := r.dec.Token(); err != nil {
+		return err
+	}
+	r.w.WriteByte(c + 2) // '{'+2 == '}', '['+2 == ']'
+	return nil
+}
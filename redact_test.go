@@ -0,0 +1,59 @@
+package json
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestRedact(t *testing.T) {
+	const in = `{
+		"user": {"name": "gopher", "password": {"hash": "x", "salt": [1, 2]}},
+		"tokens": ["abc", {"t": "def"}],
+		"other": {"password": "kept"},
+		"n": 1.50
+	}`
+	tests := []struct {
+		paths []string
+		repl  RawMessage
+		want  string
+	}{
+		{
+			[]string{"user.password", "tokens.*"}, RawMessage(`"***"`),
+			`{"user":{"name":"gopher","password":"***"},"tokens":["***","***"],"other":{"password":"kept"},"n":1.50}`,
+		},
+		{
+			[]string{"*.password"}, nil,
+			`{"user":{"name":"gopher","password":null},"tokens":["abc",{"t":"def"}],"other":{"password":null},"n":1.50}`,
+		},
+		{
+			[]string{"tokens.1.t", "missing.x", "n.x"}, RawMessage(` [ 0 ] `),
+			`{"user":{"name":"gopher","password":{"hash":"x","salt":[1,2]}},"tokens":["abc",{"t":[0]}],"other":{"password":"kept"},"n":1.50}`,
+		},
+		{[]string{""}, RawMessage(`{}`), `{}`},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if err := Redact(&out, iotest.OneByteReader(strings.NewReader(in)), tt.paths, tt.repl); err != nil {
+			t.Errorf("Redact(%q): %v", tt.paths, err)
+			continue
+		}
+		if out.String() != tt.want {
+			t.Errorf("Redact(%q):\n got %s\nwant %s", tt.paths, out.String(), tt.want)
+		}
+	}
+}
+
+func TestRedactErrors(t *testing.T) {
+	var out bytes.Buffer
+	err := Redact(&out, strings.NewReader(`{"a":1}`), []string{"a"}, RawMessage(`***`))
+	if _, ok := err.(*SyntaxError); !ok || out.Len() != 0 {
+		t.Errorf("Redact with invalid replacement = %v, output %q, want SyntaxError and no output", err, out.String())
+	}
+
+	out.Reset()
+	if err := Redact(&out, strings.NewReader(`{"a":{"b":1}`), []string{"a.b"}, nil); err == nil {
+		t.Error("Redact of truncated input: no error")
+	}
+}
@@ -13,13 +13,114 @@ package json
 // This file starts with two simple examples using the scanner
 // before diving into the scanner itself.
 
-import "strconv"
+import (
+	"strconv"
+	"unicode/utf8"
+)
 
 // Valid reports whether data is a valid JSON encoding.
 func Valid(data []byte) bool {
 	return checkValid(data, &scanner{}) == nil
 }
 
+// ValidWithError is like Valid, but returns a *SyntaxError describing the
+// first problem found if data is not a valid JSON encoding, or nil if it is.
+// As elsewhere, the error's Offset is the number of bytes read before the
+// error was detected, so the offending byte is at data[Offset-1]. Non-space
+// bytes following a complete top-level value are reported as an invalid
+// character "after top-level value".
+func ValidWithError(data []byte) error {
+	return checkValid(data, &scanner{})
+}
+
+// A StreamValidator validates a JSON value incrementally, one byte at a time,
+// without holding on to the bytes it has seen. It accepts exactly the inputs
+// accepted by Valid.
+type StreamValidator struct {
+	scan scanner
+}
+
+// NewStreamValidator returns a new StreamValidator, ready to validate the
+// first byte of a JSON value.
+func NewStreamValidator() *StreamValidator {
+	v := &StreamValidator{}
+	v.scan.reset()
+	return v
+}
+
+// Feed validates the next byte of input. It returns a *SyntaxError as soon as
+// b is found to be invalid, whose Offset is the number of bytes fed so far,
+// including b. Once Feed has returned an error, it and Done return the same
+// error for the rest of the input.
+func (v *StreamValidator) Feed(b byte) error {
+	if v.scan.err != nil {
+		return v.scan.err
+	}
+	v.scan.bytes++
+	if v.scan.step(&v.scan, b) == scanError {
+		return v.scan.err
+	}
+	return nil
+}
+
+// Done reports the end of input. It returns nil if the bytes fed form a
+// complete JSON value, and a *SyntaxError otherwise.
+func (v *StreamValidator) Done() error {
+	if v.scan.eof() == scanError {
+		return v.scan.err
+	}
+	return nil
+}
+
+// ScanNumber scans the JSON number at the start of b, reporting whether it has
+// a fraction or exponent, and so must be parsed as a float, and the number of
+// bytes it occupies. The number ends at the first byte that cannot continue
+// it, which is left for the caller, so b may hold a number embedded in a
+// larger input. ScanNumber returns a *SyntaxError if b does not start with a
+// number, or with a complete one: a bare "-", a "." or exponent without
+// digits, or a leading zero followed by further digits, as in "012".
+func ScanNumber(b []byte) (isFloat bool, consumed int, err error) {
+	if len(b) == 0 {
+		return false, 0, &SyntaxError{"unexpected end of JSON input", 0}
+	}
+	if c := b[0]; c != '-' && (c < '0' || '9' < c) {
+		return false, 0, &SyntaxError{"invalid character " + quoteChar(c) + " looking for beginning of number", 1}
+	}
+	var scan scanner
+	scan.reset()
+	scan.bytes++
+	scan.step(&scan, b[0])
+	for i, c := range b[1:] {
+		if (c < '0' || '9' < c) && c != '.' && c != 'e' && c != 'E' && c != '+' && c != '-' {
+			// c cannot continue the number. Stop here rather than let the
+			// scanner reject it as following a top-level value.
+			if p := b[i]; p < '0' || '9' < p {
+				scan.bytes++
+				scan.step(&scan, c)
+				return false, 0, scan.err
+			}
+			return isFloat, i + 1, nil
+		}
+		scan.bytes++
+		if scan.step(&scan, c) == scanContinue {
+			isFloat = isFloat || c == '.' || c == 'e' || c == 'E'
+			continue
+		}
+		if p := b[i]; p < '0' || '9' < p {
+			// The scanner rejected c within an incomplete number.
+			return false, 0, scan.err
+		}
+		if '0' <= c && c <= '9' {
+			return false, 0, &SyntaxError{"invalid character " + quoteChar(c) + " after leading zero in numeric literal", scan.bytes}
+		}
+		return isFloat, i + 1, nil
+	}
+	if c := b[len(b)-1]; c < '0' || '9' < c {
+		return false, 0, &SyntaxError{"unexpected end of JSON input", scan.bytes}
+	}
+	return isFloat, len(b), nil
+}
+
 // checkValid verifies that data is valid JSON-encoded data.
 // scan is passed in for use by checkValid to avoid an allocation.
 func checkValid(data []byte, scan *scanner) error {
@@ -44,6 +145,30 @@ type SyntaxError struct {
 
 func (e *SyntaxError) Error() string { return e.msg }
 
+// Position returns the 1-based line and column in src, the input in which
+// the error was found, of the byte at which it was detected: src[Offset-1],
+// or the start of src if Offset is zero. Lines are terminated by '\n', so a
+// CRLF sequence counts as a single line break. Columns count runes, not
+// bytes, from the start of the line. An Offset beyond the end of src is
+// taken to refer to its last byte.
+func (e *SyntaxError) Position(src []byte) (line, col int) {
+	p := int(e.Offset) - 1
+	if p > len(src)-1 {
+		p = len(src) - 1
+	}
+	if p < 0 {
+		p = 0
+	}
+	line, start := 1, 0
+	for i, c := range src[:p] {
+		if c == '\n' {
+			line++
+			start = i + 1
+		}
+	}
+	return line, utf8.RuneCount(src[start:p]) + 1
+}
+
 // A scanner is a JSON scanning state machine.
 // Callers call scan.reset() and then pass bytes in one at a time
 // by calling scan.step(&scan, c) for each byte.
@@ -74,8 +199,38 @@ type scanner struct {
 
 	// total bytes consumed, updated by decoder.Decode
 	bytes int64
+
+	// Whether a comma may follow the last element of an array or object.
+	// Unlike the fields above, this is not cleared by reset.
+	allowTrailingCommas bool
+
+	// Maximum nesting depth of arrays and objects: DefaultMaxDepth if zero,
+	// and unlimited if negative. Like allowTrailingCommas, this is not
+	// cleared by reset.
+	maxDepth int
+
+	// Whether integers may be written in hexadecimal, octal or binary,
+	// with a 0x, 0o or 0b prefix. Like allowTrailingCommas, this is not
+	// cleared by reset.
+	allowExtendedNumbers bool
+
+	// Whether the integer part of a number may have leading zeros, as in
+	// 0123. Like allowTrailingCommas, this is not cleared by reset.
+	allowLeadingZeros bool
+
+	// Maximum total number of array elements and object members in the
+	// value, or zero if unlimited. Like allowTrailingCommas, this is not
+	// cleared by reset, unlike elements, the number begun so far.
+	maxElements int
+	elements    int
 }
 
+// DefaultMaxDepth is the maximum depth to which arrays and objects may be
+// nested in the input to any function of this package, unless changed by
+// Decoder.SetMaxDepth. Input nested more deeply is rejected with a
+// *SyntaxError, rather than risking exhaustion of the stack by the decoder.
+const DefaultMaxDepth = 10000
+
 // These values are returned by the state transition functions
 // assigned to scanner.state and the method scanner.eof.
 // They give details about the current state of the scan that
@@ -118,6 +273,7 @@ func (s *scanner) reset() {
 	s.parseState = s.parseState[0:0]
 	s.err = nil
 	s.endTop = false
+	s.elements = 0
 }
 
 // eof tells the scanner that the end of input has been reached.
@@ -139,9 +295,32 @@ func (s *scanner) eof() int {
 	return scanError
 }
 
-// pushParseState pushes a new parse state p onto the parse stack.
-func (s *scanner) pushParseState(p int) {
+// pushParseState pushes a new parse state p onto the parse stack, and returns
+// op, or scanError if the stack would exceed the maximum nesting depth.
+func (s *scanner) pushParseState(p int, op int) int {
+	max := s.maxDepth
+	if max == 0 {
+		max = DefaultMaxDepth
+	}
+	if max > 0 && len(s.parseState) >= max {
+		s.step = stateError
+		s.err = &SyntaxError{"exceeds maximum nesting depth", s.bytes}
+		return scanError
+	}
 	s.parseState = append(s.parseState, p)
+	return op
+}
+
+// countElement counts the beginning of an array element or object member,
+// and returns op, or scanError if that exceeds the maximum number of them.
+func (s *scanner) countElement(op int) int {
+	s.elements++
+	if s.elements > s.maxElements {
+		s.step = stateError
+		s.err = &SyntaxError{"exceeds maximum number of elements", s.bytes}
+		return scanError
+	}
+	return op
 }
 
 // popParseState pops a parse state (already obtained) off the stack
@@ -177,15 +356,18 @@ func stateBeginValue(s *scanner, c byte) int {
 	if c <= ' ' && isSpace(c) {
 		return scanSkipSpace
 	}
+	if n := len(s.parseState); s.maxElements > 0 && n > 0 && s.parseState[n-1] == parseArrayValue {
+		if s.countElement(scanContinue) == scanError {
+			return scanError
+		}
+	}
 	switch c {
 	case '{':
 		s.step = stateBeginStringOrEmpty
-		s.pushParseState(parseObjectKey)
-		return scanBeginObject
+		return s.pushParseState(parseObjectKey, scanBeginObject)
 	case '[':
 		s.step = stateBeginValueOrEmpty
-		s.pushParseState(parseArrayValue)
-		return scanBeginArray
+		return s.pushParseState(parseArrayValue, scanBeginArray)
 	case '"':
 		s.step = stateInString
 		return scanBeginLiteral
@@ -232,6 +414,9 @@ func stateBeginString(s *scanner, c byte) int {
 	}
 	if c == '"' {
 		s.step = stateInString
+		if s.maxElements > 0 {
+			return s.countElement(scanBeginLiteral)
+		}
 		return scanBeginLiteral
 	}
 	return s.error(c, "looking for beginning of object key string")
@@ -263,7 +448,11 @@ func stateEndValue(s *scanner, c byte) int {
 	case parseObjectValue:
 		if c == ',' {
 			s.parseState[n-1] = parseObjectKey
-			s.step = stateBeginString
+			if s.allowTrailingCommas {
+				s.step = stateBeginStringOrEmpty
+			} else {
+				s.step = stateBeginString
+			}
 			return scanObjectValue
 		}
 		if c == '}' {
@@ -273,7 +462,11 @@ func stateEndValue(s *scanner, c byte) int {
 		return s.error(c, "after object key:value pair")
 	case parseArrayValue:
 		if c == ',' {
-			s.step = stateBeginValue
+			if s.allowTrailingCommas {
+				s.step = stateBeginValueOrEmpty
+			} else {
+				s.step = stateBeginValue
+			}
 			return scanArrayValue
 		}
 		if c == ']' {
@@ -385,11 +578,34 @@ func state1(s *scanner, c byte) int {
 		s.step = state1
 		return scanContinue
 	}
-	return state0(s, c)
+	return stateInt(s, c)
 }
 
 // state0 is the state after reading `0` during a number.
 func state0(s *scanner, c byte) int {
+	if s.allowExtendedNumbers {
+		switch c {
+		case 'x', 'X':
+			s.step = stateHex
+			return scanContinue
+		case 'o', 'O':
+			s.step = stateOctal
+			return scanContinue
+		case 'b', 'B':
+			s.step = stateBinary
+			return scanContinue
+		}
+	}
+	if s.allowLeadingZeros && '0' <= c && c <= '9' {
+		s.step = state1
+		return scanContinue
+	}
+	return stateInt(s, c)
+}
+
+// stateInt is the state after reading the integer part of a number, which
+// may be followed by a fraction or an exponent.
+func stateInt(s *scanner, c byte) int {
 	if c == '.' {
 		s.step = stateDot
 		return scanContinue
@@ -401,6 +617,63 @@ func state0(s *scanner, c byte) int {
 	return stateEndValue(s, c)
 }
 
+// stateHex is the state after reading `0x` during a number, which is
+// possible only if extended numbers are allowed.
+func stateHex(s *scanner, c byte) int {
+	if '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F' {
+		s.step = stateHex0
+		return scanContinue
+	}
+	return s.error(c, "after hexadecimal prefix in numeric literal")
+}
+
+// stateHex0 is the state after reading `0x` and at least one hexadecimal
+// digit during a number, such as after reading `0xff`.
+func stateHex0(s *scanner, c byte) int {
+	if '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F' {
+		return scanContinue
+	}
+	return stateEndValue(s, c)
+}
+
+// stateOctal is the state after reading `0o` during a number, which is
+// possible only if extended numbers are allowed.
+func stateOctal(s *scanner, c byte) int {
+	if '0' <= c && c <= '7' {
+		s.step = stateOctal0
+		return scanContinue
+	}
+	return s.error(c, "after octal prefix in numeric literal")
+}
+
+// stateOctal0 is the state after reading `0o` and at least one octal digit
+// during a number, such as after reading `0o755`.
+func stateOctal0(s *scanner, c byte) int {
+	if '0' <= c && c <= '7' {
+		return scanContinue
+	}
+	return stateEndValue(s, c)
+}
+
+// stateBinary is the state after reading `0b` during a number, which is
+// possible only if extended numbers are allowed.
+func stateBinary(s *scanner, c byte) int {
+	if c == '0' || c == '1' {
+		s.step = stateBinary0
+		return scanContinue
+	}
+	return s.error(c, "after binary prefix in numeric literal")
+}
+
+// stateBinary0 is the state after reading `0b` and at least one binary digit
+// during a number, such as after reading `0b101`.
+func stateBinary0(s *scanner, c byte) int {
+	if c == '0' || c == '1' {
+		return scanContinue
+	}
+	return stateEndValue(s, c)
+}
+
 // stateDot is the state after reading the integer and decimal point in a number,
 // such as after reading `1.`.
 func stateDot(s *scanner, c byte) int {
@@ -5,13 +5,16 @@
 package json
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
 	"io"
 	"math"
 	"math/rand"
 	"reflect"
 	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 var validTests = []struct {
@@ -34,6 +37,147 @@ func TestValid(t *testing.T) {
 	}
 }
 
+func TestValidWithError(t *testing.T) {
+	tests := []struct {
+		data string
+		err  error
+	}{
+		{`{"foo":"bar"}`, nil},
+		{` [1, 2] `, nil},
+		{`foo`, &SyntaxError{"invalid character 'o' in literal false (expecting 'a')", 2}},
+		{`{"foo" "bar"}`, &SyntaxError{"invalid character '\"' after object key", 8}},
+		{`[1, 2`, &SyntaxError{"unexpected end of JSON input", 5}},
+		{`{} x`, &SyntaxError{"invalid character 'x' after top-level value", 4}},
+		{`{}}{`, &SyntaxError{"invalid character '}' after top-level value", 3}},
+	}
+	for _, tt := range tests {
+		if err := ValidWithError([]byte(tt.data)); !reflect.DeepEqual(err, tt.err) {
+			t.Errorf("ValidWithError(%#q) = %#v, want %#v", tt.data, err, tt.err)
+		}
+	}
+}
+
+func TestSyntaxErrorPosition(t *testing.T) {
+	tests := []struct {
+		data      string
+		line, col int
+	}{
+		{"{\n  \"a\": 1,\n  \"b\" 2\n}", 3, 7},
+		{"{\r\n  \"a\": 1,\r\n  \"b\": tru\r\n}", 3, 11},
+		{"[\n  \"héllo\", x]", 2, 12},
+		{"[1,\n 2", 2, 2},
+		{"x", 1, 1},
+	}
+	for _, tt := range tests {
+		var serr *SyntaxError
+		if err := ValidWithError([]byte(tt.data)); !errors.As(err, &serr) {
+			t.Errorf("ValidWithError(%q) = %v, want *SyntaxError", tt.data, err)
+			continue
+		}
+		if line, col := serr.Position([]byte(tt.data)); line != tt.line || col != tt.col {
+			t.Errorf("Position(%q) = %d:%d, want %d:%d (%v)", tt.data, line, col, tt.line, tt.col, serr)
+		}
+	}
+
+	// Offsets outside the source are clamped to it.
+	src := []byte("ab\ncd")
+	if line, col := (&SyntaxError{"test", 0}).Position(src); line != 1 || col != 1 {
+		t.Errorf("Position with Offset 0 = %d:%d, want 1:1", line, col)
+	}
+	if line, col := (&SyntaxError{"test", 100}).Position(src); line != 2 || col != 2 {
+		t.Errorf("Position with Offset 100 = %d:%d, want 2:2", line, col)
+	}
+	if line, col := (&SyntaxError{"test", 1}).Position(nil); line != 1 || col != 1 {
+		t.Errorf("Position(nil) = %d:%d, want 1:1", line, col)
+	}
+}
+
+func TestScanNumber(t *testing.T) {
+	tests := []struct {
+		in       string
+		isFloat  bool
+		consumed int
+		err      error
+	}{
+		{"0", false, 1, nil},
+		{"-12", false, 3, nil},
+		{"12,", false, 2, nil},
+		{"12]", false, 2, nil},
+		{"12 ", false, 2, nil},
+		{"12abc", false, 2, nil},
+		{"1.5", true, 3, nil},
+		{"-0.5e-3}", true, 7, nil},
+		{"1E+10", true, 5, nil},
+		{"1.5.", true, 3, nil},
+		{"12-", false, 2, nil},
+		{"0.0", true, 3, nil},
+		{"-", false, 0, &SyntaxError{"unexpected end of JSON input", 1}},
+		{"-x", false, 0, &SyntaxError{"invalid character 'x' in numeric literal", 2}},
+		{"1.", false, 0, &SyntaxError{"unexpected end of JSON input", 2}},
+		{"1.e5", false, 0, &SyntaxError{"invalid character 'e' after decimal point in numeric literal", 3}},
+		{"1e", false, 0, &SyntaxError{"unexpected end of JSON input", 2}},
+		{"1e+]", false, 0, &SyntaxError{"invalid character ']' in exponent of numeric literal", 4}},
+		{"012", false, 0, &SyntaxError{"invalid character '1' after leading zero in numeric literal", 2}},
+		{"-00", false, 0, &SyntaxError{"invalid character '0' after leading zero in numeric literal", 3}},
+		{".5", false, 0, &SyntaxError{"invalid character '.' looking for beginning of number", 1}},
+		{"+1", false, 0, &SyntaxError{"invalid character '+' looking for beginning of number", 1}},
+		{"", false, 0, &SyntaxError{"unexpected end of JSON input", 0}},
+	}
+	for _, tt := range tests {
+		isFloat, consumed, err := ScanNumber([]byte(tt.in))
+		if isFloat != tt.isFloat || consumed != tt.consumed || !reflect.DeepEqual(err, tt.err) {
+			t.Errorf("ScanNumber(%q) = %v, %d, %#v, want %v, %d, %#v", tt.in, isFloat, consumed, err, tt.isFloat, tt.consumed, tt.err)
+		}
+	}
+}
+
+func TestStreamValidator(t *testing.T) {
+	for _, tt := range validTests {
+		v := NewStreamValidator()
+		var err error
+		for i := 0; i < len(tt.data) && err == nil; i++ {
+			err = v.Feed(tt.data[i])
+		}
+		if err == nil {
+			err = v.Done()
+		}
+		if ok := err == nil; ok != tt.ok {
+			t.Errorf("StreamValidator(%#q) error = %v, want ok = %v", tt.data, err, tt.ok)
+		}
+	}
+
+	// Feed reports the first invalid byte immediately, and the error sticks.
+	v := NewStreamValidator()
+	data := `[1, x, 2]`
+	want := &SyntaxError{"invalid character 'x' looking for beginning of value", 5}
+	for i := 0; i < len(data); i++ {
+		err := v.Feed(data[i])
+		if i < 4 {
+			if err != nil {
+				t.Fatalf("Feed(%q) at %d: %v", data[i], i, err)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(err, want) {
+			t.Fatalf("Feed(%q) at %d = %#v, want %#v", data[i], i, err, want)
+		}
+	}
+	if err := v.Done(); !reflect.DeepEqual(err, want) {
+		t.Errorf("Done = %#v, want %#v", err, want)
+	}
+
+	v = NewStreamValidator()
+	for _, c := range []byte(`{"a":`) {
+		if err := v.Feed(c); err != nil {
+			t.Fatalf("Feed(%q): %v", c, err)
+		}
+	}
+	want = &SyntaxError{"unexpected end of JSON input", 5}
+	if err := v.Done(); !reflect.DeepEqual(err, want) {
+		t.Errorf("Done = %#v, want %#v", err, want)
+	}
+}
+
 // Tests of simple examples.
 
 type example struct {
@@ -104,6 +248,237 @@ func TestCompactSeparators(t *testing.T) {
 	}
 }
 
+func TestIndentTo(t *testing.T) {
+	var buf bytes.Buffer
+	for _, tt := range examples {
+		buf.Reset()
+		if err := IndentTo(&buf, []byte(tt.compact), "", "\t"); err != nil {
+			t.Errorf("IndentTo(%#q): %v", tt.compact, err)
+		} else if s := buf.String(); s != tt.indent {
+			t.Errorf("IndentTo(%#q) = %#q, want %#q", tt.compact, s, tt.indent)
+		}
+	}
+
+	// Trailing space is preserved, as by Indent.
+	buf.Reset()
+	if err := IndentTo(&buf, []byte(" [1,2] \n"), ">", "  "); err != nil {
+		t.Fatalf("IndentTo: %v", err)
+	}
+	if got, want := buf.String(), "[\n>  1,\n>  2\n>] \n"; got != want {
+		t.Errorf("IndentTo = %q, want %q", got, want)
+	}
+
+	// Nothing is written for invalid input.
+	buf.Reset()
+	err := IndentTo(&buf, []byte(`[1, 2, {"a": x}]`), "", "\t")
+	if _, ok := err.(*SyntaxError); !ok {
+		t.Errorf("IndentTo error = %#v, want *SyntaxError", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("IndentTo wrote %q for invalid input", buf.String())
+	}
+}
+
+func TestIndentLike(t *testing.T) {
+	tests := []struct {
+		name   string
+		sample string
+		prefix string
+		want   string
+	}{
+		{"tabs", "{\n\t\"a\": [\n\t\t1\n\t]\n}\n", "", "{\n\t\"a\": [\n\t\t1,\n\t\t2\n\t]\n}"},
+		{"four spaces", "{\n    \"a\": 1\n}", "", "{\n    \"a\": [\n        1,\n        2\n    ]\n}"},
+		{"blank lines skipped", "{\n  \t\r\n   \"a\": 1\n}", "", "{\n   \"a\": [\n      1,\n      2\n   ]\n}"},
+		{"prefix", "// {\n// \t\"a\": 1\n// }", "// ", "{\n// \t\"a\": [\n// \t\t1,\n// \t\t2\n// \t]\n// }"},
+		{"no indentation", `{"a":1}`, "", "{\n  \"a\": [\n    1,\n    2\n  ]\n}"},
+		{"empty sample", "", "", "{\n  \"a\": [\n    1,\n    2\n  ]\n}"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := IndentLike(&buf, []byte(`{"a":[1,2]}`), []byte(tt.sample), tt.prefix); err != nil {
+			t.Errorf("%s: IndentLike: %v", tt.name, err)
+			continue
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("%s: IndentLike = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, ok := IndentLike(&buf, []byte(`{"a":}`), []byte("{\n\t1\n}"), "").(*SyntaxError); !ok || buf.Len() != 0 {
+		t.Errorf("IndentLike of invalid input: wrote %q, want *SyntaxError", buf.String())
+	}
+}
+
+func TestIndentSmart(t *testing.T) {
+	const src = `{"name": "widget", "tags": ["a", "b", "c"], "sizes": [[1, 2], [3, 4], [5, 6, 7, 8, 9, 10, 11, 12]], "empty": {}, "none": []}`
+	tests := []struct {
+		prefix, indent string
+		maxWidth       int
+		want           string
+	}{
+		{"", "  ", 200, src},
+		{"", "  ", len(src), src},
+		{"", "  ", 40, `{
+  "name": "widget",
+  "tags": ["a", "b", "c"],
+  "sizes": [
+    [1, 2],
+    [3, 4],
+    [5, 6, 7, 8, 9, 10, 11, 12]
+  ],
+  "empty": {},
+  "none": []
+}`},
+		// The last inner array needs 31 runes, with its indentation.
+		{"", "  ", 30, `{
+  "name": "widget",
+  "tags": ["a", "b", "c"],
+  "sizes": [
+    [1, 2],
+    [3, 4],
+    [
+      5,
+      6,
+      7,
+      8,
+      9,
+      10,
+      11,
+      12
+    ]
+  ],
+  "empty": {},
+  "none": []
+}`},
+		// The prefix counts on every line, including the first.
+		{"//", "\t", 26, `{
+//	"name": "widget",
+//	"tags": [
+//		"a",
+//		"b",
+//		"c"
+//	],
+//	"sizes": [
+//		[1, 2],
+//		[3, 4],
+//		[
+//			5,
+//			6,
+//			7,
+//			8,
+//			9,
+//			10,
+//			11,
+//			12
+//		]
+//	],
+//	"empty": {},
+//	"none": []
+//}`},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := IndentSmart(&buf, []byte(src), tt.prefix, tt.indent, tt.maxWidth); err != nil {
+			t.Fatalf("IndentSmart: %v", err)
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("IndentSmart(%q, %q, %d):\n%s\nwant:\n%s", tt.prefix, tt.indent, tt.maxWidth, got, tt.want)
+		}
+	}
+
+	// With no width, the output is that of Indent, less surrounding space.
+	for _, tt := range examples {
+		var buf bytes.Buffer
+		if err := IndentSmart(&buf, []byte(tt.compact), "", "\t", 0); err != nil {
+			t.Errorf("IndentSmart(%#q): %v", tt.compact, err)
+		} else if s := buf.String(); s != tt.indent {
+			t.Errorf("IndentSmart(%#q) = %#q, want %#q", tt.compact, s, tt.indent)
+		}
+	}
+
+	// Strings holding delimiters do not confuse the measurement, and widths
+	// are counted in runes.
+	var buf bytes.Buffer
+	if err := IndentSmart(&buf, []byte(`{"\u00e9\"[,": ["]}\\", "éé"]}`), "", " ", 29); err != nil {
+		t.Fatalf("IndentSmart: %v", err)
+	}
+	if got, want := buf.String(), "{\n \"\\u00e9\\\"[,\": [\"]}\\\\\", \"éé\"]\n}"; got != want {
+		t.Errorf("IndentSmart = %q, want %q", got, want)
+	}
+
+	buf.Reset()
+	buf.WriteString("x")
+	if err := IndentSmart(&buf, []byte(`[1, 2`), "", "\t", 80); err == nil || buf.String() != "x" {
+		t.Errorf("IndentSmart of invalid input = %v, wrote %q", err, buf.String())
+	}
+}
+
+func TestCompactValidate(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("prefix")
+	if err := CompactValidate(&buf, []byte("{ \"a\" : \"<\u00e9>\" }"), true); err != nil {
+		t.Fatalf("CompactValidate: %v", err)
+	}
+	if got, want := buf.String(), `prefix{"a":"\u003cé\u003e"}`; got != want {
+		t.Errorf("CompactValidate = %q, want %q", got, want)
+	}
+
+	tests := []struct {
+		in     string
+		offset int64
+	}{
+		{"[\"ok\", \"b\xffd\"]", 10},
+		{"{\"\xe2\x82\": 1}", 3},
+		{"[\"\xed\xa0\x80\"]", 3}, // surrogate half
+		{"[1 2]", 4},
+	}
+	for _, tt := range tests {
+		buf.Reset()
+		buf.WriteString("prefix")
+		err := CompactValidate(&buf, []byte(tt.in), false)
+		se, ok := err.(*SyntaxError)
+		if !ok || se.Offset != tt.offset {
+			t.Errorf("CompactValidate(%q) error = %v, want SyntaxError at offset %d", tt.in, err, tt.offset)
+		}
+		if s := buf.String(); s != "prefix" {
+			t.Errorf("CompactValidate(%q) left %q in dst after error", tt.in, s)
+		}
+	}
+}
+
+func TestCompactSpaced(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`1`, `1`},
+		{` {"a" :1 ,"b":[ 2,3 ]} `, `{"a": 1, "b": [2, 3]}`},
+		{`{"a:b,c":"d, e"}`, `{"a:b,c": "d, e"}`},
+		{"{ } [\n]", ``},
+		{`[{},[],{"x":{}}]`, `[{}, [], {"x": {}}]`},
+		{"[\n\t1,\n\t2\n]", `[1, 2]`},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		buf.WriteString("prefix")
+		err := CompactSpaced(&buf, []byte(tt.in))
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("CompactSpaced(%q): expected error", tt.in)
+			}
+			if s := buf.String(); s != "prefix" {
+				t.Errorf("CompactSpaced(%q) left %q in dst after error", tt.in, s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("CompactSpaced(%q): %v", tt.in, err)
+		} else if s := buf.String(); s != "prefix"+tt.want {
+			t.Errorf("CompactSpaced(%q) = %q, want %q", tt.in, s, "prefix"+tt.want)
+		}
+	}
+}
+
 func benchmarkIndent(in string, b *testing.B) {
 	b.ReportAllocs()
 	b.SetBytes(int64(len(in)))
@@ -191,6 +566,19 @@ func TestStreamIndent(t *testing.T) {
 		}
 	}
 
+	t.Run("split writes", func(t *testing.T) {
+		for _, tt := range examples {
+			buf.Reset()
+			w := IndentWriter(&buf, "", "\t")
+			_, err := io.Copy(w, iotest.OneByteReader(strings.NewReader(tt.compact)))
+			if err != nil {
+				t.Errorf("Indent(%#q): %v", tt.compact, err)
+			} else if s := buf.String(); s != tt.indent {
+				t.Errorf("Indent(%#q) = %#q, want %#q", tt.compact, s, tt.indent)
+			}
+		}
+	})
+
 	t.Run("malformed input", func(t *testing.T) {
 		buf.Reset()
 		w := IndentWriter(&buf, "", "\t")
@@ -201,6 +589,164 @@ func TestStreamIndent(t *testing.T) {
 	})
 }
 
+// flushRecorder records the output written to it at each call to Flush.
+type flushRecorder struct {
+	bytes.Buffer
+	flushed []string
+}
+
+func (r *flushRecorder) Flush() {
+	r.flushed = append(r.flushed, r.String())
+}
+
+func TestIndentWriterFlushOnTopLevel(t *testing.T) {
+	const in = `{"a":[1,2]} "s"` + "\n" + `[]` + "\n" + `3 {}`
+	var r flushRecorder
+	w := IndentWriter(&r, "", "  ", FlushOnTopLevel)
+	if _, err := io.Copy(w, iotest.OneByteReader(strings.NewReader(in))); err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	const obj = "{\n  \"a\": [\n    1,\n    2\n  ]\n}"
+	want := []string{
+		obj,
+		obj + ` "s"` + "\n",
+		obj + ` "s"` + "\n[]",
+		obj + ` "s"` + "\n[]\n3 ",
+		obj + ` "s"` + "\n[]\n3 {}",
+	}
+	if !reflect.DeepEqual(r.flushed, want) {
+		t.Errorf("flushed:\n got %q\nwant %q", r.flushed, want)
+	}
+
+	// Flush methods returning an error are called too, and their error is
+	// returned.
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	w = IndentWriter(bw, "", "  ", FlushOnTopLevel)
+	if _, err := io.WriteString(w, `{"a":1}`); err != nil {
+		t.Fatalf("WriteString: %v", err)
+	}
+	if got, want := buf.String(), "{\n  \"a\": 1\n}"; got != want {
+		t.Errorf("flushed %q, want %q", got, want)
+	}
+
+	// Without the option, only a single value is accepted.
+	buf.Reset()
+	w = IndentWriter(&buf, "", "  ")
+	if _, err := io.WriteString(w, `{} {}`); err == nil {
+		t.Error("expected error writing two values without FlushOnTopLevel")
+	}
+
+	// A syntax error in a later value is still reported.
+	buf.Reset()
+	w = IndentWriter(&buf, "", "  ", FlushOnTopLevel)
+	if _, err := io.WriteString(w, `{} }`); err == nil {
+		t.Error("expected error writing a stray brace")
+	}
+}
+
+func TestStreamCompact(t *testing.T) {
+	var buf bytes.Buffer
+	for _, tt := range examples {
+		buf.Reset()
+		w := CompactWriter(&buf, false)
+		_, err := io.Copy(w, strings.NewReader(tt.compact))
+		if err != nil {
+			t.Errorf("Compact(%#q): %v", tt.compact, err)
+		} else if s := buf.String(); s != tt.compact {
+			t.Errorf("Compact(%#q) = %#q, want original", tt.compact, s)
+		}
+
+		buf.Reset()
+		w = CompactWriter(&buf, false)
+		_, err = io.Copy(w, iotest.OneByteReader(strings.NewReader(tt.indent)))
+		if err != nil {
+			t.Errorf("Compact(%#q): %v", tt.indent, err)
+			continue
+		} else if s := buf.String(); s != tt.compact {
+			t.Errorf("Compact(%#q) = %#q, want %#q", tt.indent, s, tt.compact)
+		}
+	}
+
+	t.Run("escape", func(t *testing.T) {
+		in := "{\"<\u2028>\": \"&\u2029\xe2\x80\"}"
+		want := `{"\u003c\u2028\u003e":"\u0026\u2029` + "\xe2\x80" + `"}`
+		buf.Reset()
+		w := CompactWriter(&buf, true)
+		_, err := io.Copy(w, iotest.OneByteReader(strings.NewReader(in)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := buf.String(); s != want {
+			t.Errorf("CompactWriter(%q) = %q, want %q", in, s, want)
+		}
+	})
+
+	t.Run("malformed input", func(t *testing.T) {
+		buf.Reset()
+		w := CompactWriter(&buf, false)
+		if _, err := w.Write([]byte(`{"X": "foo", `)); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		_, err := w.Write([]byte(`"Y"}`))
+		want := &SyntaxError{"invalid character '}' after object key", 17}
+		if !reflect.DeepEqual(err, want) {
+			t.Errorf("Write: %#v, want %#v", err, want)
+		}
+		if _, err := w.Write([]byte(`]`)); !reflect.DeepEqual(err, want) {
+			t.Errorf("Write after error: %#v, want %#v", err, want)
+		}
+	})
+}
+
+func TestCompactCopy(t *testing.T) {
+	var buf bytes.Buffer
+	for _, tt := range examples {
+		buf.Reset()
+		n, err := CompactCopy(&buf, iotest.OneByteReader(strings.NewReader(tt.indent)), false)
+		if err != nil {
+			t.Errorf("CompactCopy(%#q): %v", tt.indent, err)
+			continue
+		}
+		if s := buf.String(); s != tt.compact || n != int64(len(s)) {
+			t.Errorf("CompactCopy(%#q) = %d, %#q, want %d, %#q", tt.indent, n, s, len(tt.compact), tt.compact)
+		}
+	}
+
+	// A number and an escaped separator are split across reads.
+	in := "[123.5e1,  " + strings.Repeat(" ", 4090) + "1234567, " + `"<&>` + "\u2028" + `"]`
+	want := `[123.5e1,1234567,"\u003c\u0026\u003e\u2028"]`
+	buf.Reset()
+	n, err := CompactCopy(&buf, iotest.HalfReader(strings.NewReader(in)), true)
+	if err != nil {
+		t.Fatalf("CompactCopy: %v", err)
+	}
+	if s := buf.String(); s != want || n != int64(len(want)) {
+		t.Errorf("CompactCopy = %d, %#q, want %d, %#q", n, s, len(want), want)
+	}
+
+	for _, tt := range []struct {
+		in   string
+		want *SyntaxError
+	}{
+		{`{"X": "foo", "Y"}`, &SyntaxError{"invalid character '}' after object key", 17}},
+		{`[1, 2`, &SyntaxError{"unexpected end of JSON input", 5}},
+		{`1 2`, &SyntaxError{"invalid character '2' after top-level value", 3}},
+		{``, &SyntaxError{"unexpected end of JSON input", 0}},
+	} {
+		buf.Reset()
+		_, err := CompactCopy(&buf, iotest.OneByteReader(strings.NewReader(tt.in)), false)
+		if !reflect.DeepEqual(err, tt.want) {
+			t.Errorf("CompactCopy(%#q): %#v, want %#v", tt.in, err, tt.want)
+		}
+	}
+
+	buf.Reset()
+	if _, err := CompactCopy(&buf, iotest.TimeoutReader(strings.NewReader(`[1,`+strings.Repeat(" ", 600)+`2]`)), false); err != iotest.ErrTimeout {
+		t.Errorf("CompactCopy from failing reader: %v, want %v", err, iotest.ErrTimeout)
+	}
+}
+
 // Tests of a large random structure.
 
 func TestCompactBig(t *testing.T) {
@@ -217,6 +763,66 @@ func TestCompactBig(t *testing.T) {
 	}
 }
 
+func TestIndentColor(t *testing.T) {
+	scheme := ColorScheme{Key: "<K>", String: "<S>", Number: "<N>", Bool: "<B>", Null: "<0>"}
+	const reset = "\x1b[0m"
+	var buf bytes.Buffer
+	in := ` {"a:b": ["x,\"}", -1.5e3, true, false, null], "c" :{}} `
+	if err := IndentColor(&buf, []byte(in), ">", "  ", scheme); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Replace(`{
+>  <K>"a:b"$: [
+>    <S>"x,\"}"$,
+>    <N>-1.5e3$,
+>    <B>true$,
+>    <B>false$,
+>    <0>null$
+>  ],
+>  <K>"c"$: {}
+>} `, "$", reset, -1)
+	if got := buf.String(); got != want {
+		t.Errorf("IndentColor = %q, want %q", got, want)
+	}
+
+	buf.Reset()
+	if err := IndentColor(&buf, []byte(`[1,"s"]`), "", "\t", ColorScheme{String: "<S>"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "[\n\t1,\n\t<S>\"s\""+reset+"\n]"; got != want {
+		t.Errorf("IndentColor with partial scheme = %q, want %q", got, want)
+	}
+
+	// Stripping the escape sequences yields the output of Indent.
+	for _, tt := range examples {
+		for _, scheme := range []ColorScheme{NoColor, DefaultColorScheme} {
+			buf.Reset()
+			if err := IndentColor(&buf, []byte(tt.compact), "", "\t", scheme); err != nil {
+				t.Errorf("IndentColor(%#q): %v", tt.compact, err)
+				continue
+			}
+			got := buf.String()
+			for _, esc := range []string{reset, scheme.Key, scheme.String, scheme.Number, scheme.Bool, scheme.Null} {
+				if esc != "" {
+					got = strings.Replace(got, esc, "", -1)
+				}
+			}
+			if got != tt.indent {
+				t.Errorf("IndentColor(%#q) without colors = %#q, want %#q", tt.compact, got, tt.indent)
+			}
+		}
+	}
+
+	buf.Reset()
+	buf.WriteString("x")
+	if err := IndentColor(&buf, []byte(`{"a" 1}`), "", "\t", DefaultColorScheme); err == nil {
+		t.Errorf("IndentColor of invalid input: expected error")
+	}
+	if buf.String() != "x" {
+		t.Errorf("IndentColor of invalid input modified dst: %q", buf.String())
+	}
+}
+
 func TestIndentBig(t *testing.T) {
 	t.Parallel()
 	initBig()
@@ -6,11 +6,19 @@ package json
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
+	"hash"
 	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 // A Decoder reads and decodes JSON values from an input stream.
+// A UTF-8 byte order mark at the start of the stream is ignored.
 type Decoder struct {
 	r       io.Reader
 	buf     []byte
@@ -20,8 +28,34 @@ type Decoder struct {
 	scan    scanner
 	err     error
 
-	tokenState int
-	tokenStack []int
+	tokenState  int
+	tokenStack  []int
+	tokenKeys   []map[string]struct{} // keys seen in each open object; nil unless DisallowDuplicateKeys
+	tokenOffset int64                 // offset of the first byte of the last token
+
+	jsonLines bool // whether to require one top-level value per line
+	lineOpen  bool // whether a top-level value has ended on the current line
+
+	allowComments bool
+	commentState  int    // state of comment stripping at the end of buf
+	commentStart  int64  // offset of the comment being stripped, if any
+	commentText   []byte // text of the comment being stripped, for commentSink
+	commentSink   func(offset int64, text string, block bool)
+
+	tee    io.Writer // if non-nil, receives a copy of the consumed input
+	teep   int       // start of consumed data in buf not yet written to tee
+	teeErr error     // error returned by tee, if any
+
+	maxBytes int64 // maximum number of bytes to read from r, if positive
+	read     int64 // number of bytes read from r
+
+	bomDone bool // whether the start of the input has been checked for a BOM
+	bomHeld int  // number of bytes of a possible BOM held back from buf
+
+	pathHandlers []pathHandler // registered by OnPath
+
+	continueOnError bool    // whether Decode skips bad records, per SetContinueOnError
+	errs            []error // errors in the records skipped, for Errors
 }
 
 // NewDecoder returns a new decoder that reads from r.
@@ -33,14 +67,248 @@ func NewDecoder(r io.Reader) *Decoder {
 }
 
 // UseNumber causes the Decoder to unmarshal a number into an interface{} as a
-// Number instead of as a float64.
-func (dec *Decoder) UseNumber() { dec.d.useNumber = true }
+// Number instead of as a float64. It is equivalent to
+// SetNumberMode(NumberModeNumber).
+func (dec *Decoder) UseNumber() { dec.d.numberMode = NumberModeNumber }
+
+// SetNumberMode sets the Go type used for numbers decoded into an
+// interface{}, at any depth. In mode NumberModeFloat64, the default, numbers
+// are decoded as float64; in NumberModeNumber, as Number; and in
+// NumberModeSmartInt, as int64 where they are integers that fit, and as
+// Number otherwise.
+func (dec *Decoder) SetNumberMode(mode NumberMode) { dec.d.numberMode = mode }
 
 // DisallowUnknownFields causes the Decoder to return an error when the destination
 // is a struct and the input contains object keys which do not match any
 // non-ignored, exported fields in the destination.
 func (dec *Decoder) DisallowUnknownFields() { dec.d.disallowUnknownFields = true }
 
+// SetCaseSensitive specifies whether object keys must match the names of
+// struct fields exactly. By default, a key that matches no field exactly is
+// matched case-insensitively, as by Unmarshal, so that "name" sets a field
+// tagged `json:"Name"`; with SetCaseSensitive(true), such a key is treated as
+// unknown, and the linear search for a case-insensitive match is skipped.
+func (dec *Decoder) SetCaseSensitive(on bool) { dec.d.caseSensitive = on }
+
+// DisallowDuplicateKeys causes the Decoder to return a DuplicateKeyError when
+// an object in the input contains the same key more than once, both from
+// Decode and from Token. Keys are compared after unescaping, so "a" and
+// "\u0061" are considered to be the same key.
+func (dec *Decoder) DisallowDuplicateKeys(on bool) { dec.d.disallowDuplicateKeys = on }
+
+// RegisterDecoder registers fn to decode JSON values into values of type t, in
+// place of Unmarshaler and TextUnmarshaler implementations and the default
+// decoding of t. It is the counterpart of Encoder.RegisterEncoder, and
+// likewise specific to dec.
+//
+// fn is called with the JSON encoding of each value, including null, that is
+// decoded into a value of exactly type t, or into a pointer to one, and with a
+// pointer of type *t to the value to set; a nil pointer is first set to point
+// to a new value. If fn returns an error, Decode stops and returns it.
+// Registering a nil fn removes any registration for t.
+func (dec *Decoder) RegisterDecoder(t reflect.Type, fn func(data []byte, v interface{}) error) {
+	if fn == nil {
+		delete(dec.d.decoders, t)
+		if len(dec.d.decoders) == 0 {
+			dec.d.decoders = nil
+		}
+		return
+	}
+	if dec.d.decoders == nil {
+		dec.d.decoders = make(map[reflect.Type]func([]byte, interface{}) error)
+	}
+	dec.d.decoders[t] = fn
+}
+
+// SetInternKeys specifies whether the Decoder deduplicates the strings it
+// allocates for the keys of objects decoded into maps, so that every
+// occurrence of a key shares the storage of the first, across all the values
+// decoded. This saves memory when decoding many objects with the same keys,
+// such as a stream of similar records, into map[string]interface{}.
+//
+// The table of interned keys holds at most 4096 distinct keys; keys beyond
+// that are allocated as usual. Each call to SetInternKeys(true) starts with
+// an empty table, and SetInternKeys(false) discards it.
+func (dec *Decoder) SetInternKeys(on bool) {
+	if on {
+		dec.d.internKeys = make(map[string]string)
+	} else {
+		dec.d.internKeys = nil
+	}
+}
+
+// SetStrictTypes causes the Decoder to reject JSON values whose type does not
+// match the Go value they are decoded into, rather than coercing or ignoring
+// them. With strict types, a JSON null decoded into anything other than an
+// interface, pointer, map or slice is an error instead of a no-op, a JSON
+// string cannot be decoded into a Number, and the ,string struct tag option is
+// ignored, so that a field tagged `json:",string"` must hold an unquoted
+// number or boolean. Everything else is unchanged: strings are still decoded
+// by encoding.TextUnmarshaler implementations, and mismatches which are
+// errors by default remain so.
+//
+// Each type mismatch in a literal is reported as an *UnmarshalTypeError
+// giving the path to the struct field, if any, and an Offset with the same
+// meaning as for the type errors reported without strict types.
+func (dec *Decoder) SetStrictTypes(on bool) { dec.d.strictTypes = on }
+
+// SetDisallowLossyNumbers specifies whether an integer that cannot be
+// represented exactly by a floating-point value it is decoded into, such as
+// 9007199254740993 (2^53 + 1) decoded into a float64 or into an interface{},
+// is an error rather than being rounded to the nearest representable value.
+// Only numbers written without a fraction or exponent are checked. The error
+// is an *UnmarshalTypeError giving the number and its offset in the input.
+//
+// Integers decoded into integer types are unaffected, since a number out of
+// range is always an error, as are numbers decoded into an interface{} while
+// UseNumber or another NumberMode that preserves them is in effect.
+func (dec *Decoder) SetDisallowLossyNumbers(on bool) { dec.d.disallowLossyNumbers = on }
+
+// SetEmptyStringAsNull specifies whether an empty JSON string, "", decoded
+// into a pointer to a type implementing Unmarshaler or
+// encoding.TextUnmarshaler is treated as null, setting the pointer to nil
+// rather than allocating a value and passing the string to its method. This
+// suits APIs that send "" for a missing value, such as a *time.Time whose
+// UnmarshalJSON would otherwise fail to parse it. Only such pointers are
+// affected: an empty string decoded into a *string, a string, or an
+// interface{} is still an empty string. By default, empty strings are decoded
+// like any other.
+func (dec *Decoder) SetEmptyStringAsNull(on bool) { dec.d.emptyStringAsNull = on }
+
+// SetTrackNulls sets a function to be called by Decode with the path of each
+// value where a JSON null is decoded into a Go value that cannot hold it, such
+// as an int, string or struct, and which is therefore left unchanged. It lets
+// an explicit null be told apart from an absent key, which also leaves the
+// value unchanged. The path is the dotted path of the enclosing struct fields,
+// by their keys, as in UnmarshalTypeError.Field, and is empty for the value
+// passed to Decode itself. Nulls decoded into pointers, interfaces, maps and
+// slices, which set them to nil, and into Unmarshalers are not reported, nor
+// are nulls rejected by SetStrictTypes. The sink only observes: the decoded
+// values are the same with or without it. A nil sink, the default, stops the
+// tracking.
+func (dec *Decoder) SetTrackNulls(sink func(path string)) { dec.d.nullSink = sink }
+
+// SetMaxDepth sets the maximum depth to which arrays and objects may be nested
+// in the input, beyond which Decode and Token return a *SyntaxError reading
+// "exceeds maximum nesting depth", at the offset of the array or object that
+// is one level too deep. Zero selects DefaultMaxDepth, and a negative n
+// removes the limit, so that the depth is bounded only by memory and the
+// size of the stack.
+func (dec *Decoder) SetMaxDepth(n int) {
+	dec.scan.maxDepth = n
+	dec.d.scan.maxDepth = n
+}
+
+// SetMaxElements sets the maximum total number of array elements and object
+// members, at all levels of nesting, in each value read by Decode, as a
+// defense against input that is small but expensive to decode, such as a
+// long array of empty objects. Beyond it, Decode returns a *SyntaxError
+// reading "exceeds maximum number of elements", at the offset of the element
+// or member that is one too many, without decoding the value. Zero or a
+// negative n removes the limit, which is the default. It complements
+// SetMaxDepth and SetMaxBytes. When Token is used to step into an array or
+// object, only the values then read by Decode are counted.
+func (dec *Decoder) SetMaxElements(n int) {
+	if n < 0 {
+		n = 0
+	}
+	dec.scan.maxElements = n
+}
+
+// SetMaxBytes sets the maximum number of bytes the Decoder reads from its
+// reader, as a defense against unexpectedly large input such as a
+// decompression bomb. Once the input goes on beyond n bytes, Decode and Token
+// return a *MaxBytesError, even in the middle of a value or token; values
+// ending within the first n bytes are decoded as usual. The Decoder never
+// reads more than one byte beyond the limit. Zero or a negative n removes the
+// limit, which is the default. The limit is independent of SetMaxDepth, and
+// Reset starts counting again from zero.
+func (dec *Decoder) SetMaxBytes(n int64) { dec.maxBytes = n }
+
+// A MaxBytesError is returned by a Decoder whose input exceeds the maximum
+// set by Decoder.SetMaxBytes.
+type MaxBytesError struct {
+	Limit int64 // maximum number of bytes
+}
+
+func (e *MaxBytesError) Error() string {
+	return fmt.Sprintf("json: input exceeds maximum size of %d bytes", e.Limit)
+}
+
+// AllowTrailingCommas specifies whether a comma may follow the last element
+// of an array or the last member of an object, as in `[1, 2,]` or `{"a": 1,}`,
+// which RFC 8259 does not permit. It affects both Decode and Token. Empty
+// arrays and objects may not contain a comma, and consecutive commas are
+// always rejected.
+func (dec *Decoder) AllowTrailingCommas(on bool) {
+	dec.scan.allowTrailingCommas = on
+	dec.d.scan.allowTrailingCommas = on
+}
+
+// AllowExtendedNumbers specifies whether integers in the input may be
+// written in hexadecimal, octal or binary, as in 0xFF, 0o755 or 0b101, with
+// an optional leading minus sign, which RFC 8259 does not permit. The prefix
+// may be upper or lower case, and must be followed by at least one digit;
+// underscores are not permitted. It affects both Decode and Token.
+//
+// Such a number can be decoded into any integer type, subject to the same
+// range checks as a decimal one, and into a Number or an interface{}, which
+// receive its decimal form, so that encoding it again writes decimal. It
+// cannot be decoded into a float type, which reports an
+// *UnmarshalTypeError. A RawMessage or Unmarshaler receives it as written.
+// Encoding is unaffected.
+func (dec *Decoder) AllowExtendedNumbers(on bool) {
+	dec.scan.allowExtendedNumbers = on
+	dec.d.scan.allowExtendedNumbers = on
+}
+
+// AllowLeadingZeros specifies whether the integer part of a number in the
+// input may have leading zeros, as in 0123 or -007.5, which RFC 8259 does
+// not permit. Such a number is read as decimal, not octal, so 0123 is 123;
+// numbers without leading zeros, such as 0.5, are unaffected. It affects both
+// Decode and Token.
+//
+// A number decoded into a Number or an interface{} has its leading zeros
+// dropped, so that encoding it again writes valid JSON, such as 123. A
+// RawMessage or Unmarshaler receives it as written.
+func (dec *Decoder) AllowLeadingZeros(on bool) {
+	dec.scan.allowLeadingZeros = on
+	dec.d.scan.allowLeadingZeros = on
+}
+
+// AllowComments specifies whether the input may contain comments, which
+// RFC 8259 does not permit: line comments, from // to the end of the line,
+// and block comments, between /* and */. Comments may appear wherever space
+// characters may, but not within strings. The Decoder replaces each comment
+// with space characters as it reads the input, so offsets are unaffected,
+// comments are visible to neither Decode nor Token, and the data returned by
+// Buffered has any comments stripped. A block comment that is not terminated
+// before the end of the input is a syntax error.
+//
+// AllowComments must be called before the first call to Decode, Token or More.
+func (dec *Decoder) AllowComments(on bool) { dec.allowComments = on }
+
+// SetCommentSink arranges for sink to be called with each comment stripped
+// from the input while AllowComments is in effect, so that tools rewriting
+// the input can preserve its comments. The offset is that of the comment's
+// first '/' in the input stream. The text excludes the delimiters: the
+// leading "//" and the terminating newline of a line comment, for which block
+// is false, and the "/*" and "*/" of a block comment, for which block is true.
+//
+// Comments are reported in input order as the Decoder reads them, which may
+// be before Decode or Token has returned the values preceding them. The sink
+// has no effect on the values decoded.
+func (dec *Decoder) SetCommentSink(sink func(offset int64, text string, block bool)) {
+	dec.commentSink = sink
+}
+
+// SetJSONLines specifies whether the input must be in the JSON Lines format
+// (also known as newline-delimited JSON), with each top-level value read by
+// Decode on a line of its own. When on, Decode returns a *SyntaxError if a
+// value spans more than one line, or begins on the same line as the end of
+// the previous value. Lines consisting only of space characters are skipped.
+func (dec *Decoder) SetJSONLines(on bool) { dec.jsonLines = on }
+
 // Decode reads the next JSON-encoded value from its
 // input and stores it in the value pointed to by v.
 //
@@ -60,17 +328,40 @@ func (dec *Decoder) Decode(v interface{}) error {
 	}
 
 	// Read whole value into buffer.
+	scanBytes := dec.scan.bytes
 	n, err := dec.readValue()
 	if err != nil {
+		if dec.continueOnError {
+			return dec.skipRecord(err, scanBytes)
+		}
 		return err
 	}
+	if dec.jsonLines && dec.tokenState == tokenTopValue {
+		if err := dec.checkLine(dec.buf[dec.scanp : dec.scanp+n]); err != nil {
+			dec.err = err
+			if dec.continueOnError {
+				return dec.skipRecord(err, scanBytes)
+			}
+			return err
+		}
+	}
 	dec.d.init(dec.buf[dec.scanp : dec.scanp+n])
+	dec.d.baseOffset = dec.offset()
 	dec.scanp += n
+	if err := dec.flushTee(); err != nil {
+		return err
+	}
 
 	// Don't save err from unmarshal into dec.err:
 	// the connection is still usable since we read a complete JSON
 	// object from it before the error happened.
 	err = dec.d.unmarshal(v)
+	if err != nil && dec.continueOnError {
+		dec.errs = append(dec.errs, err)
+	}
+	if err == nil && dec.pathHandlers != nil {
+		err = dec.callPathHandlers(dec.d.data)
+	}
 
 	// fixup token streaming state
 	dec.tokenValueEnd()
@@ -78,6 +369,327 @@ func (dec *Decoder) Decode(v interface{}) error {
 	return err
 }
 
+// SetContinueOnError specifies whether Decode, having found an error in one
+// top-level value of the input, continues with the next rather than failing
+// for good, as suits bulk imports where a bad record should not abort the
+// whole batch. The error is still returned by that call to Decode, so that
+// the caller knows to discard the value, and it is also recorded, along with
+// those of any other bad records, for Errors. An error from the underlying
+// reader, including one exceeding SetMaxBytes, still ends the stream.
+//
+// A value that is well-formed but cannot be decoded into the Decode argument
+// leaves the stream usable anyway. After a syntax error, Decode cannot find
+// the end of the bad record by matching braces, since the record may contain
+// stray or unbalanced ones, so it resynchronizes by lines. In JSON Lines
+// mode, the next call to Decode begins at the start of the line following the
+// one on which the bad record began. Otherwise, it begins at the next line
+// that starts, in its first column, with '{' or '[', which is where the next
+// record begins in a stream of objects or arrays each written starting on a
+// new line, whether compact or indented. Any values between are skipped with
+// the bad record. If the input ends within a bad record, the next call returns
+// io.EOF. Recovery applies only to values read by Decode at the top level, not
+// within an array or object being read by Token.
+func (dec *Decoder) SetContinueOnError(on bool) { dec.continueOnError = on }
+
+// Errors returns the errors returned by Decode for the records that were
+// skipped or could not be decoded while SetContinueOnError was in effect, in
+// order. The caller may modify the returned slice without affecting dec.
+func (dec *Decoder) Errors() []error {
+	return append([]error(nil), dec.errs...)
+}
+
+// skipRecord records err, the error reading the value at dec.scanp, and,
+// for a syntax error at the top level, skips the rest of the bad record so
+// that Decode can continue with the next. scanBytes is the scanner's byte
+// count at the start of the value. It returns err.
+func (dec *Decoder) skipRecord(err error, scanBytes int64) error {
+	if err == io.ErrUnexpectedEOF {
+		dec.errs = append(dec.errs, err)
+		dec.err = io.EOF
+		return err
+	}
+	if _, ok := err.(*SyntaxError); !ok || dec.tokenState != tokenTopValue {
+		return err
+	}
+	dec.errs = append(dec.errs, err)
+	off := dec.offset()
+	dec.err = dec.resync()
+	dec.scan.bytes = scanBytes + dec.offset() - off
+	dec.lineOpen = false
+	return err
+}
+
+// resync advances dec.scanp past the bad record beginning there, to the
+// point at which Decode should resume, as documented for SetContinueOnError.
+// It returns an error if the input ends or cannot be read first.
+func (dec *Decoder) resync() error {
+	const (
+		leading   = iota // in the space before the record
+		inRecord         // in the record
+		lineStart        // at the start of a later line
+	)
+	state := leading
+	i := dec.scanp
+	var err error
+	for {
+		for ; i < len(dec.buf); i++ {
+			c := dec.buf[i]
+			switch {
+			case state == leading:
+				if !isSpace(c) {
+					state = inRecord
+				}
+			case state == lineStart && (c == '{' || c == '['):
+				dec.scanp = i
+				return nil
+			case c == '\n':
+				if dec.jsonLines {
+					dec.scanp = i + 1
+					return nil
+				}
+				state = lineStart
+			default:
+				state = inRecord
+			}
+		}
+		// Nothing scanned so far is needed again.
+		dec.scanp = i
+		if err != nil {
+			return err
+		}
+		err = dec.refill()
+		i = dec.scanp
+	}
+}
+
+// A pathHandler is a callback registered by Decoder.OnPath.
+type pathHandler struct {
+	pattern []string // path elements, each a key, an array index or "*"
+	fn      func(RawMessage) error
+}
+
+// OnPath registers fn to be called by Decode with each value, within the
+// value being decoded, whose path matches pattern. A path is a sequence of
+// object keys and array indexes, in decimal, separated by dots, leading from
+// the value passed to Decode to a value within it, as in "items.0.price"; the
+// empty path is the value itself. In pattern, "*" matches any one key or
+// index, as in "items.*.price". A key containing a dot cannot be matched
+// except by "*".
+//
+// Decode first decodes the whole value into its argument, as usual, and only
+// then, if that succeeded, calls the handlers, for the matching values in the
+// order in which they end in the input: a value nested in another is passed
+// to its handlers before the enclosing value. If several handlers match the
+// same value, they are called in the order in which they were registered. If
+// a handler returns an error, Decode stops and returns it; the stream remains
+// usable. The RawMessage aliases the Decoder's buffer, and is valid only until
+// the handler returns.
+func (dec *Decoder) OnPath(pattern string, fn func(RawMessage) error) {
+	var elems []string
+	if pattern != "" {
+		elems = strings.Split(pattern, ".")
+	}
+	dec.pathHandlers = append(dec.pathHandlers, pathHandler{elems, fn})
+}
+
+// callPathHandlers calls the handlers registered by OnPath for the values
+// within data, a complete and valid JSON value.
+func (dec *Decoder) callPathHandlers(data []byte) error {
+	var d decodeState
+	d.scan.allowTrailingCommas = dec.scan.allowTrailingCommas
+	d.scan.allowExtendedNumbers = dec.scan.allowExtendedNumbers
+	d.scan.allowLeadingZeros = dec.scan.allowLeadingZeros
+	d.scan.maxDepth = dec.scan.maxDepth
+	d.init(data)
+	d.scan.reset()
+	d.scanWhile(scanSkipSpace)
+	return d.walkPaths(nil, dec.pathHandlers)
+}
+
+// walkPaths consumes the value beginning at d.data[d.off-1], whose path is
+// path, and calls the handlers matching it or any value within it.
+func (d *decodeState) walkPaths(path []string, handlers []pathHandler) error {
+	descend := false
+	for _, h := range handlers {
+		if len(h.pattern) > len(path) && matchPath(h.pattern[:len(path)], path) {
+			descend = true
+			break
+		}
+	}
+	start := d.readIndex()
+	var end int
+	switch {
+	case !descend || d.opcode == scanBeginLiteral:
+		if err := d.value(reflect.Value{}); err != nil {
+			return err
+		}
+		end = d.readIndex()
+
+	case d.opcode == scanBeginObject:
+		for {
+			d.scanWhile(scanSkipSpace)
+			if d.opcode == scanEndObject {
+				break
+			}
+			if d.opcode != scanBeginLiteral {
+				panic(phasePanicMsg)
+			}
+			keyStart := d.readIndex()
+			d.rescanLiteral()
+			key, ok := unquoteBytes(d.data[keyStart:d.readIndex()])
+			if !ok {
+				panic(phasePanicMsg)
+			}
+			if d.opcode == scanSkipSpace {
+				d.scanWhile(scanSkipSpace)
+			}
+			if d.opcode != scanObjectKey {
+				panic(phasePanicMsg)
+			}
+			d.scanWhile(scanSkipSpace)
+			if err := d.walkPaths(append(path, string(key)), handlers); err != nil {
+				return err
+			}
+			if d.opcode == scanSkipSpace {
+				d.scanWhile(scanSkipSpace)
+			}
+			if d.opcode == scanEndObject {
+				break
+			}
+			if d.opcode != scanObjectValue {
+				panic(phasePanicMsg)
+			}
+		}
+		end = d.off
+		d.scanNext()
+
+	case d.opcode == scanBeginArray:
+		for i := 0; ; i++ {
+			d.scanWhile(scanSkipSpace)
+			if d.opcode == scanEndArray {
+				break
+			}
+			if err := d.walkPaths(append(path, strconv.Itoa(i)), handlers); err != nil {
+				return err
+			}
+			if d.opcode == scanSkipSpace {
+				d.scanWhile(scanSkipSpace)
+			}
+			if d.opcode == scanEndArray {
+				break
+			}
+			if d.opcode != scanArrayValue {
+				panic(phasePanicMsg)
+			}
+		}
+		end = d.off
+		d.scanNext()
+
+	default:
+		panic(phasePanicMsg)
+	}
+
+	for _, h := range handlers {
+		if len(h.pattern) == len(path) && matchPath(h.pattern, path) {
+			if err := h.fn(d.data[start:end]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// matchPath reports whether path matches pattern, which has the same length.
+func matchPath(pattern, path []string) bool {
+	for i, p := range pattern {
+		if p != "*" && p != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// checkLine checks that value, a top-level value possibly preceded by
+// space characters, is the only value on its line.
+func (dec *Decoder) checkLine(value []byte) error {
+	i := 0
+	for ; i < len(value) && isSpace(value[i]); i++ {
+		if value[i] == '\n' {
+			dec.lineOpen = false
+		}
+	}
+	if dec.lineOpen {
+		return &SyntaxError{msg: "JSON Lines value does not begin on a new line", Offset: dec.offset() + int64(i)}
+	}
+	if j := bytes.IndexByte(value[i:], '\n'); j >= 0 {
+		return &SyntaxError{msg: "newline within JSON Lines value", Offset: dec.offset() + int64(i+j)}
+	}
+	dec.lineOpen = true
+	return nil
+}
+
+// Reset discards any buffered data and partially consumed tokens, and
+// resets the Decoder to read from r, as if newly returned by NewDecoder
+// except that settings such as UseNumber and DisallowUnknownFields are
+// preserved. InputOffset starts again from zero. Reset allows a Decoder,
+// and its internal buffer, to be reused for many streams.
+func (dec *Decoder) Reset(r io.Reader) {
+	dec.r = r
+	dec.buf = dec.buf[:0]
+	dec.scanp = 0
+	dec.scanned = 0
+	dec.scan.reset()
+	dec.scan.bytes = 0
+	dec.err = nil
+	dec.d.data = nil
+	dec.d.baseOffset = 0
+
+	dec.tokenState = tokenTopValue
+	dec.tokenStack = dec.tokenStack[:0]
+	dec.tokenKeys = dec.tokenKeys[:0]
+	dec.tokenOffset = 0
+	dec.lineOpen = false
+	dec.commentState = commentNone
+	dec.teep = 0
+	dec.teeErr = nil
+	dec.read = 0
+	dec.bomDone = false
+	dec.bomHeld = 0
+	dec.errs = nil
+}
+
+// SetTee causes the Decoder to copy the input it consumes to w, including
+// the space between values, so that w receives a verbatim record of what
+// each call to Decode or Token has read. Input that is buffered but not yet
+// consumed is copied only when a later call consumes it, and each byte is
+// copied exactly once. If AllowComments is in effect, comments are copied as
+// the spaces that replace them. If w returns an error, it is returned by the
+// call being made, and by all subsequent calls. Calling SetTee(nil) stops the
+// copying.
+func (dec *Decoder) SetTee(w io.Writer) {
+	dec.flushTee()
+	dec.tee = w
+	dec.teep = dec.scanp
+	dec.teeErr = nil
+}
+
+// flushTee writes the input consumed since the last call to dec.tee.
+func (dec *Decoder) flushTee() error {
+	if dec.teeErr != nil {
+		return dec.teeErr
+	}
+	if dec.tee == nil || dec.teep >= dec.scanp {
+		return nil
+	}
+	_, err := dec.tee.Write(dec.buf[dec.teep:dec.scanp])
+	dec.teep = dec.scanp
+	if err != nil {
+		dec.teeErr = err
+		dec.err = err
+	}
+	return err
+}
+
 // Buffered returns a reader of the data remaining in the Decoder's
 // buffer. The reader is valid until the next call to Decode.
 func (dec *Decoder) Buffered() io.Reader {
@@ -144,6 +756,10 @@ Input:
 }
 
 func (dec *Decoder) refill() error {
+	if err := dec.flushTee(); err != nil {
+		return err
+	}
+
 	// Make room to read more into the buffer.
 	// First slide down data already consumed.
 	if dec.scanp > 0 {
@@ -151,6 +767,7 @@ func (dec *Decoder) refill() error {
 		n := copy(dec.buf, dec.buf[dec.scanp:])
 		dec.buf = dec.buf[:n]
 		dec.scanp = 0
+		dec.teep = 0
 	}
 
 	// Grow buffer if not large enough.
@@ -161,13 +778,195 @@ func (dec *Decoder) refill() error {
 		dec.buf = newBuf
 	}
 
+	// Restore a '/' held back by stripComments.
+	if dec.commentState == commentSlash {
+		dec.buf = append(dec.buf, '/')
+	}
+
+	// Restore the start of a BOM held back by skipBOM.
+	dec.buf = append(dec.buf, utf8BOM[:dec.bomHeld]...)
+	dec.bomHeld = 0
+
 	// Read. Delay error for next iteration (after scan).
-	n, err := dec.r.Read(dec.buf[len(dec.buf):cap(dec.buf)])
+	p := dec.buf[len(dec.buf):cap(dec.buf)]
+	if dec.maxBytes > 0 {
+		// Read at most one byte beyond the limit, to see it exceeded.
+		if room := dec.maxBytes - dec.read + 1; room < int64(len(p)) {
+			if room < 1 {
+				room = 1
+			}
+			p = p[:room]
+		}
+	}
+	n, err := dec.r.Read(p)
+	dec.read += int64(n)
+	if dec.maxBytes > 0 && dec.read > dec.maxBytes {
+		// Discard the bytes beyond the limit.
+		if over := dec.read - dec.maxBytes; over < int64(n) {
+			n -= int(over)
+		} else {
+			n = 0
+		}
+		err = &MaxBytesError{Limit: dec.maxBytes}
+	}
 	dec.buf = dec.buf[0 : len(dec.buf)+n]
 
+	if dec.allowComments {
+		err = dec.stripComments(len(dec.buf)-n, err)
+	}
+	if !dec.bomDone {
+		dec.skipBOM(err)
+	}
 	return err
 }
 
+// utf8BOM is the UTF-8 encoding of the byte order mark, U+FEFF.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// skipBOM replaces a UTF-8 byte order mark at the start of the input with
+// spaces, so that it is ignored like any other leading space. Until the
+// check is done nothing has been consumed, so buf holds the start of the
+// input. err is the error returned by the last read.
+//
+// If buf holds only the start of a BOM, it is held back from buf until more
+// data has been read.
+func (dec *Decoder) skipBOM(err error) {
+	b := dec.buf
+	if len(b) < len(utf8BOM) && err == nil && bytes.HasPrefix(utf8BOM, b) {
+		dec.bomHeld = len(b)
+		dec.buf = b[:0]
+		return
+	}
+	if bytes.HasPrefix(b, utf8BOM) {
+		copy(b, "   ")
+	}
+	dec.bomDone = true
+}
+
+// Comment stripping states.
+const (
+	commentNone      = iota // outside strings and comments
+	commentString           // in a string
+	commentStringEsc        // in a string, after a backslash
+	commentSlash            // after a '/', which may begin a comment
+	commentLine             // in a line comment
+	commentBlock            // in a block comment
+	commentBlockStar        // in a block comment, after a '*'
+)
+
+// stripComments replaces the comments in dec.buf[start:], which has just
+// been read, with spaces. err is the error returned by the read; stripComments
+// returns it, or an error for an unterminated block comment at EOF.
+//
+// If the data read ends with a '/' that may begin a comment, it is held back
+// from dec.buf until more data has been read.
+func (dec *Decoder) stripComments(start int, err error) error {
+	buf := dec.buf
+	if dec.commentState == commentSlash {
+		// Reconsider the restored '/'.
+		start--
+		dec.commentState = commentNone
+	}
+	for i := start; i < len(buf); i++ {
+		c := buf[i]
+		switch dec.commentState {
+		case commentNone:
+			switch c {
+			case '"':
+				dec.commentState = commentString
+			case '/':
+				dec.commentState = commentSlash
+			}
+		case commentString:
+			switch c {
+			case '\\':
+				dec.commentState = commentStringEsc
+			case '"':
+				dec.commentState = commentNone
+			}
+		case commentStringEsc:
+			dec.commentState = commentString
+		case commentSlash:
+			switch c {
+			case '/':
+				dec.commentState = commentLine
+				dec.commentStart = dec.scanned + int64(i-1)
+			case '*':
+				dec.commentState = commentBlock
+				dec.commentStart = dec.scanned + int64(i-1)
+			default:
+				dec.commentState = commentNone
+				i-- // c may begin a string or another comment
+				continue
+			}
+			buf[i-1], buf[i] = ' ', ' '
+			dec.commentText = dec.commentText[:0]
+		case commentLine:
+			if c == '\n' {
+				dec.commentState = commentNone
+				dec.emitComment(false)
+			} else {
+				dec.saveComment(c)
+				buf[i] = ' '
+			}
+		case commentBlock, commentBlockStar:
+			switch {
+			case c == '/' && dec.commentState == commentBlockStar:
+				dec.commentState = commentNone
+				if dec.commentSink != nil {
+					// Drop the '*' of the terminating "*/".
+					dec.commentText = dec.commentText[:len(dec.commentText)-1]
+				}
+				dec.emitComment(true)
+			case c == '*':
+				dec.commentState = commentBlockStar
+				dec.saveComment(c)
+			default:
+				dec.commentState = commentBlock
+				dec.saveComment(c)
+			}
+			if c != '\n' {
+				buf[i] = ' '
+			}
+		}
+	}
+	if err == nil {
+		if dec.commentState == commentSlash {
+			dec.buf = buf[:len(buf)-1]
+		}
+		return nil
+	}
+	switch dec.commentState {
+	case commentSlash:
+		// Leave the '/' for the scanner to reject.
+		dec.commentState = commentNone
+	case commentLine:
+		// A line comment may end the input.
+		dec.commentState = commentNone
+		dec.emitComment(false)
+	case commentBlock, commentBlockStar:
+		if err == io.EOF {
+			return &SyntaxError{msg: "unterminated block comment", Offset: dec.commentStart}
+		}
+	}
+	return err
+}
+
+// saveComment records c as part of the text of the comment being stripped,
+// if it is to be passed to the comment sink.
+func (dec *Decoder) saveComment(c byte) {
+	if dec.commentSink != nil {
+		dec.commentText = append(dec.commentText, c)
+	}
+}
+
+// emitComment passes the comment just stripped to the comment sink, if any.
+func (dec *Decoder) emitComment(block bool) {
+	if dec.commentSink != nil {
+		dec.commentSink(dec.commentStart, string(dec.commentText), block)
+	}
+}
+
 func nonSpace(b []byte) bool {
 	for _, c := range b {
 		if !isSpace(c) {
@@ -181,17 +980,100 @@ func nonSpace(b []byte) bool {
 type Encoder struct {
 	w           io.Writer
 	err         error
-	escapeHTML  bool
+	escape      EscapeMode
 	directWrite bool
+	sortMapKeys bool
+
+	invalidFloat     InvalidFloatMode
+	invalidUTF8      InvalidUTF8Mode
+	stringerMapKeys  bool
+	floatFormat      FloatFormat
+	fieldFilter      func(path string) bool
+	trustPreEncoded  bool
+	trustMarshalJSON bool
+	encoders         map[reflect.Type]func(v interface{}) ([]byte, error)
+	fieldOrders      map[reflect.Type]*fieldOrder
+	nilSliceEmpty    bool
+	unexported       bool
+	maxStringLength  int
+	sortKeys         bool
+	errorAsString    bool
+	hasher           hash.Hash
+	hashW            hashWriter // writes to w and hasher, if hasher is set
 
 	indentBuf    *bytes.Buffer
 	indentPrefix string
 	indentValue  string
+	indentFunc   func(depth int) string
+	keySep       string
+	itemSep      string
+	lenientSeps  bool
+
+	tokenState int
+	tokenStack []int
+	tokenEnc   *encodeState // holds the top-level value being written by WriteToken
+	tokenBuf   bool         // whether tokenEnc is buffered, rather than direct
+
+	jsonLines bool
+
+	bom        bool // whether to begin the output with a BOM
+	bomWritten bool
 }
 
 // NewEncoder returns a new encoder that writes to w.
 func NewEncoder(w io.Writer) *Encoder {
-	return &Encoder{w: w, escapeHTML: true}
+	return &Encoder{w: w, escape: EscapeHTML | EscapeLineSeparators, sortMapKeys: true}
+}
+
+// An EncoderPool is a set of Encoders that may be reused, to avoid allocating
+// a new Encoder and its internal buffers for each stream. An EncoderPool is
+// safe for use by multiple goroutines simultaneously; the Encoders it returns
+// are not.
+type EncoderPool struct {
+	pool sync.Pool
+}
+
+// NewEncoderPool returns a new, empty EncoderPool.
+func NewEncoderPool() *EncoderPool {
+	return &EncoderPool{}
+}
+
+// Get returns an Encoder that writes to w, configured as if newly returned
+// by NewEncoder. It may reuse an Encoder previously passed to Put.
+func (p *EncoderPool) Get(w io.Writer) *Encoder {
+	if v := p.pool.Get(); v != nil {
+		enc := v.(*Encoder)
+		enc.w = w
+		return enc
+	}
+	return NewEncoder(w)
+}
+
+// Put resets enc, discarding its writer, settings, any error and any
+// incomplete value written by WriteToken, and adds it to the pool. enc must
+// not be used after it is passed to Put.
+func (p *EncoderPool) Put(enc *Encoder) {
+	enc.reset()
+	p.pool.Put(enc)
+}
+
+// reset restores enc to the state returned by NewEncoder, without a writer,
+// keeping only its internal buffers.
+func (enc *Encoder) reset() {
+	if e := enc.tokenEnc; e != nil && enc.tokenBuf {
+		e.writer.(*bytes.Buffer).Reset()
+		encodeStatePool.Put(e)
+	}
+	indentBuf := enc.indentBuf
+	if indentBuf != nil {
+		indentBuf.Reset()
+	}
+	*enc = Encoder{
+		escape:      EscapeHTML | EscapeLineSeparators,
+		sortMapKeys: true,
+		indentBuf:   indentBuf,
+		tokenStack:  enc.tokenStack[:0],
+	}
 }
 
 // Encode writes the JSON encoding of v to the stream,
@@ -200,90 +1082,738 @@ func NewEncoder(w io.Writer) *Encoder {
 // See the documentation for Marshal for details about the
 // conversion of Go values to JSON.
 func (enc *Encoder) Encode(v interface{}) error {
+	return enc.EncodeContext(nil, v)
+}
+
+// EncodeContext is like Encode, but values implementing MarshalerContext are
+// encoded by calling their MarshalJSONContext method with ctx. If ctx is nil,
+// EncodeContext behaves exactly like Encode.
+func (enc *Encoder) EncodeContext(ctx context.Context, v interface{}) error {
 	if enc.err != nil {
 		return enc.err
 	}
+	if err := enc.checkJSONLines(); err != nil {
+		return err
+	}
+	if err := enc.checkSeparators(); err != nil {
+		return err
+	}
+
+	if enc.directWrite && !enc.formatted() {
+		if err := enc.writeBOM(); err != nil {
+			return err
+		}
+		e := newDirectEncodeState(enc.writer())
+		e.ctx = ctx
+		err := e.marshal(v, enc.opts())
+		if err != nil {
+			return err
+		}
+		if err := e.WriteByte('\n'); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	// Create an encode state backed by a growable bytes.Buffer
+	e := newEncodeState()
+	e.ctx = ctx
+	err := e.marshal(v, enc.opts())
+	e.ctx = nil
+	if err != nil {
+		return err
+	}
+	return enc.flush(e)
+}
 
-	if enc.directWrite && enc.indentPrefix == "" && enc.indentValue == "" {
-		e := new(encodeState)
+// flush writes the complete value buffered in e, which must be backed by a
+// *bytes.Buffer, to the stream, and returns e to the pool.
+func (enc *Encoder) flush(e *encodeState) error {
+	var err error
 
-		// If underlying writer supports the required methods then use it
-		if t, ok := enc.w.(writer); ok {
-			e.writer = t
+	// Terminate each value with a newline.
+	// This makes the output look a little nicer
+	// when debugging, and some kind of space
+	// is required if the encoded value was a number,
+	// so that the reader knows there aren't more
+	// digits coming.
+	e.WriteByte('\n')
+
+	b := e.writer.(*bytes.Buffer).Bytes()
+	if enc.formatted() {
+		if enc.indentBuf == nil {
+			enc.indentBuf = new(bytes.Buffer)
+		}
+		enc.indentBuf.Reset()
+		if enc.indented() {
+			err = indentWith(enc.indentBuf, b, &indentWriter{
+				prefix:     enc.indentPrefix,
+				indent:     enc.indentValue,
+				indentFunc: enc.indentFunc,
+				keySep:     enc.keySep,
+				itemSep:    enc.itemSep,
+			})
 		} else {
-			e.writer = convertWriter{enc.w}
+			// compactFormat drops the newline, so add it again.
+			err = compactFormat(enc.indentBuf, b[:len(b)-1], false, enc.keySep, enc.itemSep)
+			enc.indentBuf.WriteByte('\n')
+		}
+		if err != nil {
+			return err
+		}
+		b = enc.indentBuf.Bytes()
+	}
+	if err = enc.writeBOM(); err == nil {
+		_, err = enc.writer().Write(b)
+	}
+	if err != nil {
+		enc.err = err
+	}
+	e.writer.(*bytes.Buffer).Reset()
+	encodeStatePool.Put(e)
+	return err
+}
+
+// WriteToken writes the next JSON token to the stream, inserting commas and
+// colons as required. It accepts the same tokens returned by Decoder.Token:
+// the delimiters [ ] { } of type Delim, bools, strings, float64 or Number
+// values, and nil, as well as values of Go's other numeric types. Inside an
+// object, tokens alternate between string keys and values.
+//
+// WriteToken returns an error if t is not valid at this point in the stream,
+// such as a delimiter that does not match the innermost open array or object,
+// or a non-string token where an object key is expected.
+//
+// Like Encode, the encoding of each top-level value is followed by a newline
+// character and, unless SetDirectWrite(true) is in effect, is buffered and
+// written to the underlying writer only once the value is complete.
+func (enc *Encoder) WriteToken(t Token) error {
+	if enc.err != nil {
+		return enc.err
+	}
+	if err := enc.checkJSONLines(); err != nil {
+		return err
+	}
+	if err := enc.checkSeparators(); err != nil {
+		return err
+	}
+
+	var sep byte
+	switch enc.tokenState {
+	case tokenArrayComma, tokenObjectComma:
+		sep = ','
+	case tokenObjectColon:
+		sep = ':'
+	}
+
+	switch t := t.(type) {
+	case Delim:
+		switch t {
+		case '[', '{':
+			if !enc.tokenValueAllowed() {
+				return enc.tokenError(t)
+			}
+			if err := enc.writeToken(sep, t); err != nil {
+				return err
+			}
+			enc.tokenStack = append(enc.tokenStack, enc.tokenState)
+			if t == '[' {
+				enc.tokenState = tokenArrayStart
+			} else {
+				enc.tokenState = tokenObjectStart
+			}
+			return nil
+		case ']':
+			if enc.tokenState != tokenArrayStart && enc.tokenState != tokenArrayComma {
+				return enc.tokenError(t)
+			}
+		case '}':
+			if enc.tokenState != tokenObjectStart && enc.tokenState != tokenObjectComma {
+				return enc.tokenError(t)
+			}
+		default:
+			return fmt.Errorf("json: invalid delimiter %s", quoteChar(byte(t)))
+		}
+		if err := enc.writeToken(0, t); err != nil {
+			return err
+		}
+		enc.tokenState = enc.tokenStack[len(enc.tokenStack)-1]
+		enc.tokenStack = enc.tokenStack[:len(enc.tokenStack)-1]
+		return enc.tokenValueEnd()
+
+	case string:
+		if enc.tokenState == tokenObjectStart || enc.tokenState == tokenObjectComma {
+			if err := enc.writeToken(sep, t); err != nil {
+				return err
+			}
+			enc.tokenState = tokenObjectColon
+			return nil
+		}
+
+	case nil, bool, Number, float32, float64,
+		int, int8, int16, int32, int64,
+		uint, uint8, uint16, uint32, uint64, uintptr:
+
+	default:
+		return fmt.Errorf("json: invalid token type %T", t)
+	}
+
+	if !enc.tokenValueAllowed() {
+		return enc.tokenError(t)
+	}
+	if err := enc.writeToken(sep, t); err != nil {
+		return err
+	}
+	return enc.tokenValueEnd()
+}
+
+// writeToken writes the separator sep, if non-zero, followed by t, to the
+// encode state holding the current top-level value.
+func (enc *Encoder) writeToken(sep byte, t Token) error {
+	if enc.tokenEnc == nil {
+		enc.tokenBuf = !enc.directWrite || enc.formatted()
+		if enc.tokenBuf {
+			enc.tokenEnc = newEncodeState()
+		} else {
+			if err := enc.writeBOM(); err != nil {
+				return err
+			}
+			enc.tokenEnc = newDirectEncodeState(enc.writer())
+		}
+	}
+	e := enc.tokenEnc
+	if sep != 0 {
+		if err := e.WriteByte(sep); err != nil {
+			return err
+		}
+	}
+	if d, ok := t.(Delim); ok {
+		return e.WriteByte(byte(d))
+	}
+	return e.marshal(t, enc.opts())
+}
+
+func (enc *Encoder) tokenValueAllowed() bool {
+	switch enc.tokenState {
+	case tokenTopValue, tokenArrayStart, tokenArrayComma, tokenObjectColon:
+		return true
+	}
+	return false
+}
+
+// tokenValueEnd advances the token state past a complete value, writing out
+// the top-level value if it is now complete.
+func (enc *Encoder) tokenValueEnd() error {
+	switch enc.tokenState {
+	case tokenArrayStart:
+		enc.tokenState = tokenArrayComma
+	case tokenObjectColon:
+		enc.tokenState = tokenObjectComma
+	case tokenTopValue:
+		e := enc.tokenEnc
+		enc.tokenEnc = nil
+		if enc.tokenBuf {
+			return enc.flush(e)
 		}
+		return e.WriteByte('\n')
+	}
+	return nil
+}
+
+func (enc *Encoder) tokenError(t Token) error {
+	var context string
+	switch enc.tokenState {
+	case tokenTopValue:
+		context = " at top level"
+	case tokenArrayStart, tokenArrayComma:
+		context = " in array"
+	case tokenObjectStart, tokenObjectComma:
+		context = " looking for object key string"
+	case tokenObjectColon:
+		context = " after object key"
+	}
+	return fmt.Errorf("json: unexpected token %v%s", t, context)
+}
+
+// opts returns the encoding options configured for enc.
+func (enc *Encoder) opts() encOpts {
+	return encOpts{
+		escapeHTML:         enc.escape&EscapeHTML != 0,
+		keepLineSeparators: enc.escape&EscapeLineSeparators == 0,
+		escapeNonASCII:     enc.escape&EscapeNonASCII != 0,
+		escapeSlash:        enc.escape&EscapeSlash != 0,
+		sortMapKeys:        enc.sortMapKeys || enc.sortKeys,
+		invalidFloat:       enc.invalidFloat,
+		invalidUTF8:        enc.invalidUTF8,
+		stringerMapKeys:    enc.stringerMapKeys,
+		floatFormat:        enc.floatFormat,
+		fieldFilter:        enc.fieldFilter,
+		trustPreEncoded:    enc.trustPreEncoded,
+		trustMarshalJSON:   enc.trustMarshalJSON,
+		encoders:           enc.encoders,
+		fieldOrders:        enc.fieldOrders,
+		nilSliceEmpty:      enc.nilSliceEmpty,
+		includeUnexported:  enc.unexported,
+		maxStringLength:    enc.maxStringLength,
+		sortKeys:           enc.sortKeys,
+		errorAsString:      enc.errorAsString,
+	}
+}
+
+// SetIndent instructs the encoder to format each subsequent encoded
+// value as if indented by the package-level function Indent(dst, src, prefix, indent).
+// Calling SetIndent("", "") disables indentation.
+func (enc *Encoder) SetIndent(prefix, indent string) {
+	enc.indentPrefix = prefix
+	enc.indentValue = indent
+}
+
+// SetIndentWidth is like SetIndent, but each level of indentation consists
+// of n copies of the byte char. Any prefix previously set by SetIndent is
+// retained. If n is zero or negative, the indentation is empty, as if the
+// indent given to SetIndent were "".
+func (enc *Encoder) SetIndentWidth(n int, char byte) {
+	if n <= 0 {
+		enc.indentValue = ""
+		return
+	}
+	enc.indentValue = string(bytes.Repeat([]byte{char}, n))
+}
+
+// SetIndentFunc is like SetIndent, but the indentation of each line, after
+// any prefix set by SetIndent, is fn(depth) rather than depth copies of a
+// fixed indent, where depth is the number of arrays and objects enclosing the
+// line. This allows depth-dependent markers, as in a tree view. Calling
+// SetIndentFunc(nil) restores the fixed indent set by SetIndent.
+func (enc *Encoder) SetIndentFunc(fn func(depth int) string) {
+	enc.indentFunc = fn
+}
+
+// indented reports whether enc indents its output.
+func (enc *Encoder) indented() bool {
+	return enc.indentPrefix != "" || enc.indentValue != "" || enc.indentFunc != nil
+}
+
+// formatted reports whether enc reformats each value once it is encoded,
+// because it indents its output or uses other separators.
+func (enc *Encoder) formatted() bool {
+	return enc.indented() || enc.keySep != "" || enc.itemSep != ""
+}
+
+// SetKeyValueSeparator sets the separator written between each object key and
+// its value, in place of the colon, or in indented output, the colon and a
+// space. It must be a colon with only spaces and tabs before and after it,
+// such as " : ", unless SetLenientSeparators(true) is in effect. An empty
+// sep restores the default.
+func (enc *Encoder) SetKeyValueSeparator(sep string) {
+	enc.keySep = sep
+}
+
+// SetItemSeparator sets the separator written between the elements of arrays
+// and objects, in place of the comma, such as ", " for readable single-line
+// output. In indented output, each element still begins on a new line, after
+// the separator. It must be a comma with only spaces and tabs before and
+// after it, unless SetLenientSeparators(true) is in effect. An empty sep
+// restores the default.
+func (enc *Encoder) SetItemSeparator(sep string) {
+	enc.itemSep = sep
+}
+
+// SetLenientSeparators specifies whether SetKeyValueSeparator and
+// SetItemSeparator accept any separator, such as " = " for output in the
+// style of a configuration file. The output is then not valid JSON, and
+// cannot be read back by a Decoder. Otherwise, while a separator is set that
+// would make the output invalid, Encode and WriteToken return an error.
+func (enc *Encoder) SetLenientSeparators(on bool) {
+	enc.lenientSeps = on
+}
+
+// checkSeparators reports whether the separators set on enc are invalid.
+func (enc *Encoder) checkSeparators() error {
+	if enc.lenientSeps {
+		return nil
+	}
+	if enc.keySep != "" && !validSeparator(enc.keySep, ':') {
+		return fmt.Errorf("json: invalid key-value separator %q", enc.keySep)
+	}
+	if enc.itemSep != "" && !validSeparator(enc.itemSep, ',') {
+		return fmt.Errorf("json: invalid item separator %q", enc.itemSep)
+	}
+	return nil
+}
+
+// validSeparator reports whether sep is c with only spaces and tabs around it.
+func validSeparator(sep string, c byte) bool {
+	trimmed := strings.Trim(sep, " \t")
+	return len(trimmed) == 1 && trimmed[0] == c
+}
+
+// SetEscapeHTML specifies whether problematic HTML characters
+// should be escaped inside JSON quoted strings.
+// The default behavior is to escape &, <, and > to \u0026, \u003c, and \u003e
+// to avoid certain safety problems that can arise when embedding JSON in HTML.
+//
+// In non-HTML settings where the escaping interferes with the readability
+// of the output, SetEscapeHTML(false) disables this behavior.
+//
+// SetEscapeHTML(on) adds EscapeHTML to, or removes it from, the flags set by
+// SetEscapeMode.
+func (enc *Encoder) SetEscapeHTML(on bool) {
+	if on {
+		enc.escape |= EscapeHTML
+	} else {
+		enc.escape &^= EscapeHTML
+	}
+}
+
+// SetEscapeSlash specifies whether the solidus / is escaped as \/ inside
+// JSON quoted strings, including object keys, which is off by default.
+// Either form is valid JSON, and Decode accepts both. It composes with the
+// escaping of HTML characters by SetEscapeHTML. The base64 encoding of byte
+// slices, whose characters include /, is not escaped, and neither is the
+// output of MarshalJSON methods.
+//
+// SetEscapeSlash(on) adds EscapeSlash to, or removes it from, the flags set
+// by SetEscapeMode.
+func (enc *Encoder) SetEscapeSlash(on bool) {
+	if on {
+		enc.escape |= EscapeSlash
+	} else {
+		enc.escape &^= EscapeSlash
+	}
+}
+
+// SetEscapeMode specifies which optional escaping is applied to characters
+// in JSON strings, as a combination of EscapeHTML, EscapeLineSeparators,
+// EscapeNonASCII and EscapeSlash. The quotation mark, backslash and control characters are
+// always escaped. The default is EscapeHTML | EscapeLineSeparators, which is
+// the behavior of Marshal.
+//
+// The output of MarshalJSON methods and RawMessage values is only escaped as
+// determined by EscapeHTML; U+2028 and U+2029 are always escaped in it, and
+// other non-ASCII characters and / never are.
+func (enc *Encoder) SetEscapeMode(mode EscapeMode) {
+	enc.escape = mode
+}
+
+// SetJSONLines specifies whether the output must be in the JSON Lines format
+// (also known as newline-delimited JSON): each value written by Encode, or
+// by a sequence of calls to WriteToken, is a single compact line terminated
+// by a newline. JSON Lines output cannot be indented; while both SetJSONLines
+// and SetIndent are in effect, Encode and WriteToken return an error.
+func (enc *Encoder) SetJSONLines(on bool) {
+	enc.jsonLines = on
+}
+
+// SetBOM specifies whether the output should begin with a UTF-8 byte order
+// mark (EF BB BF), as some consumers expect. The BOM is written once per
+// Encoder, immediately before the first value, and not before each value;
+// SetBOM should therefore be called before anything is written. The BOM is
+// included in the output seen by a hasher set by SetHasher.
+func (enc *Encoder) SetBOM(on bool) {
+	enc.bom = on
+}
+
+// writeBOM writes the BOM to the stream if it is due.
+func (enc *Encoder) writeBOM() error {
+	if !enc.bom || enc.bomWritten {
+		return nil
+	}
+	if _, err := enc.writer().Write(utf8BOM); err != nil {
+		enc.err = err
+		return err
+	}
+	enc.bomWritten = true
+	return nil
+}
+
+var errJSONLinesIndent = errors.New("json: cannot indent JSON Lines output")
+
+// checkJSONLines reports whether enc's settings conflict with SetJSONLines.
+func (enc *Encoder) checkJSONLines() error {
+	if enc.jsonLines && enc.indented() {
+		return errJSONLinesIndent
+	}
+	return nil
+}
+
+// SetSortMapKeys specifies whether the keys of maps should be sorted, as
+// described in the documentation for Marshal. The default behavior is to sort
+// them, making the output deterministic.
+//
+// Calling SetSortMapKeys(false) encodes map entries in Go's map iteration
+// order, which is unspecified, avoiding the cost of collecting and sorting
+// the keys.
+func (enc *Encoder) SetSortMapKeys(on bool) {
+	enc.sortMapKeys = on
+}
+
+// SetFloatFormat specifies the notation in which floating point numbers are
+// written; see FloatFormat. The default, FloatFormatDefault, uses exponent
+// notation for numbers of very large or very small magnitude, which some
+// consumers reject.
+func (enc *Encoder) SetFloatFormat(format FloatFormat) {
+	enc.floatFormat = format
+}
+
+// SetNilSliceEmpty specifies whether nil slices and maps are encoded as empty
+// ones, as [] and {}, rather than as null, which suits clients that do not
+// distinguish the two. A nil []byte is then encoded as "", like an empty one.
+// The default, false, encodes them all as null, as Marshal does. Nil pointers
+// and interfaces are still encoded as null.
+//
+// Note that the encoding is then not symmetric: decoding [] or {} back yields
+// a non-nil empty slice or map, where the original was nil.
+func (enc *Encoder) SetNilSliceEmpty(on bool) {
+	enc.nilSliceEmpty = on
+}
+
+// SetIncludeUnexported specifies whether unexported struct fields are encoded
+// along with exported ones, for dumping internal state in diagnostics or
+// snapshot tests. The default, false, encodes only exported fields, as
+// Marshal does. An unexported field is encoded just as an exported field of
+// the same type and struct tag would be, including by calling its MarshalJSON
+// method, and an embedded field of an unexported non-struct type, otherwise
+// skipped, is encoded under its type name.
+//
+// Go does not otherwise permit reading unexported fields from another
+// package, so the encoder reads them with package unsafe, bypassing the
+// visibility rules that protect a type's invariants. It only ever reads them,
+// and a struct value that is not addressable is first copied. Even so, this
+// exposes whatever the fields hold, and the output depends on implementation
+// details that the type's author is free to change, so it is not suitable for
+// data that will be decoded again: Decode does not set unexported fields.
+func (enc *Encoder) SetIncludeUnexported(on bool) {
+	enc.unexported = on
+}
+
+// SetSortKeys specifies whether the members of every JSON object are written
+// in sorted order of their keys, for output in which the same data always
+// appears in the same order, whatever the declaration order of the struct
+// fields. With SetSortKeys(true), struct fields are sorted by key, as map
+// keys are, with fields promoted from embedded and inlined structs sorted
+// along with the rest, and maps are sorted even if SetSortMapKeys(false) has
+// been called. Fields omitted by omitempty or omitzero are left out as usual.
+// The default, false, writes struct fields in declaration order, leaving the
+// order of map keys to SetSortMapKeys.
+//
+// The sorted order of each struct type's fields is computed once, along with
+// the rest of the information cached for the type, so sorting adds nothing to
+// the cost of encoding each value.
+func (enc *Encoder) SetSortKeys(on bool) {
+	enc.sortKeys = on
+}
+
+// SetErrorAsString specifies whether values implementing the error interface
+// are encoded as the JSON string returned by their Error method, as suits
+// diagnostic payloads. It applies to struct fields, including those of
+// interface type error, and to values held in interfaces, such as the
+// elements of a []error; a nil error is encoded as null. A type implementing
+// Marshaler or MarshalerContext is still encoded by its method. By default,
+// errors are encoded like any other value, which typically means as {} or as
+// an error from Encode depending on the concrete type. It is off by default
+// because error messages can reveal internal details.
+func (enc *Encoder) SetErrorAsString(on bool) {
+	enc.errorAsString = on
+}
+
+// SetMaxStringLength sets the maximum length in bytes, before escaping, of a
+// string value that the encoder will write, so that a service can refuse to
+// emit an excessively large token. A longer string makes Encode fail with a
+// *StringLengthError giving the dotted path of the enclosing struct field,
+// without the string being written. It applies to Go values of string kind, other than
+// Number, including those encoded under the ,string option, but not to map
+// keys, struct field names or the output of marshalers. Zero, the default,
+// means no limit.
+func (enc *Encoder) SetMaxStringLength(n int) {
+	enc.maxStringLength = n
+}
 
-		err := e.marshal(v, encOpts{escapeHTML: enc.escapeHTML})
-		if err != nil {
-			return err
-		}
-		if err := e.WriteByte('\n'); err != nil {
-			return err
+// RegisterEncoder registers fn to encode values of type t, in place of
+// Marshaler and TextMarshaler implementations and the default encoding of t.
+// It allows the encoding of types from other packages, to which a MarshalJSON
+// method cannot be added, to be customized. Registrations are specific to enc,
+// so different encoders may encode the same type differently.
+//
+// fn is called with each value of exactly type t that is encoded, including
+// struct fields, the elements of slices, arrays and maps, and the values
+// pointers point to, but not map keys. It must return a valid JSON encoding,
+// which is compacted and escaped like the output of MarshalJSON. If fn returns
+// an error, Encode fails with a *MarshalerError wrapping it. Registering a nil
+// fn removes any registration for t.
+func (enc *Encoder) RegisterEncoder(t reflect.Type, fn func(v interface{}) ([]byte, error)) {
+	if fn == nil {
+		delete(enc.encoders, t)
+		if len(enc.encoders) == 0 {
+			enc.encoders = nil
 		}
+		return
+	}
+	if enc.encoders == nil {
+		enc.encoders = make(map[reflect.Type]func(interface{}) ([]byte, error))
+	}
+	enc.encoders[t] = fn
+}
 
+// SetFieldOrder sets the order in which the encoder writes the fields of
+// struct type t: first the fields with the given keys, in the order given,
+// then any others in declaration order. It is intended for matching the field
+// sequence of an existing API where neither declaration order nor the sorted
+// order of SetSortKeys will do, and it takes precedence over SetSortKeys for
+// t. Keys are the JSON keys of the fields, after any renaming by tags.
+//
+// SetFieldOrder returns an error, and changes nothing, if t is not a struct
+// type or a key is not that of a field of t or is given twice. The order is
+// worked out once, when it is set, so it adds nothing to the cost of encoding
+// each value. Passing no keys removes any order set for t.
+func (enc *Encoder) SetFieldOrder(t reflect.Type, keys []string) error {
+	if len(keys) == 0 {
+		delete(enc.fieldOrders, t)
+		if len(enc.fieldOrders) == 0 {
+			enc.fieldOrders = nil
+		}
 		return nil
 	}
-
-	// Create an encode state backed by a growable bytes.Buffer
-	e := newEncodeState()
-	err := e.marshal(v, encOpts{escapeHTML: enc.escapeHTML})
+	if t.Kind() != reflect.Struct {
+		return fmt.Errorf("json: SetFieldOrder of non-struct type %v", t)
+	}
+	list, err := orderFields(cachedTypeFields(t).list, keys)
 	if err != nil {
-		return err
+		return fmt.Errorf("json: SetFieldOrder for %v: %v", t, err)
 	}
+	// Unexported fields, included by SetIncludeUnexported, come after the
+	// listed ones among the others.
+	unexported, _ := orderFields(cachedUnexportedTypeFields(t).list, keys)
+	if enc.fieldOrders == nil {
+		enc.fieldOrders = make(map[reflect.Type]*fieldOrder)
+	}
+	enc.fieldOrders[t] = &fieldOrder{list: list, unexported: unexported}
+	return nil
+}
 
-	// Terminate each value with a newline.
-	// This makes the output look a little nicer
-	// when debugging, and some kind of space
-	// is required if the encoded value was a number,
-	// so that the reader knows there aren't more
-	// digits coming.
-	e.WriteByte('\n')
+// SetTrustMarshalJSON specifies whether the output of MarshalJSON and
+// MarshalJSONContext methods is trusted, and so copied to the output verbatim.
+// By default, that output is compacted, escaped as set by SetEscapeHTML and
+// SetEscapeMode, and validated, which for types marshaling large values can be
+// a significant part of the cost of encoding them.
+//
+// Trusting means that malformed output from a MarshalJSON method is not
+// caught, and produces invalid JSON; nor is any space it contains removed, or
+// any character in it escaped. It should only be enabled for types whose
+// methods are known to produce compact, valid JSON.
+func (enc *Encoder) SetTrustMarshalJSON(on bool) {
+	enc.trustMarshalJSON = on
+}
 
-	b := e.writer.(*bytes.Buffer).Bytes()
-	if enc.indentPrefix != "" || enc.indentValue != "" {
-		if enc.indentBuf == nil {
-			enc.indentBuf = new(bytes.Buffer)
-		}
-		enc.indentBuf.Reset()
-		err = Indent(enc.indentBuf, b, enc.indentPrefix, enc.indentValue)
-		if err != nil {
-			return err
-		}
-		b = enc.indentBuf.Bytes()
-	}
-	if _, err = enc.w.Write(b); err != nil {
-		enc.err = err
-	}
-	e.writer.(*bytes.Buffer).Reset()
-	encodeStatePool.Put(e)
-	return err
+// SetTrustPreEncoded specifies whether PreEncoded values are trusted to be
+// valid JSON, and so written without being checked. The default is to check
+// them. Trusting an invalid PreEncoded value produces invalid output.
+func (enc *Encoder) SetTrustPreEncoded(on bool) {
+	enc.trustPreEncoded = on
 }
 
-// SetIndent instructs the encoder to format each subsequent encoded
-// value as if indented by the package-level function Indent(dst, src, prefix, indent).
-// Calling SetIndent("", "") disables indentation.
-func (enc *Encoder) SetIndent(prefix, indent string) {
-	enc.indentPrefix = prefix
-	enc.indentValue = indent
+// SetFieldFilter specifies a function that is called with the path of each
+// struct field about to be encoded, and which returns false to omit the field.
+// A path is the dotted sequence of JSON object keys naming the field from the
+// top-level value, such as "user.address.city"; the elements of slices,
+// arrays and maps add nothing to it, so every element of a field "users"
+// holding a slice of structs has fields named "users.name" and so on. A
+// field is only encoded, and its own fields consulted, if filter returns
+// true. Values implementing Marshaler encode themselves, and are not
+// filtered.
+//
+// The filter is consulted after omitempty and omitzero are applied. Passing
+// a nil filter, the default, encodes all fields.
+func (enc *Encoder) SetFieldFilter(filter func(path string) bool) {
+	enc.fieldFilter = filter
 }
 
-// SetEscapeHTML specifies whether problematic HTML characters
-// should be escaped inside JSON quoted strings.
-// The default behavior is to escape &, <, and > to \u0026, \u003c, and \u003e
-// to avoid certain safety problems that can arise when embedding JSON in HTML.
+// SetStringerMapKeys specifies whether map keys of integer types implementing
+// fmt.Stringer are encoded using their String method, rather than as decimal
+// numbers. This suits enumerations, such as a uint8 type whose String method
+// returns the name of each value. As for other keys, a key implementing
+// encoding.TextMarshaler is encoded by its MarshalText method, which takes
+// precedence over String, and sorting applies to the resulting strings.
 //
-// In non-HTML settings where the escaping interferes with the readability
-// of the output, SetEscapeHTML(false) disables this behavior.
-func (enc *Encoder) SetEscapeHTML(on bool) {
-	enc.escapeHTML = on
+// Decoding such keys back into the integer type requires that it implement
+// encoding.TextUnmarshaler to parse the strings written by String.
+func (enc *Encoder) SetStringerMapKeys(on bool) {
+	enc.stringerMapKeys = on
+}
+
+// SetInvalidFloat specifies how the floating point values NaN, +Inf and -Inf,
+// which cannot be represented as JSON numbers, are encoded. The default,
+// InvalidFloatError, causes Encode to fail with an UnsupportedValueError.
+//
+// Note that neither InvalidFloatNull nor InvalidFloatString round-trips: a
+// null decodes into a float as a no-op, and the strings written by
+// InvalidFloatString can only be decoded back into a float by a type
+// implementing Unmarshaler.
+func (enc *Encoder) SetInvalidFloat(mode InvalidFloatMode) {
+	enc.invalidFloat = mode
+}
+
+// SetInvalidUTF8 specifies how bytes of a string that are not valid UTF-8
+// are encoded. The default, InvalidUTF8Replace, replaces each with U+FFFD, as
+// Marshal does; InvalidUTF8Reject, to detect corrupt data rather than alter
+// it, causes Encode to fail with an *InvalidUTF8Error. InvalidUTF8Escape
+// writes each as \u00XX, which decodes to a valid code point rather than the
+// original byte, so it hides the corruption from a later decoder.
+func (enc *Encoder) SetInvalidUTF8(mode InvalidUTF8Mode) {
+	enc.invalidUTF8 = mode
 }
 
 // RawMessage is a raw encoded JSON value.
 // It implements Marshaler and Unmarshaler and can
 // be used to delay JSON decoding or precompute a JSON encoding.
+//
+// As with the output of any Marshaler, Marshal validates a RawMessage and
+// elides its insignificant space characters, so fragments taken from indented
+// input are embedded compactly. A RawMessage which is already compact is
+// copied to the output with a single write.
 type RawMessage []byte
 
+// SetHasher sets h to receive a copy of every byte the encoder writes to the
+// underlying writer, in the same order, including the newline following each
+// value. A byte is written to h only once the underlying writer has accepted
+// it, so that h always holds exactly the output emitted so far. Calling
+// SetHasher(nil) stops hashing.
+func (enc *Encoder) SetHasher(h hash.Hash) {
+	enc.hasher = h
+}
+
+// Sum appends the current hash of the output written since SetHasher was
+// called to b and returns the resulting slice, or returns nil if no hasher is
+// set. It does not reset the hasher.
+func (enc *Encoder) Sum(b []byte) []byte {
+	if enc.hasher == nil {
+		return nil
+	}
+	return enc.hasher.Sum(b)
+}
+
+// writer returns the writer to which enc writes its output.
+func (enc *Encoder) writer() io.Writer {
+	if enc.hasher == nil {
+		return enc.w
+	}
+	enc.hashW = hashWriter{enc.w, enc.hasher}
+	return &enc.hashW
+}
+
+// A hashWriter writes to w, and then writes the bytes accepted by w to h.
+type hashWriter struct {
+	w io.Writer
+	h hash.Hash
+}
+
+func (hw *hashWriter) Write(p []byte) (int, error) {
+	n, err := hw.w.Write(p)
+	hw.h.Write(p[:n])
+	return n, err
+}
+
 // SetDirectWrite specifies whether the encoder can periodically flush output
 // to the underlying writer as available. Otherwise, the encoder buffers the
 // entire output and only performs a single write to the underlying writer if
@@ -315,6 +1845,31 @@ func (m *RawMessage) UnmarshalJSON(data []byte) error {
 var _ Marshaler = (*RawMessage)(nil)
 var _ Unmarshaler = (*RawMessage)(nil)
 
+// PreEncoded is a JSON value encoded in advance, such as a fragment taken from
+// a cache or another service, for embedding in the output of Marshal.
+//
+// Unlike a RawMessage, which Marshal compacts, a PreEncoded value is written
+// to the output verbatim, including any space and unescaped HTML characters it
+// contains. It is first checked to be a single valid JSON value, and if it is
+// not, Marshal fails with a *MarshalerError wrapping the *SyntaxError found.
+// An Encoder can skip that check for fragments from a trusted source; see
+// Encoder.SetTrustPreEncoded. A nil PreEncoded is encoded as null.
+type PreEncoded []byte
+
+// MarshalJSON returns m, or null if m is nil, after checking that m is valid
+// JSON.
+func (m PreEncoded) MarshalJSON() ([]byte, error) {
+	if m == nil {
+		return []byte("null"), nil
+	}
+	if err := checkValid(m, &scanner{}); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+var _ Marshaler = PreEncoded(nil)
+
 // A Token holds a value of one of these types:
 //
 //	Delim, for the four JSON delimiters [ ] { }
@@ -404,25 +1959,59 @@ func (d Delim) String() string {
 // to mark the start and end of arrays and objects.
 // Commas and colons are elided.
 func (dec *Decoder) Token() (Token, error) {
+	tok, err := dec.token(true)
+	if teeErr := dec.flushTee(); teeErr != nil {
+		return nil, teeErr
+	}
+	return tok, err
+}
+
+// Peek returns the next JSON token in the input stream, as Token would,
+// without consuming it: the following call to Token returns the same token,
+// and if it begins a value, the following call to Decode decodes that value.
+// At the end of the input stream, Peek returns nil, io.EOF.
+//
+// Peek may consume the commas and colons preceding the token, which Token
+// and Decode elide anyway. A string or number token is decoded again by the
+// call to Token which consumes it.
+func (dec *Decoder) Peek() (Token, error) {
+	return dec.token(false)
+}
+
+// token implements Token, and Peek if consume is false.
+func (dec *Decoder) token(consume bool) (Token, error) {
 	for {
 		c, err := dec.peek()
 		if err != nil {
 			return nil, err
 		}
+		if consume {
+			dec.tokenOffset = dec.offset()
+		}
 		switch c {
 		case '[':
 			if !dec.tokenValueAllowed() {
 				return dec.tokenError(c)
 			}
+			if dec.tokenTooDeep() {
+				return nil, &SyntaxError{"exceeds maximum nesting depth", dec.offset() + 1}
+			}
+			if !consume {
+				return Delim(c), nil
+			}
 			dec.scanp++
 			dec.tokenStack = append(dec.tokenStack, dec.tokenState)
 			dec.tokenState = tokenArrayStart
 			return Delim('['), nil
 
 		case ']':
-			if dec.tokenState != tokenArrayStart && dec.tokenState != tokenArrayComma {
+			if dec.tokenState != tokenArrayStart && dec.tokenState != tokenArrayComma &&
+				!(dec.tokenState == tokenArrayValue && dec.scan.allowTrailingCommas) {
 				return dec.tokenError(c)
 			}
+			if !consume {
+				return Delim(c), nil
+			}
 			dec.scanp++
 			dec.tokenState = dec.tokenStack[len(dec.tokenStack)-1]
 			dec.tokenStack = dec.tokenStack[:len(dec.tokenStack)-1]
@@ -433,45 +2022,52 @@ func (dec *Decoder) Token() (Token, error) {
 			if !dec.tokenValueAllowed() {
 				return dec.tokenError(c)
 			}
+			if dec.tokenTooDeep() {
+				return nil, &SyntaxError{"exceeds maximum nesting depth", dec.offset() + 1}
+			}
+			if !consume {
+				return Delim(c), nil
+			}
 			dec.scanp++
 			dec.tokenStack = append(dec.tokenStack, dec.tokenState)
 			dec.tokenState = tokenObjectStart
+			var keys map[string]struct{}
+			if dec.d.disallowDuplicateKeys {
+				keys = make(map[string]struct{})
+			}
+			dec.tokenKeys = append(dec.tokenKeys, keys)
 			return Delim('{'), nil
 
 		case '}':
-			if dec.tokenState != tokenObjectStart && dec.tokenState != tokenObjectComma {
+			if dec.tokenState != tokenObjectStart && dec.tokenState != tokenObjectComma &&
+				!(dec.tokenState == tokenObjectKey && dec.scan.allowTrailingCommas) {
 				return dec.tokenError(c)
 			}
+			if !consume {
+				return Delim(c), nil
+			}
 			dec.scanp++
 			dec.tokenState = dec.tokenStack[len(dec.tokenStack)-1]
 			dec.tokenStack = dec.tokenStack[:len(dec.tokenStack)-1]
+			dec.tokenKeys = dec.tokenKeys[:len(dec.tokenKeys)-1]
 			dec.tokenValueEnd()
 			return Delim('}'), nil
 
-		case ':':
-			if dec.tokenState != tokenObjectColon {
-				return dec.tokenError(c)
+		case ':', ',':
+			if err := dec.tokenSeparator(c); err != nil {
+				return nil, err
 			}
-			dec.scanp++
-			dec.tokenState = tokenObjectValue
 			continue
 
-		case ',':
-			if dec.tokenState == tokenArrayComma {
-				dec.scanp++
-				dec.tokenState = tokenArrayValue
-				continue
-			}
-			if dec.tokenState == tokenObjectComma {
-				dec.scanp++
-				dec.tokenState = tokenObjectKey
-				continue
-			}
-			return dec.tokenError(c)
-
 		case '"':
 			if dec.tokenState == tokenObjectStart || dec.tokenState == tokenObjectKey {
 				var x string
+				if !consume {
+					if err := dec.peekValue(&x); err != nil {
+						return nil, err
+					}
+					return x, nil
+				}
 				old := dec.tokenState
 				dec.tokenState = tokenTopValue
 				err := dec.Decode(&x)
@@ -479,6 +2075,12 @@ func (dec *Decoder) Token() (Token, error) {
 				if err != nil {
 					return nil, err
 				}
+				if keys := dec.tokenKeys[len(dec.tokenKeys)-1]; keys != nil {
+					if _, dup := keys[x]; dup {
+						return nil, &DuplicateKeyError{Key: x, Offset: dec.tokenOffset}
+					}
+					keys[x] = struct{}{}
+				}
 				dec.tokenState = tokenObjectColon
 				return x, nil
 			}
@@ -489,6 +2091,12 @@ func (dec *Decoder) Token() (Token, error) {
 				return dec.tokenError(c)
 			}
 			var x interface{}
+			if !consume {
+				if err := dec.peekValue(&x); err != nil {
+					return nil, err
+				}
+				return x, nil
+			}
 			if err := dec.Decode(&x); err != nil {
 				return nil, err
 			}
@@ -497,6 +2105,241 @@ func (dec *Decoder) Token() (Token, error) {
 	}
 }
 
+// tokenSeparator consumes c, a colon or comma found where a token may begin,
+// or returns an error if it does not belong there.
+func (dec *Decoder) tokenSeparator(c byte) error {
+	switch {
+	case c == ':' && dec.tokenState == tokenObjectColon:
+		dec.tokenState = tokenObjectValue
+	case c == ',' && dec.tokenState == tokenArrayComma:
+		dec.tokenState = tokenArrayValue
+	case c == ',' && dec.tokenState == tokenObjectComma:
+		dec.tokenState = tokenObjectKey
+	default:
+		_, err := dec.tokenError(c)
+		return err
+	}
+	dec.scanp++
+	return nil
+}
+
+// A TokenKind is the kind of a token returned by Decoder.TokenTyped.
+type TokenKind int
+
+const (
+	// TokenKindObjectStart is the opening brace of an object.
+	TokenKindObjectStart TokenKind = iota + 1
+
+	// TokenKindKey is an object key, a string.
+	TokenKindKey
+
+	// TokenKindString is a string value.
+	TokenKindString
+
+	// TokenKindNumber is a number.
+	TokenKindNumber
+
+	// TokenKindTrue, TokenKindFalse and TokenKindNull are the literals
+	// true, false and null.
+	TokenKindTrue
+	TokenKindFalse
+	TokenKindNull
+
+	// TokenKindArrayStart is the opening bracket of an array.
+	TokenKindArrayStart
+
+	// TokenKindEnd is the closing brace or bracket of an object or array.
+	TokenKindEnd
+)
+
+// TokenTyped returns the next JSON token in the input stream, as Token does,
+// but as its kind and its text rather than as an interface{}, so that strings
+// and numbers are not copied and boxed. It is intended for tokenizers where
+// Token is too slow; callers parse the text themselves when they need to,
+// using strconv for a number, for example.
+//
+// For a key or a string, the text is its contents, without the quotes and
+// with any escape sequences decoded. For a number, it is the number as it
+// appears in the input. For the other kinds it is nil. The text may alias the
+// Decoder's buffer, and is only valid until the next call to a method of the
+// Decoder. A string containing escape sequences is decoded into new memory.
+// With an error, the kind is zero; at the end of the input stream,
+// TokenTyped returns 0, nil, io.EOF.
+//
+// TokenTyped follows the same rules as Token, and calls to it may be freely
+// mixed with calls to Token, More and Decode.
+func (dec *Decoder) TokenTyped() (TokenKind, []byte, error) {
+	kind, text, err := dec.tokenTyped()
+	if teeErr := dec.flushTee(); teeErr != nil {
+		return 0, nil, teeErr
+	}
+	return kind, text, err
+}
+
+// tokenTyped implements TokenTyped.
+func (dec *Decoder) tokenTyped() (TokenKind, []byte, error) {
+	for {
+		c, err := dec.peek()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch c {
+		case '{', '[':
+			if _, err := dec.token(true); err != nil {
+				return 0, nil, err
+			}
+			if c == '{' {
+				return TokenKindObjectStart, nil, nil
+			}
+			return TokenKindArrayStart, nil, nil
+
+		case '}', ']':
+			if _, err := dec.token(true); err != nil {
+				return 0, nil, err
+			}
+			return TokenKindEnd, nil, nil
+
+		case ':', ',':
+			if err := dec.tokenSeparator(c); err != nil {
+				return 0, nil, err
+			}
+			continue
+		}
+
+		key := c == '"' && (dec.tokenState == tokenObjectStart || dec.tokenState == tokenObjectKey)
+		if !key && !dec.tokenValueAllowed() {
+			_, err := dec.tokenError(c)
+			return 0, nil, err
+		}
+		dec.tokenOffset = dec.offset()
+		if dec.err != nil {
+			return 0, nil, dec.err
+		}
+		n, ok, err := dec.scanLiteral(key)
+		if err != nil {
+			return 0, nil, err
+		}
+		if !ok {
+			if n, err = dec.readValue(); err != nil {
+				return 0, nil, err
+			}
+		}
+		item := dec.buf[dec.scanp : dec.scanp+n]
+		if dec.jsonLines && dec.tokenState == tokenTopValue {
+			if err := dec.checkLine(item); err != nil {
+				dec.err = err
+				return 0, nil, err
+			}
+		}
+		dec.scanp += n
+
+		var kind TokenKind
+		switch c {
+		case '"':
+			var ok bool
+			if item, ok = unquoteBytes(item); !ok {
+				panic(phasePanicMsg)
+			}
+			kind = TokenKindString
+		case 't':
+			kind, item = TokenKindTrue, nil
+		case 'f':
+			kind, item = TokenKindFalse, nil
+		case 'n':
+			kind, item = TokenKindNull, nil
+		default:
+			kind = TokenKindNumber
+		}
+		if key {
+			if keys := dec.tokenKeys[len(dec.tokenKeys)-1]; keys != nil {
+				if _, dup := keys[string(item)]; dup {
+					return 0, nil, &DuplicateKeyError{Key: string(item), Offset: dec.tokenOffset}
+				}
+				keys[string(item)] = struct{}{}
+			}
+			dec.tokenState = tokenObjectColon
+			return TokenKindKey, item, nil
+		}
+		dec.tokenValueEnd()
+		return kind, item, nil
+	}
+}
+
+// scanLiteral scans the literal beginning at dec.buf[dec.scanp], an object
+// key if key is true, reading more input as needed, and returns its length.
+// Unlike readValue, which scans it as a top-level value, it scans it in the
+// context of the enclosing array or object, so that the byte ending it is
+// not treated as an error to be constructed and discarded. It reports false,
+// having consumed nothing, if the literal is malformed, is followed by a byte
+// that does not belong there, or ends the input, for readValue to deal with
+// just as Decode would.
+func (dec *Decoder) scanLiteral(key bool) (int, bool, error) {
+	s := &dec.scan
+	s.reset()
+	switch {
+	case key:
+		s.parseState = append(s.parseState, parseObjectKey)
+	case dec.tokenState == tokenObjectValue:
+		s.parseState = append(s.parseState, parseObjectValue)
+	default:
+		s.parseState = append(s.parseState, parseArrayValue)
+	}
+	start := s.bytes
+	i := dec.scanp
+	var err error
+	for {
+		for ; i < len(dec.buf); i++ {
+			switch s.step(s, dec.buf[i]) {
+			case scanBeginLiteral, scanContinue:
+				continue
+			case scanError:
+				return 0, false, nil
+			}
+			n := i - dec.scanp
+			s.bytes = start + int64(n)
+			return n, true, nil
+		}
+		if err != nil {
+			if err == io.EOF {
+				return 0, false, nil
+			}
+			dec.err = err
+			return 0, false, err
+		}
+		n := i - dec.scanp
+		err = dec.refill()
+		i = dec.scanp + n
+	}
+}
+
+// peekValue decodes the value beginning at the next unread byte of input into
+// v, without consuming it.
+func (dec *Decoder) peekValue(v interface{}) error {
+	if dec.err != nil {
+		return dec.err
+	}
+	// The bytes of the value are counted again when it is consumed.
+	bytes := dec.scan.bytes
+	n, err := dec.readValue()
+	if err != nil {
+		return err
+	}
+	dec.scan.bytes = bytes
+	dec.d.init(dec.buf[dec.scanp : dec.scanp+n])
+	dec.d.baseOffset = dec.offset()
+	return dec.d.unmarshal(v)
+}
+
+// tokenTooDeep reports whether opening another array or object with Token
+// would exceed the maximum nesting depth.
+func (dec *Decoder) tokenTooDeep() bool {
+	max := dec.scan.maxDepth
+	if max == 0 {
+		max = DefaultMaxDepth
+	}
+	return max > 0 && len(dec.tokenStack) >= max
+}
+
 func (dec *Decoder) tokenError(c byte) (Token, error) {
 	var context string
 	switch dec.tokenState {
@@ -523,6 +2366,175 @@ func (dec *Decoder) More() bool {
 	return err == nil && c != ']' && c != '}'
 }
 
+// DecodeArray reads a JSON array from dec, calling fn with each of its
+// elements in turn, and then consumes the closing bracket. It suits arrays too
+// large to decode at once. dec may be positioned anywhere a value is expected:
+// at the top level, or within an array or object whose opening delimiter,
+// and key, have already been read by Token.
+//
+// If the next value is not an array, DecodeArray returns an
+// *UnmarshalTypeError at its offset without consuming it. If fn returns an
+// error, DecodeArray stops and returns that error, leaving dec positioned
+// after the element passed to fn.
+func DecodeArray(dec *Decoder, fn func(RawMessage) error) error {
+	if err := dec.beginArray(reflect.TypeOf([]RawMessage(nil))); err != nil {
+		return err
+	}
+	for dec.More() {
+		var elem RawMessage
+		if err := dec.Decode(&elem); err != nil {
+			return err
+		}
+		if err := fn(elem); err != nil {
+			return err
+		}
+	}
+	_, err := dec.Token()
+	return err
+}
+
+// DecodeToChan reads a JSON array from dec, decoding each of its elements into
+// a new value of the element type T of ch, which must be a chan T or chan<- T,
+// and sending it on ch. A send blocks until a receiver is ready, so a slow
+// consumer holds back decoding rather than letting elements pile up. dec may
+// be positioned as for DecodeArray.
+//
+// DecodeToChan closes ch when it returns, once the closing bracket has been
+// consumed or an error has occurred: the receiver can range over ch and then
+// check the returned error. If the next value is not an array, DecodeToChan
+// returns an *UnmarshalTypeError at its offset without consuming it. If ch is
+// not a channel that can be sent on, DecodeToChan returns an error and leaves
+// ch untouched.
+func DecodeToChan(dec *Decoder, ch interface{}) error {
+	cv := reflect.ValueOf(ch)
+	if cv.Kind() != reflect.Chan || cv.Type().ChanDir()&reflect.SendDir == 0 {
+		return fmt.Errorf("json: DecodeToChan of non-sendable %s", reflect.TypeOf(ch))
+	}
+	if cv.IsNil() {
+		return fmt.Errorf("json: DecodeToChan of nil %s", cv.Type())
+	}
+	defer cv.Close()
+	et := cv.Type().Elem()
+	if err := dec.beginArray(reflect.SliceOf(et)); err != nil {
+		return err
+	}
+	for dec.More() {
+		elem := reflect.New(et)
+		if err := dec.Decode(elem.Interface()); err != nil {
+			return err
+		}
+		cv.Send(elem.Elem())
+	}
+	_, err := dec.Token()
+	return err
+}
+
+// DecodeAll reads a stream of concatenated top-level JSON values from r, such
+// as {"a":1} {"b":2}, calling fn with each in turn until the end of the input.
+// The values may be separated by any amount of space, or by none where that
+// is unambiguous, as between {} and []. Each RawMessage passed to fn is newly
+// allocated, so fn may retain it.
+//
+// DecodeAll returns nil at the end of the input, which may be empty. Otherwise
+// it returns the first error encountered: an error reading r, a *SyntaxError
+// whose Offset is that within the whole stream, or an error returned by fn,
+// which stops the iteration. It is equivalent to calling Decode repeatedly on
+// a Decoder for r, so for control over the Decoder's settings, use that
+// instead.
+func DecodeAll(r io.Reader, fn func(RawMessage) error) error {
+	dec := NewDecoder(r)
+	for {
+		var v RawMessage
+		if err := dec.Decode(&v); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+}
+
+// Transform copies the JSON values read from src to dst in compact form,
+// replacing each object key k, at any depth, by keyFn(k), as when adapting
+// between naming conventions. The input is processed a token at a time with
+// Decoder.Token and Encoder.WriteToken, so it is never decoded as a whole
+// and may be of any size. Numbers are copied verbatim, and strings other than
+// keys are unchanged in value, though escape sequences in them may be
+// rewritten, as they are in keys, with '<', '>' and '&' left unescaped. Src
+// may hold a stream of values, each of which is followed by a newline in dst.
+//
+// Keys are not checked for uniqueness once transformed. Transform returns the
+// first error from reading or writing, or a *SyntaxError for invalid input,
+// after which dst may hold a partial value.
+func Transform(dst io.Writer, src io.Reader, keyFn func(string) string) error {
+	dec := NewDecoder(src)
+	dec.SetNumberMode(NumberModeNumber)
+	enc := NewEncoder(dst)
+	enc.SetEscapeHTML(false)
+	for {
+		t, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if k, ok := t.(string); ok && (enc.tokenState == tokenObjectStart || enc.tokenState == tokenObjectComma) {
+			t = keyFn(k)
+		}
+		if err := enc.WriteToken(t); err != nil {
+			return err
+		}
+	}
+}
+
+// beginArray consumes the opening bracket of a JSON array from dec. If the
+// next value is not an array, it returns an *UnmarshalTypeError for t, the
+// type being decoded into, without consuming it.
+func (dec *Decoder) beginArray(t reflect.Type) error {
+	tok, err := dec.Peek()
+	if err != nil {
+		return err
+	}
+	if tok != Delim('[') {
+		val := "number"
+		switch tok := tok.(type) {
+		case Delim:
+			if tok != '{' {
+				// The end of the enclosing array or object.
+				return &SyntaxError{"invalid character " + quoteChar(byte(tok)) + " looking for beginning of value", dec.offset()}
+			}
+			val = "object"
+		case string:
+			val = "string"
+		case bool:
+			val = "bool"
+		case nil:
+			val = "null"
+		}
+		return &UnmarshalTypeError{Value: val, Type: t, Offset: dec.offset()}
+	}
+	_, err = dec.Token()
+	return err
+}
+
+// TokenOffset returns the input stream byte offset of the first byte of the
+// token most recently returned by Token. If Token returned an error, the
+// offset is that of the byte at which the error was detected.
+func (dec *Decoder) TokenOffset() int64 {
+	return dec.tokenOffset
+}
+
+// InputOffset returns the input stream byte offset of the current decoder position.
+// The offset gives the location of the end of the most recently returned token
+// and the beginning of the next token.
+func (dec *Decoder) InputOffset() int64 {
+	return dec.offset()
+}
+
 func (dec *Decoder) peek() (byte, error) {
 	var err error
 	for {
@@ -554,12 +2566,14 @@ type writer interface {
 
 type convertWriter struct {
 	io.Writer
+	b [1]byte // scratch space for WriteByte, to avoid an allocation per call
 }
 
-func (c convertWriter) WriteString(s string) (int, error) {
+func (c *convertWriter) WriteString(s string) (int, error) {
 	return io.WriteString(c.Writer, s)
 }
-func (c convertWriter) WriteByte(b byte) error {
-	_, err := c.Write([]byte{b})
+func (c *convertWriter) WriteByte(b byte) error {
+	c.b[0] = b
+	_, err := c.Write(c.b[:])
 	return err
 }
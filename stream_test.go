@@ -6,15 +6,25 @@ package json
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 // Test values for the stream test.
@@ -108,6 +118,171 @@ func TestEncoderIndent(t *testing.T) {
 	}
 }
 
+func TestEncoderSetIndentWidth(t *testing.T) {
+	values := []interface{}{
+		map[string]interface{}{"a": []interface{}{}, "b": map[string]interface{}{}, "c": []int{1, 2}},
+		[]interface{}{map[string]interface{}{"x": []interface{}{[]int{3}}}},
+		"scalar",
+	}
+	for _, v := range values {
+		var want, got bytes.Buffer
+		enc := NewEncoder(&want)
+		enc.SetIndent(">", "    ")
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+		enc = NewEncoder(&got)
+		enc.SetIndent(">", "")
+		enc.SetIndentWidth(4, ' ')
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != want.String() {
+			t.Errorf("SetIndentWidth(4, ' ') mismatch")
+			diff(t, got.Bytes(), want.Bytes())
+		}
+	}
+
+	tests := []struct {
+		n    int
+		char byte
+		want string
+	}{
+		{2, '\t', "[\n>\t\t1\n>]\n"},
+		{1, 0x80, "[\n>\x801\n>]\n"},
+		{0, ' ', "[\n>1\n>]\n"},
+		{-1, ' ', "[\n>1\n>]\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetIndent(">", "x")
+		enc.SetIndentWidth(tt.n, tt.char)
+		if err := enc.Encode([]int{1}); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("SetIndentWidth(%d, %q): Encode = %q, want %q", tt.n, tt.char, got, tt.want)
+		}
+	}
+}
+
+func TestEncoderSetIndentFunc(t *testing.T) {
+	v := map[string]interface{}{"a": []int{1, 2}, "b": map[string]int{"c": 3}, "d": []int{}}
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetIndent("#", "ignored")
+	enc.SetIndentFunc(func(depth int) string {
+		if depth == 0 {
+			return ""
+		}
+		return strings.Repeat("| ", depth-1) + "|-"
+	})
+	if err := enc.Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	const want = `{
+#|-"a": [
+#| |-1,
+#| |-2
+#|-],
+#|-"b": {
+#| |-"c": 3
+#|-},
+#|-"d": []
+#}
+`
+	if got := buf.String(); got != want {
+		t.Errorf("Encode with SetIndentFunc =\n%s\nwant\n%s", got, want)
+	}
+
+	// Tokens are indented likewise, and nil restores the fixed indent.
+	buf.Reset()
+	for _, tok := range []Token{Delim('['), 1, Delim(']')} {
+		if err := enc.WriteToken(tok); err != nil {
+			t.Fatal(err)
+		}
+	}
+	enc.SetIndentFunc(nil)
+	if err := enc.Encode([]int{2}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "[\n#|-1\n#]\n[\n#ignored2\n#]\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestEncoderSetSeparators(t *testing.T) {
+	v := map[string]interface{}{"a": []int{1, 2}, "b": map[string]string{"c": "x:y, z"}}
+	tests := []struct {
+		keySep, itemSep string
+		indent          bool
+		want            string
+	}{
+		{" : ", ", ", false, `{"a" : [1, 2], "b" : {"c" : "x:y, z"}}`},
+		{"", ",\t", false, `{"a":[1,` + "\t" + `2],` + "\t" + `"b":{"c":"x:y, z"}}`},
+		{"\t:", "", true, "{\n  \"a\"\t:[\n    1,\n    2\n  ],\n  \"b\"\t:{\n    \"c\"\t:\"x:y, z\"\n  }\n}"},
+		{"", " ,", true, "{\n  \"a\": [\n    1 ,\n    2\n  ] ,\n  \"b\": {\n    \"c\": \"x:y, z\"\n  }\n}"},
+	}
+	for _, tt := range tests {
+		for _, direct := range []bool{false, true} {
+			var buf bytes.Buffer
+			enc := NewEncoder(&buf)
+			enc.SetDirectWrite(direct)
+			if tt.indent {
+				enc.SetIndent("", "  ")
+			}
+			enc.SetKeyValueSeparator(tt.keySep)
+			enc.SetItemSeparator(tt.itemSep)
+			if err := enc.Encode(v); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tt.want+"\n" {
+				t.Errorf("separators %q, %q, direct=%v:\n got %q\nwant %q", tt.keySep, tt.itemSep, direct, got, tt.want+"\n")
+			}
+			var back map[string]interface{}
+			if err := Unmarshal(buf.Bytes(), &back); err != nil {
+				t.Errorf("separators %q, %q: output is not valid JSON: %v", tt.keySep, tt.itemSep, err)
+			}
+		}
+	}
+
+	// Separators also apply to values written by WriteToken.
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetItemSeparator(", ")
+	for _, tok := range []Token{Delim('['), 1, 2, Delim(']')} {
+		if err := enc.WriteToken(tok); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := buf.String(), "[1, 2]\n"; got != want {
+		t.Errorf("WriteToken output = %q, want %q", got, want)
+	}
+
+	// Other separators are only accepted in lenient mode.
+	buf.Reset()
+	enc = NewEncoder(&buf)
+	enc.SetKeyValueSeparator(" = ")
+	if err := enc.Encode(v); err == nil || buf.Len() != 0 {
+		t.Errorf("Encode with separator \" = \": err = %v, output %q, want error and no output", err, buf.String())
+	}
+	enc.SetKeyValueSeparator("")
+	enc.SetItemSeparator(";\n")
+	if err := enc.WriteToken(Delim('[')); err == nil {
+		t.Errorf("WriteToken with separator \";\\n\": no error")
+	}
+	enc.SetKeyValueSeparator(" = ")
+	enc.SetItemSeparator("; ")
+	enc.SetLenientSeparators(true)
+	if err := enc.Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), `{"a" = [1; 2]; "b" = {"c" = "x:y, z"}}`+"\n"; got != want {
+		t.Errorf("lenient output = %q, want %q", got, want)
+	}
+}
+
 type strMarshaler string
 
 func (s strMarshaler) MarshalJSON() ([]byte, error) {
@@ -203,19 +378,2663 @@ func TestEncoderSetEscapeHTML(t *testing.T) {
 		if err := enc.Encode(tt.v); err != nil {
 			t.Fatalf("SetEscapeHTML(true) SetDirectWrite(true) Encode(%s): %s", tt.name, err)
 		}
-		if got := strings.TrimSpace(buf.String()); got != tt.wantEscape {
-			t.Errorf("SetEscapeHTML(true) SetDirectWrite(true) Encode(%s) = %#q, want %#q",
-				tt.name, got, tt.want)
+		if got := strings.TrimSpace(buf.String()); got != tt.wantEscape {
+			t.Errorf("SetEscapeHTML(true) SetDirectWrite(true) Encode(%s) = %#q, want %#q",
+				tt.name, got, tt.want)
+		}
+		buf.Reset()
+		enc.SetEscapeHTML(false)
+		enc.SetDirectWrite(true)
+		if err := enc.Encode(tt.v); err != nil {
+			t.Fatalf("SetEscapeHTML(false) SetDirectWrite(true) Encode(%s): %s", tt.name, err)
+		}
+		if got := strings.TrimSpace(buf.String()); got != tt.want {
+			t.Errorf("SetEscapeHTML(false) SetDirectWrite(true) Encode(%s) = %#q, want %#q",
+				tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEncoderSetEscapeMode(t *testing.T) {
+	type T struct {
+		Text  string            `json:"é"`
+		Bytes RawMessage        `json:"raw"`
+		Map   map[string]string `json:"m"`
+	}
+	v := T{
+		Text:  "<a>\u2028 café \U0001F600",
+		Bytes: RawMessage("\"<b>\u2029ü\""),
+		Map:   map[string]string{"кл": "\U0001F600"},
+	}
+	tests := []struct {
+		mode EscapeMode
+		want string
+	}{
+		{EscapeHTML | EscapeLineSeparators,
+			"{\"é\":\"\\u003ca\\u003e\\u2028 café \U0001F600\",\"raw\":\"\\u003cb\\u003e\\u2029ü\",\"m\":{\"кл\":\"\U0001F600\"}}"},
+		{0,
+			"{\"é\":\"<a>\u2028 café \U0001F600\",\"raw\":\"<b>\\u2029ü\",\"m\":{\"кл\":\"\U0001F600\"}}"},
+		{EscapeNonASCII,
+			`{"\u00e9":"<a>\u2028 caf\u00e9 \ud83d\ude00","raw":"<b>\u2029ü","m":{"\u043a\u043b":"\ud83d\ude00"}}`},
+		{EscapeHTML | EscapeNonASCII,
+			`{"\u00e9":"\u003ca\u003e\u2028 caf\u00e9 \ud83d\ude00","raw":"\u003cb\u003e\u2029ü","m":{"\u043a\u043b":"\ud83d\ude00"}}`},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetEscapeMode(tt.mode)
+		if err := enc.Encode(v); err != nil {
+			t.Fatalf("mode %b: Encode: %v", tt.mode, err)
+		}
+		if got := strings.TrimSuffix(buf.String(), "\n"); got != tt.want {
+			t.Errorf("mode %b: Encode =\n%s\nwant\n%s", tt.mode, got, tt.want)
+		}
+		var out T
+		if err := Unmarshal(buf.Bytes(), &out); err != nil {
+			t.Fatalf("mode %b: Unmarshal: %v", tt.mode, err)
+		}
+		if out.Text != v.Text || !reflect.DeepEqual(out.Map, v.Map) {
+			t.Errorf("mode %b: round trip = %+v, want %+v", tt.mode, out, v)
+		}
+	}
+
+	// SetEscapeHTML toggles only EscapeHTML.
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetEscapeMode(EscapeNonASCII)
+	enc.SetEscapeHTML(true)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode("<\U0001F600>"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), `"<\ud83d\ude00>"`+"\n"; got != want {
+		t.Errorf("Encode = %s, want %s", got, want)
+	}
+}
+
+func TestEncoderSetEscapeSlash(t *testing.T) {
+	type T struct {
+		Path string            `json:"a/b"`
+		Raw  RawMessage        `json:"raw"`
+		Map  map[string][]byte `json:"m"`
+	}
+	v := T{Path: "a/b", Raw: RawMessage(`"</x>"`), Map: map[string][]byte{"k/": {0xff, 0xf0}}}
+	encode := func(on bool) string {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetEscapeSlash(on)
+		if err := enc.Encode(v); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		return buf.String()
+	}
+	const want = `{"a\/b":"a\/b","raw":"\u003c/x\u003e","m":{"k\/":"//A="}}` + "\n"
+	got := encode(true)
+	if got != want {
+		t.Errorf("Encode with SetEscapeSlash(true) =\n%s\nwant\n%s", got, want)
+	}
+	const wantOff = `{"a/b":"a/b","raw":"\u003c/x\u003e","m":{"k/":"//A="}}` + "\n"
+	if got := encode(false); got != wantOff {
+		t.Errorf("Encode =\n%s\nwant\n%s", got, wantOff)
+	}
+
+	var out T
+	if err := Unmarshal([]byte(got), &out); err != nil || out.Path != v.Path || !reflect.DeepEqual(out.Map, v.Map) {
+		t.Errorf("Unmarshal = %+v, %v, want %+v", out, err, v)
+	}
+
+	// The escaping composes with that of HTML characters.
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetEscapeMode(EscapeNonASCII | EscapeSlash)
+	if err := enc.Encode("</é>"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), `"<\/\u00e9>"`+"\n"; got != want {
+		t.Errorf("Encode = %s, want %s", got, want)
+	}
+	buf.Reset()
+	enc.SetEscapeHTML(true)
+	if err := enc.Encode([]string{"</script>"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), `["\u003c\/script\u003e"]`+"\n"; got != want {
+		t.Errorf("Encode = %s, want %s", got, want)
+	}
+}
+
+func TestEncoderSetSortMapKeys(t *testing.T) {
+	values := []interface{}{
+		map[string]int{"<b>": 1, "a&": 2, "c\u2028": 3, "": 4},
+		map[unmarshalerText]int{{"x", "y"}: 1, {"<", ">"}: 2},
+		map[int8]string{-1: "a", 0: "b", 1: "c"},
+		map[string]map[string]int{"nested": {"z": 1, "y": 2}},
+		map[string]int(nil),
+	}
+	for _, v := range values {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetSortMapKeys(false)
+		if err := enc.Encode(v); err != nil {
+			t.Fatalf("Encode(%#v): %v", v, err)
+		}
+		if strings.ContainsAny(buf.String(), "<>&\u2028") {
+			t.Errorf("Encode(%#v) = %q, contains unescaped characters", v, buf.String())
+		}
+		got := reflect.New(reflect.TypeOf(v))
+		if err := Unmarshal(buf.Bytes(), got.Interface()); err != nil {
+			t.Fatalf("Unmarshal(%q): %v", buf.String(), err)
+		}
+		if !reflect.DeepEqual(got.Elem().Interface(), v) {
+			t.Errorf("round trip of %#v = %#v", v, got.Elem().Interface())
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetSortMapKeys(false)
+	enc.SetSortMapKeys(true)
+	if err := enc.Encode(map[string]int{"c": 3, "b": 2, "a": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), `{"a":1,"b":2,"c":3}`+"\n"; got != want {
+		t.Errorf("SetSortMapKeys(true) Encode = %q, want %q", got, want)
+	}
+}
+
+func TestEncoderWriteToken(t *testing.T) {
+	tokens := []Token{
+		Delim('{'),
+		"a", 1,
+		"b", Delim('['), true, nil, Number("1e3"), 2.5, "<x>", Delim(']'),
+		"c", Delim('{'), Delim('}'),
+		"d", Delim('['), Delim(']'),
+		Delim('}'),
+		"top",
+		uint8(3),
+	}
+	want := `{"a":1,"b":[true,null,1e3,2.5,"\u003cx\u003e"],"c":{},"d":[]}` + "\n" +
+		`"top"` + "\n" +
+		"3\n"
+
+	for _, direct := range []bool{false, true} {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetDirectWrite(direct)
+		for i, tok := range tokens {
+			if err := enc.WriteToken(tok); err != nil {
+				t.Fatalf("direct=%v: WriteToken #%d (%v): %v", direct, i, tok, err)
+			}
+			if !direct && i < 17 && buf.Len() > 0 {
+				t.Fatalf("WriteToken #%d: output written before value was complete", i)
+			}
+		}
+		if got := buf.String(); got != want {
+			t.Errorf("direct=%v: WriteToken output = %q, want %q", direct, got, want)
+		}
+	}
+
+	t.Run("indent", func(t *testing.T) {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetIndent(">", ".")
+		for _, tok := range []Token{Delim('['), "a", "b", "c", Delim(']')} {
+			if err := enc.WriteToken(tok); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got, want := buf.String(), "[\n>.\"a\",\n>.\"b\",\n>.\"c\"\n>]\n"; got != want {
+			t.Errorf("WriteToken output = %q, want %q", got, want)
+		}
+	})
+
+	errTests := []struct {
+		tokens []Token
+		err    string
+	}{
+		{[]Token{Delim(']')}, "json: unexpected token ] at top level"},
+		{[]Token{Delim('['), Delim('}')}, "json: unexpected token } in array"},
+		{[]Token{Delim('{'), 1}, "json: unexpected token 1 looking for object key string"},
+		{[]Token{Delim('{'), "a", Delim('}')}, "json: unexpected token } after object key"},
+		{[]Token{Delim('{'), "a", 1, 2}, "json: unexpected token 2 looking for object key string"},
+		{[]Token{Delim('(')}, "json: invalid delimiter '('"},
+		{[]Token{[]int{1}}, "json: invalid token type []int"},
+	}
+	for _, tt := range errTests {
+		enc := NewEncoder(ioutil.Discard)
+		var err error
+		for _, tok := range tt.tokens {
+			if err = enc.WriteToken(tok); err != nil {
+				break
+			}
+		}
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("WriteToken(%v) error = %v, want %q", tt.tokens, err, tt.err)
+		}
+	}
+}
+
+func TestEncoderPool(t *testing.T) {
+	pool := NewEncoderPool()
+
+	var buf bytes.Buffer
+	enc := pool.Get(&buf)
+	enc.SetIndent(">", ".")
+	enc.SetEscapeHTML(false)
+	enc.SetSortMapKeys(false)
+	if err := enc.Encode([]string{"<a>"}); err != nil {
+		t.Fatal(err)
+	}
+	// Leave an incomplete value behind.
+	if err := enc.WriteToken(Delim('[')); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.WriteToken("secret"); err != nil {
+		t.Fatal(err)
+	}
+	pool.Put(enc)
+
+	// A reused Encoder must behave exactly like a new one.
+	for i := 0; i < 10; i++ {
+		var got, want bytes.Buffer
+		enc := pool.Get(&got)
+		if err := enc.Encode(map[string]string{"b": "<b>", "a": "a"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.WriteToken(1); err != nil {
+			t.Fatal(err)
+		}
+		pool.Put(enc)
+		enc = NewEncoder(&want)
+		enc.Encode(map[string]string{"b": "<b>", "a": "a"})
+		enc.WriteToken(1)
+		if got.String() != want.String() {
+			t.Fatalf("pooled Encoder wrote %q, want %q", got.String(), want.String())
+		}
+	}
+	if got, want := buf.String(), "[\n>.\"<a>\"\n>]\n"; got != want {
+		t.Errorf("first Encoder wrote %q, want %q", got, want)
+	}
+}
+
+func TestEncoderPoolConcurrent(t *testing.T) {
+	pool := NewEncoderPool()
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				var buf bytes.Buffer
+				enc := pool.Get(&buf)
+				want := fmt.Sprintf("{\"g\":%d,\"i\":%d}\n", g, i)
+				switch i % 3 {
+				case 1:
+					enc.SetDirectWrite(true)
+				case 2:
+					enc.SetIndent("", " ")
+					want = fmt.Sprintf("{\n \"g\": %d,\n \"i\": %d\n}\n", g, i)
+				}
+				if err := enc.Encode(map[string]int{"g": g, "i": i}); err != nil {
+					t.Error(err)
+					return
+				}
+				pool.Put(enc)
+				if got := buf.String(); got != want {
+					t.Errorf("goroutine %d: Encode = %q, want %q", g, got, want)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestEncoderSetFloatFormat(t *testing.T) {
+	in := []interface{}{1.5, 1e20, 1e21, 1.5e21, 1e-7, 2.5e-7, float32(1e21), -1e22, 123456789.125, 0.0}
+	tests := []struct {
+		format FloatFormat
+		want   string
+	}{
+		{FloatFormatDefault, `[1.5,100000000000000000000,1e+21,1.5e+21,1e-7,2.5e-7,1e+21,-1e+22,123456789.125,0]`},
+		{FloatFormatFixed, `[1.5,100000000000000000000,1000000000000000000000,1500000000000000000000,0.0000001,0.00000025,1000000000000000000000,-10000000000000000000000,123456789.125,0]`},
+		{FloatFormatIntegral, `[1.5,100000000000000000000,1000000000000000000000,1500000000000000000000,1e-7,2.5e-7,1000000000000000000000,-10000000000000000000000,123456789.125,0]`},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetFloatFormat(tt.format)
+		if err := enc.Encode(in); err != nil {
+			t.Fatalf("format %d: Encode: %v", tt.format, err)
+		}
+		got := strings.TrimSpace(buf.String())
+		if got != tt.want {
+			t.Errorf("format %d: Encode =\n%s\nwant\n%s", tt.format, got, tt.want)
+		}
+		var out []float64
+		if err := Unmarshal([]byte(got), &out); err != nil {
+			t.Fatalf("format %d: Unmarshal: %v", tt.format, err)
+		}
+		for i, v := range in {
+			f, ok := v.(float64)
+			if !ok {
+				f = float64(v.(float32))
+				out[i] = float64(float32(out[i]))
+			}
+			if out[i] != f {
+				t.Errorf("format %d: element %d round trips to %v, want %v", tt.format, i, out[i], f)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetFloatFormat(FloatFormatFixed)
+	if err := enc.Encode(math.MaxFloat64); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if got := strings.TrimSpace(buf.String()); len(got) != 309 || !Valid([]byte(got)) {
+		t.Errorf("Encode(math.MaxFloat64) = %s, want 309 digits", got)
+	}
+}
+
+type stringerColor uint8
+
+var stringerColorNames = []string{"red", "green", "blue"}
+
+func (c stringerColor) String() string { return stringerColorNames[c] }
+
+func (c *stringerColor) UnmarshalText(b []byte) error {
+	for i, name := range stringerColorNames {
+		if name == string(b) {
+			*c = stringerColor(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown color %q", b)
+}
+
+// textStringerKey implements both fmt.Stringer and encoding.TextMarshaler.
+type textStringerKey int
+
+func (k textStringerKey) String() string { return "string" + strconv.Itoa(int(k)) }
+func (k textStringerKey) MarshalText() ([]byte, error) {
+	return []byte("text" + strconv.Itoa(int(k))), nil
+}
+
+// thirdPartyUUID stands in for a UUID type from another package, which
+// encodes as an array of numbers by default.
+type thirdPartyUUID [16]byte
+
+func (u thirdPartyUUID) String() string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+func ExampleEncoder_RegisterEncoder() {
+	type order struct {
+		ID    thirdPartyUUID
+		Items []thirdPartyUUID
+	}
+	o := order{
+		ID:    thirdPartyUUID{0x12, 0x3e, 0x45, 0x67, 0xe8, 0x9b, 0x12, 0xd3, 0xa4, 0x56, 0x42, 0x66, 0x14, 0x17, 0x40, 0x00},
+		Items: []thirdPartyUUID{{15: 1}},
+	}
+
+	enc := NewEncoder(os.Stdout)
+	enc.RegisterEncoder(reflect.TypeOf(thirdPartyUUID{}), func(v interface{}) ([]byte, error) {
+		return Marshal(v.(thirdPartyUUID).String())
+	})
+	if err := enc.Encode(o); err != nil {
+		log.Fatal(err)
+	}
+	// Output:
+	// {"ID":"123e4567-e89b-12d3-a456-426614174000","Items":["00000000-0000-0000-0000-000000000001"]}
+}
+
+func TestEncoderSetNilSliceEmpty(t *testing.T) {
+	type inner struct {
+		Tags  []string
+		Attrs map[string]int
+	}
+	type outer struct {
+		Names  []string
+		Inners []inner
+		Inner  inner
+		PInner *inner
+		Bytes  []byte
+		Lists  [][]int
+		Empty  []int
+		Omit   []int `json:",omitempty"`
+		Any    interface{}
+	}
+	v := outer{
+		Inners: []inner{{}, {Tags: []string{"a"}}},
+		Lists:  [][]int{nil, {}},
+		Empty:  []int{},
+	}
+	encode := func(on bool) string {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetNilSliceEmpty(on)
+		if err := enc.Encode(v); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		return buf.String()
+	}
+	const wantOff = `{"Names":null,"Inners":[{"Tags":null,"Attrs":null},{"Tags":["a"],"Attrs":null}],"Inner":{"Tags":null,"Attrs":null},"PInner":null,"Bytes":null,"Lists":[null,[]],"Empty":[],"Any":null}` + "\n"
+	const wantOn = `{"Names":[],"Inners":[{"Tags":[],"Attrs":{}},{"Tags":["a"],"Attrs":{}}],"Inner":{"Tags":[],"Attrs":{}},"PInner":null,"Bytes":"","Lists":[[],[]],"Empty":[],"Any":null}` + "\n"
+	if got := encode(false); got != wantOff {
+		t.Errorf("Encode:\n\tgot:  %s\twant: %s", got, wantOff)
+	}
+	if got := encode(true); got != wantOn {
+		t.Errorf("Encode with SetNilSliceEmpty:\n\tgot:  %s\twant: %s", got, wantOn)
+	}
+
+	// Decoding the output yields empty, not nil, slices.
+	var back outer
+	if err := Unmarshal([]byte(wantOn), &back); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if back.Names == nil || len(back.Names) != 0 || back.Inner.Attrs == nil {
+		t.Errorf("Unmarshal = %#v, want non-nil empty slices and maps", back)
+	}
+}
+
+type unexportedCounter int
+
+type unexportedInner struct {
+	Shown  int
+	hidden string
+}
+
+type unexportedState struct {
+	Name    string
+	id      int
+	skip    int `json:"-"`
+	tags    []string
+	inner   unexportedInner
+	ptr     *unexportedInner
+	byName  map[string]unexportedInner
+	when    time.Time
+	counter unexportedCounter
+	unexportedCounter
+}
+
+func TestEncoderSetIncludeUnexported(t *testing.T) {
+	when := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	v := unexportedState{
+		Name:              "n",
+		id:                7,
+		skip:              1,
+		tags:              []string{"a"},
+		inner:             unexportedInner{1, "h"},
+		byName:            map[string]unexportedInner{"x": {2, "y"}},
+		when:              when,
+		counter:           3,
+		unexportedCounter: 4,
+	}
+	encode := func(v interface{}, on bool) string {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetIncludeUnexported(on)
+		if err := enc.Encode(v); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		return buf.String()
+	}
+	if got, want := encode(v, false), `{"Name":"n"}`+"\n"; got != want {
+		t.Errorf("Encode = %s, want %s", got, want)
+	}
+	const want = `{"Name":"n","id":7,"tags":["a"],"inner":{"Shown":1,"hidden":"h"},"ptr":null,"byName":{"x":{"Shown":2,"hidden":"y"}},"when":"2020-01-02T03:04:05Z","counter":3,"unexportedCounter":4}` + "\n"
+	// Addressable and unaddressable struct values are both read.
+	for _, in := range []interface{}{v, &v, []unexportedState{v}[0], map[string]interface{}{"v": v}["v"]} {
+		if got := encode(in, true); got != want {
+			t.Errorf("Encode(%T) with SetIncludeUnexported:\n\tgot:  %s\twant: %s", in, got, want)
+		}
+	}
+	if v.id != 7 || v.inner.hidden != "h" {
+		t.Errorf("Encode modified the value: %+v", v)
+	}
+	// Marshal is unaffected by the other encoder's setting.
+	if b, err := Marshal(v); err != nil || string(b) != `{"Name":"n"}` {
+		t.Errorf("Marshal = %s, %v", b, err)
+	}
+}
+
+func TestEncoderSetSortKeys(t *testing.T) {
+	type Embedded struct {
+		Mid   int
+		Alpha int `json:",omitempty"`
+	}
+	type Inlined struct {
+		Yak  int `json:"yak"`
+		Bear int `json:"bear"`
+	}
+	type T struct {
+		Zed  int
+		Name string `json:"name"`
+		Embedded
+		In   Inlined           `json:",inline"`
+		Map  map[string]int    `json:"map"`
+		Skip int               `json:",omitempty"`
+		Nest *T                `json:"nest,omitempty"`
+		Any  map[string]string `json:"-"`
+	}
+	v := T{Zed: 1, Name: "n", Embedded: Embedded{Mid: 2}, In: Inlined{3, 4}, Map: map[string]int{"b": 1, "a": 2}, Nest: &T{Zed: 5}}
+	encode := func(sortKeys, sortMapKeys bool) string {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetSortMapKeys(sortMapKeys)
+		enc.SetSortKeys(sortKeys)
+		if err := enc.Encode(v); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		return buf.String()
+	}
+	const want = `{"Mid":2,"Zed":1,"bear":4,"map":{"a":2,"b":1},"name":"n","nest":{"Mid":0,"Zed":5,"bear":0,"map":null,"name":"","yak":0},"yak":3}` + "\n"
+	for _, sortMapKeys := range []bool{true, false} {
+		if got := encode(true, sortMapKeys); got != want {
+			t.Errorf("Encode with SetSortKeys(true) and SetSortMapKeys(%v):\n\tgot:  %s\twant: %s", sortMapKeys, got, want)
+		}
+	}
+	const wantDecl = `{"Zed":1,"name":"n","Mid":2,"yak":3,"bear":4,"map":{"a":2,"b":1},"nest":{"Zed":5,"name":"","Mid":0,"yak":0,"bear":0,"map":null}}` + "\n"
+	if got := encode(false, true); got != wantDecl {
+		t.Errorf("Encode:\n\tgot:  %s\twant: %s", got, wantDecl)
+	}
+}
+
+// codeError is an error that is a struct with exported fields.
+type codeError struct {
+	Code int
+}
+
+func (e *codeError) Error() string { return "code " + strconv.Itoa(e.Code) }
+
+// marshalingError is an error with its own JSON encoding.
+type marshalingError struct{}
+
+func (marshalingError) Error() string                { return "hidden" }
+func (marshalingError) MarshalJSON() ([]byte, error) { return []byte(`{"kind":"custom"}`), nil }
+
+func TestEncoderSetErrorAsString(t *testing.T) {
+	type T struct {
+		Err     error
+		Nil     error
+		Code    *codeError
+		NilCode *codeError
+		Value   codeError
+		Custom  error
+		All     []error
+	}
+	v := T{
+		Err:    fmt.Errorf("loading config: %w", errors.New("file <missing>")),
+		Code:   &codeError{1},
+		Value:  codeError{2},
+		Custom: marshalingError{},
+		All:    []error{&codeError{3}, nil},
+	}
+	encode := func(on bool) string {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetErrorAsString(on)
+		if err := enc.Encode(&v); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		return buf.String()
+	}
+	const want = `{"Err":"loading config: file \u003cmissing\u003e","Nil":null,"Code":"code 1","NilCode":null,"Value":"code 2","Custom":{"kind":"custom"},"All":["code 3",null]}` + "\n"
+	if got := encode(true); got != want {
+		t.Errorf("Encode with SetErrorAsString(true):\n\tgot:  %s\twant: %s", got, want)
+	}
+	const wantOff = `{"Err":{},"Nil":null,"Code":{"Code":1},"NilCode":null,"Value":{"Code":2},"Custom":{"kind":"custom"},"All":[{"Code":3},null]}` + "\n"
+	if got := encode(false); got != wantOff {
+		t.Errorf("Encode:\n\tgot:  %s\twant: %s", got, wantOff)
+	}
+}
+
+func TestEncoderSetFieldOrder(t *testing.T) {
+	type Embedded struct {
+		Mid int
+	}
+	type T struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+		Embedded
+		Kind  string `json:"kind,omitempty"`
+		Extra bool
+		Nest  *T `json:"nest,omitempty"`
+	}
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	if err := enc.SetFieldOrder(reflect.TypeOf(T{}), []string{"kind", "Mid", "id"}); err != nil {
+		t.Fatal(err)
+	}
+	enc.SetSortKeys(true)
+	v := T{ID: 1, Name: "n", Embedded: Embedded{2}, Kind: "k", Nest: &T{ID: 3}}
+	if err := enc.Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	const want = `{"kind":"k","Mid":2,"id":1,"name":"n","Extra":false,"nest":{"Mid":0,"id":3,"name":"","Extra":false}}` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Encode:\n\tgot:  %s\twant: %s", got, want)
+	}
+
+	buf.Reset()
+	enc.SetFieldOrder(reflect.TypeOf(T{}), nil)
+	if err := enc.Encode(T{ID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), `{"Extra":false,"Mid":0,"id":1,"name":""}`+"\n"; got != want {
+		t.Errorf("Encode after removing order:\n\tgot:  %s\twant: %s", got, want)
+	}
+
+	for _, keys := range [][]string{{"id", "ID"}, {"Name"}, {"id", "id"}} {
+		if err := enc.SetFieldOrder(reflect.TypeOf(T{}), keys); err == nil {
+			t.Errorf("SetFieldOrder(%q): no error", keys)
+		}
+	}
+	if err := enc.SetFieldOrder(reflect.TypeOf(0), []string{"x"}); err == nil {
+		t.Error("SetFieldOrder of int: no error")
+	}
+}
+
+func TestEncoderSetMaxStringLength(t *testing.T) {
+	type Inner struct {
+		Bio  string
+		Tags []string
+	}
+	type User struct {
+		Name  string
+		ID    int64  `json:",string"`
+		Inner Inner  `json:"profile"`
+		Key   string `json:",string"`
+		Num   Number
+	}
+	long := strings.Repeat("x", 11)
+	encode := func(v interface{}) (string, error) {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetMaxStringLength(10)
+		err := enc.Encode(v)
+		return buf.String(), err
+	}
+
+	// Strings within the limit, and numbers encoded under ,string, are
+	// unaffected.
+	v := User{Name: "abcdefghij", ID: 12345678901, Inner: Inner{Tags: []string{"a"}}, Num: "123456789012"}
+	got, err := encode(v)
+	if want := `{"Name":"abcdefghij","ID":"12345678901","profile":{"Bio":"","Tags":["a"]},"Key":"\"\"","Num":123456789012}` + "\n"; err != nil || got != want {
+		t.Errorf("Encode = %s, %v, want %s", got, err, want)
+	}
+	if got, err := encode(map[string]int{long: 1}); err != nil || got != `{"`+long+`":1}`+"\n" {
+		t.Errorf("Encode of long map key = %s, %v", got, err)
+	}
+
+	tests := []struct {
+		v    interface{}
+		path string
+	}{
+		{User{Name: long}, "Name"},
+		{User{Inner: Inner{Bio: long}}, "profile.Bio"},
+		{User{Inner: Inner{Tags: []string{"a", long}}}, "profile.Tags"},
+		{User{Key: long}, "Key"},
+		{[]string{long}, ""},
+		{map[string]interface{}{"a": long}, ""},
+	}
+	for _, tt := range tests {
+		_, err := encode(tt.v)
+		want := &StringLengthError{Path: tt.path, Length: 11, Max: 10}
+		if !reflect.DeepEqual(err, want) {
+			t.Errorf("Encode(%#v): error = %#v, want %#v", tt.v, err, want)
+		}
+	}
+	if err, want := (&StringLengthError{Path: "a.b", Length: 11, Max: 10}).Error(), "json: string of 11 bytes exceeds maximum length of 10 in field a.b"; err != want {
+		t.Errorf("Error = %q, want %q", err, want)
+	}
+}
+
+func TestEncoderSetHasher(t *testing.T) {
+	values := []interface{}{
+		map[string]interface{}{"a": []int{1, 2}, "b": "<x>"},
+		"str",
+		3.5,
+	}
+	var want []byte
+	for _, v := range values {
+		b, err := Marshal(v)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		want = append(append(want, b...), '\n')
+	}
+	wantSum := sha256.Sum256(want)
+
+	for _, direct := range []bool{false, true} {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetDirectWrite(direct)
+		enc.SetHasher(sha256.New())
+		for _, v := range values {
+			if err := enc.Encode(v); err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("direct=%v: output = %q, want %q", direct, buf.Bytes(), want)
+		}
+		if got := enc.Sum(nil); !bytes.Equal(got, wantSum[:]) {
+			t.Errorf("direct=%v: Sum = %x, want %x", direct, got, wantSum)
+		}
+
+		// Tokens are hashed like values.
+		buf.Reset()
+		enc.SetHasher(sha256.New())
+		for _, tok := range []Token{Delim('['), 1.0, "two", Delim(']')} {
+			if err := enc.WriteToken(tok); err != nil {
+				t.Fatalf("WriteToken: %v", err)
+			}
+		}
+		if got, want := enc.Sum(nil), sha256.Sum256(buf.Bytes()); !bytes.Equal(got, want[:]) {
+			t.Errorf("direct=%v: Sum after WriteToken = %x, want %x", direct, got, want)
+		}
+	}
+
+	// Only the bytes accepted by the writer are hashed.
+	h := sha256.New()
+	enc := NewEncoder(&errWriter{n: 4, err: errors.New("full")})
+	enc.SetHasher(h)
+	if err := enc.Encode([]int{1, 2, 3}); err == nil {
+		t.Fatal("Encode: expected error")
+	}
+	if got, want := enc.Sum(nil), sha256.Sum256([]byte("[1,2")); !bytes.Equal(got, want[:]) {
+		t.Errorf("Sum after short write = %x, want %x", got, want)
+	}
+
+	if got := NewEncoder(ioutil.Discard).Sum(nil); got != nil {
+		t.Errorf("Sum without hasher = %x, want nil", got)
+	}
+}
+
+func TestEncoderRegisterEncoder(t *testing.T) {
+	type inner struct {
+		U thirdPartyUUID
+	}
+	u := thirdPartyUUID{15: 7}
+	v := struct {
+		A thirdPartyUUID
+		P *thirdPartyUUID
+		N *thirdPartyUUID
+		M map[string]thirdPartyUUID
+		R [1]thirdPartyUUID
+		I interface{}
+		S inner
+		T strMarshaler
+	}{A: u, P: &u, M: map[string]thirdPartyUUID{"k": u}, R: [1]thirdPartyUUID{u}, I: u, S: inner{u}, T: `"t"`}
+
+	var calls int
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.RegisterEncoder(reflect.TypeOf(u), func(v interface{}) ([]byte, error) {
+		calls++
+		return []byte(` "` + v.(thirdPartyUUID).String()[30:] + `" `), nil
+	})
+	enc.RegisterEncoder(reflect.TypeOf(strMarshaler("")), func(interface{}) ([]byte, error) {
+		return []byte(`"custom"`), nil
+	})
+	if err := enc.Encode(v); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	const want = `{"A":"000007","P":"000007","N":null,"M":{"k":"000007"},"R":["000007"],"I":"000007","S":{"U":"000007"},"T":"custom"}` + "\n"
+	if got := buf.String(); got != want || calls != 6 {
+		t.Errorf("Encode = %s after %d calls, want %s after 6", got, calls, want)
+	}
+
+	// Registrations are per Encoder, and may be removed.
+	b, err := Marshal(inner{u})
+	if err != nil || string(b) != `{"U":[0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,7]}` {
+		t.Errorf("Marshal = %s, %v", b, err)
+	}
+	enc.RegisterEncoder(reflect.TypeOf(u), nil)
+	buf.Reset()
+	if err := enc.Encode(u); err != nil || buf.String() != "[0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,7]\n" {
+		t.Errorf("Encode after removal = %s, %v", buf.String(), err)
+	}
+
+	errBad := errors.New("bad")
+	enc.RegisterEncoder(reflect.TypeOf(u), func(interface{}) ([]byte, error) { return nil, errBad })
+	if err := enc.Encode(inner{u}); !errors.Is(err, errBad) {
+		t.Errorf("Encode error = %v, want %v", err, errBad)
+	}
+	enc.RegisterEncoder(reflect.TypeOf(u), func(interface{}) ([]byte, error) { return []byte(`{`), nil })
+	if err := enc.Encode(inner{u}); err == nil {
+		t.Error("Encode of invalid output: no error")
+	}
+}
+
+func TestDecoderRegisterDecoder(t *testing.T) {
+	parse := func(data []byte, v interface{}) error {
+		var s string
+		if err := Unmarshal(data, &s); err != nil {
+			return err
+		}
+		u := v.(*thirdPartyUUID)
+		for i, j := 0, 0; i < len(s); i++ {
+			if s[i] == '-' {
+				continue
+			}
+			if i+2 > len(s) || j == len(u) {
+				return fmt.Errorf("invalid UUID %q", s)
+			}
+			n, err := strconv.ParseUint(s[i:i+2], 16, 8)
+			if err != nil {
+				return fmt.Errorf("invalid UUID %q", s)
+			}
+			u[j] = byte(n)
+			i, j = i+1, j+1
+		}
+		return nil
+	}
+	type inner struct {
+		U thirdPartyUUID
+	}
+	var v struct {
+		A thirdPartyUUID
+		P *thirdPartyUUID
+		M map[string]thirdPartyUUID
+		L []thirdPartyUUID
+		S inner
+	}
+	const id = `"00000000-0000-0000-0000-000000000009"`
+	in := `{"A":` + id + `,"P":` + id + `,"M":{"k":` + id + `},"L":[` + id + `,` + id + `],"S":{"U":` + id + `}}`
+	dec := NewDecoder(strings.NewReader(in))
+	dec.RegisterDecoder(reflect.TypeOf(thirdPartyUUID{}), parse)
+	if err := dec.Decode(&v); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	u := thirdPartyUUID{15: 9}
+	if v.A != u || v.P == nil || *v.P != u || v.M["k"] != u || len(v.L) != 2 || v.L[1] != u || v.S.U != u {
+		t.Errorf("Decode = %+v", v)
+	}
+
+	// A top-level value, and errors from the registered function.
+	dec = NewDecoder(strings.NewReader(id + ` "junk"`))
+	dec.RegisterDecoder(reflect.TypeOf(thirdPartyUUID{}), parse)
+	var w thirdPartyUUID
+	if err := dec.Decode(&w); err != nil || w != u {
+		t.Errorf("Decode = %v, %v", w, err)
+	}
+	if err := dec.Decode(&w); err == nil {
+		t.Error("Decode of junk: no error")
+	}
+}
+
+func TestEncoderSetTrustMarshalJSON(t *testing.T) {
+	x := strPtrMarshaler(`"x"`)
+	v := []interface{}{strMarshaler(`{ "a" : "<b>" }`), &x}
+	encode := func(trust bool, v interface{}) (string, error) {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetTrustMarshalJSON(trust)
+		err := enc.Encode(v)
+		return buf.String(), err
+	}
+
+	got, err := encode(false, v)
+	if want := `[{"a":"\u003cb\u003e"},"x"]` + "\n"; err != nil || got != want {
+		t.Errorf("Encode = %#q, %v, want %#q", got, err, want)
+	}
+	got, err = encode(true, v)
+	if want := `[{ "a" : "<b>" },"x"]` + "\n"; err != nil || got != want {
+		t.Errorf("Encode with SetTrustMarshalJSON = %#q, %v, want %#q", got, err, want)
+	}
+
+	bad := strMarshaler(`{"a":`)
+	if _, err := encode(false, bad); err == nil {
+		t.Error("Encode of malformed MarshalJSON output: no error")
+	}
+	got, err = encode(true, bad)
+	if want := `{"a":` + "\n"; err != nil || got != want {
+		t.Errorf("Encode with SetTrustMarshalJSON = %#q, %v, want %#q", got, err, want)
+	}
+}
+
+func TestEncoderSetFieldFilter(t *testing.T) {
+	type address struct {
+		Street string `json:"street"`
+		City   string `json:"city"`
+	}
+	type user struct {
+		Name    string   `json:"name"`
+		Email   string   `json:"email,omitempty"`
+		Address *address `json:"address"`
+	}
+	type Embedded struct {
+		ID int `json:"id"`
+	}
+	type response struct {
+		Embedded
+		User    user            `json:"user"`
+		Friends []user          `json:"friends"`
+		ByName  map[string]user `json:"byName"`
+		Raw     RawMessage      `json:"raw"`
+	}
+	v := response{
+		Embedded: Embedded{ID: 7},
+		User:     user{Name: "ann", Email: "ann@example.com", Address: &address{"1 Main St", "Springfield"}},
+		Friends:  []user{{Name: "bob", Address: &address{City: "Shelbyville"}}, {Name: "cy"}},
+		ByName:   map[string]user{"dee": {Name: "dee", Email: "dee@example.com"}},
+		Raw:      RawMessage(`{"name":"raw"}`),
+	}
+	allow := func(paths ...string) func(string) bool {
+		return func(path string) bool {
+			for _, p := range paths {
+				if path == p || strings.HasPrefix(path, p+".") || strings.HasPrefix(p, path+".") {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	tests := []struct {
+		name   string
+		filter func(string) bool
+		want   string
+	}{{
+		name: "none",
+		want: `{"id":7,"user":{"name":"ann","email":"ann@example.com","address":{"street":"1 Main St","city":"Springfield"}},"friends":[{"name":"bob","address":{"street":"","city":"Shelbyville"}},{"name":"cy","address":null}],"byName":{"dee":{"name":"dee","email":"dee@example.com","address":null}},"raw":{"name":"raw"}}`,
+	}, {
+		name:   "nested",
+		filter: allow("user.address.city"),
+		want:   `{"user":{"address":{"city":"Springfield"}}}`,
+	}, {
+		name:   "subtree",
+		filter: allow("id", "user.address"),
+		want:   `{"id":7,"user":{"address":{"street":"1 Main St","city":"Springfield"}}}`,
+	}, {
+		name:   "slice",
+		filter: allow("friends.name", "friends.address.city"),
+		want:   `{"friends":[{"name":"bob","address":{"city":"Shelbyville"}},{"name":"cy","address":null}]}`,
+	}, {
+		name:   "map",
+		filter: allow("byName.email"),
+		want:   `{"byName":{"dee":{"email":"dee@example.com"}}}`,
+	}, {
+		name:   "marshaler",
+		filter: allow("raw"),
+		want:   `{"raw":{"name":"raw"}}`,
+	}, {
+		name:   "nothing",
+		filter: func(string) bool { return false },
+		want:   `{}`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			enc := NewEncoder(&buf)
+			enc.SetFieldFilter(tt.filter)
+			if err := enc.Encode(v); err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			if got := strings.TrimSuffix(buf.String(), "\n"); got != tt.want {
+				t.Errorf("Encode:\n\tgot:  %s\n\twant: %s", got, tt.want)
+			}
+		})
+	}
+
+	// Paths are reported once per field, with omitempty fields skipped.
+	var paths []string
+	enc := NewEncoder(ioutil.Discard)
+	enc.SetFieldFilter(func(path string) bool {
+		paths = append(paths, path)
+		return path != "user"
+	})
+	if err := enc.Encode(v); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	want := []string{"id", "user", "friends", "friends.name", "friends.address", "friends.address.street", "friends.address.city", "friends.name", "friends.address", "byName", "byName.name", "byName.email", "byName.address", "raw"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("filter paths:\n\tgot:  %q\n\twant: %q", paths, want)
+	}
+}
+
+func TestEncoderSetStringerMapKeys(t *testing.T) {
+	in := map[stringerColor]int{0: 1, 1: 2, 2: 3}
+	tests := []struct {
+		on   bool
+		want string
+	}{
+		{false, `{"0":1,"1":2,"2":3}`},
+		{true, `{"blue":3,"green":2,"red":1}`},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetStringerMapKeys(tt.on)
+		if err := enc.Encode(in); err != nil {
+			t.Fatalf("SetStringerMapKeys(%v): Encode: %v", tt.on, err)
+		}
+		if got := strings.TrimSpace(buf.String()); got != tt.want {
+			t.Errorf("SetStringerMapKeys(%v): Encode = %s, want %s", tt.on, got, tt.want)
+		}
+
+		var out map[stringerColor]int
+		if err := NewDecoder(&buf).Decode(&out); err != nil {
+			if tt.on {
+				t.Errorf("SetStringerMapKeys(%v): Decode: %v", tt.on, err)
+			}
+			continue
+		}
+		if tt.on && !reflect.DeepEqual(out, in) {
+			t.Errorf("SetStringerMapKeys(%v): round trip = %v, want %v", tt.on, out, in)
+		}
+	}
+
+	// MarshalText takes precedence over String.
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetStringerMapKeys(true)
+	if err := enc.Encode(map[textStringerKey]int{1: 1}); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if got, want := strings.TrimSpace(buf.String()), `{"text1":1}`; got != want {
+		t.Errorf("Encode = %s, want %s", got, want)
+	}
+}
+
+func TestEncoderSetInvalidFloat(t *testing.T) {
+	type floats struct {
+		A float64
+		B float32
+		C float64 `json:",string"`
+		D []interface{}
+	}
+	v := floats{
+		A: math.NaN(),
+		B: float32(math.Inf(1)),
+		C: math.Inf(-1),
+		D: []interface{}{1.5, math.NaN()},
+	}
+	tests := []struct {
+		mode InvalidFloatMode
+		want string
+	}{
+		{InvalidFloatNull, `{"A":null,"B":null,"C":null,"D":[1.5,null]}`},
+		{InvalidFloatString, `{"A":"NaN","B":"+Inf","C":"-Inf","D":[1.5,"NaN"]}`},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetInvalidFloat(tt.mode)
+		if err := enc.Encode(v); err != nil {
+			t.Fatalf("mode %d: Encode: %v", tt.mode, err)
+		}
+		if got := buf.String(); got != tt.want+"\n" {
+			t.Errorf("mode %d: Encode = %q, want %q", tt.mode, got, tt.want+"\n")
+		}
+		if !Valid(buf.Bytes()) {
+			t.Errorf("mode %d: Encode wrote invalid JSON %q", tt.mode, buf.String())
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetInvalidFloat(InvalidFloatError)
+	err := enc.Encode(v)
+	if _, ok := err.(*UnsupportedValueError); !ok {
+		t.Errorf("InvalidFloatError: Encode error = %v (%T), want *UnsupportedValueError", err, err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("InvalidFloatError: Encode wrote %q, want nothing", buf.String())
+	}
+}
+
+func TestEncoderSetInvalidUTF8(t *testing.T) {
+	type inner struct {
+		S string
+		Q string `json:",string"`
+	}
+	v := struct {
+		Name  string `json:"name"`
+		Inner inner
+	}{"a\x80b\u00e9", inner{"ok", "x"}}
+	tests := []struct {
+		mode InvalidUTF8Mode
+		want string
+	}{
+		{InvalidUTF8Replace, `{"name":"a\ufffdb\u00e9","Inner":{"S":"ok","Q":"\"x\""}}`},
+		{InvalidUTF8Escape, `{"name":"a\u0080b\u00e9","Inner":{"S":"ok","Q":"\"x\""}}`},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetEscapeMode(EscapeHTML | EscapeNonASCII)
+		enc.SetInvalidUTF8(tt.mode)
+		if err := enc.Encode(v); err != nil {
+			t.Fatalf("mode %d: %v", tt.mode, err)
+		}
+		if got := strings.TrimSuffix(buf.String(), "\n"); got != tt.want {
+			t.Errorf("mode %d:\n got %s\nwant %s", tt.mode, got, tt.want)
+		}
+	}
+
+	// InvalidUTF8Reject reports the string and the field holding it.
+	for _, tc := range []struct {
+		v    interface{}
+		s    string
+		path string
+	}{
+		{v, "a\x80b\u00e9", "name"},
+		{inner{S: "\x80"}, "\x80", "S"},
+		{inner{Q: "\x80"}, `"` + "\x80" + `"`, "Q"},
+		{struct{ Inner inner }{inner{S: "x\x80"}}, "x\x80", "Inner.S"},
+		{map[string]int{"\x80": 1}, "\x80", ""},
+		{[]string{"ok", "\x80"}, "\x80", ""},
+	} {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetInvalidUTF8(InvalidUTF8Reject)
+		err := enc.Encode(tc.v)
+		ue, ok := err.(*InvalidUTF8Error)
+		if !ok || ue.S != tc.s || ue.Path != tc.path {
+			t.Errorf("Encode(%#v) error = %#v, want InvalidUTF8Error{%q, %q}", tc.v, err, tc.s, tc.path)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("Encode(%#v) wrote %q, want nothing", tc.v, buf.String())
+		}
+	}
+	if err := (&InvalidUTF8Error{S: "\x80", Path: "A.B"}).Error(); err != `json: invalid UTF-8 in string: "\x80" in field A.B` {
+		t.Errorf("Error() = %s", err)
+	}
+}
+
+func TestEncoderSetJSONLines(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetJSONLines(true)
+	for _, v := range []interface{}{
+		map[string]interface{}{"a": []int{1, 2}, "b": "x\ny"},
+		RawMessage("{ \"c\" :\n  3 }"),
+		nil,
+	} {
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tok := range []Token{Delim('['), 1, Delim(']')} {
+		if err := enc.WriteToken(tok); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := buf.String(), `{"a":[1,2],"b":"x\ny"}`+"\n"+`{"c":3}`+"\nnull\n[1]\n"; got != want {
+		t.Errorf("Encode = %q, want %q", got, want)
+	}
+
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(1); err != errJSONLinesIndent {
+		t.Errorf("Encode with SetIndent: err = %v, want %v", err, errJSONLinesIndent)
+	}
+	if err := enc.WriteToken(1); err != errJSONLinesIndent {
+		t.Errorf("WriteToken with SetIndent: err = %v, want %v", err, errJSONLinesIndent)
+	}
+	enc.SetJSONLines(false)
+	if err := enc.Encode(1); err != nil {
+		t.Errorf("Encode with SetIndent after SetJSONLines(false): %v", err)
+	}
+}
+
+func TestEncoderSetBOM(t *testing.T) {
+	for _, direct := range []bool{false, true} {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetDirectWrite(direct)
+		enc.SetBOM(true)
+		if err := enc.Encode(map[string]int{"a": 1}); err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.Encode("b"); err != nil {
+			t.Fatal(err)
+		}
+		for _, tok := range []Token{Delim('['), 2, Delim(']')} {
+			if err := enc.WriteToken(tok); err != nil {
+				t.Fatal(err)
+			}
+		}
+		out := buf.String()
+		if want := "\xEF\xBB\xBF{\"a\":1}\n\"b\"\n[2]\n"; out != want {
+			t.Errorf("direct=%v: output = %q, want %q", direct, out, want)
+		}
+
+		// The Decoder skips the BOM, even when it is read a byte at a time.
+		for _, r := range []io.Reader{strings.NewReader(out), iotest.OneByteReader(strings.NewReader(out))} {
+			dec := NewDecoder(r)
+			var got []interface{}
+			for {
+				var v interface{}
+				err := dec.Decode(&v)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("direct=%v: Decode: %v", direct, err)
+				}
+				got = append(got, v)
+			}
+			want := []interface{}{map[string]interface{}{"a": 1.0}, "b", []interface{}{2.0}}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("direct=%v: decoded %v, want %v", direct, got, want)
+			}
+		}
+	}
+
+	// Only a BOM at the very start of the input is ignored.
+	for _, in := range []string{" \xEF\xBB\xBF1", "\xEF\xBB", "\xEF1"} {
+		var v interface{}
+		if err := NewDecoder(strings.NewReader(in)).Decode(&v); err == nil {
+			t.Errorf("Decode(%q) = %v, want error", in, v)
+		}
+	}
+}
+
+func TestDecoderSetTee(t *testing.T) {
+	const first = "  {\"a\": 1.50, \"b\" : [true]}"
+	const second = " \n [1, 2.50 , {\"x\": null}]"
+	const in = first + second + " \n"
+	for _, r := range []io.Reader{strings.NewReader(in), iotest.OneByteReader(strings.NewReader(in))} {
+		var tee bytes.Buffer
+		dec := NewDecoder(r)
+		dec.UseNumber()
+		dec.SetTee(&tee)
+
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		if got := tee.String(); got != first {
+			t.Errorf("tee after Decode = %q, want %q", got, first)
+		}
+
+		// Mix Token and Decode within the second value.
+		for i := 0; i < 3; i++ {
+			if _, err := dec.Token(); err != nil {
+				t.Fatalf("Token: %v", err)
+			}
+		}
+		if got, want := tee.String(), first+" \n [1, 2.50"; got != want {
+			t.Errorf("tee after Token = %q, want %q", got, want)
+		}
+		if err := dec.Decode(&v); err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		if _, err := dec.Token(); err != nil {
+			t.Fatalf("Token: %v", err)
+		}
+		if got := tee.String(); got != first+second {
+			t.Errorf("tee after second value = %q, want %q", got, first+second)
+		}
+		if err := dec.Decode(&v); err != io.EOF {
+			t.Errorf("Decode at end of input = %v, want io.EOF", err)
+		}
+		if got := tee.String(); got != first+second {
+			t.Errorf("tee at end of input = %q, want %q", got, first+second)
+		}
+	}
+
+	wantErr := fmt.Errorf("tee failed")
+	dec := NewDecoder(strings.NewReader(`1 2`))
+	dec.SetTee(&errWriter{n: 0, err: wantErr})
+	var v interface{}
+	if err := dec.Decode(&v); err != wantErr {
+		t.Errorf("Decode error = %v, want %v", err, wantErr)
+	}
+	if _, err := dec.Token(); err != wantErr {
+		t.Errorf("Token after tee error = %v, want %v", err, wantErr)
+	}
+}
+
+func TestDecoderSetInternKeys(t *testing.T) {
+	const record = `{"alpha":1,"beta":{"gamma":[{"alpha":2}]},"delta":"x","epsilon":null}` + "\n"
+	allocs := func(intern bool) float64 {
+		dec := NewDecoder(strings.NewReader(strings.Repeat(record, 200)))
+		dec.SetInternKeys(intern)
+		var first map[string]interface{}
+		if err := dec.Decode(&first); err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		return testing.AllocsPerRun(100, func() {
+			var m map[string]interface{}
+			if err := dec.Decode(&m); err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if !reflect.DeepEqual(m, first) {
+				t.Fatalf("Decode = %v, want %v", m, first)
+			}
+		})
+	}
+	if plain, interned := allocs(false), allocs(true); interned >= plain {
+		t.Errorf("allocations per Decode with interned keys = %v, want fewer than %v", interned, plain)
+	}
+
+	// Map types with string keys are interned too, and the table is bounded.
+	dec := NewDecoder(strings.NewReader(`{"a":1,"b":2}`))
+	dec.SetInternKeys(true)
+	var m map[string]int
+	if err := dec.Decode(&m); err != nil || m["a"] != 1 || m["b"] != 2 {
+		t.Fatalf("Decode = %v, %v", m, err)
+	}
+	if len(dec.d.internKeys) != 2 {
+		t.Errorf("interned %d keys, want 2", len(dec.d.internKeys))
+	}
+	var many bytes.Buffer
+	many.WriteByte('{')
+	for i := 0; i < maxInternedKeys+10; i++ {
+		fmt.Fprintf(&many, `"k%d":%d,`, i, i)
+	}
+	many.WriteString(`"end":0}`)
+	dec = NewDecoder(&many)
+	dec.SetInternKeys(true)
+	var big map[string]interface{}
+	if err := dec.Decode(&big); err != nil || len(big) != maxInternedKeys+11 {
+		t.Fatalf("Decode = %d keys, %v", len(big), err)
+	}
+	if len(dec.d.internKeys) != maxInternedKeys {
+		t.Errorf("interned %d keys, want %d", len(dec.d.internKeys), maxInternedKeys)
+	}
+	dec.SetInternKeys(true)
+	if len(dec.d.internKeys) != 0 {
+		t.Errorf("SetInternKeys(true) kept %d keys", len(dec.d.internKeys))
+	}
+}
+
+func TestDecoderSetStrictTypes(t *testing.T) {
+	type inner struct {
+		N int
+	}
+	type strict struct {
+		I   int
+		F   float64
+		B   bool
+		S   string
+		Num Number
+		Q   int `json:",string"`
+		In  inner
+		P   *int
+		Any interface{}
+		T   time.Time
+	}
+	tests := []struct {
+		name  string
+		in    string
+		value string
+		typ   reflect.Type
+		field string
+		off   int64
+		lax   bool // whether the input decodes without strict types
+	}{
+		{name: "string to int", in: ` {"I":"42"}`, value: "string", typ: reflect.TypeOf(0), field: "I", off: 10},
+		{name: "string to float", in: `{"F":"1.5"}`, value: "string", typ: reflect.TypeOf(0.0), field: "F", off: 10},
+		{name: "string to Number", in: `{"Num":"7"}`, value: "string", typ: reflect.TypeOf(Number("")), field: "Num", off: 10, lax: true},
+		{name: "quoted option", in: `{"Q":"3"}`, value: "string", typ: reflect.TypeOf(0), field: "Q", off: 8, lax: true},
+		{name: "number to bool", in: `{"B":1}`, value: "number", typ: reflect.TypeOf(false), field: "B", off: 6},
+		{name: "number to string", in: `{"S":12}`, value: "number", typ: reflect.TypeOf(""), field: "S", off: 7},
+		{name: "bool to int", in: `{"I":true}`, value: "bool", typ: reflect.TypeOf(0), field: "I", off: 9},
+		{name: "float to int", in: `{"I":2.5}`, value: "number 2.5", typ: reflect.TypeOf(0), field: "I", off: 8},
+		{name: "null to int", in: `{"I":null}`, value: "null", typ: reflect.TypeOf(0), field: "I", off: 9, lax: true},
+		{name: "null to struct", in: `{"In":null}`, value: "null", typ: reflect.TypeOf(inner{}), field: "In", off: 10, lax: true},
+		{name: "nested", in: `{"S":"s","In":{"N":"1"}}`, value: "string", typ: reflect.TypeOf(0), field: "In.N", off: 22},
+		{name: "no error", lax: true, in: `{"I":1,"F":1.5,"B":true,"S":"s","Num":7,"Q":3,"In":{"N":1},"P":null,"Any":"x","T":"2000-01-01T00:00:00Z"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lax strict
+			laxErr := NewDecoder(strings.NewReader(tt.in)).Decode(&lax)
+
+			dec := NewDecoder(strings.NewReader(tt.in))
+			dec.SetStrictTypes(true)
+			var v strict
+			err := dec.Decode(&v)
+			if tt.value == "" {
+				if err != nil {
+					t.Fatalf("Decode: %v", err)
+				}
+				return
+			}
+			ute, ok := err.(*UnmarshalTypeError)
+			if !ok {
+				t.Fatalf("Decode error = %#v, want *UnmarshalTypeError", err)
+			}
+			if ute.Value != tt.value || ute.Type != tt.typ || ute.Field != tt.field || ute.Offset != tt.off {
+				t.Errorf("Decode error = {Value: %q, Type: %v, Field: %q, Offset: %d}, want {%q, %v, %q, %d}",
+					ute.Value, ute.Type, ute.Field, ute.Offset, tt.value, tt.typ, tt.field, tt.off)
+			}
+			if tt.lax && laxErr != nil {
+				t.Errorf("Decode without strict types: %v", laxErr)
+			} else if !tt.lax && laxErr == nil {
+				t.Error("Decode without strict types succeeded")
+			}
+		})
+	}
+
+	// Offsets have the same meaning with and without strict types.
+	for _, on := range []bool{false, true} {
+		dec := NewDecoder(strings.NewReader(`{"I":1}   {"I":"xyz"}`))
+		dec.SetStrictTypes(on)
+		var v strict
+		if err := dec.Decode(&v); err != nil {
+			t.Fatalf("strict %v: Decode: %v", on, err)
+		}
+		err := dec.Decode(&v)
+		if ute, ok := err.(*UnmarshalTypeError); !ok || ute.Offset != 13 {
+			t.Errorf("strict %v: Decode error = %v, want *UnmarshalTypeError at offset 13", on, err)
+		}
+	}
+}
+
+func TestDecodeArray(t *testing.T) {
+	collect := func(dec *Decoder) ([]string, error) {
+		var elems []string
+		err := DecodeArray(dec, func(m RawMessage) error {
+			elems = append(elems, string(m))
+			return nil
+		})
+		return elems, err
+	}
+
+	dec := NewDecoder(strings.NewReader(` [1, "two", {"three": [3]}, [], null] [] {"a":1}`))
+	elems, err := collect(dec)
+	if err != nil {
+		t.Fatalf("DecodeArray: %v", err)
+	}
+	if want := []string{`1`, `"two"`, `{"three": [3]}`, `[]`, `null`}; !reflect.DeepEqual(elems, want) {
+		t.Errorf("DecodeArray elements = %q, want %q", elems, want)
+	}
+	if elems, err := collect(dec); err != nil || len(elems) != 0 {
+		t.Errorf("DecodeArray of empty array = %q, %v", elems, err)
+	}
+	_, err = collect(dec)
+	if ute, ok := err.(*UnmarshalTypeError); !ok || ute.Value != "object" || ute.Offset != 41 {
+		t.Fatalf("DecodeArray of object: error = %v, want *UnmarshalTypeError at offset 41", err)
+	}
+	// The object was not consumed.
+	var obj map[string]int
+	if err := dec.Decode(&obj); err != nil || obj["a"] != 1 {
+		t.Errorf("Decode after DecodeArray = %v, %v", obj, err)
+	}
+
+	// An already-positioned Decoder.
+	dec = NewDecoder(strings.NewReader(`{"items": [{"id": 1}, {"id": 2}, {"id": 3}], "next": "x"}`))
+	for i := 0; i < 2; i++ {
+		if _, err := dec.Token(); err != nil {
+			t.Fatalf("Token: %v", err)
+		}
+	}
+	var ids []int
+	stop := errors.New("stop")
+	err = DecodeArray(dec, func(m RawMessage) error {
+		var v struct{ ID int }
+		if err := Unmarshal(m, &v); err != nil {
+			return err
+		}
+		ids = append(ids, v.ID)
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(ids, []int{1, 2, 3}) {
+		t.Fatalf("DecodeArray = %v, %v", ids, err)
+	}
+	for _, want := range []Token{"next", "x", Delim('}')} {
+		if tok, err := dec.Token(); err != nil || tok != want {
+			t.Fatalf("Token = %v, %v, want %v", tok, err, want)
+		}
+	}
+
+	// Errors from fn stop the iteration.
+	dec = NewDecoder(strings.NewReader(`[1, 2, 3]`))
+	n := 0
+	err = DecodeArray(dec, func(RawMessage) error {
+		if n++; n == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || n != 2 {
+		t.Errorf("DecodeArray = %v after %d elements, want stop after 2", err, n)
+	}
+
+	for _, in := range []string{`"a"`, `3`, `true`, `null`} {
+		_, err := collect(NewDecoder(strings.NewReader(in)))
+		if _, ok := err.(*UnmarshalTypeError); !ok {
+			t.Errorf("DecodeArray(%s): error = %v, want *UnmarshalTypeError", in, err)
+		}
+	}
+	if _, err := collect(NewDecoder(strings.NewReader(`[1,`))); err == nil {
+		t.Error("DecodeArray of truncated array: no error")
+	}
+}
+
+func TestDecodeToChan(t *testing.T) {
+	type item struct {
+		ID   int
+		Name string
+	}
+	dec := NewDecoder(strings.NewReader(`[{"ID": 1, "Name": "a"}, {"ID": 2}, {"ID": 3, "Name": "c"}] 4`))
+	ch := make(chan item)
+	errc := make(chan error, 1)
+	go func() { errc <- DecodeToChan(dec, ch) }()
+	var got []item
+	for it := range ch {
+		got = append(got, it)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("DecodeToChan: %v", err)
+	}
+	if want := []item{{1, "a"}, {2, ""}, {3, "c"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodeToChan received %v, want %v", got, want)
+	}
+	// The closing bracket was consumed.
+	var n int
+	if err := dec.Decode(&n); err != nil || n != 4 {
+		t.Errorf("Decode after DecodeToChan = %v, %v", n, err)
+	}
+
+	// An error mid-stream is returned after the channel is closed, and the
+	// elements before it have been sent.
+	ptrs := make(chan *item, 10)
+	err := DecodeToChan(NewDecoder(strings.NewReader(`[{"ID": 1}, {"ID": "two"}, {"ID": 3}]`)), (chan<- *item)(ptrs))
+	if _, ok := err.(*UnmarshalTypeError); !ok {
+		t.Errorf("DecodeToChan: error = %v, want *UnmarshalTypeError", err)
+	}
+	var ids []int
+	for it := range ptrs {
+		ids = append(ids, it.ID)
+	}
+	if !reflect.DeepEqual(ids, []int{1}) {
+		t.Errorf("DecodeToChan sent IDs %v before the error, want [1]", ids)
+	}
+
+	// A non-array is reported as such, and also closes the channel.
+	ints := make(chan int)
+	err = DecodeToChan(NewDecoder(strings.NewReader(`{"a": 1}`)), ints)
+	if ute, ok := err.(*UnmarshalTypeError); !ok || ute.Value != "object" || ute.Type != reflect.TypeOf([]int(nil)) {
+		t.Errorf("DecodeToChan of object: error = %v, want *UnmarshalTypeError for []int", err)
+	}
+	if _, ok := <-ints; ok {
+		t.Error("DecodeToChan of object did not close the channel")
+	}
+
+	var nilChan chan int
+	for _, ch := range []interface{}{nil, 3, []int{}, make(<-chan int), nilChan} {
+		if err := DecodeToChan(NewDecoder(strings.NewReader(`[1]`)), ch); err == nil {
+			t.Errorf("DecodeToChan(%T): no error", ch)
+		}
+	}
+}
+
+func TestDecoderOnPath(t *testing.T) {
+	const in = `{"items": [{"price": 1.5, "name": "a"}, {"name": "b", "price": {"amount": 2}}],
+		"total": 3.5, "meta": {"price": 9}}
+	{"items": [{"price": 4}]}`
+	var calls []string
+	record := func(label string) func(RawMessage) error {
+		return func(m RawMessage) error {
+			calls = append(calls, label+"="+string(m))
+			return nil
+		}
+	}
+	dec := NewDecoder(strings.NewReader(in))
+	dec.OnPath("items.*.price", record("price"))
+	dec.OnPath("items.1", record("second"))
+	dec.OnPath("*.price", record("any"))
+	dec.OnPath("", record("top"))
+
+	var v struct {
+		Items []struct{ Name string }
+		Total float64
+	}
+	if err := dec.Decode(&v); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if len(v.Items) != 2 || v.Items[1].Name != "b" || v.Total != 3.5 {
+		t.Errorf("Decode = %+v", v)
+	}
+	want := []string{
+		`price=1.5`,
+		`price={"amount": 2}`,
+		`second={"name": "b", "price": {"amount": 2}}`,
+		`any=9`,
+	}
+	if len(calls) != len(want)+1 || !strings.HasPrefix(calls[len(want)], `top={"items"`) {
+		t.Fatalf("calls = %q", calls)
+	}
+	if !reflect.DeepEqual(calls[:len(want)], want) {
+		t.Errorf("calls:\n got %q\nwant %q", calls[:len(want)], want)
+	}
+
+	// A handler's error is returned, and the stream remains usable.
+	calls = nil
+	errStop := errors.New("stop")
+	dec.OnPath("items.0", func(RawMessage) error { return errStop })
+	if err := dec.Decode(&v); err != errStop {
+		t.Errorf("Decode error = %v, want %v", err, errStop)
+	}
+	if want := []string{"price=4"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+	if err := dec.Decode(&v); err != io.EOF {
+		t.Errorf("Decode at end = %v, want io.EOF", err)
+	}
+
+	// No handler is called if decoding fails.
+	calls = nil
+	dec = NewDecoder(strings.NewReader(`{"items": "x"}`))
+	dec.OnPath("items", record("items"))
+	if err := dec.Decode(&v); err == nil || calls != nil {
+		t.Errorf("Decode = %v, calls %q, want an error and no calls", err, calls)
+	}
+}
+
+// endlessReader produces an endless JSON array of numbers.
+type endlessReader struct {
+	read int
+}
+
+func (r *endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		switch {
+		case r.read == 0:
+			p[i] = '['
+		case r.read%2 == 1:
+			p[i] = '1'
+		default:
+			p[i] = ','
+		}
+		r.read++
+	}
+	return len(p), nil
+}
+
+func TestDecoderSetMaxBytes(t *testing.T) {
+	r := &endlessReader{}
+	dec := NewDecoder(r)
+	dec.SetMaxBytes(1000)
+	var v interface{}
+	err := dec.Decode(&v)
+	if want := (&MaxBytesError{Limit: 1000}); !reflect.DeepEqual(err, want) {
+		t.Fatalf("Decode error = %v, want %v", err, want)
+	}
+	if r.read > 1001 {
+		t.Errorf("read %d bytes, want at most 1001", r.read)
+	}
+	if err := dec.Decode(&v); !reflect.DeepEqual(err, &MaxBytesError{Limit: 1000}) {
+		t.Errorf("second Decode error = %v", err)
+	}
+
+	// The limit applies to Token too, including within a token.
+	dec = NewDecoder(strings.NewReader(`["abcdefghij"]`))
+	dec.SetMaxBytes(6)
+	if tok, err := dec.Token(); err != nil || tok != Delim('[') {
+		t.Fatalf("Token = %v, %v, want [", tok, err)
+	}
+	if _, err := dec.Token(); !reflect.DeepEqual(err, &MaxBytesError{Limit: 6}) {
+		t.Errorf("Token error = %v, want MaxBytesError", err)
+	}
+
+	// Values ending within the limit are decoded.
+	dec = NewDecoder(strings.NewReader(`{"a":1} {"b":2}`))
+	dec.SetMaxBytes(8)
+	if err := dec.Decode(&v); err != nil {
+		t.Errorf("Decode of value within limit: %v", err)
+	}
+	if err := dec.Decode(&v); !reflect.DeepEqual(err, &MaxBytesError{Limit: 8}) {
+		t.Errorf("Decode of value beyond limit error = %v", err)
+	}
+
+	// Input of exactly the limit is accepted, and Reset counts afresh.
+	dec.Reset(strings.NewReader(`[1,2]`))
+	dec.SetMaxBytes(5)
+	if err := dec.Decode(&v); err != nil {
+		t.Errorf("Decode of input of the maximum size: %v", err)
+	}
+	if err := dec.Decode(&v); err != io.EOF {
+		t.Errorf("Decode at end of input = %v, want io.EOF", err)
+	}
+}
+
+func TestDecoderSetDisallowLossyNumbers(t *testing.T) {
+	type target struct {
+		F   float64
+		F32 float32
+		I   int64
+		Any interface{}
+	}
+	tests := []struct {
+		in     string
+		offset int64 // of the lossy number, or 0 if there is none
+	}{
+		{`{"F":9007199254740992}`, 0},
+		{`{"F":-9007199254740992}`, 0},
+		{`{"F":9007199254740993}`, 5},
+		{`{"F":-9007199254740993}`, 5},
+		{`{"F":9007199254740994}`, 0},
+		{`{"F":18014398509481985}`, 5},
+		{`{"F":123456789012345678901234567890}`, 5},
+		{`{"F":9007199254740993.0}`, 0},
+		{`{"F":1.5,"Any":0.1}`, 0},
+		{`{"F32":16777216}`, 0},
+		{`{"F32":16777217}`, 7},
+		{`{"I":9007199254740993}`, 0},
+		{`{"Any":9007199254740992}`, 0},
+		{`{"Any": 9007199254740993}`, 8},
+		{`{"Any":[1,{"x":9007199254740993}]}`, 15},
+	}
+	for _, tt := range tests {
+		var v target
+		dec := NewDecoder(strings.NewReader(tt.in))
+		dec.SetDisallowLossyNumbers(true)
+		err := dec.Decode(&v)
+		if tt.offset == 0 {
+			if err != nil {
+				t.Errorf("%s: Decode: %v", tt.in, err)
+			}
+		} else if te, ok := err.(*UnmarshalTypeError); !ok {
+			t.Errorf("%s: Decode error = %v, want UnmarshalTypeError", tt.in, err)
+		} else if !strings.HasPrefix(te.Value, "number ") || te.Offset != tt.offset {
+			t.Errorf("%s: UnmarshalTypeError Value = %q, Offset = %d, want the number at offset %d", tt.in, te.Value, te.Offset, tt.offset)
+		}
+
+		// By default the number is accepted, as it is in an interface{} with
+		// UseNumber.
+		if err := Unmarshal([]byte(tt.in), &v); err != nil {
+			t.Errorf("%s: Unmarshal: %v", tt.in, err)
+		}
+		if strings.HasPrefix(tt.in, `{"Any"`) {
+			dec := NewDecoder(strings.NewReader(tt.in))
+			dec.UseNumber()
+			dec.SetDisallowLossyNumbers(true)
+			if err := dec.Decode(&v); err != nil {
+				t.Errorf("%s: Decode with UseNumber: %v", tt.in, err)
+			}
+		}
+	}
+}
+
+func TestDecoderSetCaseSensitive(t *testing.T) {
+	type T struct {
+		Name  string `json:"Name"`
+		Count int
+		Alias string `json:"in=alias"`
+	}
+	const in = `{"name":"a","COUNT":1,"ALIAS":"x","Alias":"y"}`
+	decode := func(caseSensitive, disallowUnknown bool) (T, error) {
+		var v T
+		dec := NewDecoder(strings.NewReader(in))
+		dec.SetCaseSensitive(caseSensitive)
+		if disallowUnknown {
+			dec.DisallowUnknownFields()
+		}
+		err := dec.Decode(&v)
+		return v, err
+	}
+
+	got, err := decode(false, false)
+	if want := (T{"a", 1, "y"}); err != nil || got != want {
+		t.Errorf("Decode = %+v, %v, want %+v", got, err, want)
+	}
+	got, err = decode(true, false)
+	if want := (T{Alias: "y"}); err != nil || got != want {
+		t.Errorf("Decode with SetCaseSensitive(true) = %+v, %v, want %+v", got, err, want)
+	}
+	if _, err := decode(true, true); err == nil || err.Error() != `json: unknown field "name"` {
+		t.Errorf("Decode with SetCaseSensitive(true) and DisallowUnknownFields error = %v", err)
+	}
+
+	var v T
+	dec := NewDecoder(strings.NewReader(`{"Name":"b","Count":2,"alias":"z"}`))
+	dec.SetCaseSensitive(true)
+	if err := dec.Decode(&v); err != nil || v != (T{"b", 2, "z"}) {
+		t.Errorf("Decode of exact keys = %+v, %v", v, err)
+	}
+}
+
+func TestDecoderSetEmptyStringAsNull(t *testing.T) {
+	type T struct {
+		When  *time.Time
+		Name  string
+		PName *string
+		Any   interface{}
+		Times []*time.Time
+		ByKey map[string]*time.Time
+	}
+	const in = `{"When": "", "Name": "", "PName": "", "Any": "", "Times": ["", "2020-01-02T03:04:05Z"], "ByKey": {"a": ""}}`
+	now := time.Now()
+	pname := "x"
+	v := T{When: &now, Name: "x", PName: &pname}
+	dec := NewDecoder(strings.NewReader(in))
+	dec.SetEmptyStringAsNull(true)
+	if err := dec.Decode(&v); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if v.When != nil {
+		t.Errorf("Decode: When = %v, want nil", v.When)
+	}
+	if v.PName == nil || *v.PName != "" {
+		t.Errorf("Decode: PName = %v, want pointer to empty string", v.PName)
+	}
+	if v.Name != "" || v.Any != "" {
+		t.Errorf("Decode: Name = %q, Any = %#v, want empty strings", v.Name, v.Any)
+	}
+	if len(v.Times) != 2 || v.Times[0] != nil || v.Times[1] == nil || v.Times[1].Year() != 2020 {
+		t.Errorf("Decode: Times = %v, want [nil 2020-01-02...]", v.Times)
+	}
+	if p, ok := v.ByKey["a"]; !ok || p != nil {
+		t.Errorf("Decode: ByKey = %v, want a: nil", v.ByKey)
+	}
+
+	// A pointer passed to Decode directly.
+	p := &now
+	dec = NewDecoder(strings.NewReader(`""`))
+	dec.SetEmptyStringAsNull(true)
+	if err := dec.Decode(&p); err != nil || p != nil {
+		t.Errorf("Decode into **time.Time = %v, %v, want nil", p, err)
+	}
+	s := "x"
+	dec = NewDecoder(strings.NewReader(`""`))
+	dec.SetEmptyStringAsNull(true)
+	if err := dec.Decode(&s); err != nil || s != "" {
+		t.Errorf("Decode into *string = %q, %v, want empty string", s, err)
+	}
+	n := new(int)
+	dec = NewDecoder(strings.NewReader(`""`))
+	dec.SetEmptyStringAsNull(true)
+	if err := dec.Decode(&n); err == nil || n == nil {
+		t.Errorf("Decode into **int = %v, %v, want type error leaving pointer set", n, err)
+	}
+
+	// By default, the empty string is passed to the unmarshaler.
+	if err := NewDecoder(strings.NewReader(in)).Decode(&v); err == nil {
+		t.Error("Decode without SetEmptyStringAsNull: no error")
+	}
+}
+
+func TestDecoderSetTrackNulls(t *testing.T) {
+	type Inner struct {
+		N int `json:"n"`
+	}
+	type T struct {
+		A     int    `json:"a"`
+		B     int    `json:"b"`
+		S     string `json:"s"`
+		P     *int   `json:"p"`
+		In    Inner  `json:"in"`
+		Items []Inner
+	}
+	var paths []string
+	track := func(path string) { paths = append(paths, path) }
+
+	dec := NewDecoder(strings.NewReader(`{"a":null,"b":1}`))
+	dec.SetTrackNulls(track)
+	v := T{A: 5}
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a"}; !reflect.DeepEqual(paths, want) || v.A != 5 || v.B != 1 {
+		t.Errorf("Decode: paths = %q, v = %+v, want %q and A unchanged", paths, v, want)
+	}
+
+	paths = nil
+	const in = `{"s":null,"p":null,"in":{"n":null},"Items":[{"n":null},null]} null`
+	dec = NewDecoder(strings.NewReader(in))
+	dec.SetTrackNulls(track)
+	for i := 0; i < 2; i++ {
+		if err := dec.Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []string{"s", "in.n", "Items.n", "Items", ""}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("Decode: paths = %q, want %q", paths, want)
+	}
+}
+
+func TestDecodeAll(t *testing.T) {
+	collect := func(in string) ([]string, error) {
+		var vals []string
+		err := DecodeAll(strings.NewReader(in), func(m RawMessage) error {
+			vals = append(vals, string(m))
+			return nil
+		})
+		return vals, err
+	}
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{" \n\t ", nil},
+		{`{"a":1} {"b":2}` + "\n" + `{"c":3}`, []string{`{"a":1}`, `{"b":2}`, `{"c":3}`}},
+		{"\r\n[1]\n\n\t\"s\"  null 1 2.5{}[]\n", []string{`[1]`, `"s"`, `null`, `1`, `2.5`, `{}`, `[]`}},
+	}
+	for _, tt := range tests {
+		vals, err := collect(tt.in)
+		if err != nil || !reflect.DeepEqual(vals, tt.want) {
+			t.Errorf("DecodeAll(%q) = %q, %v, want %q", tt.in, vals, err, tt.want)
+		}
+	}
+
+	// The first syntax error is reported at its offset in the stream, after
+	// the values before it.
+	vals, err := collect(`{"a":1} {"b":2}` + "\n" + `{"c":x} {"d":4}`)
+	want := &SyntaxError{"invalid character 'x' looking for beginning of value", 22}
+	if !reflect.DeepEqual(err, want) || len(vals) != 2 {
+		t.Errorf("DecodeAll = %q, %#v, want 2 values and %#v", vals, err, want)
+	}
+	if _, err := collect(`{"a":1} {"b":`); err != io.ErrUnexpectedEOF {
+		t.Errorf("DecodeAll of truncated input: error = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+
+	// Errors from fn stop the iteration, and values may be retained.
+	stop := errors.New("stop")
+	var kept []RawMessage
+	err = DecodeAll(strings.NewReader(`1 2 3`), func(m RawMessage) error {
+		kept = append(kept, m)
+		if len(kept) == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || len(kept) != 2 || string(kept[0]) != "1" || string(kept[1]) != "2" {
+		t.Errorf("DecodeAll = %q, %v, want [1 2] and stop", kept, err)
+	}
+}
+
+func TestTransform(t *testing.T) {
+	camel := func(s string) string {
+		parts := strings.Split(s, "_")
+		for i := 1; i < len(parts); i++ {
+			if parts[i] != "" {
+				parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+			}
+		}
+		return strings.Join(parts, "")
+	}
+	tests := []struct {
+		in, want string
+	}{
+		{`{"user_id": 1, "first_name": "snake_case_value"}`, `{"userId":1,"firstName":"snake_case_value"}` + "\n"},
+		{`{"a_b": {"c_d": [{"e_f": [1.50, 1e3, -0, 12345678901234567890]}, "g_h"]}, "empty_obj": {}, "empty_arr": []}`,
+			`{"aB":{"cD":[{"eF":[1.50,1e3,-0,12345678901234567890]},"g_h"]},"emptyObj":{},"emptyArr":[]}` + "\n"},
+		{`["a_b", {"x_y": null}, true] "top_level" 3 {"k_1": "<&>"}`, `["a_b",{"xY":null},true]` + "\n" + `"top_level"` + "\n" + `3` + "\n" + `{"k1":"<&>"}` + "\n"},
+		{``, ``},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := Transform(&buf, strings.NewReader(tt.in), camel); err != nil {
+			t.Errorf("Transform(%#q): %v", tt.in, err)
+			continue
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("Transform(%#q):\n\tgot:  %#q\n\twant: %#q", tt.in, got, tt.want)
+		}
+	}
+
+	var buf bytes.Buffer
+	err := Transform(&buf, strings.NewReader(`{"a": [1, 2}`), camel)
+	if _, ok := err.(*SyntaxError); !ok {
+		t.Errorf("Transform of invalid input: error = %v, want *SyntaxError", err)
+	}
+}
+
+func TestDecoderSetMaxDepth(t *testing.T) {
+	nested := func(n int) string {
+		return strings.Repeat("[", n) + strings.Repeat("]", n)
+	}
+	depthErr := func(offset int64) error {
+		return &SyntaxError{"exceeds maximum nesting depth", offset}
+	}
+
+	var v interface{}
+	if err := Unmarshal([]byte(nested(DefaultMaxDepth)), &v); err != nil {
+		t.Errorf("Unmarshal at DefaultMaxDepth: %v", err)
+	}
+	deep := nested(DefaultMaxDepth + 1)
+	if err := Unmarshal([]byte(deep), &v); !reflect.DeepEqual(err, depthErr(DefaultMaxDepth+1)) {
+		t.Errorf("Unmarshal beyond DefaultMaxDepth: error = %#v", err)
+	}
+	if Valid([]byte(deep)) {
+		t.Error("Valid beyond DefaultMaxDepth = true, want false")
+	}
+
+	tests := []struct {
+		max int
+		in  string
+		err error
+	}{
+		{0, deep, depthErr(DefaultMaxDepth + 1)},
+		{3, `[{"a": [1]}]`, nil},
+		{3, ` [{"a": [[1]]}]`, depthErr(10)},
+		{-1, nested(3 * DefaultMaxDepth), nil},
+	}
+	for _, tt := range tests {
+		dec := NewDecoder(strings.NewReader(tt.in))
+		dec.SetMaxDepth(tt.max)
+		var v interface{}
+		if err := dec.Decode(&v); !reflect.DeepEqual(err, tt.err) {
+			t.Errorf("SetMaxDepth(%d): Decode(%.20q) error = %#v, want %#v", tt.max, tt.in, err, tt.err)
+		}
+	}
+
+	dec := NewDecoder(strings.NewReader(`[[[1]]]`))
+	dec.SetMaxDepth(2)
+	for i := 0; i < 2; i++ {
+		if _, err := dec.Token(); err != nil {
+			t.Fatalf("Token: %v", err)
+		}
+	}
+	if _, err := dec.Token(); !reflect.DeepEqual(err, depthErr(3)) {
+		t.Errorf("Token beyond maximum depth: error = %#v, want %#v", err, depthErr(3))
+	}
+}
+
+func TestDecoderSetMaxElements(t *testing.T) {
+	elemErr := func(offset int64) error {
+		return &SyntaxError{"exceeds maximum number of elements", offset}
+	}
+	const n = 5
+	tests := []struct {
+		in  string
+		err error
+	}{
+		{`[1, 2, {"a": 3}, []]`, nil}, // exactly n
+		{`[1, 2, {"a": 3}, [4]]`, elemErr(19)},
+		{` {"a": {}, "b": [], "c": {"d": 1, "e": 2, "f": 3}}`, elemErr(43)},
+		{`[[],[],[],[],[],[]]`, elemErr(17)},
+		{`"not a container"`, nil},
+	}
+	for _, tt := range tests {
+		dec := NewDecoder(strings.NewReader(tt.in))
+		dec.SetMaxElements(n)
+		var v interface{}
+		if err := dec.Decode(&v); !reflect.DeepEqual(err, tt.err) {
+			t.Errorf("Decode(%q) error = %#v, want %#v", tt.in, err, tt.err)
+		}
+	}
+
+	// The count starts again for each value, and zero removes the limit.
+	dec := NewDecoder(strings.NewReader(`[1, 2, 3] [4, 5, 6] [7, 8, 9, 10]`))
+	dec.SetMaxElements(3)
+	var v []int
+	for i := 0; i < 2; i++ {
+		if err := dec.Decode(&v); err != nil {
+			t.Fatalf("Decode #%d: %v", i, err)
+		}
+	}
+	dec.SetMaxElements(0)
+	if err := dec.Decode(&v); err != nil || len(v) != 4 {
+		t.Errorf("Decode without limit = %v, %v", v, err)
+	}
+}
+
+func TestDecoderAllowTrailingCommas(t *testing.T) {
+	tests := []struct {
+		in   string
+		want interface{}
+		err  string
+	}{
+		{in: `[1, 2,]`, want: []interface{}{1.0, 2.0}},
+		{in: `{"a": 1, "b": 2 , }`, want: map[string]interface{}{"a": 1.0, "b": 2.0}},
+		{in: `{"a": [[1,], {"b": {},},], }`, want: map[string]interface{}{
+			"a": []interface{}{[]interface{}{1.0}, map[string]interface{}{"b": map[string]interface{}{}}},
+		}},
+		{in: `[1,,]`, err: "invalid character ',' looking for beginning of value"},
+		{in: `[1,,2]`, err: "invalid character ',' looking for beginning of value"},
+		{in: `{"a":1,,}`, err: "invalid character ',' looking for beginning of object key string"},
+		{in: `[,]`, err: "invalid character ',' looking for beginning of value"},
+		{in: `{,}`, err: "invalid character ',' looking for beginning of object key string"},
+		{in: `{"a",}`, err: "invalid character ',' after object key"},
+	}
+	for _, tt := range tests {
+		dec := NewDecoder(strings.NewReader(tt.in))
+		dec.AllowTrailingCommas(true)
+		var v interface{}
+		err := dec.Decode(&v)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("Decode(%#q) error = %v, want %s", tt.in, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Decode(%#q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(v, tt.want) {
+			t.Errorf("Decode(%#q) = %#v, want %#v", tt.in, v, tt.want)
+		}
+
+		// Decoding into typed values works too.
+		dec = NewDecoder(strings.NewReader(tt.in))
+		dec.AllowTrailingCommas(true)
+		typed := reflect.New(reflect.TypeOf(tt.want))
+		if err := dec.Decode(typed.Interface()); err != nil {
+			t.Errorf("Decode(%#q) into %v: %v", tt.in, typed.Type(), err)
+		}
+
+		// The option is off by default.
+		dec = NewDecoder(strings.NewReader(tt.in))
+		if err := dec.Decode(&v); err == nil {
+			t.Errorf("Decode(%#q) without AllowTrailingCommas: expected error", tt.in)
+		}
+	}
+
+	dec := NewDecoder(strings.NewReader(`{"a": [1, {},], "b": true,}`))
+	dec.AllowTrailingCommas(true)
+	var toks []Token
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Token: %v", err)
+		}
+		toks = append(toks, tok)
+	}
+	want := []Token{Delim('{'), "a", Delim('['), 1.0, Delim('{'), Delim('}'), Delim(']'), "b", true, Delim('}')}
+	if !reflect.DeepEqual(toks, want) {
+		t.Errorf("Token = %v, want %v", toks, want)
+	}
+
+	dec = NewDecoder(strings.NewReader(`[1,]`))
+	var err error
+	for err == nil {
+		_, err = dec.Token()
+	}
+	if se, ok := err.(*SyntaxError); !ok || se.Error() != "invalid character ']' looking for beginning of value" {
+		t.Errorf("Token without AllowTrailingCommas: err = %v", err)
+	}
+}
+
+func TestDecoderAllowExtendedNumbers(t *testing.T) {
+	type T struct {
+		I   int
+		I8  int8
+		U   uint16
+		Any interface{}
+		N   Number
+		F   float64
+	}
+	tests := []struct {
+		in   string
+		want T
+		err  string
+	}{
+		{in: `{"I": 0xFF, "I8": -0x80, "U": 0o755, "Any": 0b101, "N": 0XfF}`, want: T{I: 255, I8: -128, U: 0755, Any: 5.0, N: "255"}},
+		{in: `{"I": -0b1, "U": 0B0, "Any": -0O17}`, want: T{I: -1, U: 0, Any: -15.0}},
+		{in: `{"I": 0x0, "N": -0x0}`, want: T{N: "0"}},
+		{in: `{"I8": 0x80}`, err: "json: cannot unmarshal number 0x80 into Go struct field T.I8 of type int8"},
+		{in: `{"U": -0x1}`, err: "json: cannot unmarshal number -0x1 into Go struct field T.U of type uint16"},
+		{in: `{"I": 0x10000000000000000}`, err: "json: cannot unmarshal number 0x10000000000000000 into Go struct field T.I of type int"},
+		{in: `{"F": 0x10}`, err: "json: cannot unmarshal number 0x10 into Go struct field T.F of type float64"},
+		{in: `{"I": 0x}`, err: "invalid character '}' after hexadecimal prefix in numeric literal"},
+		{in: `{"I": 0o8}`, err: "invalid character '8' after octal prefix in numeric literal"},
+		{in: `{"I": 0b12}`, err: "invalid character '2' after object key:value pair"},
+		{in: `{"I": 0x_1}`, err: "invalid character '_' after hexadecimal prefix in numeric literal"},
+		{in: `{"I": 1x1}`, err: "invalid character 'x' after object key:value pair"},
+		{in: `{"F": 0x1p4}`, err: "invalid character 'p' after object key:value pair"},
+	}
+	for _, tt := range tests {
+		var v T
+		dec := NewDecoder(strings.NewReader(tt.in))
+		dec.AllowExtendedNumbers(true)
+		err := dec.Decode(&v)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("Decode(%#q) error = %v, want %s", tt.in, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Decode(%#q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(v, tt.want) {
+			t.Errorf("Decode(%#q) = %#v, want %#v", tt.in, v, tt.want)
+		}
+
+		// The option is off by default.
+		if err := NewDecoder(strings.NewReader(tt.in)).Decode(&v); err == nil {
+			t.Errorf("Decode(%#q) without AllowExtendedNumbers: expected error", tt.in)
+		}
+	}
+
+	// Numbers in interfaces are converted to decimal, whatever the
+	// number mode, and Token returns them likewise.
+	dec := NewDecoder(strings.NewReader(`[0xffffffffffffffffff, 0o10] [0x10]`))
+	dec.AllowExtendedNumbers(true)
+	dec.SetNumberMode(NumberModeNumber)
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if want := []interface{}{Number("4722366482869645213695"), Number("8")}; !reflect.DeepEqual(v, want) {
+		t.Errorf("Decode = %#v, want %#v", v, want)
+	}
+	var toks []Token
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Token: %v", err)
+		}
+		toks = append(toks, tok)
+	}
+	if want := []Token{Delim('['), Number("16"), Delim(']')}; !reflect.DeepEqual(toks, want) {
+		t.Errorf("Token = %v, want %v", toks, want)
+	}
+
+	// A RawMessage receives the number as written.
+	var raw []RawMessage
+	dec = NewDecoder(strings.NewReader(`[0x1F]`))
+	dec.AllowExtendedNumbers(true)
+	if err := dec.Decode(&raw); err != nil || len(raw) != 1 || string(raw[0]) != "0x1F" {
+		t.Errorf("Decode into []RawMessage = %q, %v", raw, err)
+	}
+}
+
+func TestDecoderAllowLeadingZeros(t *testing.T) {
+	type T struct {
+		I   int
+		U   uint8
+		F   float64
+		Any interface{}
+		N   Number
+	}
+	tests := []struct {
+		in   string
+		want T
+	}{
+		{`{"I": 0123, "U": 00, "F": 0.5, "Any": -007, "N": 0010}`, T{I: 123, U: 0, F: 0.5, Any: -7.0, N: "10"}},
+		{`{"I": -0010, "F": 00.25, "Any": 0.5, "N": -000.5e1}`, T{I: -10, F: 0.25, Any: 0.5, N: "-0.5e1"}},
+		{`{"I": 08, "F": 0009e2, "N": 0}`, T{I: 8, F: 900, N: "0"}},
+	}
+	for _, tt := range tests {
+		var v T
+		dec := NewDecoder(strings.NewReader(tt.in))
+		dec.AllowLeadingZeros(true)
+		if err := dec.Decode(&v); err != nil {
+			t.Errorf("Decode(%#q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(v, tt.want) {
+			t.Errorf("Decode(%#q) = %#v, want %#v", tt.in, v, tt.want)
+		}
+
+		// Strict mode, the default, still rejects the input.
+		err := NewDecoder(strings.NewReader(tt.in)).Decode(&v)
+		if _, ok := err.(*SyntaxError); !ok {
+			t.Errorf("Decode(%#q) without AllowLeadingZeros: err = %v, want SyntaxError", tt.in, err)
+		}
+	}
+
+	// Numbers in an interface{} are re-encoded without their leading zeros.
+	dec := NewDecoder(strings.NewReader(`[0123, -00.5, 0] 007`))
+	dec.AllowLeadingZeros(true)
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if out, err := Marshal(v); err != nil || string(out) != `[123,-0.5,0]` {
+		t.Errorf("Marshal of decoded value = %s, %v, want [123,-0.5,0]", out, err)
+	}
+	if tok, err := dec.Token(); err != nil || tok != Number("7") {
+		t.Errorf("Token = %v, %v, want 7", tok, err)
+	}
+
+	// Leading zeros remain invalid elsewhere.
+	for _, in := range []string{`[0x1]`, `[01.]`, `[00-1]`, `[0.05.1]`} {
+		dec := NewDecoder(strings.NewReader(in))
+		dec.AllowLeadingZeros(true)
+		if err := dec.Decode(&v); err == nil {
+			t.Errorf("Decode(%#q): no error", in)
+		}
+	}
+}
+
+type sunkComment struct {
+	offset int64
+	text   string
+	block  bool
+}
+
+func TestDecoderSetCommentSink(t *testing.T) {
+	const in = "// header\n{\"a\": /* one */ 1, \"s\": \"/* not */\", /**/\n\"b\": [2 /* two\n * lines */]} // end"
+	want := []sunkComment{
+		{0, " header", false},
+		{16, " one ", true},
+		{47, "", true},
+		{60, " two\n * lines ", true},
+		{81, " end", false},
+	}
+	for _, r := range []io.Reader{strings.NewReader(in), iotest.OneByteReader(strings.NewReader(in))} {
+		var got []sunkComment
+		dec := NewDecoder(r)
+		dec.AllowComments(true)
+		dec.SetCommentSink(func(offset int64, text string, block bool) {
+			got = append(got, sunkComment{offset, text, block})
+		})
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		if err := dec.Decode(&v); err != io.EOF {
+			t.Fatalf("Decode at end of input: %v, want io.EOF", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("comments = %+v, want %+v", got, want)
+		}
+		for _, c := range got {
+			prefix := "//"
+			if c.block {
+				prefix = "/*"
+			}
+			if !strings.HasPrefix(in[c.offset:], prefix+c.text) {
+				t.Errorf("comment %q does not begin at offset %d", c.text, c.offset)
+			}
+		}
+	}
+}
+
+func TestDecoderAllowComments(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []interface{}
+		err  string
+	}{
+		{
+			in:   "// header\n{\"a\": 1, // one\n\"b\": \"x//y/*z*/\" /* two\n*/ }",
+			want: []interface{}{map[string]interface{}{"a": 1.0, "b": "x//y/*z*/"}},
+		},
+		{in: `/**/[1/* * / ** */,2]/*/ */3//`, want: []interface{}{[]interface{}{1.0, 2.0}, 3.0}},
+		{in: `"\"//" "\\" //"`, want: []interface{}{`"//`, `\`}},
+		{in: `1 /* unterminated`, want: []interface{}{1.0}, err: "unterminated block comment"},
+		{in: `1 /* unterminated *`, want: []interface{}{1.0}, err: "unterminated block comment"},
+		{in: `[1 / 2]`, err: "invalid character '/' after array element"},
+		{in: `[1, /2]`, err: "invalid character '/' looking for beginning of value"},
+		{in: `1 /`, want: []interface{}{1.0}, err: "invalid character '/' looking for beginning of value"},
+	}
+	for _, tt := range tests {
+		for _, oneByte := range []bool{false, true} {
+			var r io.Reader = strings.NewReader(tt.in)
+			if oneByte {
+				r = iotest.OneByteReader(r)
+			}
+			dec := NewDecoder(r)
+			dec.AllowComments(true)
+			var got []interface{}
+			var err error
+			for {
+				var v interface{}
+				if err = dec.Decode(&v); err != nil {
+					break
+				}
+				got = append(got, v)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode(%#q) (oneByte=%v) = %#v, want %#v", tt.in, oneByte, got, tt.want)
+			}
+			if tt.err == "" {
+				if err != io.EOF {
+					t.Errorf("Decode(%#q) (oneByte=%v) error = %v, want EOF", tt.in, oneByte, err)
+				}
+			} else if _, ok := err.(*SyntaxError); !ok || err.Error() != tt.err {
+				t.Errorf("Decode(%#q) (oneByte=%v) error = %#v, want SyntaxError %q", tt.in, oneByte, err, tt.err)
+			}
+		}
+	}
+
+	dec := NewDecoder(strings.NewReader(`1 /* unterminated`))
+	dec.AllowComments(true)
+	var v interface{}
+	dec.Decode(&v)
+	if err := dec.Decode(&v); err == nil || err.(*SyntaxError).Offset != 2 {
+		t.Errorf("Decode error = %#v, want offset 2", err)
+	}
+
+	dec = NewDecoder(strings.NewReader("{/* a */\"a\"// b\n:[1,/**/2]}"))
+	dec.AllowComments(true)
+	var toks []Token
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Token: %v", err)
+		}
+		toks = append(toks, tok)
+	}
+	want := []Token{Delim('{'), "a", Delim('['), 1.0, 2.0, Delim(']'), Delim('}')}
+	if !reflect.DeepEqual(toks, want) {
+		t.Errorf("Token = %v, want %v", toks, want)
+	}
+
+	// The option is off by default.
+	dec = NewDecoder(strings.NewReader(`[1 /* c */]`))
+	if err := dec.Decode(&v); err == nil {
+		t.Errorf("Decode without AllowComments: expected error")
+	}
+}
+
+func TestDecoderSetJSONLines(t *testing.T) {
+	tests := []struct {
+		in  string
+		n   int // values decoded successfully
+		err *SyntaxError
+	}{
+		{in: "{\"a\":1}\n[2]\n3\n", n: 3},
+		{in: "  1  \r\n\n   \n\t2", n: 2},
+		{in: "", n: 0},
+		{in: "1 2\n", n: 1, err: &SyntaxError{"JSON Lines value does not begin on a new line", 2}},
+		{in: "[1]\n{\"a\":\n1}\n", n: 1, err: &SyntaxError{"newline within JSON Lines value", 9}},
+		{in: "[1,\n2]", n: 0, err: &SyntaxError{"newline within JSON Lines value", 3}},
+	}
+	for _, tt := range tests {
+		dec := NewDecoder(strings.NewReader(tt.in))
+		dec.SetJSONLines(true)
+		var n int
+		var err error
+		for {
+			var v interface{}
+			if err = dec.Decode(&v); err != nil {
+				break
+			}
+			n++
+		}
+		if n != tt.n {
+			t.Errorf("%q: decoded %d values, want %d", tt.in, n, tt.n)
+		}
+		if tt.err == nil {
+			if err != io.EOF {
+				t.Errorf("%q: err = %v, want EOF", tt.in, err)
+			}
+			continue
+		}
+		if se, ok := err.(*SyntaxError); !ok || *se != *tt.err {
+			t.Errorf("%q: err = %#v, want %#v", tt.in, err, tt.err)
+		}
+	}
+
+	// Without SetJSONLines, the same input is accepted.
+	dec := NewDecoder(strings.NewReader("1 [1,\n2]"))
+	var v interface{}
+	for i := 0; i < 2; i++ {
+		if err := dec.Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDecoderSetContinueOnError(t *testing.T) {
+	type rec struct {
+		ID int `json:"id"`
+	}
+	const lines = `{"id": 1}
+{"id": 2, "note": "}}{{"
+{"id": "three"}
+{{{ ]]] }
+
+{"id": 4, "a": {"b": [}
+  {"id": 5}
+{"id": 6}
+{"id": 7`
+	dec := NewDecoder(iotest.OneByteReader(strings.NewReader(lines)))
+	dec.SetJSONLines(true)
+	dec.SetContinueOnError(true)
+	var ids []int
+	failures := 0
+	for {
+		var r rec
+		err := dec.Decode(&r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if failures++; failures > 10 {
+				t.Fatalf("Decode did not move past error %v", err)
+			}
+			continue
 		}
-		buf.Reset()
-		enc.SetEscapeHTML(false)
-		enc.SetDirectWrite(true)
-		if err := enc.Encode(tt.v); err != nil {
-			t.Fatalf("SetEscapeHTML(false) SetDirectWrite(true) Encode(%s): %s", tt.name, err)
+		ids = append(ids, r.ID)
+	}
+	if want := []int{1, 5, 6}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("JSON Lines: decoded %v, want %v", ids, want)
+	}
+	errs := dec.Errors()
+	if len(errs) != 5 || failures != len(errs) {
+		t.Fatalf("JSON Lines: %d failures, Errors() = %v, want 5", failures, errs)
+	}
+	if _, ok := errs[1].(*UnmarshalTypeError); !ok {
+		t.Errorf("Errors()[1] = %#v, want UnmarshalTypeError", errs[1])
+	}
+	if se, ok := errs[2].(*SyntaxError); !ok || se.Offset != int64(strings.Index(lines, "{{{")+2) {
+		t.Errorf("Errors()[2] = %#v, want SyntaxError at the second {", errs[2])
+	}
+	if errs[4] != io.ErrUnexpectedEOF {
+		t.Errorf("Errors()[4] = %v, want io.ErrUnexpectedEOF", errs[4])
+	}
+	errs[0] = nil
+	if dec.Errors()[0] == nil {
+		t.Error("modifying the result of Errors changed the Decoder")
+	}
+
+	// Otherwise, decoding resumes at a line beginning with { or [.
+	const stream = `{
+	"id": 1
+}
+{
+	"id": 2,
+	"bad": {"x": ]},
+	"stray": "}"
+}
+[{"id": 9}] and this is not JSON
+{
+	"id": 3
+}
+`
+	dec = NewDecoder(strings.NewReader(stream))
+	dec.SetContinueOnError(true)
+	var got []interface{}
+	for i := 0; i < 10; i++ {
+		var v interface{}
+		err := dec.Decode(&v)
+		if err == io.EOF {
+			break
 		}
-		if got := strings.TrimSpace(buf.String()); got != tt.want {
-			t.Errorf("SetEscapeHTML(false) SetDirectWrite(true) Encode(%s) = %#q, want %#q",
-				tt.name, got, tt.want)
+		if err == nil {
+			got = append(got, v)
+		}
+	}
+	want := []interface{}{
+		map[string]interface{}{"id": 1.0},
+		[]interface{}{map[string]interface{}{"id": 9.0}},
+		map[string]interface{}{"id": 3.0},
+	}
+	if !reflect.DeepEqual(got, want) || len(dec.Errors()) != 2 {
+		t.Errorf("decoded %v with errors %v, want %v and 2 errors", got, dec.Errors(), want)
+	}
+
+	// By default, a syntax error ends the stream.
+	dec = NewDecoder(strings.NewReader(lines))
+	dec.SetJSONLines(true)
+	var r rec
+	if err := dec.Decode(&r); err != nil {
+		t.Fatal(err)
+	}
+	err := dec.Decode(&r)
+	if err == nil || dec.Decode(&r) != err || dec.Errors() != nil {
+		t.Errorf("without SetContinueOnError: Decode = %v, then %v, Errors() = %v", err, dec.Decode(&r), dec.Errors())
+	}
+}
+
+type localeKey struct{}
+
+// ctxGreeting implements MarshalerContext and Marshaler.
+type ctxGreeting struct{}
+
+func (ctxGreeting) MarshalJSON() ([]byte, error) {
+	return []byte(`"hello"`), nil
+}
+
+func (ctxGreeting) MarshalJSONContext(ctx context.Context) ([]byte, error) {
+	if ctx.Value(localeKey{}) == "fr" {
+		return []byte(`"bonjour"`), nil
+	}
+	return []byte(`"hello"`), nil
+}
+
+// ctxPtrGreeting implements MarshalerContext on its pointer type only, and
+// does not implement Marshaler.
+type ctxPtrGreeting struct {
+	Name string
+}
+
+func (g *ctxPtrGreeting) MarshalJSONContext(ctx context.Context) ([]byte, error) {
+	locale, _ := ctx.Value(localeKey{}).(string)
+	return []byte(fmt.Sprintf(`"%s:%s"`, locale, g.Name)), nil
+}
+
+func TestEncoderEncodeContext(t *testing.T) {
+	type T struct {
+		G  ctxGreeting
+		P  ctxPtrGreeting
+		PP *ctxPtrGreeting
+		I  interface{}
+	}
+	v := &T{G: ctxGreeting{}, P: ctxPtrGreeting{"a"}, I: ctxGreeting{}}
+	fr := context.WithValue(context.Background(), localeKey{}, "fr")
+
+	tests := []struct {
+		ctx  context.Context
+		v    interface{}
+		want string
+	}{
+		{nil, v, `{"G":"hello","P":{"Name":"a"},"PP":null,"I":"hello"}`},
+		{context.Background(), v, `{"G":"hello","P":":a","PP":null,"I":"hello"}`},
+		{fr, v, `{"G":"bonjour","P":"fr:a","PP":null,"I":"bonjour"}`},
+		{fr, T{P: ctxPtrGreeting{"a"}}, `{"G":"bonjour","P":{"Name":"a"},"PP":null,"I":null}`},
+		{fr, []int{1, 2}, `[1,2]`},
+	}
+	for _, direct := range []bool{false, true} {
+		for i, tt := range tests {
+			var buf bytes.Buffer
+			enc := NewEncoder(&buf)
+			enc.SetDirectWrite(direct)
+			if err := enc.EncodeContext(tt.ctx, tt.v); err != nil {
+				t.Fatalf("#%d: EncodeContext: %v", i, err)
+			}
+			if got, want := buf.String(), tt.want+"\n"; got != want {
+				t.Errorf("#%d (direct=%v): EncodeContext = %q, want %q", i, direct, got, want)
+			}
 		}
 	}
 }
@@ -339,6 +3158,46 @@ func TestNullRawMessage(t *testing.T) {
 	}
 }
 
+func TestPreEncoded(t *testing.T) {
+	type cached struct {
+		A PreEncoded
+		B *PreEncoded
+		C interface{}
+		D PreEncoded
+	}
+	frag := PreEncoded(`{"x": [1, 2], "h": "<b>"}`)
+	v := cached{A: frag, B: &frag, C: frag}
+	const want = `{"A":{"x": [1, 2], "h": "<b>"},"B":{"x": [1, 2], "h": "<b>"},"C":{"x": [1, 2], "h": "<b>"},"D":null}`
+	b, err := Marshal(v)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if string(b) != want {
+		t.Errorf("Marshal:\n\tgot:  %s\n\twant: %s", b, want)
+	}
+
+	for _, bad := range []PreEncoded{PreEncoded(`{"x":`), PreEncoded(`1 2`), PreEncoded(``)} {
+		_, err := Marshal(cached{A: bad})
+		var serr *SyntaxError
+		if !errors.As(err, &serr) {
+			t.Errorf("Marshal(%#q) error = %v, want *SyntaxError", bad, err)
+		}
+		if _, err := bad.MarshalJSON(); err == nil {
+			t.Errorf("%#q.MarshalJSON: no error", bad)
+		}
+
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetTrustPreEncoded(true)
+		if err := enc.Encode(cached{A: bad}); err != nil {
+			t.Errorf("Encode(%#q) with SetTrustPreEncoded: %v", bad, err)
+		}
+		if got, want := buf.String(), `{"A":`+string(bad)+`,"B":null,"C":null,"D":null}`+"\n"; got != want {
+			t.Errorf("Encode with SetTrustPreEncoded:\n\tgot:  %s\twant: %s", got, want)
+		}
+	}
+}
+
 var blockingTests = []string{
 	`{"x": 1}`,
 	`[1, 2, 3]`,
@@ -490,6 +3349,539 @@ func TestDecodeInStream(t *testing.T) {
 	}
 }
 
+// typedToken returns the token that Token returns for the kind and text
+// returned by TokenTyped.
+func typedToken(kind TokenKind, text []byte) (Token, error) {
+	switch kind {
+	case TokenKindObjectStart:
+		return Delim('{'), nil
+	case TokenKindArrayStart:
+		return Delim('['), nil
+	case TokenKindKey, TokenKindString:
+		return string(text), nil
+	case TokenKindNumber:
+		return strconv.ParseFloat(string(text), 64)
+	case TokenKindTrue:
+		return true, nil
+	case TokenKindFalse:
+		return false, nil
+	case TokenKindNull:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unexpected kind %d", kind)
+}
+
+func TestDecoderTokenTyped(t *testing.T) {
+	for ci, tcase := range tokenStreamCases {
+		dec := NewDecoder(strings.NewReader(tcase.json))
+		// Delimiters are checked by kind; Token would also tell { and [
+		// apart from } and ].
+		var stack []byte
+		for i, etk := range tcase.expTokens {
+			var tk interface{}
+			var err error
+			if dt, ok := etk.(decodeThis); ok {
+				etk = dt.v
+				err = dec.Decode(&tk)
+			} else {
+				var kind TokenKind
+				var text []byte
+				kind, text, err = dec.TokenTyped()
+				if err == nil && kind == TokenKindEnd {
+					tk = Delim(stack[len(stack)-1] + 2)
+					stack = stack[:len(stack)-1]
+				} else if err == nil {
+					if tk, err = typedToken(kind, text); tk == Delim('{') || tk == Delim('[') {
+						stack = append(stack, byte(tk.(Delim)))
+					}
+				}
+			}
+			if experr, ok := etk.(error); ok {
+				if !reflect.DeepEqual(err, experr) {
+					t.Errorf("case %v: error = %#v in %q, want %#v", ci, err, tcase.json, experr)
+				}
+				break
+			}
+			if err != nil {
+				t.Errorf("case %v: %q @ %v: %v", ci, tcase.json, i, err)
+				break
+			}
+			if !reflect.DeepEqual(tk, etk) {
+				t.Errorf("case %v: %q @ %v: got %T(%v), want %T(%v)", ci, tcase.json, i, tk, tk, etk, etk)
+				break
+			}
+		}
+	}
+
+	type typed struct {
+		kind TokenKind
+		text string
+	}
+	const in = `{"k\u00e9y": "a\nb", "n": [1.50, -0, 2e3], "t": true, "f": false, "z": null} 7`
+	want := []typed{
+		{TokenKindObjectStart, ""},
+		{TokenKindKey, "k\u00e9y"}, {TokenKindString, "a\nb"},
+		{TokenKindKey, "n"}, {TokenKindArrayStart, ""},
+		{TokenKindNumber, "1.50"}, {TokenKindNumber, "-0"}, {TokenKindNumber, "2e3"},
+		{TokenKindEnd, ""},
+		{TokenKindKey, "t"}, {TokenKindTrue, ""},
+		{TokenKindKey, "f"}, {TokenKindFalse, ""},
+		{TokenKindKey, "z"}, {TokenKindNull, ""},
+		{TokenKindEnd, ""},
+		{TokenKindNumber, "7"},
+	}
+	dec := NewDecoder(iotest.OneByteReader(strings.NewReader(in)))
+	var got []typed
+	var offsets []int64
+	for {
+		kind, text, err := dec.TokenTyped()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("TokenTyped: %v", err)
+		}
+		got = append(got, typed{kind, string(text)})
+		offsets = append(offsets, dec.TokenOffset())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TokenTyped:\n got %v\nwant %v", got, want)
+	}
+	if n := len(offsets); n < 3 || offsets[1] != 1 || offsets[2] != 13 || offsets[n-1] != int64(len(in)-1) {
+		t.Errorf("TokenOffset = %v", offsets)
+	}
+
+	dec = NewDecoder(strings.NewReader(`{"a": 1, "a": 2}`))
+	dec.DisallowDuplicateKeys(true)
+	var err error
+	for i := 0; i < 4 && err == nil; i++ {
+		_, _, err = dec.TokenTyped()
+	}
+	if de, ok := err.(*DuplicateKeyError); !ok || de.Key != "a" || de.Offset != 9 {
+		t.Errorf("TokenTyped with duplicate key: error = %#v", err)
+	}
+	if kind, text, err := NewDecoder(strings.NewReader(`[}`)).TokenTyped(); kind != TokenKindArrayStart || text != nil || err != nil {
+		t.Errorf("TokenTyped = %v, %q, %v", kind, text, err)
+	}
+
+	// Malformed input gives the same errors as with Token.
+	for _, in := range []string{`[tru]`, `[1 2]`, `{"a" 1}`, `{"a":1x}`, `["a\qb"]`, `[1,]`, `{"a":"b`, `12`} {
+		var typedErr, tokenErr error
+		dec := NewDecoder(strings.NewReader(in))
+		for typedErr == nil {
+			_, _, typedErr = dec.TokenTyped()
+		}
+		dec = NewDecoder(strings.NewReader(in))
+		for tokenErr == nil {
+			_, tokenErr = dec.Token()
+		}
+		if !reflect.DeepEqual(typedErr, tokenErr) {
+			t.Errorf("TokenTyped(%q) error = %#v, want %#v", in, typedErr, tokenErr)
+		}
+	}
+}
+
+func TestDecoderPeek(t *testing.T) {
+	for ci, tcase := range tokenStreamCases {
+		dec := NewDecoder(strings.NewReader(tcase.json))
+		for i, etk := range tcase.expTokens {
+			if _, ok := etk.(error); ok {
+				break
+			}
+			dt, decode := etk.(decodeThis)
+			if decode {
+				etk = dt.v
+				if _, ok := etk.(error); ok {
+					break
+				}
+			}
+			// Peeking twice must not consume anything.
+			for j := 0; j < 2; j++ {
+				pk, err := dec.Peek()
+				if err != nil {
+					t.Fatalf("case %v: %q @ %v: Peek: %v", ci, tcase.json, i, err)
+				}
+				if !decode && !reflect.DeepEqual(pk, etk) {
+					t.Fatalf("case %v: %q @ %v: Peek = %T(%v), want %T(%v)", ci, tcase.json, i, pk, pk, etk, etk)
+				}
+			}
+			var tk interface{}
+			var err error
+			if decode {
+				err = dec.Decode(&tk)
+			} else {
+				tk, err = dec.Token()
+			}
+			if err != nil {
+				t.Fatalf("case %v: %q @ %v: %v", ci, tcase.json, i, err)
+			}
+			if !reflect.DeepEqual(tk, etk) {
+				t.Fatalf("case %v: %q @ %v: got %T(%v) after Peek, want %T(%v)", ci, tcase.json, i, tk, tk, etk, etk)
+			}
+		}
+	}
+
+	// Dispatch on the type of each element.
+	dec := NewDecoder(strings.NewReader(`[{"a":1}, [2], "three", 4] `))
+	if _, err := dec.Token(); err != nil {
+		t.Fatal(err)
+	}
+	var got []interface{}
+	for dec.More() {
+		offset := dec.TokenOffset()
+		pk, err := dec.Peek()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dec.TokenOffset() != offset {
+			t.Errorf("Peek changed TokenOffset from %d to %d", offset, dec.TokenOffset())
+		}
+		var v interface{}
+		switch pk {
+		case Delim('{'):
+			var m map[string]int
+			err = dec.Decode(&m)
+			v = m
+		case Delim('['):
+			var a []int
+			err = dec.Decode(&a)
+			v = a
+		default:
+			v, err = dec.Token()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, v)
+	}
+	want := []interface{}{map[string]int{"a": 1}, []int{2}, "three", 4.0}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("dispatched %v, want %v", got, want)
+	}
+	if tk, err := dec.Peek(); tk != Delim(']') || err != nil {
+		t.Errorf("Peek = %v, %v, want ], nil", tk, err)
+	}
+	if _, err := dec.Token(); err != nil {
+		t.Fatal(err)
+	}
+	if tk, err := dec.Peek(); tk != nil || err != io.EOF {
+		t.Errorf("Peek at end of input = %v, %v, want nil, io.EOF", tk, err)
+	}
+
+	// Peeking strings and numbers does not count their bytes twice in the
+	// offset of a later syntax error.
+	for _, in := range []string{`["abcdef", 12345, x]`, `{"key": "value", "n": 12, x}`} {
+		var offsets [2]int64
+		for i, peek := range []bool{false, true} {
+			dec := NewDecoder(strings.NewReader(in))
+			var err error
+			for err == nil {
+				if peek {
+					if _, err = dec.Peek(); err != nil {
+						break
+					}
+				}
+				_, err = dec.Token()
+			}
+			se, ok := err.(*SyntaxError)
+			if !ok {
+				t.Fatalf("%#q: peek %v: error = %v, want *SyntaxError", in, peek, err)
+			}
+			offsets[i] = se.Offset
+		}
+		if offsets[0] != offsets[1] {
+			t.Errorf("%#q: syntax error offset = %d with Peek, want %d as without", in, offsets[1], offsets[0])
+		}
+	}
+
+	dec = NewDecoder(strings.NewReader(`[1 2]`))
+	dec.Token()
+	dec.Token()
+	want2 := &SyntaxError{"invalid character '2' after array element", 3}
+	if _, err := dec.Peek(); !reflect.DeepEqual(err, want2) {
+		t.Errorf("Peek error = %#v, want %#v", err, want2)
+	}
+}
+
+func TestDecoderSetNumberMode(t *testing.T) {
+	const in = `[1, -2, 1.5, 1e3, 9223372036854775807, 9223372036854775808, -9223372036854775809, {"a": [0, 0.0]}]`
+	tests := []struct {
+		mode NumberMode
+		want interface{}
+	}{
+		{NumberModeFloat64, []interface{}{
+			1.0, -2.0, 1.5, 1e3, 9223372036854775807.0, 9223372036854775808.0, -9223372036854775809.0,
+			map[string]interface{}{"a": []interface{}{0.0, 0.0}},
+		}},
+		{NumberModeNumber, []interface{}{
+			Number("1"), Number("-2"), Number("1.5"), Number("1e3"),
+			Number("9223372036854775807"), Number("9223372036854775808"), Number("-9223372036854775809"),
+			map[string]interface{}{"a": []interface{}{Number("0"), Number("0.0")}},
+		}},
+		{NumberModeSmartInt, []interface{}{
+			int64(1), int64(-2), Number("1.5"), Number("1e3"),
+			int64(9223372036854775807), Number("9223372036854775808"), Number("-9223372036854775809"),
+			map[string]interface{}{"a": []interface{}{int64(0), Number("0.0")}},
+		}},
+	}
+	for _, tt := range tests {
+		dec := NewDecoder(strings.NewReader(in))
+		dec.SetNumberMode(tt.mode)
+		var got interface{}
+		if err := dec.Decode(&got); err != nil {
+			t.Fatalf("mode %d: Decode: %v", tt.mode, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mode %d: Decode = %#v, want %#v", tt.mode, got, tt.want)
+		}
+	}
+
+	// Numbers decoded into other types are unaffected.
+	dec := NewDecoder(strings.NewReader(`{"F": 1.5, "I": 2}`))
+	dec.SetNumberMode(NumberModeSmartInt)
+	var v struct {
+		F float64
+		I interface{}
+	}
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v.F != 1.5 || v.I != int64(2) {
+		t.Errorf("Decode = %+v, want {F:1.5 I:2}", v)
+	}
+}
+
+func TestDecoderReset(t *testing.T) {
+	dec := NewDecoder(strings.NewReader(`{"a": 1} {"b": [2, 3`))
+	dec.UseNumber()
+	dec.DisallowUnknownFields()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	// Leave a half-consumed value and a sticky error behind.
+	for i := 0; i < 3; i++ {
+		if _, err := dec.Token(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; ; i++ {
+		if err := dec.Decode(&v); err != nil {
+			break
+		}
+		if i == 2 {
+			t.Fatal("Decode of truncated input: expected error")
+		}
+	}
+
+	dec.Reset(strings.NewReader(` [4]`))
+	if got := dec.InputOffset(); got != 0 {
+		t.Errorf("InputOffset after Reset = %d, want 0", got)
+	}
+	if err := dec.Decode(&v); err != nil {
+		t.Fatalf("Decode after Reset: %v", err)
+	}
+	if want := []interface{}{Number("4")}; !reflect.DeepEqual(v, want) {
+		t.Errorf("Decode after Reset = %#v, want %#v", v, want)
+	}
+	if got := dec.InputOffset(); got != 4 {
+		t.Errorf("InputOffset = %d, want 4", got)
+	}
+	if dec.More() {
+		t.Errorf("More after Reset = true, want false")
+	}
+
+	dec.Reset(strings.NewReader(`{"X": 1}`))
+	var s struct{}
+	if err := dec.Decode(&s); err == nil || err.Error() != `json: unknown field "X"` {
+		t.Errorf("Decode after Reset: err = %v, want unknown field error", err)
+	}
+
+	dec.Reset(strings.NewReader(`{"a" 1}`))
+	_, err := dec.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = dec.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = dec.Token()
+	if se, ok := err.(*SyntaxError); !ok || se.Offset != 5 {
+		t.Errorf("Token after Reset: err = %#v, want SyntaxError at offset 5", err)
+	}
+}
+
+func BenchmarkDecoderReset(b *testing.B) {
+	var lines bytes.Buffer
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&lines, `{"id":%d,"name":"n%d"}`+"\n", i, i)
+	}
+	data := lines.Bytes()
+	type record struct {
+		ID   int
+		Name string
+	}
+	decodeAll := func(dec *Decoder) {
+		var r record
+		for s := data; len(s) > 0; {
+			i := bytes.IndexByte(s, '\n') + 1
+			dec.Reset(bytes.NewReader(s[:i]))
+			if err := dec.Decode(&r); err != nil {
+				b.Fatal(err)
+			}
+			s = s[i:]
+		}
+	}
+	b.Run("Reset", func(b *testing.B) {
+		b.ReportAllocs()
+		dec := NewDecoder(nil)
+		for i := 0; i < b.N; i++ {
+			decodeAll(dec)
+		}
+	})
+	b.Run("New", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var r record
+			for s := data; len(s) > 0; {
+				i := bytes.IndexByte(s, '\n') + 1
+				if err := NewDecoder(bytes.NewReader(s[:i])).Decode(&r); err != nil {
+					b.Fatal(err)
+				}
+				s = s[i:]
+			}
+		}
+	})
+}
+
+func TestDecoderDisallowDuplicateKeys(t *testing.T) {
+	tests := []struct {
+		in  string
+		err error
+	}{
+		{in: `{"a":1,"b":2}`},
+		{in: `[{"a":1},{"a":2}]`},
+		{in: `{"a":{"a":1}}`},
+		{in: `{"a":1,"a":2}`, err: &DuplicateKeyError{Key: "a", Offset: 7}},
+		{in: `{"a":1,"\u0061":2}`, err: &DuplicateKeyError{Key: "a", Offset: 7}},
+		{in: ` [1, {"x": [{"b":0, "b":1}]}]`, err: &DuplicateKeyError{Key: "b", Offset: 20}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			var v interface{}
+			dec := NewDecoder(strings.NewReader(tt.in))
+			dec.DisallowDuplicateKeys(true)
+			if err := dec.Decode(&v); !reflect.DeepEqual(err, tt.err) {
+				t.Errorf("Decode: %v, want %v", err, tt.err)
+			}
+
+			dec = NewDecoder(strings.NewReader(tt.in))
+			dec.DisallowDuplicateKeys(true)
+			var err error
+			for {
+				_, err = dec.Token()
+				if err != nil {
+					break
+				}
+			}
+			if err == io.EOF {
+				err = nil
+			}
+			if !reflect.DeepEqual(err, tt.err) {
+				t.Errorf("Token: %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	t.Run("struct", func(t *testing.T) {
+		var v struct {
+			A int
+			M map[string]int
+		}
+		for in, want := range map[string]error{
+			`{"A":1,"a":2}`:        nil,
+			`{"M":{"x":1,"x":2}}`:  &DuplicateKeyError{Key: "x", Offset: 12},
+			`{"Z":{"x":1,"x":2}}`:  &DuplicateKeyError{Key: "x", Offset: 12},
+			`{"A":1,"M":{},"A":2}`: &DuplicateKeyError{Key: "A", Offset: 14},
+			`{"A":"x","A":1}`:      &DuplicateKeyError{Key: "A", Offset: 9},
+		} {
+			dec := NewDecoder(strings.NewReader(in))
+			dec.DisallowDuplicateKeys(true)
+			if err := dec.Decode(&v); !reflect.DeepEqual(err, want) {
+				t.Errorf("Decode(%s): %v, want %v", in, err, want)
+			}
+		}
+	})
+
+	t.Run("second value", func(t *testing.T) {
+		dec := NewDecoder(strings.NewReader(`{"a":1} {"b":1,"b":2}`))
+		dec.DisallowDuplicateKeys(true)
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		want := &DuplicateKeyError{Key: "b", Offset: 15}
+		if err := dec.Decode(&v); !reflect.DeepEqual(err, want) {
+			t.Errorf("Decode: %v, want %v", err, want)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		var v map[string]int
+		if err := NewDecoder(strings.NewReader(`{"a":1,"a":2}`)).Decode(&v); err != nil || v["a"] != 2 {
+			t.Errorf("Decode: %v, %v", v, err)
+		}
+	})
+}
+
+func TestDecoderTokenOffset(t *testing.T) {
+	const in = `{"a":1,"b":[2,3]} ["x", {}]`
+	want := []struct {
+		tok         Token
+		off, endOff int64
+	}{
+		{Delim('{'), 0, 1},
+		{"a", 1, 4},
+		{1.0, 5, 6},
+		{"b", 7, 10},
+		{Delim('['), 11, 12},
+		{2.0, 12, 13},
+		{3.0, 14, 15},
+		{Delim(']'), 15, 16},
+		{Delim('}'), 16, 17},
+		{Delim('['), 18, 19},
+		{"x", 19, 22},
+		{Delim('{'), 24, 25},
+		{Delim('}'), 25, 26},
+		{Delim(']'), 26, 27},
+	}
+	for _, oneByte := range []bool{false, true} {
+		var r io.Reader = strings.NewReader(in)
+		if oneByte {
+			r = iotest.OneByteReader(r)
+		}
+		dec := NewDecoder(r)
+		for i, w := range want {
+			// Interleave calls to More, which must not affect the offsets.
+			dec.More()
+			tok, err := dec.Token()
+			if err != nil {
+				t.Fatalf("Token #%d: %v", i, err)
+			}
+			if !reflect.DeepEqual(tok, w.tok) {
+				t.Fatalf("Token #%d = %v, want %v", i, tok, w.tok)
+			}
+			if off := dec.TokenOffset(); off != w.off {
+				t.Errorf("oneByte=%v: TokenOffset after %v = %d, want %d", oneByte, tok, off, w.off)
+			}
+			if off := dec.InputOffset(); off != w.endOff {
+				t.Errorf("oneByte=%v: InputOffset after %v = %d, want %d", oneByte, tok, off, w.endOff)
+			}
+		}
+	}
+}
+
 // Test from golang.org/issue/11893
 func TestHTTPDecoding(t *testing.T) {
 	const raw = `{ "foo": "bar" }`
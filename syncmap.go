@@ -0,0 +1,136 @@
+package json
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+var syncMapType = reflect.TypeOf((*sync.Map)(nil)).Elem()
+
+// syncMapEncoder encodes a sync.Map as a JSON object, ranging over its
+// entries. Keys are turned into strings as for the keys of ordinary maps,
+// according to their dynamic types; a key that cannot be is an error. So is
+// a sync.Map that is not addressable, since Range needs a pointer to it and
+// a sync.Map must not be copied.
+func syncMapEncoder(e *encodeState, v reflect.Value, opts encOpts) {
+	if !v.CanAddr() {
+		e.error(&UnsupportedValueError{v, "sync.Map that is not addressable"})
+	}
+	m := v.Addr().Interface().(*sync.Map)
+
+	type entry struct {
+		key   string
+		value interface{}
+	}
+	var entries []entry
+	m.Range(func(k, val interface{}) bool {
+		kv := reflectWithString{v: reflect.ValueOf(k)}
+		if !isSyncMapKey(kv.v, opts.stringerMapKeys) {
+			e.error(&UnsupportedValueError{kv.v, fmt.Sprintf("sync.Map key of type %T", k)})
+		}
+		if err := kv.resolve(opts.stringerMapKeys); err != nil {
+			e.error(&MarshalerError{kv.v.Type(), err})
+		}
+		entries = append(entries, entry{kv.s, val})
+		return true
+	})
+	if opts.sortMapKeys {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	}
+
+	if err := e.WriteByte('{'); err != nil {
+		e.error(err)
+	}
+	for i, en := range entries {
+		if i > 0 {
+			if err := e.WriteByte(','); err != nil {
+				e.error(err)
+			}
+		}
+		e.string(en.key, opts)
+		if err := e.WriteByte(':'); err != nil {
+			e.error(err)
+		}
+		mv := reflect.ValueOf(en.value)
+		if opts.encoders == nil || !mv.IsValid() || !e.encodeCustom(mv, opts) {
+			e.reflectValue(mv, opts)
+		}
+	}
+	if err := e.WriteByte('}'); err != nil {
+		e.error(err)
+	}
+}
+
+// isSyncMapKey reports whether k, a key of a sync.Map, can be encoded as an
+// object key: whether it is of a type that may be the key type of an
+// encodable map.
+func isSyncMapKey(k reflect.Value, stringer bool) bool {
+	if !k.IsValid() {
+		return false
+	}
+	switch k.Kind() {
+	case reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	t := k.Type()
+	if t.Implements(textMarshalerType) || t.Implements(binaryMarshalerType) {
+		return true
+	}
+	_, ok := k.Interface().(fmt.Stringer)
+	return stringer && ok
+}
+
+// syncMapValue decodes the value beginning at d.data[d.off-1] into v, which
+// is, or points to, a sync.Map, and reports true. The members of an object
+// are decoded as into a map[string]interface{} and added to the sync.Map with
+// Store, keyed by string. Null decoded into a sync.Map itself is a no-op;
+// decoded into a pointer, it is left to the general decoder, which sets it to
+// nil, and syncMapValue reports false, having consumed nothing.
+func (d *decodeState) syncMapValue(v reflect.Value) (bool, error) {
+	t := v.Type()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	isNull := d.opcode == scanBeginLiteral && d.data[d.readIndex()] == 'n'
+	if isNull && v.Kind() == reflect.Ptr && v.CanSet() {
+		return false, nil
+	}
+	_, _, pv := indirect(v, false)
+	if isNull {
+		d.rescanLiteral()
+		return true, nil
+	}
+	if d.opcode != scanBeginObject {
+		kind := "array"
+		if d.opcode == scanBeginLiteral {
+			kind = literalKind(d.data[d.readIndex()])
+		}
+		d.saveError(&UnmarshalTypeError{Value: kind, Type: t, Offset: int64(d.readIndex())})
+		return true, d.value(reflect.Value{})
+	}
+	var members map[string]interface{}
+	if err := d.value(reflect.ValueOf(&members).Elem()); err != nil {
+		return true, err
+	}
+	m := pv.Addr().Interface().(*sync.Map)
+	for k, val := range members {
+		m.Store(k, val)
+	}
+	return true, nil
+}
+
+// literalKind returns the kind of JSON literal that begins with c, as used in
+// an UnmarshalTypeError.
+func literalKind(c byte) string {
+	switch c {
+	case '"':
+		return "string"
+	case 't', 'f':
+		return "bool"
+	}
+	return "number"
+}
@@ -0,0 +1,150 @@
+package json
+
+import (
+	"bytes"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestSyncMapRoundTrip(t *testing.T) {
+	var s struct {
+		M   sync.Map
+		Ptr *sync.Map
+		Nil *sync.Map
+	}
+	s.M.Store("b", 2)
+	s.M.Store("a", []string{"<x>"})
+	s.M.Store(3, nil)
+	s.M.Store(textKey{"t"}, map[string]int{"n": 1})
+	s.Ptr = new(sync.Map)
+	s.Ptr.Store(uint8(7), true)
+
+	const want = `{"M":{"3":null,"a":["\u003cx\u003e"],"b":2,"key:t":{"n":1}},"Ptr":{"7":true},"Nil":null}`
+	out, err := Marshal(&s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != want {
+		t.Errorf("Marshal:\n got %s\nwant %s", out, want)
+	}
+
+	var got struct {
+		M   sync.Map
+		Ptr *sync.Map
+		Nil *sync.Map
+	}
+	got.M.Store("old", "kept")
+	dec := NewDecoder(bytes.NewReader(out))
+	dec.UseNumber()
+	if err := dec.Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	entries := map[string]interface{}{}
+	got.M.Range(func(k, v interface{}) bool {
+		entries[k.(string)] = v
+		return true
+	})
+	wantEntries := map[string]interface{}{
+		"old":   "kept",
+		"3":     nil,
+		"a":     []interface{}{"<x>"},
+		"b":     Number("2"),
+		"key:t": map[string]interface{}{"n": Number("1")},
+	}
+	if !reflect.DeepEqual(entries, wantEntries) {
+		t.Errorf("decoded M = %v, want %v", entries, wantEntries)
+	}
+	if got.Ptr == nil {
+		t.Fatal("decoded Ptr is nil")
+	}
+	if v, ok := got.Ptr.Load("7"); !ok || v != true {
+		t.Errorf(`Ptr.Load("7") = %v, %v, want true, true`, v, ok)
+	}
+	if got.Nil != nil {
+		t.Errorf("decoded Nil = %v, want nil", got.Nil)
+	}
+
+	// Null leaves a sync.Map unchanged and sets a pointer to nil.
+	if err := Unmarshal([]byte(`{"M":null,"Ptr":null}`), &got); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got.M.Load("old"); !ok || got.Ptr != nil {
+		t.Errorf("after null: M has old = %v, Ptr = %v", ok, got.Ptr)
+	}
+}
+
+func TestSyncMapErrors(t *testing.T) {
+	var m sync.Map
+	m.Store(struct{}{}, 1)
+	if _, err := Marshal(&m); err == nil {
+		t.Error("Marshal with struct key: no error")
+	} else if _, ok := err.(*UnsupportedValueError); !ok {
+		t.Errorf("Marshal with struct key = %T %v, want *UnsupportedValueError", err, err)
+	}
+
+	var n sync.Map
+	n.Store(1.5, 1)
+	if _, err := Marshal(&n); err == nil {
+		t.Error("Marshal with float64 key: no error")
+	}
+
+	// A sync.Map that is not addressable is not copied.
+	p := new(sync.Map)
+	p.Store("a", 1)
+	if _, err := Marshal(reflect.ValueOf(p).Elem().Interface()); err == nil {
+		t.Error("Marshal of sync.Map by value: no error")
+	} else if _, ok := err.(*UnsupportedValueError); !ok {
+		t.Errorf("Marshal of sync.Map by value = %T %v, want *UnsupportedValueError", err, err)
+	}
+
+	var s struct {
+		M sync.Map
+		N int
+	}
+	err := Unmarshal([]byte(`{"M":[1,2],"N":3}`), &s)
+	if te, ok := err.(*UnmarshalTypeError); !ok || te.Value != "array" || te.Type != syncMapType {
+		t.Errorf("Unmarshal array = %v, want UnmarshalTypeError for array", err)
+	}
+	if s.N != 3 {
+		t.Errorf("N = %d, want 3 after type error", s.N)
+	}
+	err = Unmarshal([]byte(`"x"`), &m)
+	if te, ok := err.(*UnmarshalTypeError); !ok || te.Value != "string" {
+		t.Errorf("Unmarshal string = %v, want UnmarshalTypeError for string", err)
+	}
+}
+
+func TestSyncMapConcurrentStore(t *testing.T) {
+	var m sync.Map
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			m.Store("writer", i)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			if _, err := Marshal(&m); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	if err := Unmarshal([]byte(`{"a":1,"b":2}`), &m); err != nil {
+		t.Error(err)
+	}
+	wg.Wait()
+	if _, ok := m.Load("a"); !ok {
+		t.Error(`Load("a") failed after Unmarshal`)
+	}
+}
+
+// textKey is a sync.Map key that implements encoding.TextMarshaler.
+type textKey struct{ s string }
+
+func (k textKey) MarshalText() ([]byte, error) {
+	return []byte("key:" + k.s), nil
+}
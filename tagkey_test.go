@@ -118,3 +118,47 @@ func TestStructTagObjectKey(t *testing.T) {
 		}
 	}
 }
+
+type directionalTags struct {
+	UserID   int    `json:"out=user_id,in=userId"`
+	Name     string `json:"in=fullName,out=name,omitempty"`
+	Email    string `json:"out=email_address"`
+	Age      int    `json:"in=years"`
+	Nickname string `json:"nick"`
+}
+
+func TestDirectionalTagNames(t *testing.T) {
+	in := `{"userId":1,"fullName":"Ann","Email":"ann@example.com","years":30,"nick":"A"}`
+	var v directionalTags
+	if err := Unmarshal([]byte(in), &v); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	want := directionalTags{UserID: 1, Name: "Ann", Email: "ann@example.com", Age: 30, Nickname: "A"}
+	if v != want {
+		t.Fatalf("Unmarshal = %+v, want %+v", v, want)
+	}
+
+	b, err := Marshal(v)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	wantJSON := `{"user_id":1,"name":"Ann","email_address":"ann@example.com","Age":30,"nick":"A"}`
+	if string(b) != wantJSON {
+		t.Errorf("Marshal = %s, want %s", b, wantJSON)
+	}
+	if b, _ := Marshal(directionalTags{}); string(b) != `{"user_id":0,"email_address":"","Age":0,"nick":""}` {
+		t.Errorf("Marshal of zero value = %s, want name omitted", b)
+	}
+
+	// The Go field names, case-insensitively, are still matched when decoding,
+	// but the out names are not.
+	var got directionalTags
+	in = `{"userid":2,"NAME":"Bob","user_id":3,"email_address":"x","age":40}`
+	if err := Unmarshal([]byte(in), &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	want = directionalTags{UserID: 2, Name: "Bob", Age: 40}
+	if got != want {
+		t.Errorf("Unmarshal = %+v, want %+v", got, want)
+	}
+}
@@ -21,6 +21,21 @@ func parseTag(tag string) (string, tagOptions) {
 	return tag, tagOptions("")
 }
 
+// parseTagNames is like parseTag, but also recognizes a tag that gives
+// separate names for encoding and decoding, of the form "out=name,in=name"
+// followed by any other options. Either of out and in may be omitted, in which
+// case the corresponding name is empty and directional is still true.
+func parseTagNames(tag string) (out, in string, opts tagOptions, directional bool) {
+	name, opts := parseTag(tag)
+	if !strings.HasPrefix(name, "out=") && !strings.HasPrefix(name, "in=") {
+		return name, "", opts, false
+	}
+	opts = tagOptions(tag)
+	out, _ = opts.Get("out")
+	in, _ = opts.Get("in")
+	return out, in, opts, true
+}
+
 // Contains reports whether a comma-separated list of options
 // contains a particular substr flag. substr must be surrounded by a
 // string boundary or commas.
@@ -42,3 +57,21 @@ func (o tagOptions) Contains(optionName string) bool {
 	}
 	return false
 }
+
+// Get returns the value of an option of the form name=value in a
+// comma-separated list of options, and whether it was present.
+func (o tagOptions) Get(optionName string) (string, bool) {
+	s := string(o)
+	for s != "" {
+		var next string
+		i := strings.Index(s, ",")
+		if i >= 0 {
+			s, next = s[:i], s[i+1:]
+		}
+		if strings.HasPrefix(s, optionName) && len(s) > len(optionName) && s[len(optionName)] == '=' {
+			return s[len(optionName)+1:], true
+		}
+		s = next
+	}
+	return "", false
+}
@@ -26,3 +26,46 @@ func TestTagParsing(t *testing.T) {
 		}
 	}
 }
+
+func TestTagOptionValue(t *testing.T) {
+	_, opts := parseTag("field,omitempty,layout=2006-01-02,x=")
+	for _, tt := range []struct {
+		opt   string
+		value string
+		ok    bool
+	}{
+		{"layout", "2006-01-02", true},
+		{"x", "", true},
+		{"omitempty", "", false},
+		{"lay", "", false},
+		{"missing", "", false},
+	} {
+		if value, ok := opts.Get(tt.opt); value != tt.value || ok != tt.ok {
+			t.Errorf("Get(%q) = %q, %v, want %q, %v", tt.opt, value, ok, tt.value, tt.ok)
+		}
+	}
+}
+
+func TestTagNamesParsing(t *testing.T) {
+	for _, tt := range []struct {
+		tag         string
+		out, in     string
+		directional bool
+		omitempty   bool
+	}{
+		{"field,omitempty", "field", "", false, true},
+		{"out=a,in=b", "a", "b", true, false},
+		{"in=b,out=a,omitempty", "a", "b", true, true},
+		{"in=b", "", "b", true, false},
+		{"out=a", "a", "", true, false},
+		{"output=a,in=b", "output=a", "", false, false},
+	} {
+		out, in, opts, directional := parseTagNames(tt.tag)
+		if out != tt.out || in != tt.in || directional != tt.directional {
+			t.Errorf("parseTagNames(%q) = %q, %q, %v; want %q, %q, %v", tt.tag, out, in, directional, tt.out, tt.in, tt.directional)
+		}
+		if got := opts.Contains("omitempty"); got != tt.omitempty {
+			t.Errorf("parseTagNames(%q) options contain omitempty = %v, want %v", tt.tag, got, tt.omitempty)
+		}
+	}
+}